@@ -0,0 +1,253 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/andrew-delph/my-key-store/kvp"
+)
+
+// ringVersionMetadata must match http.RingVersionMetadata.
+const ringVersionMetadata = "x-ring-version"
+
+type Config struct {
+	// Seeds are host:port addresses of the grpc api of nodes used to fetch the first ring.
+	Seeds []string
+	// Resolve returns the address of a ring member. it defaults to member:port where port is the grpc port of the ring.
+	Resolve func(member string, port int) string
+	// MaxAttempts is the number of times a request is tried before its error is returned.
+	MaxAttempts int
+	// Backoff is the wait before the first retry. it doubles on every retry.
+	Backoff     time.Duration
+	DialOptions []grpc.DialOption
+}
+
+func DefaultConfig(seeds ...string) Config {
+	return Config{Seeds: seeds, MaxAttempts: 3, Backoff: 50 * time.Millisecond}
+}
+
+// Client sends each request straight to a replica of its key using the ring fetched from the cluster.
+// requests sent with a stale ring are rejected by the node, the client then refreshes the ring and retries.
+type Client struct {
+	config Config
+	lock   sync.RWMutex
+	ring   *kvp.RingResponse
+	conns  map[string]*grpc.ClientConn
+}
+
+func New(ctx context.Context, config Config) (*Client, error) {
+	if len(config.Seeds) == 0 {
+		return nil, errors.New("client needs at least one seed")
+	}
+	if config.Resolve == nil {
+		config.Resolve = func(member string, port int) string {
+			return fmt.Sprintf("%s:%d", member, port)
+		}
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	c := &Client{config: config, conns: make(map[string]*grpc.ClientConn)}
+	err := c.Refresh(ctx)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var err error
+	for addr, conn := range c.conns {
+		err = errors.Join(err, conn.Close())
+		delete(c.conns, addr)
+	}
+	return err
+}
+
+// Refresh fetches the ring from the members of the current ring or the seeds.
+func (c *Client) Refresh(ctx context.Context) error {
+	addrs := c.memberAddrs()
+	addrs = append(addrs, c.config.Seeds...)
+	var err error
+	for _, addr := range addrs {
+		var conn *grpc.ClientConn
+		conn, err = c.conn(addr)
+		if err != nil {
+			continue
+		}
+		var ring *kvp.RingResponse
+		ring, err = kvp.NewKeyValueServiceClient(conn).Ring(ctx, &kvp.RingRequest{})
+		if err != nil {
+			continue
+		}
+		c.lock.Lock()
+		c.ring = ring
+		c.lock.Unlock()
+		return nil
+	}
+	return fmt.Errorf("failed to fetch ring: %w", err)
+}
+
+func (c *Client) memberAddrs() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.ring == nil {
+		return nil
+	}
+	var addrs []string
+	for _, member := range c.ring.Members {
+		addrs = append(addrs, c.config.Resolve(member, int(c.ring.GrpcPort)))
+	}
+	return addrs
+}
+
+// partitionId matches the partition the hashring assigns to key.
+func partitionId(key string, partitionCount int32) int {
+	return int(xxhash.Sum64String(key) % uint64(partitionCount))
+}
+
+// replicas returns the addresses of the replicas of key and the version of the ring they were taken from.
+// the seeds are returned when the ring has no replicas for key.
+func (c *Client) replicas(key string) ([]string, string) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.ring.PartitionCount < 1 || len(c.ring.Partitions) != int(c.ring.PartitionCount) {
+		return c.config.Seeds, ""
+	}
+	members := c.ring.Partitions[partitionId(key, c.ring.PartitionCount)].Members
+	if len(members) == 0 {
+		return c.config.Seeds, ""
+	}
+	addrs := make([]string, 0, len(members))
+	for _, member := range members {
+		addrs = append(addrs, c.config.Resolve(member, int(c.ring.GrpcPort)))
+	}
+	return addrs, c.ring.Version
+}
+
+func (c *Client) conn(addr string) (*grpc.ClientConn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	opts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, c.config.DialOptions...)
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// retryable reports whether a request may succeed on another replica.
+func retryable(ctx context.Context, err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return true
+	case codes.DeadlineExceeded:
+		// the node gave up but the caller has not
+		return ctx.Err() == nil
+	}
+	return false
+}
+
+// invoke calls call on the replicas of key in turn until it succeeds, fails with an error which is not retryable or MaxAttempts is reached.
+func (c *Client) invoke(ctx context.Context, key string, call func(ctx context.Context, client kvp.KeyValueServiceClient) error) error {
+	var err error
+	for attempt := 0; attempt < c.config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.config.Backoff << (attempt - 1)):
+			case <-ctx.Done():
+				return err
+			}
+		}
+		replicas, version := c.replicas(key)
+		conn, connErr := c.conn(replicas[attempt%len(replicas)])
+		if connErr != nil {
+			err = connErr
+			continue
+		}
+		callCtx := ctx
+		if version != "" {
+			callCtx = metadata.AppendToOutgoingContext(ctx, ringVersionMetadata, version)
+		}
+		err = call(callCtx, kvp.NewKeyValueServiceClient(conn))
+		if status.Code(err) == codes.Aborted {
+			refreshErr := c.Refresh(ctx)
+			if refreshErr != nil {
+				return errors.Join(err, refreshErr)
+			}
+			continue
+		}
+		if err == nil || !retryable(ctx, err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) Get(ctx context.Context, req *kvp.GetRequest) (*kvp.GetResponse, error) {
+	var res *kvp.GetResponse
+	err := c.invoke(ctx, req.Key, func(ctx context.Context, client kvp.KeyValueServiceClient) (err error) {
+		res, err = client.Get(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+func (c *Client) Put(ctx context.Context, req *kvp.PutRequest) (*kvp.PutResponse, error) {
+	var res *kvp.PutResponse
+	err := c.invoke(ctx, req.Key, func(ctx context.Context, client kvp.KeyValueServiceClient) (err error) {
+		res, err = client.Put(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+func (c *Client) Delete(ctx context.Context, req *kvp.DeleteRequest) (*kvp.DeleteResponse, error) {
+	var res *kvp.DeleteResponse
+	err := c.invoke(ctx, req.Key, func(ctx context.Context, client kvp.KeyValueServiceClient) (err error) {
+		res, err = client.Delete(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+// BatchGet is sent to a replica of the first key. the node groups the keys by replica itself.
+func (c *Client) BatchGet(ctx context.Context, req *kvp.BatchGetRequest) (*kvp.BatchGetResponse, error) {
+	key := ""
+	if len(req.Keys) > 0 {
+		key = req.Keys[0]
+	}
+	var res *kvp.BatchGetResponse
+	err := c.invoke(ctx, key, func(ctx context.Context, client kvp.KeyValueServiceClient) (err error) {
+		res, err = client.BatchGet(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+// Scan opens the stream on a replica of the start of the range. errors on the stream are not retried.
+func (c *Client) Scan(ctx context.Context, req *kvp.ScanRequest) (kvp.KeyValueService_ScanClient, error) {
+	var stream kvp.KeyValueService_ScanClient
+	err := c.invoke(ctx, req.Prefix+req.Start, func(ctx context.Context, client kvp.KeyValueServiceClient) (err error) {
+		stream, err = client.Scan(ctx, req)
+		return err
+	})
+	return stream, err
+}
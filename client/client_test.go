@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/andrew-delph/my-key-store/kvp"
+)
+
+type testNode struct {
+	kvp.UnimplementedKeyValueServiceServer
+	name     string
+	ring     *kvp.RingResponse
+	lock     sync.Mutex
+	errs     []error
+	versions []string
+	rings    int
+}
+
+func (node *testNode) Get(ctx context.Context, req *kvp.GetRequest) (*kvp.GetResponse, error) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	md, _ := metadata.FromIncomingContext(ctx)
+	node.versions = append(node.versions, md.Get(ringVersionMetadata)...)
+	if len(node.errs) > 0 {
+		err := node.errs[0]
+		node.errs = node.errs[1:]
+		return nil, err
+	}
+	return &kvp.GetResponse{Value: []byte(node.name)}, nil
+}
+
+func (node *testNode) Ring(ctx context.Context, req *kvp.RingRequest) (*kvp.RingResponse, error) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.rings++
+	return node.ring, nil
+}
+
+func (node *testNode) ringCount() int {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return node.rings
+}
+
+// startTestCluster starts nodes a and b. b is the first replica of every key.
+func startTestCluster(t *testing.T) (Config, *testNode, *testNode) {
+	ring := &kvp.RingResponse{
+		Version:        "v1",
+		Members:        []string{"a", "b"},
+		PartitionCount: 1,
+		Partitions:     []*kvp.Replicas{{Members: []string{"b", "a"}}},
+	}
+	addrs := make(map[string]string)
+	nodes := make(map[string]*testNode)
+	for _, name := range []string{"a", "b"} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		node := &testNode{name: name, ring: ring}
+		server := grpc.NewServer()
+		kvp.RegisterKeyValueServiceServer(server, node)
+		go server.Serve(lis)
+		t.Cleanup(server.Stop)
+		addrs[name] = lis.Addr().String()
+		nodes[name] = node
+	}
+	config := DefaultConfig(addrs["a"])
+	config.Backoff = time.Millisecond
+	config.Resolve = func(member string, port int) string {
+		return addrs[member]
+	}
+	return config, nodes["a"], nodes["b"]
+}
+
+func TestClientRoutesToReplica(t *testing.T) {
+	config, _, b := startTestCluster(t)
+	c, err := New(context.Background(), config)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	res, err := c.Get(context.Background(), &kvp.GetRequest{Key: "key1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), res.Value, "request should go to the first replica")
+	assert.Equal(t, []string{"v1"}, b.versions, "ring version should be sent")
+}
+
+func TestClientRetriesOtherReplica(t *testing.T) {
+	config, _, b := startTestCluster(t)
+	b.errs = []error{status.Error(codes.Unavailable, "quorum failed")}
+	c, err := New(context.Background(), config)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	res, err := c.Get(context.Background(), &kvp.GetRequest{Key: "key1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), res.Value, "request should be retried on the next replica")
+
+	b.errs = []error{status.Error(codes.NotFound, "value not found")}
+	_, err = c.Get(context.Background(), &kvp.GetRequest{Key: "key1"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "NotFound should not be retried")
+}
+
+func TestClientRefreshesRing(t *testing.T) {
+	config, a, b := startTestCluster(t)
+	b.errs = []error{status.Error(codes.Aborted, "ring version mismatch")}
+	c, err := New(context.Background(), config)
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, 1, a.ringCount()+b.ringCount(), "ring should be fetched once by New")
+
+	_, err = c.Get(context.Background(), &kvp.GetRequest{Key: "key1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, a.ringCount()+b.ringCount(), "ring should be refreshed on a ring version mismatch")
+}
@@ -0,0 +1,9 @@
+module client
+
+go 1.20
+
+require (
+	github.com/cespare/xxhash v1.1.0
+	github.com/stretchr/testify v1.8.4
+	google.golang.org/grpc v1.55.0
+)
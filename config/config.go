@@ -22,16 +22,39 @@ type ManagerConfig struct {
 	PartitionConcurrency int     `mapstructure:"PARTITION_CONCURRENCY"`
 	DataPath             string  `mapstructure:"DATA_PATH"` // TODO REMOVE THIS?
 	Hostname             string
-	RingDebounce         float64 `mapstructure:"RING_DEBOUNCE"`
+	RingDebounce         float64                `mapstructure:"RING_DEBOUNCE"`
+	TombstoneGracePeriod int                    `mapstructure:"TOMBSTONE_GRACE_PERIOD"`
+	TombstoneGcInterval  int                    `mapstructure:"TOMBSTONE_GC_INTERVAL"`
+	ReadRepairBlocking   bool                   `mapstructure:"READ_REPAIR_BLOCKING"`
+	SiblingPrefixes      []string               `mapstructure:"SIBLING_PREFIXES"`
+	StrongPrefixes       []string               `mapstructure:"STRONG_PREFIXES"`
+	SecondaryIndexes     []SecondaryIndexConfig `mapstructure:"SECONDARY_INDEXES"`
+	HintedHandoff        bool                   `mapstructure:"HINTED_HANDOFF"`
+	SloppyQuorum         bool                   `mapstructure:"SLOPPY_QUORUM"`
+	HintTtl              int                    `mapstructure:"HINT_TTL"`
+	MaxHints             int                    `mapstructure:"MAX_HINTS"`
+	HintReplayInterval   int                    `mapstructure:"HINT_REPLAY_INTERVAL"`
+	TtlSweepInterval     int                    `mapstructure:"TTL_SWEEP_INTERVAL"`
+	TxnTimeout           int                    `mapstructure:"TXN_TIMEOUT"`
+	TxnResolveInterval   int                    `mapstructure:"TXN_RESOLVE_INTERVAL"`
+	ReencryptInterval    int                    `mapstructure:"REENCRYPT_INTERVAL"`
 	Operator             bool
 }
 
+// SecondaryIndexConfig indexes the JSON values of the keys with Prefix by the field at the dotted Path.
+type SecondaryIndexConfig struct {
+	Name   string `mapstructure:"NAME"`
+	Prefix string `mapstructure:"PREFIX"`
+	Path   string `mapstructure:"PATH"`
+}
+
 type ConsensusConfig struct {
 	DataPath         string `mapstructure:"DATA_PATH"`
 	EpochTime        int    `mapstructure:"EPOCH_TIME"`
 	EnableLogs       bool   `mapstructure:"ENABLE_LOGS"`
 	AutoBootstrap    bool   `mapstructure:"AUTO_BOOTSTRAP"`
 	BootstrapTimeout int    `mapstructure:"BOOTSTRAP_TIMEOUT"`
+	PartitionPort    int    `mapstructure:"PARTITION_PORT"`
 	Name             string
 }
 
@@ -42,15 +65,29 @@ type GossipConfig struct {
 }
 
 type StorageConfig struct {
-	DataPath string `mapstructure:"DATA_PATH"`
+	DataPath          string `mapstructure:"DATA_PATH"`
+	Engine            string `mapstructure:"ENGINE"`
+	Compression       string `mapstructure:"COMPRESSION"`
+	// EncryptionKeyFile holds the keys which encrypt stored values. storage keys, which hold user keys, stay in plain text.
+	EncryptionKeyFile string `mapstructure:"ENCRYPTION_KEY_FILE"`
+	// EncryptionKeys is read from the ENCRYPTION_KEYS env var so keys stay out of config files.
+	EncryptionKeys string `mapstructure:"-"`
 }
 
 type RpcConfig struct {
 	Port           int `mapstructure:"PORT"`
 	DefaultTimeout int `mapstructure:"DEFAULT_TIMEOUT"`
+	// TLS is enabled when the cert, key and CA files are set. they are reloaded when they change.
+	TlsCertFile string `mapstructure:"TLS_CERT_FILE"`
+	TlsKeyFile  string `mapstructure:"TLS_KEY_FILE"`
+	TlsCaFile   string `mapstructure:"TLS_CA_FILE"`
+	// TlsAllowedPeers are the certificate names allowed to call a node besides the ring members, such as the operator.
+	TlsAllowedPeers []string `mapstructure:"TLS_ALLOWED_PEERS"`
 }
 type HttpConfig struct {
 	DefaultTimeout int `mapstructure:"DEFAULT_TIMEOUT"`
+	MaxValueSize   int `mapstructure:"MAX_VALUE_SIZE"`
+	GrpcPort       int `mapstructure:"GRPC_PORT"`
 	Hostname       string
 }
 
@@ -106,6 +143,8 @@ func getConfigOverride(allow_override bool) Config {
 	_, exists := os.LookupEnv("OPERATOR")
 	config.Manager.Operator = exists
 
+	config.Storage.EncryptionKeys = os.Getenv("ENCRYPTION_KEYS")
+
 	// // Print the JSON string
 	// settings := viper.AllSettings()
 	// jsonString, err := json.MarshalIndent(settings, "", "  ")
@@ -29,6 +29,21 @@ func TestConfigDefault(t *testing.T) {
 	assert.NotEqualValues(t, 0, config.Manager.Load, "PartitionConcurrency wrong value")
 	assert.NotEqualValues(t, 0, config.Manager.PartitionReplicas, "PartitionReplicas wrong value")
 	assert.NotEqualValues(t, 0, config.Manager.RingDebounce, "RingDebounce wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.TombstoneGracePeriod, "TombstoneGracePeriod wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.TombstoneGcInterval, "TombstoneGcInterval wrong value")
+	assert.EqualValues(t, false, config.Manager.ReadRepairBlocking, "ReadRepairBlocking wrong value")
+	assert.Empty(t, config.Manager.SiblingPrefixes, "SiblingPrefixes wrong value")
+	assert.Empty(t, config.Manager.StrongPrefixes, "StrongPrefixes wrong value")
+	assert.Empty(t, config.Manager.SecondaryIndexes, "SecondaryIndexes wrong value")
+	assert.EqualValues(t, true, config.Manager.HintedHandoff, "HintedHandoff wrong value")
+	assert.EqualValues(t, false, config.Manager.SloppyQuorum, "SloppyQuorum wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.HintTtl, "HintTtl wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.MaxHints, "MaxHints wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.HintReplayInterval, "HintReplayInterval wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.TtlSweepInterval, "TtlSweepInterval wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.TxnTimeout, "TxnTimeout wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.TxnResolveInterval, "TxnResolveInterval wrong value")
+	assert.NotEqualValues(t, 0, config.Manager.ReencryptInterval, "ReencryptInterval wrong value")
 	assert.EqualValues(t, false, config.Manager.Operator, "Operator wrong value")
 
 	// consensus config
@@ -37,6 +52,7 @@ func TestConfigDefault(t *testing.T) {
 	assert.EqualValues(t, false, config.Consensus.EnableLogs, "EnableLogs wrong value")
 	assert.EqualValues(t, true, config.Consensus.AutoBootstrap, "AutoBootstrap wrong value")
 	assert.NotEqualValues(t, 0, config.Consensus.BootstrapTimeout, "BootstrapTimeout wrong value")
+	assert.EqualValues(t, 7001, config.Consensus.PartitionPort, "PartitionPort wrong value")
 
 	// gossip config
 	assert.EqualValues(t, []string{"store:8081", "store-0:8081", "store-0.store.default:8081"}, config.Gossip.InitMembers, "InitMembers wrong value")
@@ -44,9 +60,12 @@ func TestConfigDefault(t *testing.T) {
 
 	// storage
 	assert.EqualValues(t, "/data/storage", config.Storage.DataPath, "DataPath wrong value")
+	assert.EqualValues(t, "badger", config.Storage.Engine, "Engine wrong value")
 
 	// http
 	assert.NotEqual(t, 0, config.Http.DefaultTimeout, "DefaultTimeout wrong value")
+	assert.EqualValues(t, 1048576, config.Http.MaxValueSize, "MaxValueSize wrong value")
+	assert.EqualValues(t, 9090, config.Http.GrpcPort, "GrpcPort wrong value")
 }
 
 func TestConfigOverwrite(t *testing.T) {
@@ -40,9 +40,16 @@ type FsmTask struct {
 	Epoch       int64
 	Members     []string
 	TempMembers []string
+	Namespaces  []*datap.Namespace
 	ResCh       chan interface{}
 }
 
+// ErrNamespaceExists is returned when a namespace is created with the name of an existing namespace.
+var ErrNamespaceExists = errors.New("namespace already exists")
+
+// ErrNamespaceNotFound is returned when a namespace which does not exist is deleted.
+var ErrNamespaceNotFound = errors.New("namespace not found")
+
 func CreateConsensusCluster(consensusConfig config.ConsensusConfig, reqCh chan interface{}) *ConsensusCluster {
 	raftConf := raft.DefaultConfig()
 	raftConf.LocalID = raft.ServerID(consensusConfig.Name)
@@ -261,6 +268,36 @@ func (consensusCluster *ConsensusCluster) UpdateFsm(Epoch int64, members, temp_m
 	return err
 }
 
+// ChangeNamespace commits the creation or deletion of a namespace. it returns ErrNotLeader on followers.
+func (consensusCluster *ConsensusCluster) ChangeNamespace(change *datap.NamespaceChange) error {
+	if consensusCluster.raftNode.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	updateBytes, err := proto.Marshal(&datap.Fsm{NamespaceChange: change})
+	if err != nil {
+		return err
+	}
+
+	logEntry := consensusCluster.raftNode.Apply(updateBytes, 0)
+	err = logEntry.Error()
+	if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+		return ErrNotLeader
+	} else if err != nil {
+		return err
+	}
+	if err, ok := logEntry.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Leader returns the member which leads the cluster, empty when there is no known leader.
+func (consensusCluster *ConsensusCluster) Leader() string {
+	_, id := consensusCluster.raftNode.LeaderWithID()
+	return string(id)
+}
+
 func (consensusCluster *ConsensusCluster) IsHealthy() error {
 	currState := consensusCluster.raftNode.State()
 	currEpoch := consensusCluster.fsm.data.Epoch
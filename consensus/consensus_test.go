@@ -2,8 +2,55 @@ package consensus
 
 import (
 	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/datap"
 )
 
 func TestConsensusDefault(t *testing.T) {
 	consensusTest()
 }
+
+func TestFsmNamespaces(t *testing.T) {
+	reqCh := make(chan interface{})
+	fsm := &FSM{reqCh: reqCh, index: new(uint64), data: &datap.Fsm{}}
+	tasks := make(chan FsmTask, 10)
+	go func() {
+		for raw := range reqCh {
+			task := raw.(FsmTask)
+			tasks <- task
+			task.ResCh <- true
+		}
+	}()
+	defer close(reqCh)
+	apply := func(entry *datap.Fsm) interface{} {
+		data, err := proto.Marshal(entry)
+		assert.NoError(t, err)
+		return fsm.Apply(&raft.Log{Data: data})
+	}
+
+	assert.Nil(t, apply(&datap.Fsm{Epoch: 2, Members: []string{"a", "b"}}))
+	assert.Equal(t, int64(2), (<-tasks).Epoch, "epoch wrong value")
+
+	assert.Nil(t, apply(&datap.Fsm{NamespaceChange: &datap.NamespaceChange{Create: &datap.Namespace{Name: "tenant1", MaxKeys: 10}}}))
+	task := <-tasks
+	assert.Equal(t, int64(2), task.Epoch, "a namespace change should keep the epoch")
+	assert.Equal(t, []string{"a", "b"}, task.Members, "a namespace change should keep the members")
+	assert.Len(t, task.Namespaces, 1, "namespace should be created")
+
+	err := apply(&datap.Fsm{NamespaceChange: &datap.NamespaceChange{Create: &datap.Namespace{Name: "tenant1"}}})
+	assert.ErrorIs(t, err.(error), ErrNamespaceExists, "namespace should not be created twice")
+
+	assert.Nil(t, apply(&datap.Fsm{Epoch: 3, Members: []string{"a"}}))
+	task = <-tasks
+	assert.Equal(t, int64(3), task.Epoch, "epoch wrong value")
+	assert.Len(t, task.Namespaces, 1, "an epoch update should keep the namespaces")
+
+	assert.Nil(t, apply(&datap.Fsm{NamespaceChange: &datap.NamespaceChange{Delete: "tenant1"}}))
+	assert.Empty(t, (<-tasks).Namespaces, "namespace should be deleted")
+	err = apply(&datap.Fsm{NamespaceChange: &datap.NamespaceChange{Delete: "tenant1"}})
+	assert.ErrorIs(t, err.(error), ErrNamespaceNotFound, "unknown namespace should not be deleted")
+}
@@ -2,6 +2,7 @@ package consensus
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"sync"
 
@@ -31,6 +32,16 @@ func (fsm *FSM) Apply(logEntry *raft.Log) interface{} {
 	if err != nil {
 		return err
 	}
+	if data.NamespaceChange != nil {
+		namespaces, err := applyNamespaceChange(fsm.data.GetNamespaces(), data.NamespaceChange)
+		if err != nil {
+			return err
+		}
+		data = &datap.Fsm{Epoch: fsm.data.GetEpoch(), Members: fsm.data.GetMembers(), TempMembers: fsm.data.GetTempMembers(), Namespaces: namespaces}
+	} else {
+		// epoch and member updates carry no namespaces
+		data.Namespaces = fsm.data.GetNamespaces()
+	}
 	fsm.data = data
 
 	if fsm.data.Epoch > data.Epoch {
@@ -39,7 +50,7 @@ func (fsm *FSM) Apply(logEntry *raft.Log) interface{} {
 	}
 
 	resCh := make(chan interface{})
-	fsm.reqCh <- FsmTask{Epoch: data.Epoch, ResCh: resCh, Members: data.Members, TempMembers: data.TempMembers}
+	fsm.reqCh <- FsmTask{Epoch: data.Epoch, ResCh: resCh, Members: data.Members, TempMembers: data.TempMembers, Namespaces: data.Namespaces}
 	rawRes := <-resCh
 
 	logrus.Debug("rawRes %v", rawRes)
@@ -47,6 +58,29 @@ func (fsm *FSM) Apply(logEntry *raft.Log) interface{} {
 	return nil
 }
 
+// applyNamespaceChange returns namespaces with change applied.
+func applyNamespaceChange(namespaces []*datap.Namespace, change *datap.NamespaceChange) ([]*datap.Namespace, error) {
+	var changed []*datap.Namespace
+	found := false
+	for _, namespace := range namespaces {
+		if namespace.Name == change.GetCreate().GetName() {
+			return nil, fmt.Errorf("%w: %s", ErrNamespaceExists, namespace.Name)
+		}
+		if namespace.Name == change.Delete {
+			found = true
+			continue
+		}
+		changed = append(changed, namespace)
+	}
+	if change.Create != nil {
+		return append(changed, change.Create), nil
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, change.Delete)
+	}
+	return changed, nil
+}
+
 func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
 	// logrus.Warnf("Snapshot start")
 	// defer logrus.Warnf("Snapshot done")
@@ -77,7 +111,7 @@ func (fsm *FSM) Restore(serialized io.ReadCloser) error {
 
 	fsm.data = data
 	resCh := make(chan interface{})
-	fsm.reqCh <- FsmTask{Epoch: data.Epoch, ResCh: resCh, Members: data.Members, TempMembers: data.TempMembers}
+	fsm.reqCh <- FsmTask{Epoch: data.Epoch, ResCh: resCh, Members: data.Members, TempMembers: data.TempMembers, Namespaces: data.Namespaces}
 	rawRes := <-resCh
 
 	logrus.Debug("rawRes %v", rawRes)
@@ -0,0 +1,134 @@
+package consensus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// muxHeaderTimeout is how long an accepted connection has to send the partition it is for.
+const muxHeaderTimeout = 5 * time.Second
+
+var errLayerClosed = errors.New("partition layer closed")
+
+// streamMux shares one listener between the raft groups of every partition.
+// each connection starts with the partition id of the group it is for.
+type streamMux struct {
+	listener  net.Listener
+	advertise net.Addr
+	lock      sync.Mutex
+	layers    map[int]*muxLayer
+}
+
+func newStreamMux(listener net.Listener, advertise net.Addr) *streamMux {
+	mux := &streamMux{listener: listener, advertise: advertise, layers: make(map[int]*muxLayer)}
+	go mux.serve()
+	return mux
+}
+
+func (mux *streamMux) serve() {
+	for {
+		conn, err := mux.listener.Accept()
+		if err != nil {
+			logrus.Debugf("streamMux Accept err = %v", err)
+			return
+		}
+		go mux.dispatch(conn)
+	}
+}
+
+func (mux *streamMux) dispatch(conn net.Conn) {
+	header := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(muxHeaderTimeout))
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	partitionId := int(binary.BigEndian.Uint32(header))
+
+	mux.lock.Lock()
+	layer, ok := mux.layers[partitionId]
+	mux.lock.Unlock()
+	if !ok {
+		logrus.Debugf("streamMux no group for partition %d", partitionId)
+		conn.Close()
+		return
+	}
+	select {
+	case layer.connCh <- conn:
+	case <-layer.closeCh:
+		conn.Close()
+	}
+}
+
+// Layer returns the stream layer of the raft group of partitionId.
+func (mux *streamMux) Layer(partitionId int) *muxLayer {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+	layer := &muxLayer{mux: mux, partitionId: partitionId, connCh: make(chan net.Conn), closeCh: make(chan struct{})}
+	mux.layers[partitionId] = layer
+	return layer
+}
+
+func (mux *streamMux) Close() error {
+	return mux.listener.Close()
+}
+
+// muxLayer is the raft.StreamLayer of one partition.
+type muxLayer struct {
+	mux         *streamMux
+	partitionId int
+	connCh      chan net.Conn
+	closeCh     chan struct{}
+	closeOnce   sync.Once
+}
+
+var _ raft.StreamLayer = (*muxLayer)(nil)
+
+func (layer *muxLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-layer.connCh:
+		return conn, nil
+	case <-layer.closeCh:
+		return nil, errLayerClosed
+	}
+}
+
+func (layer *muxLayer) Close() error {
+	layer.closeOnce.Do(func() {
+		close(layer.closeCh)
+		layer.mux.lock.Lock()
+		if layer.mux.layers[layer.partitionId] == layer {
+			delete(layer.mux.layers, layer.partitionId)
+		}
+		layer.mux.lock.Unlock()
+	})
+	return nil
+}
+
+func (layer *muxLayer) Addr() net.Addr {
+	return layer.mux.advertise
+}
+
+func (layer *muxLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(layer.partitionId))
+	_, err = conn.Write(header)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
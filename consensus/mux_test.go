@@ -0,0 +1,48 @@
+package consensus
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamMux(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	mux := newStreamMux(listener, listener.Addr())
+	defer mux.Close()
+
+	layer1 := mux.Layer(1)
+	layer2 := mux.Layer(2)
+	address := raft.ServerAddress(listener.Addr().String())
+
+	go func() {
+		conn, err := layer2.Dial(address, time.Second)
+		assert.NoError(t, err)
+		conn.Write([]byte("two"))
+		conn.Close()
+	}()
+	conn, err := layer2.Accept()
+	assert.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(data), "connection should reach the layer of its partition")
+	assert.Len(t, layer1.connCh, 0, "other layers should not receive the connection")
+
+	layer1.Close()
+	_, err = layer1.Accept()
+	assert.ErrorIs(t, err, errLayerClosed)
+
+	// connections for a partition without a group are closed
+	dialer := &muxLayer{mux: mux, partitionId: 3}
+	conn, err = dialer.Dial(address, time.Second)
+	assert.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "connection should be closed")
+	conn.Close()
+}
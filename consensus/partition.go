@@ -0,0 +1,374 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/datap"
+)
+
+// ErrNotLeader is returned when a partition group or the cluster is asked to apply on a node which is not its leader.
+var ErrNotLeader = errors.New("not the leader")
+
+// PartitionApplyTask writes a value committed by the raft group of a partition to storage.
+// the manager answers with the value stored or an error.
+type PartitionApplyTask struct {
+	PartitionId int
+	Value       *datap.Value
+	ResCh       chan interface{}
+}
+
+// PartitionSnapshotTask reads the strong values of a partition for a snapshot of its group.
+// the manager answers with a *datap.ValueBatch or an error.
+type PartitionSnapshotTask struct {
+	PartitionId int
+	ResCh       chan interface{}
+}
+
+// PartitionRestoreTask writes the values of a snapshot of a partition group to storage.
+type PartitionRestoreTask struct {
+	PartitionId int
+	Values      []*datap.Value
+	ResCh       chan interface{}
+}
+
+// PartitionGroups runs a raft group for each partition with strong keys held by this node.
+// every group listens on the same PartitionPort.
+type PartitionGroups struct {
+	consensusConfig config.ConsensusConfig
+	localId         string
+	reqCh           chan interface{}
+	mux             *streamMux
+	lock            sync.RWMutex
+	groups          map[int]*PartitionGroup
+}
+
+func CreatePartitionGroups(consensusConfig config.ConsensusConfig, localId string, reqCh chan interface{}) *PartitionGroups {
+	return &PartitionGroups{consensusConfig: consensusConfig, localId: localId, reqCh: reqCh, groups: make(map[int]*PartitionGroup)}
+}
+
+// PartitionAddress is the raft address of member for partition groups.
+func (pg *PartitionGroups) PartitionAddress(member string) raft.ServerAddress {
+	return raft.ServerAddress(fmt.Sprintf("%s:%d", member, pg.consensusConfig.PartitionPort))
+}
+
+func (pg *PartitionGroups) Start() error {
+	bindAddr := fmt.Sprintf(":%d", pg.consensusConfig.PartitionPort)
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return errors.Wrap(err, "PartitionGroups Listen")
+	}
+	advertise, err := net.ResolveTCPAddr("tcp", string(pg.PartitionAddress(pg.localId)))
+	if err != nil {
+		listener.Close()
+		return errors.Wrap(err, "PartitionGroups ResolveTCPAddr")
+	}
+	pg.lock.Lock()
+	pg.mux = newStreamMux(listener, advertise)
+	pg.lock.Unlock()
+	return nil
+}
+
+// Get returns the group of partitionId, nil when this node does not run it.
+func (pg *PartitionGroups) Get(partitionId int) *PartitionGroup {
+	pg.lock.RLock()
+	defer pg.lock.RUnlock()
+	return pg.groups[partitionId]
+}
+
+// Partitions returns the partitions with a group on this node.
+func (pg *PartitionGroups) Partitions() []int {
+	pg.lock.RLock()
+	defer pg.lock.RUnlock()
+	partitions := make([]int, 0, len(pg.groups))
+	for partitionId := range pg.groups {
+		partitions = append(partitions, partitionId)
+	}
+	sort.Ints(partitions)
+	return partitions
+}
+
+func (pg *PartitionGroups) groupDir(partitionId int) string {
+	return filepath.Join(pg.consensusConfig.DataPath, pg.consensusConfig.Name, "partitions", strconv.Itoa(partitionId))
+}
+
+// Ensure starts the group of partitionId when it is not running.
+// a group without state is bootstrapped with members, every replica bootstraps with the same members.
+// a replica which joins a running group cannot win an election with its empty log and is added by the leader.
+func (pg *PartitionGroups) Ensure(partitionId int, members []string) (*PartitionGroup, error) {
+	pg.lock.Lock()
+	defer pg.lock.Unlock()
+	if group, ok := pg.groups[partitionId]; ok {
+		return group, nil
+	}
+	if pg.mux == nil {
+		return nil, errors.New("partition groups are not started")
+	}
+
+	dir := pg.groupDir(partitionId)
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(dir, "log.db"))
+	if err != nil {
+		return nil, err
+	}
+	snapshotStore, err := raft.NewFileSnapshotStore(filepath.Join(dir, "snap"), 2, io.Discard)
+	if err != nil {
+		logStore.Close()
+		return nil, err
+	}
+	layer := pg.mux.Layer(partitionId)
+	trans := raft.NewNetworkTransport(layer, 3, 10*time.Second, io.Discard)
+
+	raftConf := raft.DefaultConfig()
+	raftConf.LocalID = raft.ServerID(pg.localId)
+	if !pg.consensusConfig.EnableLogs {
+		raftConf.Logger = hclog.New(&hclog.LoggerOptions{
+			Name:   "discard",
+			Output: io.Discard,
+			Level:  hclog.NoLevel,
+		})
+	}
+
+	hasState, err := raft.HasExistingState(logStore, logStore, snapshotStore)
+	if err != nil {
+		trans.Close()
+		logStore.Close()
+		return nil, err
+	}
+
+	fsm := &partitionFSM{partitionId: partitionId, reqCh: pg.reqCh}
+	raftNode, err := raft.NewRaft(raftConf, fsm, logStore, logStore, snapshotStore, trans)
+	if err != nil {
+		trans.Close()
+		logStore.Close()
+		return nil, err
+	}
+
+	if !hasState {
+		var servers []raft.Server
+		for _, member := range members {
+			servers = append(servers, raft.Server{Suffrage: raft.Voter, ID: raft.ServerID(member), Address: pg.PartitionAddress(member)})
+		}
+		err = raftNode.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+		if err != nil && err != raft.ErrCantBootstrap {
+			logrus.Errorf("partition %d BootstrapCluster err = %v", partitionId, err)
+		}
+	}
+
+	group := &PartitionGroup{partitionId: partitionId, raftNode: raftNode, logStore: logStore, trans: trans, dir: dir, groups: pg}
+	pg.groups[partitionId] = group
+	return group, nil
+}
+
+// Remove stops the group of partitionId and deletes its state.
+// the node is a new member if the partition is moved back to it.
+func (pg *PartitionGroups) Remove(partitionId int) error {
+	pg.lock.Lock()
+	group, ok := pg.groups[partitionId]
+	delete(pg.groups, partitionId)
+	pg.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	err := group.shutdown()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(group.dir)
+}
+
+// Shutdown stops every group and keeps their state.
+func (pg *PartitionGroups) Shutdown() {
+	pg.lock.Lock()
+	defer pg.lock.Unlock()
+	for partitionId, group := range pg.groups {
+		err := group.shutdown()
+		if err != nil {
+			logrus.Errorf("partition %d shutdown err = %v", partitionId, err)
+		}
+	}
+	pg.groups = make(map[int]*PartitionGroup)
+	if pg.mux != nil {
+		pg.mux.Close()
+	}
+}
+
+type PartitionGroup struct {
+	partitionId int
+	raftNode    *raft.Raft
+	logStore    *raftboltdb.BoltStore
+	trans       *raft.NetworkTransport
+	dir         string
+	groups      *PartitionGroups
+}
+
+func (group *PartitionGroup) IsLeader() bool {
+	return group.raftNode.State() == raft.Leader
+}
+
+// Leader returns the member which leads the group, empty when there is no known leader.
+func (group *PartitionGroup) Leader() string {
+	_, id := group.raftNode.LeaderWithID()
+	return string(id)
+}
+
+// Apply commits value to the group and returns the value stored by this node.
+func (group *PartitionGroup) Apply(value *datap.Value, timeout time.Duration) (*datap.Value, error) {
+	if !group.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	data, err := proto.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	future := group.raftNode.Apply(data, timeout)
+	err = future.Error()
+	if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+		return nil, ErrNotLeader
+	} else if err != nil {
+		return nil, err
+	}
+	switch res := future.Response().(type) {
+	case *datap.Value:
+		return res, nil
+	case error:
+		return nil, res
+	default:
+		return nil, errors.Errorf("partition %d unknown apply response %v", group.partitionId, res)
+	}
+}
+
+// Barrier returns once every entry committed before it is applied on this node.
+// it commits an entry so it fails on a node which is no longer the leader.
+func (group *PartitionGroup) Barrier(timeout time.Duration) error {
+	if !group.IsLeader() {
+		return ErrNotLeader
+	}
+	err := group.raftNode.Barrier(timeout).Error()
+	if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+		return ErrNotLeader
+	}
+	return err
+}
+
+// SetMembers changes the voters of the group to members. only the leader changes the configuration.
+func (group *PartitionGroup) SetMembers(members []string) error {
+	if !group.IsLeader() {
+		return nil
+	}
+	configFuture := group.raftNode.GetConfiguration()
+	err := configFuture.Error()
+	if err != nil {
+		return err
+	}
+	current := make(map[raft.ServerID]bool)
+	for _, server := range configFuture.Configuration().Servers {
+		current[server.ID] = true
+	}
+	wanted := make(map[raft.ServerID]bool)
+	for _, member := range members {
+		id := raft.ServerID(member)
+		wanted[id] = true
+		if current[id] {
+			continue
+		}
+		err = group.raftNode.AddVoter(id, group.groups.PartitionAddress(member), 0, 0).Error()
+		if err != nil {
+			return errors.Wrapf(err, "partition %d AddVoter %s", group.partitionId, member)
+		}
+	}
+	// members are removed after the new members are added so the group keeps a quorum
+	for id := range current {
+		if wanted[id] {
+			continue
+		}
+		err = group.raftNode.RemoveServer(id, 0, 0).Error()
+		if err != nil {
+			return errors.Wrapf(err, "partition %d RemoveServer %s", group.partitionId, id)
+		}
+	}
+	return nil
+}
+
+func (group *PartitionGroup) shutdown() error {
+	err := group.raftNode.Shutdown().Error()
+	if err != nil {
+		return err
+	}
+	group.trans.Close()
+	return group.logStore.Close()
+}
+
+// partitionFSM applies the writes of a partition group to storage through the manager.
+// the storage is the state of the group, snapshots hold the strong values of the partition.
+type partitionFSM struct {
+	partitionId int
+	reqCh       chan interface{}
+}
+
+func (fsm *partitionFSM) Apply(logEntry *raft.Log) interface{} {
+	value := &datap.Value{}
+	err := proto.Unmarshal(logEntry.Data, value)
+	if err != nil {
+		return err
+	}
+	resCh := make(chan interface{})
+	fsm.reqCh <- PartitionApplyTask{PartitionId: fsm.partitionId, Value: value, ResCh: resCh}
+	return <-resCh
+}
+
+func (fsm *partitionFSM) Snapshot() (raft.FSMSnapshot, error) {
+	resCh := make(chan interface{})
+	fsm.reqCh <- PartitionSnapshotTask{PartitionId: fsm.partitionId, ResCh: resCh}
+	switch res := (<-resCh).(type) {
+	case *datap.ValueBatch:
+		data, err := proto.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		return &FSMSnapshot{DataBytes: data}, nil
+	case error:
+		return nil, res
+	default:
+		return nil, errors.Errorf("partition %d unknown snapshot response %v", fsm.partitionId, res)
+	}
+}
+
+func (fsm *partitionFSM) Restore(serialized io.ReadCloser) error {
+	defer serialized.Close()
+	var snapshot FSMSnapshot
+	if err := json.NewDecoder(serialized).Decode(&snapshot); err != nil {
+		return err
+	}
+	batch := &datap.ValueBatch{}
+	err := proto.Unmarshal(snapshot.DataBytes, batch)
+	if err != nil {
+		return err
+	}
+	resCh := make(chan interface{})
+	fsm.reqCh <- PartitionRestoreTask{PartitionId: fsm.partitionId, Values: batch.Values, ResCh: resCh}
+	if err, ok := (<-resCh).(error); ok {
+		return err
+	}
+	logrus.Warnf("Restore partition %d. values = %d", fsm.partitionId, len(batch.Values))
+	return nil
+}
@@ -3,6 +3,9 @@ package hashring
 import (
 	"errors"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -182,6 +185,18 @@ func (ring *Hashring) getMembersNames(temp bool) []string {
 	return memberNames
 }
 
+// Version identifies the members and temp members of the ring.
+// clients which route by the ring send it with each request to detect that their ring is stale.
+func (ring *Hashring) Version() string {
+	ring.rwLock.RLock()
+	defer ring.rwLock.RUnlock()
+	members := ring.getMembersNames(false)
+	tempMembers := ring.getMembersNames(true)
+	sort.Strings(members)
+	sort.Strings(tempMembers)
+	return strconv.FormatUint(xxhash.Sum64String(strings.Join(members, ",")+"|"+strings.Join(tempMembers, ",")), 16)
+}
+
 func (ring *Hashring) GetClosestN(key string, count int, includeSelf bool) ([]consistent.Member, error) {
 	ring.rwLock.RLock()
 	defer ring.rwLock.RUnlock()
@@ -246,3 +246,28 @@ func TestHashringTempNodes(t *testing.T) {
 	assert.EqualValues(t, 3, len(hr.GetMembers(false)), "wrong number of members")
 	assert.EqualValues(t, 3, len(hr.GetMembers(true)), "wrong number of members")
 }
+
+func TestHashringVersion(t *testing.T) {
+	reqCh := make(chan interface{}, 10)
+	go func() {
+		for {
+			rawEvent := <-reqCh
+			event := rawEvent.(RingUpdateTask)
+			event.ResCh <- true
+		}
+	}()
+	c := config.GetConfig().Manager
+	c.PartitionCount = 100
+	c.PartitionReplicas = 2
+	c.Load = 1.25
+	hr := CreateHashring(c, reqCh)
+
+	hr.SetRingMembers([]string{"test1", "test2"}, []string{"test1", "test2"})
+	version := hr.Version()
+
+	hr.SetRingMembers([]string{"test2", "test1"}, []string{"test2", "test1"})
+	assert.Equal(t, version, hr.Version(), "member order should not change the version")
+
+	hr.SetRingMembers([]string{"test1", "test2"}, []string{"test1", "test2", "test3"})
+	assert.NotEqual(t, version, hr.Version(), "temp members should change the version")
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidConsistency = errors.New("invalid consistency level")
+
+// ConsistencyLevel is the number of replicas a request must reach. It is either a named level or an explicit number.
+type ConsistencyLevel string
+
+const (
+	ConsistencyDefault ConsistencyLevel = "DEFAULT"
+	ConsistencyOne     ConsistencyLevel = "ONE"
+	ConsistencyQuorum  ConsistencyLevel = "QUORUM"
+	ConsistencyAll     ConsistencyLevel = "ALL"
+)
+
+func ParseConsistencyLevel(raw string) (ConsistencyLevel, error) {
+	level := ConsistencyLevel(strings.ToUpper(strings.TrimSpace(raw)))
+	switch level {
+	case "":
+		return ConsistencyDefault, nil
+	case ConsistencyDefault, ConsistencyOne, ConsistencyQuorum, ConsistencyAll:
+		return level, nil
+	}
+	count, err := strconv.Atoi(string(level))
+	if err != nil || count < 1 {
+		return "", fmt.Errorf("%w: %s", ErrInvalidConsistency, raw)
+	}
+	return level, nil
+}
+
+// Quorum resolves the level to a number of replicas. defaultQuorum is used for ConsistencyDefault.
+func (level ConsistencyLevel) Quorum(replicaCount, defaultQuorum int) (int, error) {
+	switch level {
+	case ConsistencyDefault, "":
+		return defaultQuorum, nil
+	case ConsistencyOne:
+		return 1, nil
+	case ConsistencyQuorum:
+		return replicaCount/2 + 1, nil
+	case ConsistencyAll:
+		return replicaCount, nil
+	}
+	count, err := strconv.Atoi(string(level))
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidConsistency, level)
+	}
+	if count > replicaCount {
+		return 0, fmt.Errorf("%w: %d is greater than ReplicaCount %d", ErrInvalidConsistency, count, replicaCount)
+	}
+	return count, nil
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistencyLevel(t *testing.T) {
+	replicaCount := 5
+	defaultQuorum := 2
+
+	cases := map[string]int{
+		"":       defaultQuorum,
+		"one":    1,
+		"QUORUM": 3,
+		"All":    5,
+		"4":      4,
+	}
+	for raw, expected := range cases {
+		level, err := ParseConsistencyLevel(raw)
+		if err != nil {
+			t.Error(err)
+		}
+		quorum, err := level.Quorum(replicaCount, defaultQuorum)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, expected, quorum, "wrong quorum for %s", raw)
+	}
+
+	_, err := ParseConsistencyLevel("SOME")
+	assert.Equal(t, true, errors.Is(err, ErrInvalidConsistency), "SOME should be invalid")
+
+	_, err = ParseConsistencyLevel("0")
+	assert.Equal(t, true, errors.Is(err, ErrInvalidConsistency), "0 should be invalid")
+
+	level, err := ParseConsistencyLevel("6")
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = level.Quorum(replicaCount, defaultQuorum)
+	assert.Equal(t, true, errors.Is(err, ErrInvalidConsistency), "6 should be greater than ReplicaCount")
+}
@@ -6,6 +6,7 @@ require (
 	github.com/prometheus/client_golang v1.16.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/stretchr/testify v1.8.4
+	google.golang.org/grpc v1.55.0
 )
 
 require (
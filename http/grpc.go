@@ -0,0 +1,351 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/kvp"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// RingVersionMetadata carries the ring version of a client which routes requests by the ring.
+const RingVersionMetadata = "x-ring-version"
+
+// GrpcServer serves the public KeyValueService.
+// requests are sent to the manager as the same tasks as the http api.
+type GrpcServer struct {
+	httpConfig  config.HttpConfig
+	reqCh       chan interface{}
+	grpc        *grpc.Server
+	ringVersion func() string
+}
+
+type RingTask struct {
+	ResCh chan interface{}
+}
+
+type RingResponse struct {
+	Version        string
+	Members        []string
+	TempMembers    []string
+	PartitionCount int
+	ReplicaCount   int
+	// replicas of each partition, indexed by partition id
+	Partitions [][]string
+}
+
+// CreateGrpcServer returns the server of the public api. ringVersion is compared to the ring version sent by clients.
+func CreateGrpcServer(httpConfig config.HttpConfig, reqCh chan interface{}, ringVersion func() string) *GrpcServer {
+	s := &GrpcServer{httpConfig: httpConfig, reqCh: reqCh, ringVersion: ringVersion}
+	s.grpc = grpc.NewServer(grpc.UnaryInterceptor(s.ringVersionInterceptor))
+	kvp.RegisterKeyValueServiceServer(s.grpc, s)
+	return s
+}
+
+// ringVersionInterceptor rejects requests routed with a stale ring with Aborted so the client refreshes its ring.
+// requests without a ring version are served by any node as before.
+func (s *GrpcServer) ringVersionInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && s.ringVersion != nil {
+		for _, version := range md.Get(RingVersionMetadata) {
+			if current := s.ringVersion(); version != current {
+				return nil, status.Errorf(codes.Aborted, "ring version mismatch: client = %s node = %s", version, current)
+			}
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (s *GrpcServer) StartGrpc() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.httpConfig.GrpcPort))
+	if err != nil {
+		logrus.Fatalf("failed to listen: %v", err)
+	}
+	logrus.Debugf("grpc server listening at %v", lis.Addr())
+	if err := s.grpc.Serve(lis); err != nil {
+		logrus.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func (s *GrpcServer) Stop() error {
+	s.grpc.GracefulStop()
+	return nil
+}
+
+// request sends task to the manager and waits for the response on resCh.
+// it gives up at the deadline of ctx or after DefaultTimeout. resCh must be buffered so the manager never blocks on a request which gave up.
+func (s *GrpcServer) request(ctx context.Context, task interface{}, resCh chan interface{}) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.httpConfig.DefaultTimeout)*time.Second)
+	defer cancel()
+	select {
+	case s.reqCh <- task:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	select {
+	case res := <-resCh:
+		return res, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func grpcError(err error) error {
+	if isInvalidRequest(err) || errors.Is(err, ErrValueTooLarge) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, ErrQuotaExceeded) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func checkKey(key string) error {
+	if key == "" {
+		return status.Error(codes.InvalidArgument, "missing key")
+	}
+	return nil
+}
+
+func toBytesList(values []string) [][]byte {
+	var list [][]byte
+	for _, value := range values {
+		list = append(list, []byte(value))
+	}
+	return list
+}
+
+func (s *GrpcServer) Get(ctx context.Context, req *kvp.GetRequest) (*kvp.GetResponse, error) {
+	err := checkKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	consistency, err := ParseConsistencyLevel(req.Consistency)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resCh := make(chan interface{}, 1)
+	rawRes, err := s.request(ctx, GetTask{Namespace: req.Namespace, Key: req.Key, Consistency: consistency, ResCh: resCh}, resCh)
+	if err != nil {
+		return nil, err
+	}
+	switch res := rawRes.(type) {
+	case GetResponse:
+		if res.Error != "" {
+			return nil, status.Error(codes.Unavailable, res.Error)
+		}
+		if !res.Found {
+			return nil, status.Error(codes.NotFound, "value not found")
+		}
+		return &kvp.GetResponse{Value: []byte(res.Value), Siblings: toBytesList(res.Siblings), Context: res.Context, Version: res.Version, ContentType: res.ContentType}, nil
+	case nil:
+		return nil, status.Error(codes.NotFound, "value not found")
+	case error:
+		return nil, grpcError(res)
+	default:
+		logrus.Panicf("grpc unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, nil
+}
+
+// Put answers FailedPrecondition when a conditional write fails. the stored version is sent in the x-version trailer.
+func (s *GrpcServer) Put(ctx context.Context, req *kvp.PutRequest) (*kvp.PutResponse, error) {
+	err := checkKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	err = checkValueSize(s.httpConfig, len(req.Value))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	consistency, err := ParseConsistencyLevel(req.Consistency)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if req.Ttl < 0 {
+		return nil, grpcError(fmt.Errorf("%w: ttl must be a positive number of seconds", ErrInvalidTtl))
+	}
+	resCh := make(chan interface{}, 1)
+	task := SetTask{Namespace: req.Namespace, Key: req.Key, Value: string(req.Value), Context: req.Context, ContentType: req.ContentType, IfVersion: req.IfVersion, IfAbsent: req.IfAbsent, Ttl: int(req.Ttl), Consistency: consistency, ResCh: resCh}
+	rawRes, err := s.request(ctx, task, resCh)
+	if err != nil {
+		return nil, err
+	}
+	switch res := rawRes.(type) {
+	case SetResponse:
+		if res.PreconditionFailed {
+			grpc.SetTrailer(ctx, metadata.Pairs(strings.ToLower(VersionHeader), res.Version))
+			return nil, status.Error(codes.FailedPrecondition, res.Error)
+		}
+		if res.QuotaExceeded {
+			return nil, status.Error(codes.ResourceExhausted, res.Error)
+		}
+		if res.Error != "" {
+			return nil, status.Error(codes.Unavailable, res.Error)
+		}
+		return &kvp.PutResponse{Version: res.Version, Members: res.Members}, nil
+	case error:
+		return nil, grpcError(res)
+	default:
+		logrus.Panicf("grpc unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, nil
+}
+
+func (s *GrpcServer) Delete(ctx context.Context, req *kvp.DeleteRequest) (*kvp.DeleteResponse, error) {
+	err := checkKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	consistency, err := ParseConsistencyLevel(req.Consistency)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resCh := make(chan interface{}, 1)
+	rawRes, err := s.request(ctx, DeleteTask{Namespace: req.Namespace, Key: req.Key, Context: req.Context, Consistency: consistency, ResCh: resCh}, resCh)
+	if err != nil {
+		return nil, err
+	}
+	switch res := rawRes.(type) {
+	case DeleteResponse:
+		if res.Error != "" {
+			return nil, status.Error(codes.Unavailable, res.Error)
+		}
+		return &kvp.DeleteResponse{Members: res.Members}, nil
+	case error:
+		return nil, grpcError(res)
+	default:
+		logrus.Panicf("grpc unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, nil
+}
+
+func (s *GrpcServer) BatchGet(ctx context.Context, req *kvp.BatchGetRequest) (*kvp.BatchGetResponse, error) {
+	err := validateBatchSize(len(req.Keys))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	consistency, err := ParseConsistencyLevel(req.Consistency)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resCh := make(chan interface{}, 1)
+	rawRes, err := s.request(ctx, MGetTask{Keys: req.Keys, Consistency: consistency, ResCh: resCh}, resCh)
+	if err != nil {
+		return nil, err
+	}
+	switch res := rawRes.(type) {
+	case MGetResponse:
+		items := make([]*kvp.BatchGetItem, 0, len(res.Items))
+		for _, item := range res.Items {
+			items = append(items, &kvp.BatchGetItem{Key: item.Key, Found: item.Found, Value: []byte(item.Value), Siblings: toBytesList(item.Siblings), Context: item.Context, Version: item.Version, Error: item.Error})
+		}
+		return &kvp.BatchGetResponse{Items: items}, nil
+	case error:
+		return nil, grpcError(res)
+	default:
+		logrus.Panicf("grpc unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, nil
+}
+
+// Scan pages through the range with scan tokens until the range or the limit is exhausted.
+func (s *GrpcServer) Scan(req *kvp.ScanRequest, stream kvp.KeyValueService_ScanServer) error {
+	consistency, err := ParseConsistencyLevel(req.Consistency)
+	if err != nil {
+		return grpcError(err)
+	}
+	if req.Limit < 0 {
+		return grpcError(fmt.Errorf("%w: limit must not be negative", ErrInvalidScan))
+	}
+	token := ""
+	sent := 0
+	for {
+		limit := MaxScanLimit
+		if req.Limit > 0 {
+			limit = utils.Min(limit, int(req.Limit)-sent)
+		}
+		resCh := make(chan interface{}, 1)
+		rawRes, err := s.request(stream.Context(), ScanTask{Prefix: req.Prefix, Start: req.Start, End: req.End, Token: token, Limit: limit, Consistency: consistency, ResCh: resCh}, resCh)
+		if err != nil {
+			return err
+		}
+		switch res := rawRes.(type) {
+		case ScanResponse:
+			if res.Error != "" {
+				return status.Error(codes.Unavailable, res.Error)
+			}
+			for _, item := range res.Items {
+				err = stream.Send(&kvp.KeyValue{Key: item.Key, Value: []byte(item.Value), Siblings: toBytesList(item.Siblings)})
+				if err != nil {
+					return err
+				}
+				sent++
+			}
+			if res.Token == "" || (req.Limit > 0 && sent >= int(req.Limit)) {
+				return nil
+			}
+			token = res.Token
+		case error:
+			return grpcError(res)
+		default:
+			logrus.Panicf("grpc unkown res type: %v", reflect.TypeOf(res))
+		}
+	}
+}
+
+func (s *GrpcServer) Ring(ctx context.Context, req *kvp.RingRequest) (*kvp.RingResponse, error) {
+	resCh := make(chan interface{}, 1)
+	rawRes, err := s.request(ctx, RingTask{ResCh: resCh}, resCh)
+	if err != nil {
+		return nil, err
+	}
+	switch res := rawRes.(type) {
+	case RingResponse:
+		partitions := make([]*kvp.Replicas, 0, len(res.Partitions))
+		for _, members := range res.Partitions {
+			partitions = append(partitions, &kvp.Replicas{Members: members})
+		}
+		return &kvp.RingResponse{Version: res.Version, Members: res.Members, TempMembers: res.TempMembers, PartitionCount: int32(res.PartitionCount), ReplicaCount: int32(res.ReplicaCount), GrpcPort: int32(s.httpConfig.GrpcPort), Partitions: partitions}, nil
+	case error:
+		return nil, grpcError(res)
+	default:
+		logrus.Panicf("grpc unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, nil
+}
+
+func (s *GrpcServer) Watch(req *kvp.WatchRequest, stream kvp.KeyValueService_WatchServer) error {
+	ctx := stream.Context()
+	events := make(chan WatchEvent)
+	resCh := make(chan interface{}, 1)
+	rawRes, err := s.request(ctx, WatchTask{Ctx: ctx, Key: req.Key, Prefix: req.Prefix, Cursor: req.Cursor, Events: events, ResCh: resCh}, resCh)
+	if err != nil {
+		return err
+	}
+	switch res := rawRes.(type) {
+	case bool:
+	case error:
+		return grpcError(res)
+	default:
+		logrus.Panicf("grpc unkown res type: %v", reflect.TypeOf(res))
+	}
+	for event := range events {
+		err = stream.Send(&kvp.WatchEvent{Key: event.Key, Value: []byte(event.Value), Siblings: toBytesList(event.Siblings), Deleted: event.Deleted, Version: event.Version, Cursor: event.Cursor})
+		if err != nil {
+			return err
+		}
+	}
+	return status.FromContextError(ctx.Err()).Err()
+}
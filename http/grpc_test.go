@@ -0,0 +1,187 @@
+package http
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/kvp"
+)
+
+type testScanStream struct {
+	grpc.ServerStream
+	items []*kvp.KeyValue
+}
+
+func (stream *testScanStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *testScanStream) Send(item *kvp.KeyValue) error {
+	stream.items = append(stream.items, item)
+	return nil
+}
+
+type testWatchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events []*kvp.WatchEvent
+}
+
+func (stream *testWatchStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *testWatchStream) Send(event *kvp.WatchEvent) error {
+	stream.events = append(stream.events, event)
+	return nil
+}
+
+func TestGrpcGet(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	grpcServer := CreateGrpcServer(c.Http, reqCh, nil)
+
+	go func() {
+		task := (<-reqCh).(GetTask)
+		assert.Equal(t, "key1", task.Key, "key wrong value")
+		assert.Equal(t, ConsistencyQuorum, task.Consistency, "consistency wrong value")
+		task.ResCh <- GetResponse{Value: "value1", Version: "v1", Found: true}
+	}()
+	res, err := grpcServer.Get(context.Background(), &kvp.GetRequest{Key: "key1", Consistency: "quorum"})
+	assert.NoError(t, err, "get should succeed")
+	assert.Equal(t, []byte("value1"), res.Value, "value wrong value")
+	assert.Equal(t, "v1", res.Version, "version wrong value")
+
+	go func() {
+		task := (<-reqCh).(GetTask)
+		task.ResCh <- GetResponse{}
+	}()
+	_, err = grpcServer.Get(context.Background(), &kvp.GetRequest{Key: "key2"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "missing key should be NotFound")
+
+	go func() {
+		task := (<-reqCh).(GetTask)
+		task.ResCh <- GetResponse{Version: "v2", Found: true}
+	}()
+	res, err = grpcServer.Get(context.Background(), &kvp.GetRequest{Key: "key3"})
+	assert.NoError(t, err, "empty value should be found")
+	assert.Equal(t, 0, len(res.Value), "value wrong value")
+
+	_, err = grpcServer.Get(context.Background(), &kvp.GetRequest{Key: "key1", Consistency: "bad"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "bad consistency should be InvalidArgument")
+}
+
+func TestGrpcPut(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	c.Http.MaxValueSize = 8
+	grpcServer := CreateGrpcServer(c.Http, reqCh, nil)
+
+	go func() {
+		task := (<-reqCh).(SetTask)
+		assert.Equal(t, "key1", task.Key, "key wrong value")
+		assert.Equal(t, "value1", task.Value, "value wrong value")
+		assert.Equal(t, 60, task.Ttl, "ttl wrong value")
+		task.ResCh <- SetResponse{Version: "v1", Members: []string{"a", "b"}}
+	}()
+	res, err := grpcServer.Put(context.Background(), &kvp.PutRequest{Key: "key1", Value: []byte("value1"), Ttl: 60})
+	assert.NoError(t, err, "put should succeed")
+	assert.Equal(t, "v1", res.Version, "version wrong value")
+	assert.Equal(t, []string{"a", "b"}, res.Members, "members wrong value")
+
+	go func() {
+		task := (<-reqCh).(SetTask)
+		assert.True(t, task.IfAbsent, "if_absent wrong value")
+		task.ResCh <- SetResponse{Version: "v1", PreconditionFailed: true, Error: "precondition failed"}
+	}()
+	_, err = grpcServer.Put(context.Background(), &kvp.PutRequest{Key: "key1", Value: []byte("value2"), IfAbsent: true})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "failed precondition wrong code")
+
+	_, err = grpcServer.Put(context.Background(), &kvp.PutRequest{Key: "key1", Value: []byte("123456789")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "large value should be InvalidArgument")
+
+	_, err = grpcServer.Put(context.Background(), &kvp.PutRequest{Value: []byte("value1")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "missing key should be InvalidArgument")
+}
+
+func TestGrpcDeadline(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	grpcServer := CreateGrpcServer(c.Http, reqCh, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := grpcServer.Get(ctx, &kvp.GetRequest{Key: "key1"})
+	assert.Equal(t, codes.Canceled, status.Code(err), "canceled request wrong code")
+}
+
+func TestGrpcScanPages(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	grpcServer := CreateGrpcServer(c.Http, reqCh, nil)
+
+	go func() {
+		task := (<-reqCh).(ScanTask)
+		assert.Equal(t, "", task.Token, "first page token wrong value")
+		assert.Equal(t, 3, task.Limit, "first page limit wrong value")
+		task.ResCh <- ScanResponse{Items: []ScanItem{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}, Token: "t1"}
+
+		task = (<-reqCh).(ScanTask)
+		assert.Equal(t, "t1", task.Token, "second page token wrong value")
+		assert.Equal(t, 1, task.Limit, "second page limit wrong value")
+		task.ResCh <- ScanResponse{Items: []ScanItem{{Key: "c", Value: "3"}}, Token: "t2"}
+	}()
+	stream := &testScanStream{}
+	err := grpcServer.Scan(&kvp.ScanRequest{Prefix: "", Limit: 3}, stream)
+	assert.NoError(t, err, "scan should succeed")
+	assert.Len(t, stream.items, 3, "scan items wrong length")
+	assert.Equal(t, "c", stream.items[2].Key, "last key wrong value")
+}
+
+func TestGrpcRingVersion(t *testing.T) {
+	c := config.GetConfig()
+	grpcServer := CreateGrpcServer(c.Http, nil, func() string { return "v2" })
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "served", nil
+	}
+
+	res, err := grpcServer.ringVersionInterceptor(context.Background(), nil, nil, handler)
+	assert.NoError(t, err, "requests without a ring version should be served")
+	assert.Equal(t, "served", res, "handler was not called")
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RingVersionMetadata, "v2"))
+	_, err = grpcServer.ringVersionInterceptor(ctx, nil, nil, handler)
+	assert.NoError(t, err, "current ring version should be served")
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(RingVersionMetadata, "v1"))
+	_, err = grpcServer.ringVersionInterceptor(ctx, nil, nil, handler)
+	assert.Equal(t, codes.Aborted, status.Code(err), "stale ring version should be Aborted")
+}
+
+func TestGrpcWatch(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	grpcServer := CreateGrpcServer(c.Http, reqCh, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		task := (<-reqCh).(WatchTask)
+		assert.Equal(t, "key1", task.Key, "key wrong value")
+		task.ResCh <- true
+		task.Events <- WatchEvent{Key: "key1", Value: "v1", Version: "v", Cursor: "1.1"}
+		cancel()
+		close(task.Events)
+	}()
+	stream := &testWatchStream{ctx: ctx}
+	err := grpcServer.Watch(&kvp.WatchRequest{Key: "key1"}, stream)
+	assert.Equal(t, codes.Canceled, status.Code(err), "watch should end when the client cancels")
+	assert.Len(t, stream.events, 1, "watch events wrong length")
+	assert.Equal(t, "1.1", stream.events[0].Cursor, "cursor wrong value")
+}
@@ -3,9 +3,11 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -26,27 +28,155 @@ type HttpServer struct {
 }
 
 type SetTask struct {
-	Key   string
-	Value string
-	ResCh chan interface{}
+	Namespace   string
+	Key         string
+	Value       string
+	Context     string
+	ContentType string
+	IfVersion   string
+	IfAbsent    bool
+	Ttl         int
+	Consistency ConsistencyLevel
+	ResCh       chan interface{}
 }
 
 type GetTask struct {
-	Key   string
-	ResCh chan interface{}
+	Namespace   string
+	Key         string
+	Consistency ConsistencyLevel
+	ResCh       chan interface{}
+}
+
+type DeleteTask struct {
+	Namespace   string
+	Key         string
+	Context     string
+	Consistency ConsistencyLevel
+	ResCh       chan interface{}
 }
 
 type GetResponse struct {
 	Value          string
+	Siblings       []string
+	Context        string
+	Version        string
+	ContentType    string
 	Failed_members []string
-	Error          string
+	Consistency    ConsistencyLevel
+	// Found is false when the key has no live value. an empty value is found.
+	Found bool
+	Error string
 }
 
 type SetResponse struct {
+	Members            []string
+	Version            string
+	PreconditionFailed bool
+	QuotaExceeded      bool
+	Consistency        ConsistencyLevel
+	Error              string
+}
+
+type DeleteResponse struct {
+	Members     []string
+	Consistency ConsistencyLevel
+	Error       string
+}
+
+type ScanTask struct {
+	Prefix      string
+	Start       string
+	End         string
+	Token       string
+	Limit       int
+	Consistency ConsistencyLevel
+	ResCh       chan interface{}
+}
+
+type ScanItem struct {
+	Key      string
+	Value    string
+	Siblings []string
+}
+
+type ScanResponse struct {
+	Items       []ScanItem
+	Token       string
+	Consistency ConsistencyLevel
+	Error       string
+}
+
+type BatchItem struct {
+	Key     string
+	Value   string
+	Context string
+}
+
+type MSetRequest struct {
+	Items []BatchItem
+}
+
+type MGetRequest struct {
+	Keys []string
+}
+
+type MSetTask struct {
+	Items       []BatchItem
+	Consistency ConsistencyLevel
+	ResCh       chan interface{}
+}
+
+type MGetTask struct {
+	Keys        []string
+	Consistency ConsistencyLevel
+	ResCh       chan interface{}
+}
+
+type MSetItem struct {
+	Key     string
 	Members []string
 	Error   string
 }
 
+type MGetItem struct {
+	Key      string
+	Value    string
+	Siblings []string
+	Context  string
+	Version  string
+	Found    bool
+	Error    string
+}
+
+type MSetResponse struct {
+	Items       []MSetItem
+	Consistency ConsistencyLevel
+}
+
+type MGetResponse struct {
+	Items       []MGetItem
+	Consistency ConsistencyLevel
+}
+
+// WatchTask starts a watch. the manager answers on ResCh and then sends the events on Events until Ctx is done.
+type WatchTask struct {
+	Ctx    context.Context
+	Key    string
+	Prefix string
+	Cursor string
+	Events chan WatchEvent
+	ResCh  chan interface{}
+}
+
+type WatchEvent struct {
+	Key      string
+	Value    string
+	Siblings []string
+	Deleted  bool
+	Version  string
+	Cursor   string
+}
+
 type HealthTask struct {
 	ResCh chan interface{}
 }
@@ -69,14 +199,116 @@ func handleShuttingDown(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, requestedURL, http.StatusFound)
 }
 
+// ErrInvalidContext is returned when the causal context of a sibling mode write cannot be decoded.
+var ErrInvalidContext = errors.New("invalid causal context")
+
+// ErrInvalidScan is returned when the range, limit or token of a scan is invalid.
+var ErrInvalidScan = errors.New("invalid scan")
+
+// ErrInvalidPrecondition is returned when the if_version or if_absent of a conditional write is invalid.
+var ErrInvalidPrecondition = errors.New("invalid precondition")
+
+// ErrInvalidTtl is returned when the ttl of a write is invalid.
+var ErrInvalidTtl = errors.New("invalid ttl")
+
+// ErrValueTooLarge is returned when a value is larger than MaxValueSize.
+var ErrValueTooLarge = errors.New("value too large")
+
+// ErrInvalidWatch is returned when the key, prefix or cursor of a watch is invalid.
+var ErrInvalidWatch = errors.New("invalid watch")
+
+// ErrInvalidBatch is returned when a /mget or /mset body is malformed or too large.
+var ErrInvalidBatch = errors.New("invalid batch")
+
+// ErrInvalidTxn is returned when a /txn body is malformed or its keys are invalid.
+var ErrInvalidTxn = errors.New("invalid transaction")
+
+// ErrInvalidQuery is returned when the index of a /query is missing or not declared.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrInvalidNamespace is returned when a namespace does not exist, or cannot be created or deleted.
+var ErrInvalidNamespace = errors.New("invalid namespace")
+
+// ErrQuotaExceeded is returned when a write would grow a namespace past its key or byte quota.
+var ErrQuotaExceeded = errors.New("namespace quota exceeded")
+
+const MaxBatchSize = 1000
+
+const (
+	DefaultScanLimit = 100
+	MaxScanLimit     = 1000
+)
+
+func (s HttpServer) handleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrValueTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, ErrQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if isInvalidRequest(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%v hostname = %s", err, s.httpConfig.Hostname), http.StatusInternalServerError)
+}
+
+// isInvalidRequest reports whether err was caused by the options of the request.
+func isInvalidRequest(err error) bool {
+	return errors.Is(err, ErrInvalidConsistency) || errors.Is(err, ErrInvalidContext) || errors.Is(err, ErrInvalidScan) || errors.Is(err, ErrInvalidBatch) || errors.Is(err, ErrInvalidPrecondition) || errors.Is(err, ErrInvalidTtl) || errors.Is(err, ErrInvalidWatch) || errors.Is(err, ErrInvalidTxn) || errors.Is(err, ErrInvalidQuery) || errors.Is(err, ErrInvalidNamespace)
+}
+
 // Define a setHandler function
 func (s HttpServer) setHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	value := r.URL.Query().Get("value")
 	logrus.Debugf("http handler path = \"%s\" key = \"%s\" value: \"%s\" ", r.URL.Path, key, value)
+	err := checkValueSize(s.httpConfig, len(value))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	task, err := parseSetTask(r, key, value)
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	s.handleSetTask(w, r, task)
+}
+
+// parseSetTask builds the write of key from the options in the query string.
+func parseSetTask(r *http.Request, key, value string) (SetTask, error) {
+	query := r.URL.Query()
+	consistency, err := ParseConsistencyLevel(query.Get("consistency"))
+	if err != nil {
+		return SetTask{}, err
+	}
+	ifAbsent, err := parseIfAbsent(query.Get("if_absent"))
+	if err != nil {
+		return SetTask{}, err
+	}
+	ttl, err := parseTtl(query.Get("ttl"))
+	if err != nil {
+		return SetTask{}, err
+	}
+	return SetTask{Namespace: query.Get("namespace"), Key: key, Value: value, Context: query.Get("context"), IfVersion: query.Get("if_version"), IfAbsent: ifAbsent, Ttl: ttl, Consistency: consistency}, nil
+}
+
+// checkValueSize rejects values larger than MaxValueSize before they are sent to the manager.
+func checkValueSize(httpConfig config.HttpConfig, size int) error {
+	if httpConfig.MaxValueSize > 0 && size > httpConfig.MaxValueSize {
+		return fmt.Errorf("%w: %d bytes is larger than %d", ErrValueTooLarge, size, httpConfig.MaxValueSize)
+	}
+	return nil
+}
+
+func (s HttpServer) handleSetTask(w http.ResponseWriter, r *http.Request, task SetTask) {
 	resCh := make(chan interface{})
+	task.ResCh = resCh
 
-	err := utils.WriteChannelTimeout(s.reqCh, SetTask{Key: key, Value: value, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	err := utils.WriteChannelTimeout(s.reqCh, task, s.httpConfig.DefaultTimeout)
 	if err != nil {
 		handleShuttingDown(w, r)
 		return
@@ -87,12 +319,16 @@ func (s HttpServer) setHandler(w http.ResponseWriter, r *http.Request) {
 	case SetResponse:
 		data, _ := json.Marshal(res)
 		w.Header().Set("Content-Type", "application/json")
-		if res.Error != "" {
+		if res.PreconditionFailed {
+			w.WriteHeader(http.StatusPreconditionFailed)
+		} else if res.QuotaExceeded {
+			w.WriteHeader(http.StatusInsufficientStorage)
+		} else if res.Error != "" {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		w.Write(data)
 	case error:
-		http.Error(w, fmt.Sprintf("%v hostname = %s", res, s.httpConfig.Hostname), http.StatusInternalServerError)
+		s.handleError(w, res)
 	default:
 		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
 	}
@@ -101,9 +337,14 @@ func (s HttpServer) setHandler(w http.ResponseWriter, r *http.Request) {
 func (s HttpServer) getHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	logrus.Debugf("http handler path = \"%s\" key = \"%s\"", r.URL.Path, key)
+	consistency, err := ParseConsistencyLevel(r.URL.Query().Get("consistency"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
 	resCh := make(chan interface{})
 
-	err := utils.WriteChannelTimeout(s.reqCh, GetTask{Key: key, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	err = utils.WriteChannelTimeout(s.reqCh, GetTask{Namespace: r.URL.Query().Get("namespace"), Key: key, Consistency: consistency, ResCh: resCh}, s.httpConfig.DefaultTimeout)
 	if err != nil {
 		handleShuttingDown(w, r)
 		return
@@ -116,14 +357,213 @@ func (s HttpServer) getHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		if res.Error != "" {
 			w.WriteHeader(http.StatusInternalServerError)
-		} else if res.Value == "" {
+		} else if !res.Found {
 			w.WriteHeader(http.StatusNotFound)
 		}
 		w.Write(data)
 	case nil:
 		http.Error(w, "value not found", http.StatusNotFound)
 	case error:
-		http.Error(w, fmt.Sprintf("%v hostname = %s", res, s.httpConfig.Hostname), http.StatusInternalServerError)
+		s.handleError(w, res)
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+}
+
+func (s HttpServer) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	logrus.Debugf("http handler path = \"%s\" key = \"%s\"", r.URL.Path, key)
+	s.handleDelete(w, r, key)
+}
+
+func (s HttpServer) handleDelete(w http.ResponseWriter, r *http.Request, key string) {
+	consistency, err := ParseConsistencyLevel(r.URL.Query().Get("consistency"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	resCh := make(chan interface{})
+
+	err = utils.WriteChannelTimeout(s.reqCh, DeleteTask{Namespace: r.URL.Query().Get("namespace"), Key: key, Context: r.URL.Query().Get("context"), Consistency: consistency, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case DeleteResponse:
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		if res.Error != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write(data)
+	case error:
+		s.handleError(w, res)
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+}
+
+func parseIfAbsent(raw string) (bool, error) {
+	if raw == "" {
+		return false, nil
+	}
+	ifAbsent, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%w: if_absent must be a boolean", ErrInvalidPrecondition)
+	}
+	return ifAbsent, nil
+}
+
+// parseTtl returns the ttl of a write in seconds, zero when the value does not expire.
+func parseTtl(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	ttl, err := strconv.Atoi(raw)
+	if err != nil || ttl < 1 {
+		return 0, fmt.Errorf("%w: ttl must be a positive number of seconds", ErrInvalidTtl)
+	}
+	return ttl, nil
+}
+
+func parseScanLimit(raw string) (int, error) {
+	if raw == "" {
+		return DefaultScanLimit, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > MaxScanLimit {
+		return 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidScan, MaxScanLimit)
+	}
+	return limit, nil
+}
+
+func (s HttpServer) scanHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	logrus.Debugf("http handler path = \"%s\" query = \"%s\"", r.URL.Path, r.URL.RawQuery)
+	consistency, err := ParseConsistencyLevel(query.Get("consistency"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	limit, err := parseScanLimit(query.Get("limit"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	resCh := make(chan interface{})
+
+	err = utils.WriteChannelTimeout(s.reqCh, ScanTask{Prefix: query.Get("prefix"), Start: query.Get("start"), End: query.Get("end"), Token: query.Get("token"), Limit: limit, Consistency: consistency, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case ScanResponse:
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		if res.Error != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write(data)
+	case error:
+		s.handleError(w, res)
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+}
+
+func validateBatchSize(size int) error {
+	if size == 0 || size > MaxBatchSize {
+		return fmt.Errorf("%w: batch must hold between 1 and %d items", ErrInvalidBatch, MaxBatchSize)
+	}
+	return nil
+}
+
+func (s HttpServer) msetHandler(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("http handler path = \"%s\"", r.URL.Path)
+	consistency, err := ParseConsistencyLevel(r.URL.Query().Get("consistency"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	var req MSetRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.handleError(w, fmt.Errorf("%w: %v", ErrInvalidBatch, err))
+		return
+	}
+	err = validateBatchSize(len(req.Items))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	for _, item := range req.Items {
+		err = checkValueSize(s.httpConfig, len(item.Value))
+		if err != nil {
+			s.handleError(w, err)
+			return
+		}
+	}
+	resCh := make(chan interface{})
+
+	err = utils.WriteChannelTimeout(s.reqCh, MSetTask{Items: req.Items, Consistency: consistency, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case MSetResponse:
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	case error:
+		s.handleError(w, res)
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+}
+
+func (s HttpServer) mgetHandler(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("http handler path = \"%s\"", r.URL.Path)
+	consistency, err := ParseConsistencyLevel(r.URL.Query().Get("consistency"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	var req MGetRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.handleError(w, fmt.Errorf("%w: %v", ErrInvalidBatch, err))
+		return
+	}
+	err = validateBatchSize(len(req.Keys))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	resCh := make(chan interface{})
+
+	err = utils.WriteChannelTimeout(s.reqCh, MGetTask{Keys: req.Keys, Consistency: consistency, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case MGetResponse:
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	case error:
+		s.handleError(w, res)
 	default:
 		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
 	}
@@ -189,6 +629,15 @@ func (s HttpServer) StartHttp() {
 	logrus.Debug("starting http server")
 	http.HandleFunc("/set", s.setHandler)
 	http.HandleFunc("/get", s.getHandler)
+	http.HandleFunc("/delete", s.deleteHandler)
+	http.HandleFunc("/scan", s.scanHandler)
+	http.HandleFunc("/mset", s.msetHandler)
+	http.HandleFunc("/mget", s.mgetHandler)
+	http.HandleFunc("/txn", s.txnHandler)
+	http.HandleFunc("/query", s.queryHandler)
+	http.HandleFunc("/namespace", s.namespaceHandler)
+	http.HandleFunc(kvPath, s.kvHandler)
+	http.HandleFunc("/watch", s.watchHandler)
 	http.HandleFunc("/health", s.healthHandler)
 	http.HandleFunc("/ready", s.readyHandler)
 	http.Handle("/metrics", promhttp.Handler())
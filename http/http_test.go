@@ -21,3 +21,53 @@ func TestHttpDefault(t *testing.T) {
 
 	logrus.Info("httpServer: ", httpServer)
 }
+
+func TestParseScanLimit(t *testing.T) {
+	limit, err := parseScanLimit("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultScanLimit, limit, "default limit wrong value")
+
+	limit, err = parseScanLimit("10")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, limit, "limit wrong value")
+
+	for _, raw := range []string{"0", "-1", "abc", "1001"} {
+		_, err = parseScanLimit(raw)
+		assert.ErrorIs(t, err, ErrInvalidScan, raw)
+	}
+}
+
+func TestValidateBatchSize(t *testing.T) {
+	assert.NoError(t, validateBatchSize(1))
+	assert.NoError(t, validateBatchSize(MaxBatchSize))
+	assert.ErrorIs(t, validateBatchSize(0), ErrInvalidBatch)
+	assert.ErrorIs(t, validateBatchSize(MaxBatchSize+1), ErrInvalidBatch)
+}
+
+func TestParseIfAbsent(t *testing.T) {
+	ifAbsent, err := parseIfAbsent("")
+	assert.NoError(t, err)
+	assert.Equal(t, false, ifAbsent, "default if_absent wrong value")
+
+	ifAbsent, err = parseIfAbsent("true")
+	assert.NoError(t, err)
+	assert.Equal(t, true, ifAbsent, "if_absent wrong value")
+
+	_, err = parseIfAbsent("maybe")
+	assert.ErrorIs(t, err, ErrInvalidPrecondition)
+}
+
+func TestParseTtl(t *testing.T) {
+	ttl, err := parseTtl("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, ttl, "default ttl wrong value")
+
+	ttl, err = parseTtl("60")
+	assert.NoError(t, err)
+	assert.Equal(t, 60, ttl, "ttl wrong value")
+
+	for _, raw := range []string{"0", "-1", "abc"} {
+		_, err = parseTtl(raw)
+		assert.ErrorIs(t, err, ErrInvalidTtl, raw)
+	}
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// kvPath serves raw values. the rest of the path is the key.
+const kvPath = "/kv/"
+
+const defaultContentType = "application/octet-stream"
+
+// VersionHeader and ContextHeader return the version and causal context of a raw value.
+const (
+	VersionHeader = "X-Version"
+	ContextHeader = "X-Context"
+)
+
+func (s HttpServer) kvHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, kvPath)
+	logrus.Debugf("http handler method = %s path = \"%s\" key = \"%s\"", r.Method, r.URL.Path, key)
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		s.putKv(w, r, key)
+	case http.MethodGet:
+		s.getKv(w, r, key)
+	case http.MethodDelete:
+		s.handleDelete(w, r, key)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readValue reads the request body. bodies larger than MaxValueSize are rejected without reading them whole.
+func (s HttpServer) readValue(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	err := checkValueSize(s.httpConfig, int(r.ContentLength))
+	if err != nil {
+		return nil, err
+	}
+	body := r.Body
+	if s.httpConfig.MaxValueSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, int64(s.httpConfig.MaxValueSize))
+	}
+	value, err := io.ReadAll(body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrValueTooLarge, maxBytesErr.Limit)
+	}
+	return value, err
+}
+
+func (s HttpServer) putKv(w http.ResponseWriter, r *http.Request, key string) {
+	value, err := s.readValue(w, r)
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	task, err := parseSetTask(r, key, string(value))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	task.ContentType = r.Header.Get("Content-Type")
+	s.handleSetTask(w, r, task)
+}
+
+// getKv writes the raw value of key with its stored content type.
+// keys with concurrent siblings answer 300 with the siblings as json.
+func (s HttpServer) getKv(w http.ResponseWriter, r *http.Request, key string) {
+	consistency, err := ParseConsistencyLevel(r.URL.Query().Get("consistency"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	resCh := make(chan interface{})
+
+	err = utils.WriteChannelTimeout(s.reqCh, GetTask{Namespace: r.URL.Query().Get("namespace"), Key: key, Consistency: consistency, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case GetResponse:
+		if res.Error != "" || len(res.Siblings) > 1 {
+			data, _ := json.Marshal(res)
+			w.Header().Set("Content-Type", "application/json")
+			if res.Error != "" {
+				w.WriteHeader(http.StatusInternalServerError)
+			} else {
+				w.WriteHeader(http.StatusMultipleChoices)
+			}
+			w.Write(data)
+			return
+		}
+		if !res.Found {
+			http.Error(w, "value not found", http.StatusNotFound)
+			return
+		}
+		contentType := res.ContentType
+		if contentType == "" {
+			contentType = defaultContentType
+		}
+		w.Header().Set("Content-Type", contentType)
+		if res.Version != "" {
+			w.Header().Set(VersionHeader, res.Version)
+		}
+		if res.Context != "" {
+			w.Header().Set(ContextHeader, res.Context)
+		}
+		w.Write([]byte(res.Value))
+	case nil:
+		http.Error(w, "value not found", http.StatusNotFound)
+	case error:
+		s.handleError(w, res)
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+func TestKvPutGet(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	c.Http.MaxValueSize = 8
+	httpServer := CreateHttpServer(c.Http, reqCh)
+
+	value := []byte{0x00, 0xff, 0x10, '\n'}
+
+	req := httptest.NewRequest(http.MethodPut, "/kv/a/b?ttl=60", bytes.NewReader(value))
+	req.Header.Set("Content-Type", "image/png")
+	rec := httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(SetTask)
+		assert.Equal(t, "a/b", task.Key, "key wrong value")
+		assert.Equal(t, value, []byte(task.Value), "value should be binary safe")
+		assert.Equal(t, "image/png", task.ContentType, "content type wrong value")
+		assert.Equal(t, 60, task.Ttl, "ttl wrong value")
+		task.ResCh <- SetResponse{Version: "v1"}
+	}()
+	httpServer.kvHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "put status wrong value")
+
+	req = httptest.NewRequest(http.MethodGet, "/kv/a/b", nil)
+	rec = httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(GetTask)
+		task.ResCh <- GetResponse{Value: string(value), ContentType: "image/png", Version: "v1", Found: true}
+	}()
+	httpServer.kvHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "get status wrong value")
+	assert.Equal(t, value, rec.Body.Bytes(), "get body wrong value")
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"), "get content type wrong value")
+	assert.Equal(t, "v1", rec.Header().Get(VersionHeader), "get version wrong value")
+
+	req = httptest.NewRequest(http.MethodGet, "/kv/empty", nil)
+	rec = httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(GetTask)
+		task.ResCh <- GetResponse{Version: "v2", Found: true}
+	}()
+	httpServer.kvHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "empty value should be found")
+	assert.Equal(t, 0, rec.Body.Len(), "get body wrong value")
+
+	req = httptest.NewRequest(http.MethodGet, "/kv/missing", nil)
+	rec = httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(GetTask)
+		task.ResCh <- GetResponse{}
+	}()
+	httpServer.kvHandler(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code, "missing key should not be found")
+}
+
+func TestKvValueTooLarge(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	c.Http.MaxValueSize = 8
+	httpServer := CreateHttpServer(c.Http, reqCh)
+
+	req := httptest.NewRequest(http.MethodPut, "/kv/a", bytes.NewReader(make([]byte, 9)))
+	rec := httptest.NewRecorder()
+	httpServer.kvHandler(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "declared length should be rejected")
+
+	// bodies without a declared length are cut off while reading
+	req = httptest.NewRequest(http.MethodPut, "/kv/a", bytes.NewReader(make([]byte, 9)))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	httpServer.kvHandler(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "streamed body should be rejected")
+
+	req = httptest.NewRequest(http.MethodGet, "/set?key=a&value=123456789", nil)
+	rec = httptest.NewRecorder()
+	httpServer.setHandler(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "query value should be rejected")
+
+	assert.Equal(t, 0, len(reqCh), "rejected values should not reach the manager")
+}
@@ -0,0 +1,144 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// Namespace holds the replication overrides and quotas of a namespace.
+// zero counts and quorums use the manager config, zero quotas are unlimited and an empty Codec uses the storage compression.
+// Keys and Bytes are the usage stored on the node which answered.
+type Namespace struct {
+	Name         string
+	ReplicaCount int
+	WriteQuorum  int
+	ReadQuorum   int
+	MaxKeys      int64
+	MaxBytes     int64
+	Codec        string
+	Keys         int64
+	Bytes        int64
+}
+
+// NamespaceTask creates Create or deletes the namespace named Delete. the manager answers true once it is committed.
+type NamespaceTask struct {
+	Create *Namespace
+	Delete string
+	ResCh  chan interface{}
+}
+
+// ListNamespacesTask lists the namespaces. the manager answers with a NamespacesResponse.
+type ListNamespacesTask struct {
+	ResCh chan interface{}
+}
+
+type NamespacesResponse struct {
+	Namespaces []Namespace
+}
+
+// namespaceHandler lists the namespaces on GET, creates a namespace on PUT and deletes one on DELETE.
+func (s HttpServer) namespaceHandler(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("http handler method = %s path = \"%s\" query = \"%s\"", r.Method, r.URL.Path, r.URL.RawQuery)
+	var task interface{}
+	resCh := make(chan interface{})
+	switch r.Method {
+	case http.MethodGet:
+		task = ListNamespacesTask{ResCh: resCh}
+	case http.MethodPut:
+		namespace, err := parseNamespace(r)
+		if err != nil {
+			s.handleError(w, err)
+			return
+		}
+		task = NamespaceTask{Create: &namespace, ResCh: resCh}
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			s.handleError(w, fmt.Errorf("%w: name is required", ErrInvalidNamespace))
+			return
+		}
+		task = NamespaceTask{Delete: name, ResCh: resCh}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := utils.WriteChannelTimeout(s.reqCh, task, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case NamespacesResponse:
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	case bool:
+		fmt.Fprintf(w, "namespace changed")
+	case error:
+		s.handleError(w, res)
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+}
+
+// parseNamespace builds the namespace to create from the query string.
+func parseNamespace(r *http.Request) (Namespace, error) {
+	query := r.URL.Query()
+	namespace := Namespace{Name: query.Get("name")}
+	if namespace.Name == "" {
+		return Namespace{}, fmt.Errorf("%w: name is required", ErrInvalidNamespace)
+	}
+	counts := []struct {
+		name  string
+		value *int
+	}{
+		{"replica_count", &namespace.ReplicaCount},
+		{"write_quorum", &namespace.WriteQuorum},
+		{"read_quorum", &namespace.ReadQuorum},
+	}
+	for _, count := range counts {
+		raw := query.Get(count.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 {
+			return Namespace{}, fmt.Errorf("%w: %s must be a positive number", ErrInvalidNamespace, count.name)
+		}
+		*count.value = value
+	}
+	quotas := []struct {
+		name  string
+		value *int64
+	}{
+		{"max_keys", &namespace.MaxKeys},
+		{"max_bytes", &namespace.MaxBytes},
+	}
+	for _, quota := range quotas {
+		raw := query.Get(quota.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value < 1 {
+			return Namespace{}, fmt.Errorf("%w: %s must be a positive number", ErrInvalidNamespace, quota.name)
+		}
+		*quota.value = value
+	}
+	namespace.Codec = query.Get("codec")
+	if _, err := utils.ParseCodec(namespace.Codec); err != nil {
+		return Namespace{}, fmt.Errorf("%w: %v", ErrInvalidNamespace, err)
+	}
+	return namespace, nil
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+func TestNamespaceHandler(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	httpServer := CreateHttpServer(c.Http, reqCh)
+
+	req := httptest.NewRequest(http.MethodPut, "/namespace?name=tenant1&replica_count=2&write_quorum=2&max_keys=10&max_bytes=1024&codec=zstd", nil)
+	rec := httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(NamespaceTask)
+		assert.Equal(t, Namespace{Name: "tenant1", ReplicaCount: 2, WriteQuorum: 2, MaxKeys: 10, MaxBytes: 1024, Codec: "zstd"}, *task.Create, "namespace wrong value")
+		task.ResCh <- true
+	}()
+	httpServer.namespaceHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "create status wrong value")
+
+	req = httptest.NewRequest(http.MethodDelete, "/namespace?name=tenant1", nil)
+	rec = httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(NamespaceTask)
+		assert.Nil(t, task.Create, "delete should not create")
+		assert.Equal(t, "tenant1", task.Delete, "delete wrong value")
+		task.ResCh <- ErrInvalidNamespace
+	}()
+	httpServer.namespaceHandler(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "delete of an unknown namespace should be rejected")
+
+	req = httptest.NewRequest(http.MethodGet, "/namespace", nil)
+	rec = httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(ListNamespacesTask)
+		task.ResCh <- NamespacesResponse{Namespaces: []Namespace{{Name: "tenant1", Keys: 3}}}
+	}()
+	httpServer.namespaceHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "list status wrong value")
+	assert.Contains(t, rec.Body.String(), `"Keys":3`, "usage wrong value")
+
+	for _, query := range []string{"", "?name=", "?name=a&replica_count=0", "?name=a&max_bytes=x", "?name=a&codec=gzip"} {
+		req = httptest.NewRequest(http.MethodPut, "/namespace"+query, nil)
+		rec = httptest.NewRecorder()
+		httpServer.namespaceHandler(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "namespace %q should be rejected", query)
+	}
+}
+
+func TestSetNamespace(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	httpServer := CreateHttpServer(c.Http, reqCh)
+
+	req := httptest.NewRequest(http.MethodGet, "/set?key=k1&value=v1&namespace=tenant1", nil)
+	rec := httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(SetTask)
+		assert.Equal(t, "tenant1", task.Namespace, "namespace wrong value")
+		task.ResCh <- SetResponse{Error: ErrQuotaExceeded.Error(), QuotaExceeded: true}
+	}()
+	httpServer.setHandler(rec, req)
+	assert.Equal(t, http.StatusInsufficientStorage, rec.Code, "write over the quota status wrong value")
+
+	req = httptest.NewRequest(http.MethodGet, "/get?key=k1&namespace=tenant1", nil)
+	rec = httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(GetTask)
+		assert.Equal(t, "tenant1", task.Namespace, "namespace wrong value")
+		task.ResCh <- ErrInvalidNamespace
+	}()
+	httpServer.getHandler(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "unknown namespace should be rejected")
+}
@@ -0,0 +1,63 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// QueryTask pages through the keys holding Value in the secondary index Index.
+type QueryTask struct {
+	Index       string
+	Value       string
+	Token       string
+	Limit       int
+	Consistency ConsistencyLevel
+	ResCh       chan interface{}
+}
+
+func (s HttpServer) queryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	logrus.Debugf("http handler path = \"%s\" query = \"%s\"", r.URL.Path, r.URL.RawQuery)
+	if query.Get("index") == "" {
+		s.handleError(w, fmt.Errorf("%w: index is required", ErrInvalidQuery))
+		return
+	}
+	consistency, err := ParseConsistencyLevel(query.Get("consistency"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	limit, err := parseScanLimit(query.Get("limit"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	resCh := make(chan interface{})
+
+	err = utils.WriteChannelTimeout(s.reqCh, QueryTask{Index: query.Get("index"), Value: query.Get("value"), Token: query.Get("token"), Limit: limit, Consistency: consistency, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case ScanResponse:
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		if res.Error != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write(data)
+	case error:
+		s.handleError(w, res)
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+}
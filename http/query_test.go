@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+func TestQueryHandler(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	httpServer := CreateHttpServer(c.Http, reqCh)
+
+	req := httptest.NewRequest(http.MethodGet, "/query?index=city&value=paris&limit=2&token=t1", nil)
+	rec := httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(QueryTask)
+		assert.Equal(t, "city", task.Index, "index wrong value")
+		assert.Equal(t, "paris", task.Value, "value wrong value")
+		assert.Equal(t, "t1", task.Token, "token wrong value")
+		assert.Equal(t, 2, task.Limit, "limit wrong value")
+		task.ResCh <- ScanResponse{Items: []ScanItem{{Key: "user/1", Value: `{"city":"paris"}`}}, Token: "t2"}
+	}()
+	httpServer.queryHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "query status wrong value")
+	assert.Contains(t, rec.Body.String(), `"Token":"t2"`, "token wrong value")
+
+	req = httptest.NewRequest(http.MethodGet, "/query?index=missing", nil)
+	rec = httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(QueryTask)
+		task.ResCh <- ErrInvalidQuery
+	}()
+	httpServer.queryHandler(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "unknown index should be rejected")
+
+	req = httptest.NewRequest(http.MethodGet, "/query?value=paris", nil)
+	rec = httptest.NewRecorder()
+	httpServer.queryHandler(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "query without an index should be rejected")
+}
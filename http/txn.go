@@ -0,0 +1,103 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// TxnRead is a key the transaction depends on. the transaction aborts if the key changes from Version, or exists when Absent is set.
+type TxnRead struct {
+	Key     string
+	Version string
+	Absent  bool
+}
+
+type TxnWrite struct {
+	Key    string
+	Value  string
+	Delete bool
+}
+
+type TxnRequest struct {
+	Reads  []TxnRead
+	Writes []TxnWrite
+}
+
+type TxnTask struct {
+	Reads       []TxnRead
+	Writes      []TxnWrite
+	Consistency ConsistencyLevel
+	ResCh       chan interface{}
+}
+
+// TxnResponse is the outcome of a transaction. Conflicts holds the keys which aborted it.
+type TxnResponse struct {
+	Id          string
+	Committed   bool
+	Conflicts   []string
+	Error       string
+	Consistency ConsistencyLevel
+}
+
+// txnHandler writes a set of keys atomically.
+// an aborted transaction answers 409 with the conflicting keys. a transaction which failed or is in doubt answers 500.
+func (s HttpServer) txnHandler(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("http handler path = \"%s\"", r.URL.Path)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	consistency, err := ParseConsistencyLevel(r.URL.Query().Get("consistency"))
+	if err != nil {
+		s.handleError(w, err)
+		return
+	}
+	var req TxnRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.handleError(w, fmt.Errorf("%w: %v", ErrInvalidTxn, err))
+		return
+	}
+	if len(req.Writes) == 0 || len(req.Reads)+len(req.Writes) > MaxBatchSize {
+		s.handleError(w, fmt.Errorf("%w: transaction must write between 1 and %d keys", ErrInvalidTxn, MaxBatchSize))
+		return
+	}
+	for _, write := range req.Writes {
+		err = checkValueSize(s.httpConfig, len(write.Value))
+		if err != nil {
+			s.handleError(w, err)
+			return
+		}
+	}
+	resCh := make(chan interface{})
+
+	err = utils.WriteChannelTimeout(s.reqCh, TxnTask{Reads: req.Reads, Writes: req.Writes, Consistency: consistency, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case TxnResponse:
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		if res.Error != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if !res.Committed {
+			w.WriteHeader(http.StatusConflict)
+		}
+		w.Write(data)
+	case error:
+		s.handleError(w, res)
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+func TestTxnHandler(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	httpServer := CreateHttpServer(c.Http, reqCh)
+
+	body := `{"Reads":[{"Key":"a","Version":"v1"}],"Writes":[{"Key":"b","Value":"1"},{"Key":"c","Delete":true}]}`
+	req := httptest.NewRequest(http.MethodPost, "/txn", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(TxnTask)
+		assert.Equal(t, []TxnRead{{Key: "a", Version: "v1"}}, task.Reads, "reads wrong value")
+		assert.Equal(t, []TxnWrite{{Key: "b", Value: "1"}, {Key: "c", Delete: true}}, task.Writes, "writes wrong value")
+		task.ResCh <- TxnResponse{Id: "txn1", Committed: true}
+	}()
+	httpServer.txnHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "committed status wrong value")
+
+	req = httptest.NewRequest(http.MethodPost, "/txn", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(TxnTask)
+		task.ResCh <- TxnResponse{Id: "txn1", Conflicts: []string{"a"}}
+	}()
+	httpServer.txnHandler(rec, req)
+	assert.Equal(t, http.StatusConflict, rec.Code, "aborted status wrong value")
+	assert.Contains(t, rec.Body.String(), `"Conflicts":["a"]`, "conflicts wrong value")
+
+	req = httptest.NewRequest(http.MethodPost, "/txn", strings.NewReader(`{"Reads":[{"Key":"a"}]}`))
+	rec = httptest.NewRecorder()
+	httpServer.txnHandler(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "transaction without writes should be rejected")
+
+	req = httptest.NewRequest(http.MethodGet, "/txn", nil)
+	rec = httptest.NewRecorder()
+	httpServer.txnHandler(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code, "get should not be allowed")
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// watchHandler streams the changes of a key or prefix as server-sent events.
+// each event id is its cursor so an EventSource resumes with Last-Event-ID after a reconnect.
+func (s HttpServer) watchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	logrus.Debugf("http handler path = \"%s\" query = \"%s\"", r.URL.Path, r.URL.RawQuery)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	cursor := query.Get("cursor")
+	if cursor == "" {
+		cursor = r.Header.Get("Last-Event-ID")
+	}
+	events := make(chan WatchEvent)
+	resCh := make(chan interface{})
+
+	err := utils.WriteChannelTimeout(s.reqCh, WatchTask{Ctx: r.Context(), Key: query.Get("key"), Prefix: query.Get("prefix"), Cursor: cursor, Events: events, ResCh: resCh}, s.httpConfig.DefaultTimeout)
+	if err != nil {
+		handleShuttingDown(w, r)
+		return
+	}
+
+	rawRes := utils.RecieveChannelTimeout(resCh, s.httpConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case bool:
+	case error:
+		s.handleError(w, res)
+		return
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for event := range events {
+		data, _ := json.Marshal(event)
+		eventType := "put"
+		if event.Deleted {
+			eventType = "delete"
+		}
+		_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Cursor, eventType, data)
+		if err != nil {
+			logrus.Debugf("watch write err = %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+func TestWatchSse(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	httpServer := CreateHttpServer(c.Http, reqCh)
+
+	req := httptest.NewRequest(http.MethodGet, "/watch?prefix=user/", nil)
+	req.Header.Set("Last-Event-ID", "3.100")
+	rec := httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(WatchTask)
+		assert.Equal(t, "user/", task.Prefix, "prefix wrong value")
+		assert.Equal(t, "3.100", task.Cursor, "Last-Event-ID should be used as the cursor")
+		task.ResCh <- true
+		task.Events <- WatchEvent{Key: "user/1", Value: "v1", Cursor: "3.200"}
+		task.Events <- WatchEvent{Key: "user/2", Deleted: true, Cursor: "3.300"}
+		close(task.Events)
+	}()
+	httpServer.watchHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "watch status wrong value")
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"), "watch content type wrong value")
+	body := rec.Body.String()
+	assert.Contains(t, body, "id: 3.200\nevent: put\ndata: {\"Key\":\"user/1\"", "put event wrong value")
+	assert.Contains(t, body, "id: 3.300\nevent: delete\n", "delete event wrong value")
+}
+
+func TestWatchInvalid(t *testing.T) {
+	reqCh := make(chan interface{}, 1)
+	c := config.GetConfig()
+	httpServer := CreateHttpServer(c.Http, reqCh)
+
+	req := httptest.NewRequest(http.MethodGet, "/watch?key=a&prefix=b", nil)
+	rec := httptest.NewRecorder()
+	go func() {
+		task := (<-reqCh).(WatchTask)
+		task.ResCh <- ErrInvalidWatch
+	}()
+	httpServer.watchHandler(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "invalid watch status wrong value")
+}
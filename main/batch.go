@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogo/status"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+)
+
+// batchEntry is a key of a batch which was sent to a member.
+type batchEntry struct {
+	item  int
+	value *rpc.RpcValue
+}
+
+type batchSetResponse struct {
+	member      string
+	entries     []batchEntry
+	errs        []string
+	err         error
+	unavailable bool
+}
+
+type batchGetResponse struct {
+	member  string
+	entries []batchEntry
+	values  map[string]*rpc.RpcValue
+	err     error
+}
+
+// MSetRequest writes a batch of items with one batched rpc per replica.
+// every item must reach writeQuorum on its own replicas.
+func (m *Manager) MSetRequest(items []http.BatchItem, writeQuorum int) []http.MSetItem {
+	results := make([]http.MSetItem, len(items))
+	memberEntries := make(map[string][]batchEntry)
+	for i, item := range items {
+		results[i].Key = item.Key
+		err := validateDefaultKey(item.Key)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if m.isStrongKey(item.Key) {
+			results[i].Error = ErrStrongBatch.Error()
+			continue
+		}
+		causalContext, err := DecodeCausalContext(item.Context)
+		if err != nil {
+			results[i].Error = fmt.Errorf("%w: %v", http.ErrInvalidContext, err).Error()
+			continue
+		}
+		version := m.clock.Now()
+		value := &rpc.RpcValue{Key: item.Key, Value: []byte(item.Value), Epoch: m.GetCurrentEpoch(), UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version)}
+		if m.isSiblingKey(item.Key) {
+			value.Context = causalContext
+		}
+		nodes, err := m.ring.GetClosestN(item.Key, m.keyReplicaCount(item.Key), true)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		for _, member := range nodes {
+			memberEntries[member.String()] = append(memberEntries[member.String()], batchEntry{item: i, value: value})
+			results[i].Members = append(results[i].Members, member.String())
+		}
+	}
+
+	responseCh := make(chan batchSetResponse, len(memberEntries))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+	for member, entries := range memberEntries {
+		member, entries := member, entries
+		go func() {
+			res := batchSetResponse{member: member, entries: entries}
+			client, err := m.clientManager.GetClient(member)
+			if err != nil {
+				res.err = err
+				res.unavailable = true
+				responseCh <- res
+				return
+			}
+			batch := &rpc.RpcValueBatch{}
+			for _, entry := range entries {
+				batch.Values = append(batch.Values, entry.value)
+			}
+			batchResult, err := client.BatchSetRequest(ctx, batch)
+			if err != nil {
+				res.err = err
+				res.unavailable = isHintable(err)
+			} else {
+				res.errs = batchResult.Errors
+			}
+			responseCh <- res
+		}()
+	}
+
+	responseCount := make([]int, len(items))
+	errorCount := make([]int, len(items))
+	for range memberEntries {
+		res := <-responseCh
+		for i, entry := range res.entries {
+			var err error
+			if res.err != nil {
+				err = res.err
+			} else if i < len(res.errs) && res.errs[i] != "" {
+				err = errors.New(res.errs[i])
+			}
+			if err == nil || (res.unavailable && m.handoffWrite(res.member, entry.value)) {
+				responseCount[entry.item]++
+			} else {
+				errorCount[entry.item]++
+				logrus.Debugf("MSetRequest member = %s key = %s err = %v", res.member, entry.value.Key, err)
+			}
+		}
+	}
+
+	for i := range results {
+		if results[i].Error == "" && responseCount[i] < writeQuorum {
+			results[i].Error = fmt.Sprintf("failed WriteQuorum %d. responseCount = %d errorCount = %d", writeQuorum, responseCount[i], errorCount[i])
+		}
+	}
+	return results
+}
+
+// MGetRequest reads a batch of keys with one batched rpc per replica.
+func (m *Manager) MGetRequest(keys []string, readQuorum int) []http.MGetItem {
+	results := make([]http.MGetItem, len(keys))
+	memberEntries := make(map[string][]batchEntry)
+	for i, key := range keys {
+		results[i].Key = key
+		err := validateDefaultKey(key)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if m.isStrongKey(key) {
+			results[i].Error = ErrStrongBatch.Error()
+			continue
+		}
+		nodes, err := m.ring.GetClosestN(key, m.keyReplicaCount(key), true)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		for _, member := range nodes {
+			memberEntries[member.String()] = append(memberEntries[member.String()], batchEntry{item: i})
+		}
+	}
+
+	responseCh := make(chan batchGetResponse, len(memberEntries))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+	for member, entries := range memberEntries {
+		member, entries := member, entries
+		go func() {
+			res := batchGetResponse{member: member, entries: entries}
+			client, err := m.clientManager.GetClient(member)
+			if err != nil {
+				res.err = err
+				responseCh <- res
+				return
+			}
+			req := &rpc.RpcBatchGetRequest{}
+			for _, entry := range entries {
+				req.Keys = append(req.Keys, keys[entry.item])
+			}
+			batch, err := client.BatchGetRequest(ctx, req)
+			if err != nil {
+				res.err = err
+			} else {
+				res.values = make(map[string]*rpc.RpcValue)
+				for _, value := range batch.Values {
+					res.values[value.Key] = value
+				}
+			}
+			responseCh <- res
+		}()
+	}
+
+	// replica answers are grouped per key so each key can be resolved and repaired like a single read
+	responses := make([][]readResponse, len(keys))
+	for range memberEntries {
+		res := <-responseCh
+		if res.err != nil {
+			logrus.Debugf("MGetRequest member = %s err = %v", res.member, res.err)
+			continue
+		}
+		for _, entry := range res.entries {
+			value, ok := res.values[keys[entry.item]]
+			if ok {
+				responses[entry.item] = append(responses[entry.item], readResponse{member: res.member, value: value})
+			} else {
+				responses[entry.item] = append(responses[entry.item], readResponse{member: res.member, err: status.Error(codes.NotFound, "Resource not found")})
+			}
+		}
+	}
+
+	for i, key := range keys {
+		if results[i].Error != "" {
+			continue
+		}
+		if len(responses[i]) < readQuorum {
+			results[i].Error = fmt.Sprintf("failed ReadQuorum. responseCount = %d", len(responses[i]))
+			continue
+		}
+		var recentValue *rpc.RpcValue
+		for _, res := range responses[i] {
+			if res.value == nil {
+				continue
+			}
+			if m.isSiblingKey(key) {
+				recentValue = mergeSiblings(recentValue, res.value)
+			} else if recentValue == nil || isNewerValue(recentValue, res.value) {
+				recentValue = res.value
+			}
+		}
+
+		var staleMembers []string
+		for _, res := range responses[i] {
+			if needsReadRepair(recentValue, res) {
+				staleMembers = append(staleMembers, res.member)
+			}
+		}
+		if len(staleMembers) > 0 {
+			go m.readRepair(recentValue, staleMembers)
+		}
+
+		if recentValue == nil || recentValue.Deleted || isExpired(recentValue, time.Now().UnixMilli()) {
+			continue
+		}
+		results[i].Found = true
+		if len(recentValue.Siblings) > 0 {
+			results[i].Siblings = liveSiblings(recentValue)
+			if len(results[i].Siblings) == 1 {
+				results[i].Value = results[i].Siblings[0]
+			}
+			causalContext, err := EncodeCausalContext(recentValue.Context)
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+			results[i].Context = causalContext
+		} else {
+			results[i].Value = string(recentValue.Value)
+			version, err := valueVersion(recentValue)
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+			results[i].Version = version
+		}
+	}
+	return results
+}
+
+// GetValues returns the stored values of keys, keys which are not found are left out.
+func (m *Manager) GetValues(keys []string) ([]*rpc.RpcValue, error) {
+	var values []*rpc.RpcValue
+	for _, key := range keys {
+		value, err := m.GetValue(key)
+		if err == storage.KEY_NOT_FOUND {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
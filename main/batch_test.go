@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func TestSetValuesBatch(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+
+	older := utils.HybridTimestamp{Physical: 1000100, Node: "store-0"}
+	newer := utils.HybridTimestamp{Physical: 1000200, Node: "store-0"}
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "key2", Value: []byte("existing"), Epoch: 1, UnixTimestamp: newer.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(newer)})
+	assert.NoError(t, err)
+
+	errs, err := manager.SetValues([]*rpc.RpcValue{
+		{Key: "key1", Value: []byte("value1"), Epoch: 1, UnixTimestamp: older.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(older)},
+		{Key: "key2", Value: []byte("stale"), Epoch: 1, UnixTimestamp: older.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(older)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(errs), "errs wrong length")
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1], "stale value should be rejected without aborting the batch")
+
+	values, err := manager.GetValues([]string{"key1", "key2", "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(values), "missing keys should be left out")
+	assert.Equal(t, "value1", string(values[0].Value), "key1 wrong value")
+	assert.Equal(t, "existing", string(values[1].Value), "key2 wrong value")
+
+	// values of a namespace are checked against its quotas
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "tenant1", MaxKeys: 1}})
+	errs, err = manager.SetValues([]*rpc.RpcValue{
+		{Key: "tenant1\x00a", Value: []byte("a"), Epoch: 1, UnixTimestamp: newer.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(newer)},
+		{Key: "tenant1\x00b", Value: []byte("b"), Epoch: 1, UnixTimestamp: newer.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(newer)},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], http.ErrQuotaExceeded, "a value past the quota should be rejected")
+
+	results := manager.MSetRequest([]http.BatchItem{{Key: "tenant1\x00c", Value: "c"}}, 1)
+	assert.NotEmpty(t, results[0].Error, "keys holding the separator should be rejected")
+	assert.Empty(t, results[0].Members, "rejected keys should not be sent")
+}
+
+// conflictStorage fails every commit as if another write conflicted with it.
+type conflictStorage struct {
+	storage.Storage
+}
+
+func (db conflictStorage) NewTransaction(update bool) storage.Transaction {
+	return conflictTransaction{db.Storage.NewTransaction(update)}
+}
+
+type conflictTransaction struct {
+	storage.Transaction
+}
+
+func (trx conflictTransaction) Commit() error {
+	return storage.ErrConflict
+}
+
+func TestSetValuesBatchAtomic(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "tenant1", MaxKeys: 1}})
+
+	version := utils.HybridTimestamp{Physical: 1000100, Node: "store-0"}
+	db := manager.db
+	manager.db = conflictStorage{db}
+	_, err := manager.SetValues([]*rpc.RpcValue{
+		{Key: "key", Value: []byte("v"), Epoch: 1, UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version)},
+		{Key: "tenant1\x00key", Value: []byte("v"), Epoch: 1, UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version)},
+	})
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	manager.db = db
+
+	_, err = manager.GetValue("key")
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "a failed batch should not write the default namespace")
+	_, err = manager.GetValue("tenant1\x00key")
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "a failed batch should not write a namespace with quotas")
+	usage, err := manager.readNamespaceUsage("tenant1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, usage.keys, "a failed batch should not count toward the quota")
+}
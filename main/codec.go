@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// maxCodecByte bounds the codec bytes. records starting below it are compressed, the others are legacy marshalled values
+// since a marshalled value starts with a field tag, which is at least 0x08.
+const maxCodecByte = 0x08
+
+// valueCodec returns the codec of a stored key. the codec of its namespace is used if set, else the storage compression.
+func (m *Manager) valueCodec(key string) utils.Codec {
+	namespace, err := m.getNamespace(keyNamespace(key))
+	if err == nil && namespace.Codec != "" {
+		codec, err := utils.ParseCodec(namespace.Codec)
+		if err == nil {
+			return codec
+		}
+	}
+	if m.codec == 0 {
+		return utils.CodecNone
+	}
+	return m.codec
+}
+
+// encodeValueRecord returns the item record of value, its marshalled bytes compressed and prefixed with the codec byte.
+// values which do not shrink are stored with CodecNone.
+func (m *Manager) encodeValueRecord(value *rpc.RpcValue) ([]byte, error) {
+	data, err := proto.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	codec := m.valueCodec(value.Key)
+	compressed, err := codec.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	valueBytesCounter.WithLabelValues(codec.String()).Add(float64(len(data)))
+	valueCompressedBytesCounter.WithLabelValues(codec.String()).Add(float64(len(compressed)))
+	if len(data) > 0 {
+		valueCompressionRatioHistogram.WithLabelValues(codec.String()).Observe(float64(len(compressed)) / float64(len(data)))
+	}
+	if len(compressed) >= len(data) {
+		codec = utils.CodecNone
+		compressed = data
+	}
+	record := make([]byte, 0, len(compressed)+1)
+	record = append(record, byte(codec))
+	return append(record, compressed...), nil
+}
+
+// decodeValueRecord returns the value of an item record written by encodeValueRecord or before values were compressed.
+func decodeValueRecord(record []byte) (*rpc.RpcValue, error) {
+	data := record
+	if len(record) > 0 && record[0] < maxCodecByte {
+		var err error
+		data, err = utils.Codec(record[0]).Decompress(record[1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "decodeValueRecord")
+		}
+	}
+	value := &rpc.RpcValue{}
+	err := proto.Unmarshal(data, value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func TestValueCodec(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Storage.Compression = "snappy"
+	manager := NewManager(c)
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "tenant1", Codec: "zstd"}, {Name: "tenant2"}})
+
+	assert.Equal(t, utils.CodecSnappy, manager.valueCodec("k"), "default codec wrong value")
+	assert.Equal(t, utils.CodecZstd, manager.valueCodec("tenant1\x00k"), "namespace codec wrong value")
+	assert.Equal(t, utils.CodecSnappy, manager.valueCodec("tenant2\x00k"), "namespace without a codec should use the storage compression")
+
+	large := &rpc.RpcValue{Key: "tenant1\x00k", Value: bytes.Repeat([]byte(`{"name":"value"}`), 100)}
+	record, err := manager.encodeValueRecord(large)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(utils.CodecZstd), record[0], "record codec wrong value")
+	data, err := proto.Marshal(large)
+	assert.NoError(t, err)
+	assert.Less(t, len(record), len(data), "record should be compressed")
+
+	small := &rpc.RpcValue{Key: "k", Value: []byte("v")}
+	record, err = manager.encodeValueRecord(small)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(utils.CodecNone), record[0], "values which do not shrink should not be compressed")
+
+	// records written before compression and with every codec are read
+	legacy, err := proto.Marshal(small)
+	assert.NoError(t, err)
+	for _, record := range [][]byte{legacy, record} {
+		value, err := decodeValueRecord(record)
+		assert.NoError(t, err)
+		assert.Equal(t, "v", string(value.Value), "value wrong value")
+	}
+
+	err = manager.SetValue(large)
+	assert.NoError(t, err)
+	value, err := manager.GetValue(large.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, large.Value, value.Value, "value wrong value")
+
+	_, err = decodeValueRecord([]byte{byte(utils.CodecZstd), 1, 2, 3})
+	assert.Error(t, err, "corrupt records should fail")
+}
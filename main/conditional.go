@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+)
+
+// preconditionError is returned when the precondition of a conditional write does not hold.
+// Version is the encoded version of the stored value, empty when no live value is stored.
+type preconditionError struct {
+	Version string
+}
+
+func (err *preconditionError) Error() string {
+	return fmt.Sprintf("precondition failed: current version = %q", err.Version)
+}
+
+// EncodeVersion returns the opaque version handed to clients for conditional writes.
+func EncodeVersion(version *rpc.RpcHybridTimestamp) (string, error) {
+	if version == nil {
+		return "", nil
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(version)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func DecodeVersion(raw string) (*rpc.RpcHybridTimestamp, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	version := &rpc.RpcHybridTimestamp{}
+	err = proto.Unmarshal(data, version)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// valueVersion returns the encoded version of a live value.
+func valueVersion(value *rpc.RpcValue) (string, error) {
+	if value == nil || value.Deleted {
+		return "", nil
+	}
+	return EncodeVersion(rpc.NewRpcHybridTimestamp(rpc.ValueHybridTimestamp(value)))
+}
+
+// checkPrecondition returns a preconditionError if precondition does not hold for the stored value.
+// tombstones and expired values are treated as absent.
+func checkPrecondition(precondition *rpc.RpcPrecondition, existing *rpc.RpcValue) error {
+	if precondition == nil {
+		return nil
+	}
+	live := existing != nil && !existing.Deleted && !isExpired(existing, time.Now().UnixMilli())
+	var ok bool
+	if precondition.IfAbsent {
+		ok = !live
+	} else if precondition.IfVersion != nil {
+		ok = live && rpc.ValueHybridTimestamp(existing).Compare(rpc.ToHybridTimestamp(precondition.IfVersion)) == 0
+	} else {
+		ok = true
+	}
+	if ok {
+		return nil
+	}
+	version, err := valueVersion(existing)
+	if err != nil {
+		return err
+	}
+	return &preconditionError{Version: version}
+}
+
+// newestVersion returns the newest of the encoded versions reported by conflicting replicas.
+func newestVersion(versions []string) string {
+	var newest string
+	var newestVersion *rpc.RpcHybridTimestamp
+	for _, raw := range versions {
+		version, err := DecodeVersion(raw)
+		if err != nil || version == nil {
+			continue
+		}
+		if newestVersion == nil || rpc.ToHybridTimestamp(newestVersion).Compare(rpc.ToHybridTimestamp(version)) < 0 {
+			newest, newestVersion = raw, version
+		}
+	}
+	return newest
+}
+
+// conditionalWriteRequest writes a conditional value with the two phase commit of transactions.
+// every replica checks the precondition and the quotas and stages the write as an intent, which locks the key.
+// once writeQuorum replicas staged it the write is committed through the transaction record of the key, otherwise it is
+// aborted on every replica, so a replica whose precondition held does not keep a write which failed.
+// quotas are checked when the write is staged, writes committed in between may still pass them.
+func (m *Manager) conditionalWriteRequest(value *rpc.RpcValue, writeQuorum int) ([]string, error) {
+	nodes, err := m.ring.GetClosestN(value.Key, m.keyReplicaCount(value.Key), true)
+	if err != nil {
+		return nil, err
+	}
+	txnId := fmt.Sprintf("%s-%s", m.config.Manager.Hostname, rpc.ValueHybridTimestamp(value).String())
+	prepare := &rpc.RpcTxnPrepare{TxnId: txnId, Anchor: value.Key, Writes: []*rpc.RpcValue{value}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+	var members []string
+	memberKeys := make(map[string][]string)
+	votesCh := make(chan txnVoteResponse, len(nodes))
+	for _, node := range nodes {
+		member := node.String()
+		members = append(members, member)
+		// every member is resolved, since an intent may be staged on a member whose vote was lost
+		memberKeys[member] = []string{value.Key}
+		go func() {
+			client, err := m.clientManager.GetClient(member)
+			if err != nil {
+				votesCh <- txnVoteResponse{member: member, err: err}
+				return
+			}
+			vote, err := client.PrepareTxn(ctx, prepare)
+			votesCh <- txnVoteResponse{member: member, vote: vote, err: err}
+		}()
+	}
+	prepared := 0
+	quotaExceeded := false
+	var versions []string
+	for range nodes {
+		res := <-votesCh
+		if res.err != nil {
+			logrus.Debugf("PrepareTxn member = %s err = %v", res.member, res.err)
+			continue
+		}
+		if version, ok := res.vote.Versions[value.Key]; ok {
+			versions = append(versions, version)
+		}
+		if len(res.vote.QuotaExceeded) > 0 {
+			quotaExceeded = true
+		}
+		if len(res.vote.Conflicts) == 0 {
+			prepared++
+		}
+	}
+
+	state := rpc.TxnAborted
+	if prepared >= writeQuorum {
+		state = m.decideTxn(txnId, value.Key, rpc.TxnCommitted)
+	} else {
+		m.decideTxn(txnId, value.Key, rpc.TxnAborted)
+	}
+	if state == rpc.TxnPending {
+		return members, ErrTxnInDoubt
+	}
+	m.resolveParticipants(txnId, state == rpc.TxnCommitted, memberKeys)
+	if state == rpc.TxnCommitted {
+		return members, nil
+	}
+	if len(versions) > 0 {
+		return members, &preconditionError{Version: newestVersion(versions)}
+	}
+	if quotaExceeded {
+		return members, fmt.Errorf("%w: %s", http.ErrQuotaExceeded, value.Key)
+	}
+	return members, fmt.Errorf("SET: failed WriteQuorum %d. prepared = %d", writeQuorum, prepared)
+}
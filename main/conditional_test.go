@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func conditionalWrite(value string, physical int64, precondition *rpc.RpcPrecondition) *rpc.RpcValue {
+	version := utils.HybridTimestamp{Physical: physical, Node: "store-0"}
+	return &rpc.RpcValue{Key: "key", Value: []byte(value), Epoch: 1, UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version), Precondition: precondition}
+}
+
+func TestConditionalSetValue(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+
+	first := conditionalWrite("first", 1000, &rpc.RpcPrecondition{IfAbsent: true})
+	err := manager.SetValue(first)
+	assert.NoError(t, err, "if_absent should hold for a missing key")
+
+	var conflict *preconditionError
+	err = manager.SetValue(conditionalWrite("second", 2000, &rpc.RpcPrecondition{IfAbsent: true}))
+	assert.Equal(t, true, errors.As(err, &conflict), "if_absent should fail for an existing key")
+	firstVersion, err := valueVersion(first)
+	assert.NoError(t, err)
+	assert.Equal(t, firstVersion, conflict.Version, "conflict should report the current version")
+
+	stale := rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: 500, Node: "store-0"})
+	err = manager.SetValue(conditionalWrite("second", 2000, &rpc.RpcPrecondition{IfVersion: stale}))
+	assert.Equal(t, true, errors.As(err, &conflict), "stale if_version should fail")
+
+	err = manager.SetValue(conditionalWrite("second", 2000, &rpc.RpcPrecondition{IfVersion: first.Hlc}))
+	assert.NoError(t, err, "current if_version should hold")
+
+	getVal, err := manager.GetValue("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(getVal.Value), "conditional write should be applied")
+	assert.Nil(t, getVal.Precondition, "precondition should not be stored")
+}
+
+func TestVersionEncoding(t *testing.T) {
+	version := rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: 1000, Logical: 2, Node: "store-1"})
+	raw, err := EncodeVersion(version)
+	assert.NoError(t, err)
+	decoded, err := DecodeVersion(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rpc.ToHybridTimestamp(version).Compare(rpc.ToHybridTimestamp(decoded)), "version should round trip")
+
+	_, err = DecodeVersion("not a version!")
+	assert.Error(t, err)
+
+	older, err := EncodeVersion(rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: 500, Node: "store-0"}))
+	assert.NoError(t, err)
+	assert.Equal(t, raw, newestVersion([]string{"", older, raw}), "newest version wrong value")
+}
+
+func TestBuildPrecondition(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.SiblingPrefixes = []string{"cart/"}
+	manager := NewManager(c)
+
+	precondition, err := manager.buildPrecondition("key", "", false)
+	assert.NoError(t, err)
+	assert.Nil(t, precondition, "unconditional write should have no precondition")
+
+	_, err = manager.buildPrecondition("key", "abc", true)
+	assert.ErrorIs(t, err, http.ErrInvalidPrecondition)
+
+	_, err = manager.buildPrecondition("cart/1", "", true)
+	assert.ErrorIs(t, err, http.ErrInvalidPrecondition)
+}
+
+func TestConditionalWriteRequest(t *testing.T) {
+	c := config.GetConfig()
+	c.Manager.ReplicaCount = 2
+
+	c.Manager.Hostname = "store-1"
+	store1, client1 := startTestNode(t, c)
+	c.Manager.Hostname = "store-2"
+	store2, client2 := startTestNode(t, c)
+	c.Manager.Hostname = "store-0"
+	coordinator, _ := startTestNode(t, c)
+	coordinator.clientManager.AddClient("store-1", client1)
+	coordinator.clientManager.AddClient("store-2", client2)
+	coordinator.ring.SetRingMembers([]string{"store-1", "store-2"}, []string{"store-1", "store-2"})
+
+	noIntent := func(manager *Manager, key string) {
+		trx := manager.db.NewTransaction(false)
+		defer trx.Discard()
+		intent, err := getIntent(trx, key)
+		assert.NoError(t, err)
+		assert.Nil(t, intent, "no intent should be left on the key")
+	}
+
+	first := conditionalWrite("first", 1000, nil)
+	assert.NoError(t, store1.SetValue(first))
+	assert.NoError(t, store2.SetValue(conditionalWrite("other", 1500, nil)))
+
+	// the precondition only holds on store-1, so the write fails the write quorum and is aborted on store-1 too
+	_, err := coordinator.writeRequest(conditionalWrite("second", 2000, &rpc.RpcPrecondition{IfVersion: first.Hlc}), 2)
+	var conflict *preconditionError
+	assert.Equal(t, true, errors.As(err, &conflict), "precondition should fail on the write quorum")
+	value, err := store1.GetValue("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(value.Value), "replica whose precondition held should not keep the write")
+	noIntent(store1, "key")
+	noIntent(store2, "key")
+
+	for _, store := range []*Manager{store1, store2} {
+		value := conditionalWrite("first", 1000, nil)
+		value.Key = "key2"
+		assert.NoError(t, store.SetValue(value))
+	}
+	second := conditionalWrite("second", 3000, &rpc.RpcPrecondition{IfVersion: first.Hlc})
+	second.Key = "key2"
+	_, err = coordinator.writeRequest(second, 2)
+	assert.NoError(t, err, "precondition should hold on the write quorum")
+	for _, store := range []*Manager{store1, store2} {
+		value, err := store.GetValue("key2")
+		assert.NoError(t, err)
+		assert.Equal(t, "second", string(value.Value), "write should be committed on every replica")
+		assert.Nil(t, value.Precondition, "precondition should not be stored")
+		noIntent(store, "key2")
+	}
+}
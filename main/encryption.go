@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/storage"
+)
+
+// skipUnreadable reports if the entry it is at cannot be read, such as a record which no configured key decrypts.
+// the entry is logged and the caller skips it.
+func skipUnreadable(it storage.Iterator, caller string) bool {
+	err := it.Error()
+	if err == nil {
+		return false
+	}
+	logrus.Errorf("%s skipping unreadable key = %q err = %v", caller, it.Key(), err)
+	return true
+}
+
+// reencryptBatchSize is the number of records rewritten per transaction after the encryption keys are rotated.
+const reencryptBatchSize = 1000
+
+// reencryptStorage reloads the encryption keys and rewrites the records which are not encrypted with the active key.
+// a rotation of the key file is completed without a restart.
+func (m *Manager) reencryptStorage() (int, error) {
+	encrypted, ok := m.db.(storage.EncryptedStorage)
+	if !ok {
+		return 0, nil
+	}
+	keys, err := storage.LoadEncryptionKeys(m.config.Storage)
+	if err != nil {
+		return 0, err
+	}
+	err = encrypted.ReloadKeys(keys)
+	if err != nil {
+		return 0, err
+	}
+	reencrypted, err := encrypted.Reencrypt(reencryptBatchSize)
+	storageReencryptedCounter.Add(float64(reencrypted))
+	if err != nil {
+		return reencrypted, err
+	}
+	if reencrypted > 0 {
+		logrus.Infof("reencrypted %d records, every record uses the active encryption key", reencrypted)
+	}
+	return reencrypted, nil
+}
+
+// startReencryptWorker reencrypts the storage on start and then every ReencryptInterval.
+func (m *Manager) startReencryptWorker() {
+	for {
+		_, err := m.reencryptStorage()
+		if err != nil {
+			logrus.Errorf("reencryptStorage err = %v", err)
+		}
+		<-m.reencryptTick.C
+	}
+}
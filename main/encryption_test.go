@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func TestReencryptStorage(t *testing.T) {
+	oldKey := "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	newKey := "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Storage.Engine = "leveldb"
+	c.Storage.EncryptionKeys = oldKey
+	manager := NewManager(c)
+	assert.IsType(t, storage.EncryptedStorage{}, manager.db, "storage should be encrypted")
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "k", Value: []byte("v"), Hlc: rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: 1})})
+	assert.NoError(t, err)
+	reencrypted, err := manager.reencryptStorage()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reencrypted, "records should use the active key")
+	assert.NoError(t, manager.db.Close())
+
+	c.Storage.EncryptionKeys = newKey + "," + oldKey
+	manager = NewManager(c)
+	reencrypted, err = manager.reencryptStorage()
+	assert.NoError(t, err)
+	assert.Greater(t, reencrypted, 0, "records should be reencrypted with the new key")
+	assert.NoError(t, manager.db.Close())
+
+	c.Storage.EncryptionKeys = newKey
+	manager = NewManager(c)
+	value, err := manager.GetValue("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", string(value.Value), "value wrong value")
+}
+
+func TestReencryptStorageReloadsKeys(t *testing.T) {
+	oldKey := "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	newKey := "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Storage.Engine = "memory"
+	c.Storage.EncryptionKeys = oldKey
+	manager := NewManager(c)
+	err := manager.SetValue(&rpc.RpcValue{Key: "k", Value: []byte("v"), Hlc: rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: 1})})
+	assert.NoError(t, err)
+
+	// the keys are rotated while the node runs
+	manager.config.Storage.EncryptionKeys = newKey + "," + oldKey
+	reencrypted, err := manager.reencryptStorage()
+	assert.NoError(t, err)
+	assert.Greater(t, reencrypted, 0, "records should be reencrypted with the reloaded key")
+
+	manager.config.Storage.EncryptionKeys = newKey
+	reencrypted, err = manager.reencryptStorage()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reencrypted, "records should use the active key")
+	value, err := manager.GetValue("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", string(value.Value), "value wrong value")
+}
+
+func TestScanSkipsUnreadable(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+
+	keys, err := storage.LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="})
+	assert.NoError(t, err)
+	plain := storage.NewMemoryStorage()
+	manager.db, err = storage.NewEncryptedStorage(plain, keys)
+	assert.NoError(t, err)
+
+	for _, key := range []string{"a", "b"} {
+		err = manager.SetValue(&rpc.RpcValue{Key: key, Value: []byte("v"), Hlc: rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: 1})})
+		assert.NoError(t, err)
+	}
+	// the record of a is not encrypted with a configured key
+	keyIndex, err := BuildKeyIndex("a")
+	assert.NoError(t, err)
+	err = plain.Put([]byte(keyIndex), []byte("plain"))
+	assert.NoError(t, err)
+
+	resCh := make(chan interface{}, 10)
+	err = manager.scanLocal("", "", 10, []int32{0}, resCh)
+	assert.NoError(t, err)
+	close(resCh)
+	var scanned []string
+	for res := range resCh {
+		scanned = append(scanned, res.(*rpc.RpcValue).Key)
+	}
+	assert.Equal(t, []string{"b"}, scanned, "the unreadable value should be skipped")
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gogo/status"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+)
+
+var ErrTooManyHints = errors.New("too many hints stored")
+
+type hintEntry struct {
+	index string
+	value *rpc.RpcValue
+}
+
+const maxHintCreated = 9999999999
+
+func countHints(db storage.Storage) int64 {
+	var count int64
+	it := db.NewIterator(hintIndexType.Start(), hintIndexType.Limit(), false)
+	for !it.IsDone() {
+		count++
+		it.Next()
+	}
+	it.Release()
+	return count
+}
+
+// isHintable reports if a write failed because the member is unavailable, rather than rejected by it.
+func isHintable(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	return st.Code() == codes.Unavailable || st.Code() == codes.DeadlineExceeded
+}
+
+// isSuperseded reports if a member rejected a write because it already holds a newer value.
+func isSuperseded(err error) bool {
+	st, ok := status.FromError(err)
+	return ok && st.Code() == codes.AlreadyExists
+}
+
+// hintsLock serializes the writes and deletes of hints, so concurrent hints cannot each pass the MaxHints check.
+var hintsLock sync.Mutex
+
+// StoreHint keeps a write for member so it can be replayed once the member is available again.
+func (m *Manager) StoreHint(member string, value *rpc.RpcValue) error {
+	index, err := BuildHintIndex(member, value.UnixTimestamp, value.Key)
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	hintsLock.Lock()
+	defer hintsLock.Unlock()
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	existingData, err := trx.Get([]byte(index))
+	if err == nil {
+		// writes of the same key in the same second share a hint, it keeps the newest of them
+		existing := &rpc.RpcValue{}
+		err = proto.Unmarshal(existingData, existing)
+		if err != nil {
+			return errors.Wrap(err, "StoreHint Unmarshal")
+		}
+		if rpc.ValueHybridTimestamp(existing).Compare(rpc.ValueHybridTimestamp(value)) >= 0 {
+			return nil
+		}
+		err = trx.Set([]byte(index), data)
+		if err != nil {
+			return err
+		}
+		return trx.Commit()
+	} else if err != storage.KEY_NOT_FOUND {
+		return err
+	}
+	if m.hintCount.Load() >= int64(m.config.Manager.MaxHints) {
+		hintsDroppedCounter.Inc()
+		return ErrTooManyHints
+	}
+	err = trx.Set([]byte(index), data)
+	if err != nil {
+		return err
+	}
+	err = trx.Commit()
+	if err != nil {
+		return err
+	}
+	m.hintCount.Add(1)
+	hintsStoredCounter.Inc()
+	return nil
+}
+
+// handoffWrite stores a hint for a member which could not be written to.
+// it reports if the hint counts toward the write quorum.
+func (m *Manager) handoffWrite(member string, value *rpc.RpcValue) bool {
+	// a hint cannot check the precondition of a conditional write
+	if !m.config.Manager.HintedHandoff || value.Precondition != nil {
+		return false
+	}
+	err := m.StoreHint(member, value)
+	if err != nil {
+		logrus.Warnf("StoreHint member = %s key = %s err = %v", member, value.Key, err)
+		return false
+	}
+	return m.config.Manager.SloppyQuorum
+}
+
+func (m *Manager) deleteHint(index string) error {
+	hintsLock.Lock()
+	defer hintsLock.Unlock()
+	err := m.db.Delete([]byte(index))
+	if err != nil {
+		return err
+	}
+	m.hintCount.Add(-1)
+	return nil
+}
+
+// ReplayHints sends the hints held for member to it. expired hints are dropped even if the member is unavailable.
+func (m *Manager) ReplayHints(member string) (int, error) {
+	start, err := BuildHintIndex(member, 0, "")
+	if err != nil {
+		return 0, err
+	}
+	limit, err := BuildHintIndex(member, maxHintCreated, "")
+	if err != nil {
+		return 0, err
+	}
+	var hints []hintEntry
+	it := m.db.NewIterator([]byte(start), []byte(limit), false)
+	for !it.IsDone() {
+		if skipUnreadable(it, "ReplayHints") {
+			it.Next()
+			continue
+		}
+		value := &rpc.RpcValue{}
+		err = proto.Unmarshal(it.Value(), value)
+		if err != nil {
+			it.Release()
+			return 0, errors.Wrap(err, "ReplayHints Unmarshal")
+		}
+		hints = append(hints, hintEntry{index: string(it.Key()), value: value})
+		it.Next()
+	}
+	it.Release()
+
+	client, clientErr := m.clientManager.GetClient(member)
+	cutoff := time.Now().Unix() - int64(m.config.Manager.HintTtl)
+	replayed := 0
+	for _, hint := range hints {
+		if hint.value.UnixTimestamp < cutoff {
+			hintsDroppedCounter.Inc()
+			err = m.deleteHint(hint.index)
+			if err != nil {
+				return replayed, err
+			}
+			continue
+		}
+		if clientErr != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+		hint.value.Repair = true
+		_, err = client.SetRequest(ctx, hint.value)
+		cancel()
+		if err != nil && isHintable(err) {
+			return replayed, errors.Wrap(err, "ReplayHints SetRequest")
+		}
+		if err != nil && !isSuperseded(err) {
+			// the hint is kept and retried until it expires
+			logrus.Warnf("ReplayHints member = %s key = %s err = %v", member, hint.value.Key, err)
+			continue
+		}
+		if err != nil {
+			logrus.Debugf("ReplayHints member = %s key = %s already holds a newer value", member, hint.value.Key)
+		} else {
+			replayed++
+			hintsReplayedCounter.Inc()
+		}
+		err = m.deleteHint(hint.index)
+		if err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+func (m *Manager) replayAllHints() {
+	members := make(map[string]bool)
+	it := m.db.NewIterator(hintIndexType.Start(), hintIndexType.Limit(), false)
+	for !it.IsDone() {
+		member, err := ParseHintMember(string(it.Key()))
+		if err != nil {
+			logrus.Errorf("replayAllHints err = %v", err)
+		} else {
+			members[member] = true
+		}
+		it.Next()
+	}
+	it.Release()
+
+	for member := range members {
+		replayed, err := m.ReplayHints(member)
+		if err != nil {
+			logrus.Debugf("ReplayHints member = %s err = %v", member, err)
+		}
+		if replayed > 0 {
+			logrus.Infof("replayed %d hints to %s", replayed, member)
+		}
+	}
+}
+
+// startHintWorker replays hints when a member joins and periodically retries the rest.
+func (m *Manager) startHintWorker() {
+	for {
+		select {
+		case member := <-m.hintCh:
+			replayed, err := m.ReplayHints(member)
+			if err != nil {
+				logrus.Debugf("ReplayHints member = %s err = %v", member, err)
+			}
+			if replayed > 0 {
+				logrus.Infof("replayed %d hints to %s", replayed, member)
+			}
+		case <-m.hintTick.C:
+			m.replayAllHints()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func TestHints(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	c.Manager.MaxHints = 2
+	c.Manager.HintTtl = 60
+	manager := NewManager(c)
+
+	now := time.Now().Unix()
+	err := manager.StoreHint("store-1", &rpc.RpcValue{Key: "fresh", Value: []byte("v"), Epoch: 1, UnixTimestamp: now})
+	assert.NoError(t, err)
+	err = manager.StoreHint("store-1", &rpc.RpcValue{Key: "expired", Value: []byte("v"), Epoch: 1, UnixTimestamp: now - 120})
+	assert.NoError(t, err)
+	err = manager.StoreHint("store-2", &rpc.RpcValue{Key: "capped", Value: []byte("v"), Epoch: 1, UnixTimestamp: now})
+	assert.ErrorIs(t, err, ErrTooManyHints)
+	// replacing an existing hint does not count toward the limit
+	newer := utils.HybridTimestamp{Physical: now*1000 + 500, Node: "store-0"}
+	err = manager.StoreHint("store-1", &rpc.RpcValue{Key: "fresh", Value: []byte("v2"), Epoch: 1, UnixTimestamp: now, Hlc: rpc.NewRpcHybridTimestamp(newer)})
+	assert.NoError(t, err)
+	// an older write of the same second does not replace the hint
+	older := utils.HybridTimestamp{Physical: now*1000 + 100, Node: "store-0"}
+	err = manager.StoreHint("store-1", &rpc.RpcValue{Key: "fresh", Value: []byte("v1"), Epoch: 1, UnixTimestamp: now, Hlc: rpc.NewRpcHybridTimestamp(older)})
+	assert.NoError(t, err)
+	index, err := BuildHintIndex("store-1", now, "fresh")
+	assert.NoError(t, err)
+	data, err := manager.db.Get([]byte(index))
+	assert.NoError(t, err)
+	hint := &rpc.RpcValue{}
+	assert.NoError(t, proto.Unmarshal(data, hint))
+	assert.Equal(t, "v2", string(hint.Value), "hint should keep the newest write")
+	assert.EqualValues(t, 2, manager.hintCount.Load(), "hintCount wrong value")
+	assert.EqualValues(t, 2, countHints(manager.db), "countHints wrong value")
+
+	// store-1 is unavailable so only the expired hint is dropped
+	replayed, err := manager.ReplayHints("store-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, replayed, "nothing should be replayed")
+	assert.EqualValues(t, 1, manager.hintCount.Load(), "hintCount wrong value")
+	assert.EqualValues(t, 1, countHints(manager.db), "countHints wrong value")
+}
+
+func TestReplayHintsLaggingEpoch(t *testing.T) {
+	c := config.GetConfig()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	c.Manager.HintTtl = 3600
+	member, client := startTestNode(t, c)
+	// the member moved on several epochs while the hints were held
+	member.SetCurrentEpoch(10)
+
+	c.Storage.DataPath = t.TempDir()
+	manager := NewManager(c)
+	manager.clientManager.AddClient("store-1", client)
+
+	now := time.Now().Unix()
+	err := manager.StoreHint("store-1", &rpc.RpcValue{Key: "key", Value: []byte("hinted"), Epoch: 1, UnixTimestamp: now - 1800})
+	assert.NoError(t, err)
+	replayed, err := manager.ReplayHints("store-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replayed, "hint of a lagging epoch should be replayed")
+	value, err := member.GetValue("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hinted", string(value.Value), "member should hold the hinted value")
+	assert.EqualValues(t, 1, value.Epoch, "hint should keep its epoch")
+	assert.Equal(t, false, value.Repair, "repair flag should not be stored")
+
+	// the member already holds a newer value, so the hint is dropped
+	err = manager.StoreHint("store-1", &rpc.RpcValue{Key: "key", Value: []byte("older"), Epoch: 1, UnixTimestamp: now - 3000})
+	assert.NoError(t, err)
+	replayed, err = manager.ReplayHints("store-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, replayed, "older hint should not be replayed")
+	assert.EqualValues(t, 0, countHints(manager.db), "superseded hint should be deleted")
+	value, err = member.GetValue("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "hinted", string(value.Value), "member should keep the newer value")
+}
+
+func TestStoreHintsConcurrently(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.MaxHints = 5
+	manager := NewManager(c)
+
+	now := time.Now().Unix()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			manager.StoreHint("store-1", &rpc.RpcValue{Key: fmt.Sprintf("key%d", i), Value: []byte("v"), Epoch: 1, UnixTimestamp: now})
+		}(i)
+	}
+	wg.Wait()
+	assert.EqualValues(t, 5, manager.hintCount.Load(), "hintCount wrong value")
+	assert.EqualValues(t, 5, countHints(manager.db), "hints past MaxHints should be dropped")
+}
+
+func TestHintIndex(t *testing.T) {
+	index, err := BuildHintIndex("store-1", 123, "key_with_separator")
+	assert.NoError(t, err)
+
+	member, err := ParseHintMember(index)
+	assert.NoError(t, err)
+	assert.Equal(t, "store-1", member, "member wrong value")
+
+	keyIndex, err := BuildKeyIndex("key")
+	assert.NoError(t, err)
+	_, err = ParseHintMember(keyIndex)
+	assert.Error(t, err)
+}
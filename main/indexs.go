@@ -1,17 +1,33 @@
 package main
 
 import (
+	"encoding/binary"
 	"strconv"
 
 	"github.com/pkg/errors"
 
 	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
 )
 
 var epochLength = 10
 
+// the types of the index entries, every type has its own prefix so user keys and internal records never share a range.
+// the prefixes are part of the storage format, they must not be changed or reused.
+var (
+	epochIndexType        = storage.IndexType{Name: "epoch", Prefix: 0x01}
+	epochTreeIndexType    = storage.IndexType{Name: "epochtree", Prefix: 0x02}
+	keyIndexType          = storage.IndexType{Name: "item", Prefix: 0x03}
+	hintIndexType         = storage.IndexType{Name: "hint", Prefix: 0x04}
+	intentIndexType       = storage.IndexType{Name: "intent", Prefix: 0x05}
+	txnRecordIndexType    = storage.IndexType{Name: "txn", Prefix: 0x06}
+	secondaryIndexType    = storage.IndexType{Name: "idx", Prefix: 0x07}
+	secondaryIndexDefType = storage.IndexType{Name: "idxdef", Prefix: 0x08}
+	namespaceUsageType    = storage.IndexType{Name: "nsusage", Prefix: 0x09}
+)
+
 func BuildEpochIndex(parition int, bucket uint64, epoch int64, key string) (string, error) { // TODO create an itorator for lowerEpoch to upperEpoch
-	return storage.NewIndex("epoch").
+	return storage.NewIndex(epochIndexType).
 		AddColumn(storage.CreateUnorderedColumn("parition", strconv.FormatInt(int64(parition), 10))).
 		AddColumn(storage.CreateUnorderedColumn("bucket", strconv.FormatUint(bucket, 10))).
 		AddColumn(storage.CreateOrderedColumn("epoch", strconv.FormatInt(epoch, 10), epochLength)).
@@ -20,7 +36,7 @@ func BuildEpochIndex(parition int, bucket uint64, epoch int64, key string) (stri
 }
 
 func ParseEpochIndex(indexStr string) (int, uint64, int64, string, error) {
-	indexMap, err := storage.NewIndex("epoch").
+	indexMap, err := storage.NewIndex(epochIndexType).
 		AddColumn(storage.CreateUnorderedColumn("parition", "1")).
 		AddColumn(storage.CreateUnorderedColumn("bucket", "1")).
 		AddColumn(storage.CreateOrderedColumn("epoch", "1", epochLength)).
@@ -28,9 +44,6 @@ func ParseEpochIndex(indexStr string) (int, uint64, int64, string, error) {
 	if err != nil {
 		return 0, 0, 0, "", err
 	}
-	if indexMap["name"] != "epoch" {
-		return 0, 0, 0, "", errors.New("index name is wrong")
-	}
 	parition, err := strconv.ParseInt(indexMap["parition"], 10, 64)
 	if err != nil {
 		return 0, 0, 0, "", err
@@ -47,14 +60,187 @@ func ParseEpochIndex(indexStr string) (int, uint64, int64, string, error) {
 	return int(parition), uint64(bucket), epoch, key, nil
 }
 
+// flags of an epoch index value
+const (
+	tombstoneMarker = byte(1)
+	expiryMarker    = byte(2)
+)
+
+// epochIndexValueHeader is the physical millis, logical counter and flags preceding the expiry and node id.
+const epochIndexValueHeader = 8 + 4 + 1
+
+// BuildEpochIndexValue encodes the version stored under an epoch index entry.
+// tombstones carry a marker so they hash differently in the merkle tree.
+// values with a ttl carry their expiry in unix millis, zero means the value does not expire.
+func BuildEpochIndexValue(version utils.HybridTimestamp, deleted bool, expiresAt int64) ([]byte, error) {
+	data := make([]byte, epochIndexValueHeader, epochIndexValueHeader+8+len(version.Node))
+	binary.LittleEndian.PutUint64(data[0:8], uint64(version.Physical))
+	binary.LittleEndian.PutUint32(data[8:12], uint32(version.Logical))
+	if deleted {
+		data[12] |= tombstoneMarker
+	}
+	if expiresAt != 0 {
+		data[12] |= expiryMarker
+		data = binary.LittleEndian.AppendUint64(data, uint64(expiresAt))
+	}
+	return append(data, version.Node...), nil
+}
+
+// ParseEpochIndexValue decodes an epoch index entry into its version, tombstone marker and expiry.
+// entries written before hybrid clocks only hold a unix timestamp in seconds.
+func ParseEpochIndexValue(data []byte) (utils.HybridTimestamp, bool, int64, error) {
+	if len(data) == 8 || len(data) == 9 {
+		unixTimestamp, err := utils.DecodeBytesToInt64(data[:8])
+		if err != nil {
+			return utils.HybridTimestamp{}, false, 0, err
+		}
+		deleted := len(data) == 9 && data[8] == tombstoneMarker
+		return utils.HybridTimestamp{Physical: unixTimestamp * 1000}, deleted, 0, nil
+	}
+	if len(data) < epochIndexValueHeader {
+		return utils.HybridTimestamp{}, false, 0, errors.Errorf("epoch index value too short: %d", len(data))
+	}
+	flags := data[12]
+	nodeStart := epochIndexValueHeader
+	var expiresAt int64
+	if flags&expiryMarker != 0 {
+		nodeStart += 8
+		if len(data) < nodeStart {
+			return utils.HybridTimestamp{}, false, 0, errors.Errorf("epoch index value too short for expiry: %d", len(data))
+		}
+		expiresAt = int64(binary.LittleEndian.Uint64(data[epochIndexValueHeader:nodeStart]))
+	}
+	version := utils.HybridTimestamp{
+		Physical: int64(binary.LittleEndian.Uint64(data[0:8])),
+		Logical:  int32(binary.LittleEndian.Uint32(data[8:12])),
+		Node:     string(data[nodeStart:]),
+	}
+	return version, flags&tombstoneMarker != 0, expiresAt, nil
+}
+
+var hintCreatedLength = 10
+
+// BuildHintIndex builds the index of a write held for a member which was unavailable.
+func BuildHintIndex(member string, created int64, key string) (string, error) {
+	return storage.NewIndex(hintIndexType).
+		AddColumn(storage.CreateUnorderedColumn("member", member)).
+		AddColumn(storage.CreateOrderedColumn("created", strconv.FormatInt(created, 10), hintCreatedLength)).
+		AddColumn(storage.CreateUnorderedColumn("key", key)).
+		Build()
+}
+
+// ParseHintMember returns the member of a hint index.
+func ParseHintMember(index string) (string, error) {
+	indexMap, err := storage.NewIndex(hintIndexType).
+		AddColumn(storage.CreateUnorderedColumn("member", "")).
+		AddColumn(storage.CreateOrderedColumn("created", "0", hintCreatedLength)).
+		AddColumn(storage.CreateUnorderedColumn("key", "")).Parse(index)
+	if err != nil {
+		return "", err
+	}
+	return indexMap["member"], nil
+}
+
 func BuildKeyIndex(key string) (string, error) {
-	return storage.NewIndex("item").
+	return storage.NewIndex(keyIndexType).
+		AddColumn(storage.CreateUnorderedColumn("key", key)).
+		Build()
+}
+
+// BuildIntentIndex builds the index of the intent a transaction staged on key.
+func BuildIntentIndex(key string) (string, error) {
+	return storage.NewIndex(intentIndexType).
+		AddColumn(storage.CreateUnorderedColumn("key", key)).
+		Build()
+}
+
+// ParseIntentKey returns the key of an intent index.
+func ParseIntentKey(index string) (string, error) {
+	indexMap, err := storage.NewIndex(intentIndexType).
+		AddColumn(storage.CreateUnorderedColumn("key", "")).Parse(index)
+	if err != nil {
+		return "", err
+	}
+	return indexMap["key"], nil
+}
+
+// BuildTxnRecordIndex builds the index of the outcome of a transaction.
+func BuildTxnRecordIndex(txnId string) (string, error) {
+	return storage.NewIndex(txnRecordIndexType).
+		AddColumn(storage.CreateUnorderedColumn("id", txnId)).
+		Build()
+}
+
+// BuildSecondaryIndex builds the entry of key under value in the secondary index name.
+func BuildSecondaryIndex(name, value, key string) (string, error) {
+	return storage.NewIndex(secondaryIndexType).
+		AddColumn(storage.CreateUnorderedColumn("index", name)).
+		AddColumn(storage.CreateUnorderedColumn("value", value)).
 		AddColumn(storage.CreateUnorderedColumn("key", key)).
 		Build()
 }
 
+// BuildSecondaryIndexPrefix builds the start of the entries of every key under value in the secondary index name.
+func BuildSecondaryIndexPrefix(name, value string) (string, error) {
+	return storage.NewIndex(secondaryIndexType).
+		AddColumn(storage.CreateUnorderedColumn("index", name)).
+		AddColumn(storage.CreateUnorderedColumn("value", value)).
+		Build()
+}
+
+// ParseSecondaryIndexKey returns the key of a secondary index entry.
+func ParseSecondaryIndexKey(index string) (string, error) {
+	indexMap, err := storage.NewIndex(secondaryIndexType).
+		AddColumn(storage.CreateUnorderedColumn("index", "")).
+		AddColumn(storage.CreateUnorderedColumn("value", "")).
+		AddColumn(storage.CreateUnorderedColumn("key", "")).Parse(index)
+	if err != nil {
+		return "", err
+	}
+	return indexMap["key"], nil
+}
+
+// BuildSecondaryIndexDefIndex builds the index of the definition a secondary index was last built with.
+func BuildSecondaryIndexDefIndex(name string) (string, error) {
+	return storage.NewIndex(secondaryIndexDefType).
+		AddColumn(storage.CreateUnorderedColumn("index", name)).
+		Build()
+}
+
+// ParseSecondaryIndexDefName returns the name of the secondary index of a definition index.
+func ParseSecondaryIndexDefName(index string) (string, error) {
+	indexMap, err := storage.NewIndex(secondaryIndexDefType).
+		AddColumn(storage.CreateUnorderedColumn("index", "")).Parse(index)
+	if err != nil {
+		return "", err
+	}
+	return indexMap["index"], nil
+}
+
+var namespaceUsageSeqLength = 19
+
+// BuildNamespaceUsageIndex builds the index of a change to the keys and bytes this node stores for a namespace.
+// the usage of a namespace is the sum of its changes.
+func BuildNamespaceUsageIndex(namespace string, seq int64) (string, error) {
+	return storage.NewIndex(namespaceUsageType).
+		AddColumn(storage.CreateUnorderedColumn("namespace", namespace)).
+		AddColumn(storage.CreateOrderedColumn("seq", strconv.FormatInt(seq, 10), namespaceUsageSeqLength)).
+		Build()
+}
+
+// ParseNamespaceUsageSeq returns the sequence number of a namespace usage index.
+func ParseNamespaceUsageSeq(index string) (int64, error) {
+	indexMap, err := storage.NewIndex(namespaceUsageType).
+		AddColumn(storage.CreateUnorderedColumn("namespace", "")).
+		AddColumn(storage.CreateOrderedColumn("seq", "0", namespaceUsageSeqLength)).Parse(index)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(indexMap["seq"], 10, 64)
+}
+
 func BuildEpochTreeObjectIndex(partitionId int, epoch int64) (string, error) {
-	return storage.NewIndex("epochtree").
+	return storage.NewIndex(epochTreeIndexType).
 		AddColumn(storage.CreateUnorderedColumn("partition", strconv.FormatInt(int64(partitionId), 10))).
 		AddColumn(storage.CreateOrderedColumn("epoch", strconv.FormatInt(epoch, 10), epochLength)).
 		Build()
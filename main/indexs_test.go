@@ -4,18 +4,19 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/utils"
 )
 
 func TestIndexManager(t *testing.T) {
 	parition := 1
 	bucket := uint64(2)
 	epoch := int64(3)
-	key := "zz"
+	key := "z_z"
 	index1, err := BuildEpochIndex(parition, bucket, epoch, key)
 	if err != nil {
 		t.Error(err)
 	}
-	assert.Equal(t, "epoch_1_2_0000000003_zz", index1, "equal index")
 	paritionParsed, bucketParsed, epochParsed, keyParsed, err := ParseEpochIndex(index1)
 	if err != nil {
 		t.Error(err)
@@ -25,9 +26,84 @@ func TestIndexManager(t *testing.T) {
 	assert.Equal(t, epoch, epochParsed, "parsed epoch")
 	assert.Equal(t, key, keyParsed, "parsed key")
 
+	// the range of an epoch holds no other partition, even if the partition is a prefix of it
+	lower, err := BuildEpochIndex(parition, bucket, epoch, "")
+	if err != nil {
+		t.Error(err)
+	}
+	upper, err := BuildEpochIndex(parition, bucket, epoch+1, "")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.True(t, lower <= index1 && index1 < upper, "index in the range of its epoch")
+	otherPartition, err := BuildEpochIndex(11, bucket, epoch, key)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.False(t, lower <= otherPartition && otherPartition < upper, "other partition outside the range")
+
+	// internal records never share a range with user keys
 	index2, err := BuildEpochTreeObjectIndex(1, 2)
 	if err != nil {
 		t.Error(err)
 	}
-	assert.Equal(t, "epochtree_1_0000000002", index2, "equal index")
+	keyIndex, err := BuildKeyIndex("epochtree_1_0000000002")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.NotEqual(t, index2, keyIndex, "epoch tree index should not collide with a key")
+	assert.True(t, keyIndex >= string(keyIndexType.Start()) && keyIndex < string(keyIndexType.Limit()), "key index in the range of its type")
+	assert.False(t, index2 >= string(keyIndexType.Start()) && index2 < string(keyIndexType.Limit()), "epoch tree index outside the range of the keys")
+}
+
+func TestEpochIndexValue(t *testing.T) {
+	version := utils.HybridTimestamp{Physical: 123456, Logical: 7, Node: "store-0"}
+	valueBytes, err := BuildEpochIndexValue(version, false, 0)
+	if err != nil {
+		t.Error(err)
+	}
+	tombstoneBytes, err := BuildEpochIndexValue(version, true, 0)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.NotEqual(t, valueBytes, tombstoneBytes, "tombstone should encode differently")
+
+	parsed, deleted, expiresAt, err := ParseEpochIndexValue(valueBytes)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, version, parsed, "parsed version")
+	assert.Equal(t, false, deleted, "parsed deleted")
+	assert.EqualValues(t, 0, expiresAt, "parsed expiresAt")
+
+	parsed, deleted, expiresAt, err = ParseEpochIndexValue(tombstoneBytes)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, version, parsed, "parsed tombstone version")
+	assert.Equal(t, true, deleted, "parsed tombstone deleted")
+
+	legacyBytes, err := utils.EncodeInt64ToBytes(123)
+	if err != nil {
+		t.Error(err)
+	}
+	parsed, deleted, expiresAt, err = ParseEpochIndexValue(legacyBytes)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, utils.HybridTimestamp{Physical: 123000}, parsed, "parsed legacy version")
+	assert.Equal(t, false, deleted, "parsed legacy deleted")
+
+	expiringBytes, err := BuildEpochIndexValue(version, false, 999000)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.NotEqual(t, valueBytes, expiringBytes, "expiring value should encode differently")
+	parsed, deleted, expiresAt, err = ParseEpochIndexValue(expiringBytes)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, version, parsed, "parsed expiring version")
+	assert.Equal(t, false, deleted, "parsed expiring deleted")
+	assert.EqualValues(t, 999000, expiresAt, "parsed expiring expiresAt")
 }
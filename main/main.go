@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/sirupsen/logrus"
 
 	"github.com/andrew-delph/my-key-store/config"
@@ -19,6 +21,13 @@ func main() {
 	// })
 
 	c := config.GetConfig()
+
+	// migrate rewrites the data directory in the current storage format while the node is stopped
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(c)
+		return
+	}
+
 	initMetrics(c.Manager.Hostname)
 
 	logrus.Warnf("OPERATOR MODE: %v", c.Manager.Operator)
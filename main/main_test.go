@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain registers the metrics before any test creates a manager, which updates them from its workers.
+func TestMain(m *testing.M) {
+	initMetrics("main_test")
+	os.Exit(m.Run())
+}
@@ -10,7 +10,9 @@ import (
 	"os/signal"
 	"reflect"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -31,21 +33,38 @@ import (
 	"github.com/andrew-delph/my-key-store/utils"
 )
 
+// ErrNewerValue is returned by a replica which already holds a value that wins over the write.
+var ErrNewerValue = errors.New("a newer value already exists")
+
 type Manager struct {
 	config                config.Config
 	reqCh                 chan interface{}
 	db                    storage.Storage
 	httpServer            *http.HttpServer
+	grpcServer            *http.GrpcServer
 	gossipCluster         *gossip.GossipCluster
 	consensusCluster      *consensus.ConsensusCluster
+	partitionGroups       *consensus.PartitionGroups
 	ring                  *hashring.Hashring
 	rpcWrapper            *rpc.RpcWrapper
 	myPartitions          *utils.IntSet
 	consistencyController *ConsistencyController
 	clientManager         *ClientManager
+	clock                 *utils.HybridClock
+	changeFeed            *ChangeFeed
+	hintCount             *atomic.Int64
+	hintCh                chan string
+	namespaceUsageSeq     *atomic.Int64
+	namespaces            map[string]*rpc.RpcNamespace
+	codec                 utils.Codec
 
 	debugTick         *time.Ticker
 	epochTick         *time.Ticker
+	tombstoneTick     *time.Ticker
+	hintTick          *time.Ticker
+	ttlTick           *time.Ticker
+	txnTick           *time.Ticker
+	reencryptTick     *time.Ticker
 	CurrentEpoch      int64
 	LastEpochUpdateId string
 }
@@ -55,31 +74,65 @@ func NewManager(c config.Config) Manager {
 
 	httpServer := http.CreateHttpServer(c.Http, reqCh)
 	gossipCluster := gossip.CreateGossipCluster(c.Gossip, reqCh)
-	db := storage.NewBadgerStorage(c.Storage)
-	// db := storage.NewLevelDbStorage(c.Storage)
+	db, err := storage.NewStorage(c.Storage)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	err = storage.CheckFormatVersion(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	codec, err := utils.ParseCodec(c.Storage.Compression)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 	consensusCluster := consensus.CreateConsensusCluster(c.Consensus, reqCh)
+	partitionGroups := consensus.CreatePartitionGroups(c.Consensus, c.Manager.Hostname, reqCh)
 	ring := hashring.CreateHashring(c.Manager, reqCh)
+	grpcServer := http.CreateGrpcServer(c.Http, reqCh, ring.Version)
 
-	rpcWrapper := rpc.CreateRpcWrapper(c.Rpc, reqCh)
+	clock := utils.NewHybridClock(c.Manager.Hostname)
+	rpcWrapper := rpc.CreateRpcWrapper(c.Rpc, reqCh, clock, func() []string {
+		return append(ring.GetMembersNames(false), ring.GetMembersNames(true)...)
+	})
 	parts := utils.NewIntSet()
 
 	clientManager := NewClientManager()
 
 	consistencyController := NewConsistencyController(c.Manager.PartitionConcurrency, c.Manager.PartitionCount, reqCh)
+
+	hintCount := &atomic.Int64{}
+	hintCount.Store(countHints(db))
+	namespaceUsageSeq := &atomic.Int64{}
+	namespaceUsageSeq.Store(lastNamespaceUsageSeq(db))
 	return Manager{
 		config:                c,
 		reqCh:                 reqCh,
 		db:                    db,
 		httpServer:            &httpServer,
+		grpcServer:            grpcServer,
 		gossipCluster:         gossipCluster,
 		consensusCluster:      consensusCluster,
+		partitionGroups:       partitionGroups,
 		ring:                  ring,
 		rpcWrapper:            rpcWrapper,
 		myPartitions:          &parts,
 		consistencyController: consistencyController,
 		clientManager:         clientManager,
+		clock:                 clock,
+		changeFeed:            NewChangeFeed(),
+		hintCount:             hintCount,
+		hintCh:                make(chan string, c.Manager.ReqChannelSize),
+		namespaceUsageSeq:     namespaceUsageSeq,
+		namespaces:            make(map[string]*rpc.RpcNamespace),
+		codec:                 codec,
 		debugTick:             time.NewTicker(time.Second * 5),
 		epochTick:             time.NewTicker(time.Duration(c.Consensus.EpochTime) * time.Second),
+		tombstoneTick:         time.NewTicker(time.Duration(c.Manager.TombstoneGcInterval) * time.Second),
+		hintTick:              time.NewTicker(time.Duration(c.Manager.HintReplayInterval) * time.Second),
+		ttlTick:               time.NewTicker(time.Duration(c.Manager.TtlSweepInterval) * time.Second),
+		txnTick:               time.NewTicker(time.Duration(c.Manager.TxnResolveInterval) * time.Second),
+		reencryptTick:         time.NewTicker(time.Duration(c.Manager.ReencryptInterval) * time.Second),
 	}
 }
 
@@ -101,15 +154,32 @@ func (m *Manager) StartManager() {
 	if m.config.Manager.PartitionBuckets%2 != 0 {
 		logrus.Fatalf("PartitionBuckets must be even. PartitionBuckets = %d", m.config.Manager.PartitionBuckets)
 	}
-	var err error
+	err := validateSecondaryIndexes(m.config.Manager.SecondaryIndexes)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	err = m.SyncSecondaryIndexes()
+	if err != nil {
+		logrus.Fatal(err)
+	}
 	go m.startWorkers()
 
+	go m.startHintWorker()
+
+	go m.startReencryptWorker()
+
 	go m.rpcWrapper.StartRpcServer()
 
 	err = m.consensusCluster.StartConsensusCluster()
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	if len(m.config.Manager.StrongPrefixes) > 0 {
+		err = m.partitionGroups.Start()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
 	err = m.gossipCluster.Join()
 	if err != nil {
 		logrus.Fatal(err)
@@ -117,6 +187,8 @@ func (m *Manager) StartManager() {
 
 	go m.httpServer.StartHttp()
 
+	go m.grpcServer.StartGrpc()
+
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM)
 	<-signals
@@ -145,6 +217,13 @@ func (m *Manager) StartManager() {
 		logrus.Errorf("Failed to http Shutdown err = %v", err)
 	}
 
+	err = m.grpcServer.Stop()
+	if err != nil {
+		logrus.Errorf("Failed to grpc Stop err = %v", err)
+	}
+
+	m.partitionGroups.Shutdown()
+
 	err = m.rpcWrapper.Stop()
 	if err != nil {
 		logrus.Errorf("Failed to rpc Stop err = %v", err)
@@ -212,6 +291,50 @@ func (m *Manager) startWorker(workerId int) {
 				}
 			}
 
+		case <-m.tombstoneTick.C:
+			compacted, err := m.compactNamespaceUsages()
+			if err != nil {
+				logrus.Errorf("compactNamespaceUsages err = %v", err)
+			}
+			logrus.Debugf("compacted %d namespace usage changes", compacted)
+
+			partitions, err := m.ring.GetMyPartions()
+			if err != nil {
+				logrus.Errorf("GetMyPartions err = %v", err)
+				continue
+			}
+			for _, partitionId := range partitions {
+				collected, err := m.CollectTombstones(partitionId)
+				if err != nil {
+					logrus.Errorf("CollectTombstones partition %d err = %v", partitionId, err)
+					continue
+				}
+				logrus.Debugf("collected %d tombstones partition %d", collected, partitionId)
+			}
+
+		case <-m.ttlTick.C:
+			partitions, err := m.ring.GetMyPartions()
+			if err != nil {
+				logrus.Errorf("GetMyPartions err = %v", err)
+				continue
+			}
+			for _, partitionId := range partitions {
+				swept, err := m.SweepExpired(partitionId)
+				if err != nil {
+					logrus.Errorf("SweepExpired partition %d err = %v", partitionId, err)
+					continue
+				}
+				logrus.Debugf("swept %d expired values partition %d", swept, partitionId)
+			}
+
+		case <-m.txnTick.C:
+			resolved, err := m.ResolveStaleIntents()
+			if err != nil {
+				logrus.Errorf("ResolveStaleIntents err = %v", err)
+				continue
+			}
+			logrus.Debugf("resolved %d stale intents", resolved)
+
 		// case isLeader: <-m.consensusCluster.LeaderCh():
 		case isLeader := <-m.consensusCluster.LeaderCh():
 			// logrus.Warnf("worker LeaderChangeTask: %+v", task)
@@ -350,25 +473,338 @@ func (m *Manager) startWorker(workerId int) {
 
 			case http.SetTask:
 				logrus.Debugf("worker SetTask: %+v", task)
-				members, err := m.SetRequest(task.Key, task.Value)
+				namespace, err := m.getNamespace(task.Namespace)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				key, err := namespaceKey(task.Namespace, task.Key)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				replicaCount, writeQuorum, _ := m.namespaceReplication(namespace)
+				writeQuorum, err = task.Consistency.Quorum(replicaCount, writeQuorum)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				causalContext, err := DecodeCausalContext(task.Context)
+				if err != nil {
+					task.ResCh <- fmt.Errorf("%w: %v", http.ErrInvalidContext, err)
+					continue
+				}
+				precondition, err := m.buildPrecondition(key, task.IfVersion, task.IfAbsent)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				if task.Ttl > 0 && m.isSiblingKey(key) {
+					task.ResCh <- fmt.Errorf("%w: sibling mode keys cannot expire", http.ErrInvalidTtl)
+					continue
+				}
+				members, version, err := m.SetRequest(key, []byte(task.Value), writeQuorum, setOptions{causalContext: causalContext, precondition: precondition, ttl: task.Ttl, contentType: task.ContentType})
 				errorStr := ""
+				preconditionFailed := false
+				var conflict *preconditionError
+				if errors.As(err, &conflict) {
+					version = conflict.Version
+					preconditionFailed = true
+				}
 				if err != nil {
 					errorStr = err.Error()
 				}
-				task.ResCh <- http.SetResponse{Error: errorStr, Members: members}
+				task.ResCh <- http.SetResponse{Error: errorStr, Members: members, Version: version, PreconditionFailed: preconditionFailed, QuotaExceeded: errors.Is(err, http.ErrQuotaExceeded), Consistency: task.Consistency}
 
 			case http.GetTask:
 				logrus.Debugf("worker GetTask: %+v", task)
-				value, failed_members, err := m.GetRequest(task.Key)
+				namespace, err := m.getNamespace(task.Namespace)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				key, err := namespaceKey(task.Namespace, task.Key)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				replicaCount, _, readQuorum := m.namespaceReplication(namespace)
+				readQuorum, err = task.Consistency.Quorum(replicaCount, readQuorum)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				value, failed_members, err := m.GetRequest(key, readQuorum)
 				var valueStr string
-				if value != nil {
-					valueStr = value.Value
+				var siblings []string
+				var causalContext string
+				var version string
+				var contentType string
+				found := false
+				if value != nil && len(value.Siblings) > 0 {
+					siblings = liveSiblings(value)
+					if len(siblings) == 1 {
+						valueStr = siblings[0]
+					}
+					found = len(siblings) > 0
+					causalContext, err = EncodeCausalContext(value.Context)
+				} else if value != nil {
+					found = true
+					valueStr = string(value.Value)
+					contentType = value.ContentType
+					version, err = valueVersion(value)
 				}
 				errorStr := ""
 				if err != nil {
 					errorStr = err.Error()
 				}
-				task.ResCh <- http.GetResponse{Value: valueStr, Error: errorStr, Failed_members: failed_members}
+				task.ResCh <- http.GetResponse{Value: valueStr, Siblings: siblings, Context: causalContext, Version: version, ContentType: contentType, Error: errorStr, Failed_members: failed_members, Consistency: task.Consistency, Found: found}
+
+			case http.DeleteTask:
+				logrus.Debugf("worker DeleteTask: %+v", task)
+				namespace, err := m.getNamespace(task.Namespace)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				key, err := namespaceKey(task.Namespace, task.Key)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				replicaCount, writeQuorum, _ := m.namespaceReplication(namespace)
+				writeQuorum, err = task.Consistency.Quorum(replicaCount, writeQuorum)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				causalContext, err := DecodeCausalContext(task.Context)
+				if err != nil {
+					task.ResCh <- fmt.Errorf("%w: %v", http.ErrInvalidContext, err)
+					continue
+				}
+				members, err := m.DeleteRequest(key, writeQuorum, causalContext)
+				errorStr := ""
+				if err != nil {
+					errorStr = err.Error()
+				}
+				task.ResCh <- http.DeleteResponse{Error: errorStr, Members: members, Consistency: task.Consistency}
+
+			case http.NamespaceTask:
+				logrus.Debugf("worker NamespaceTask: %+v", task)
+				change := &rpc.RpcNamespaceChange{Delete: task.Delete}
+				if task.Create != nil {
+					change.Create = &rpc.RpcNamespace{
+						Name:         task.Create.Name,
+						ReplicaCount: int32(task.Create.ReplicaCount),
+						WriteQuorum:  int32(task.Create.WriteQuorum),
+						ReadQuorum:   int32(task.Create.ReadQuorum),
+						MaxKeys:      task.Create.MaxKeys,
+						MaxBytes:     task.Create.MaxBytes,
+						Codec:        task.Create.Codec,
+					}
+				}
+				err := m.ChangeNamespace(change)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				task.ResCh <- true
+
+			case http.ListNamespacesTask:
+				namespaces, err := m.ListNamespaces()
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				task.ResCh <- http.NamespacesResponse{Namespaces: namespaces}
+
+			case rpc.ChangeNamespaceTask:
+				logrus.Debugf("worker ChangeNamespaceTask: %+v", task.Change)
+				err := m.ChangeNamespace(task.Change)
+				if errors.Is(err, http.ErrInvalidNamespace) {
+					task.ResCh <- status.Error(codes.InvalidArgument, err.Error())
+				} else if err != nil {
+					task.ResCh <- err
+				} else {
+					task.ResCh <- true
+				}
+
+			case http.WatchTask:
+				logrus.Debugf("worker WatchTask: key = %s prefix = %s cursor = %s", task.Key, task.Prefix, task.Cursor)
+				err := m.WatchRequest(task)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				task.ResCh <- true
+
+			case http.RingTask:
+				logrus.Debugf("worker RingTask: %+v", task)
+				res, err := m.RingRequest()
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				task.ResCh <- res
+
+			case http.ScanTask:
+				logrus.Debugf("worker ScanTask: %+v", task)
+				readQuorum, err := task.Consistency.Quorum(m.config.Manager.ReplicaCount, m.config.Manager.ReadQuorum)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				start, end, err := scanRange(task.Prefix, task.Start, task.End, task.Token)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				values, token, err := m.ScanRequest(start, end, task.Limit, readQuorum)
+				errorStr := ""
+				if err != nil {
+					errorStr = err.Error()
+				}
+				task.ResCh <- http.ScanResponse{Items: scanItems(values), Token: token, Error: errorStr, Consistency: task.Consistency}
+
+			case http.QueryTask:
+				logrus.Debugf("worker QueryTask: %+v", task)
+				readQuorum, err := task.Consistency.Quorum(m.config.Manager.ReplicaCount, m.config.Manager.ReadQuorum)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				start, _, err := scanRange("", "", "", task.Token)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				values, token, err := m.QueryRequest(task.Index, task.Value, start, task.Limit, readQuorum)
+				if errors.Is(err, http.ErrInvalidQuery) {
+					task.ResCh <- err
+					continue
+				}
+				errorStr := ""
+				if err != nil {
+					errorStr = err.Error()
+				}
+				task.ResCh <- http.ScanResponse{Items: scanItems(values), Token: token, Error: errorStr, Consistency: task.Consistency}
+
+			case http.MSetTask:
+				logrus.Debugf("worker MSetTask: %d items", len(task.Items))
+				writeQuorum, err := task.Consistency.Quorum(m.config.Manager.ReplicaCount, m.config.Manager.WriteQuorum)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				task.ResCh <- http.MSetResponse{Items: m.MSetRequest(task.Items, writeQuorum), Consistency: task.Consistency}
+
+			case http.TxnTask:
+				logrus.Debugf("worker TxnTask: %d reads %d writes", len(task.Reads), len(task.Writes))
+				writeQuorum, err := task.Consistency.Quorum(m.config.Manager.ReplicaCount, m.config.Manager.WriteQuorum)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				id, conflicts, err := m.TxnRequest(task.Reads, task.Writes, writeQuorum)
+				if errors.Is(err, http.ErrInvalidTxn) || errors.Is(err, http.ErrInvalidPrecondition) {
+					task.ResCh <- err
+					continue
+				}
+				errorStr := ""
+				if err != nil {
+					errorStr = err.Error()
+				}
+				committed := err == nil && len(conflicts) == 0
+				task.ResCh <- http.TxnResponse{Id: id, Committed: committed, Conflicts: conflicts, Error: errorStr, Consistency: task.Consistency}
+
+			case http.MGetTask:
+				logrus.Debugf("worker MGetTask: %d keys", len(task.Keys))
+				readQuorum, err := task.Consistency.Quorum(m.config.Manager.ReplicaCount, m.config.Manager.ReadQuorum)
+				if err != nil {
+					task.ResCh <- err
+					continue
+				}
+				task.ResCh <- http.MGetResponse{Items: m.MGetRequest(task.Keys, readQuorum), Consistency: task.Consistency}
+
+			case rpc.SetValueBatchTask:
+				logrus.Debugf("worker SetValueBatchTask: %d values", len(task.Values))
+				var values []*rpc.RpcValue
+				batchErrors := make([]string, len(task.Values))
+				var valueIndexes []int
+				for i, value := range task.Values {
+					if value.Epoch < m.GetCurrentEpoch()-1 {
+						batchErrors[i] = "cannot set lagging epoch"
+						continue
+					}
+					values = append(values, value)
+					valueIndexes = append(valueIndexes, i)
+				}
+				errs, err := m.SetValues(values)
+				if err != nil {
+					logrus.Warnf("SetValues err = %v", err)
+					task.ResCh <- err
+					continue
+				}
+				for i, err := range errs {
+					if err != nil {
+						batchErrors[valueIndexes[i]] = err.Error()
+					}
+				}
+				task.ResCh <- &rpc.RpcBatchResult{Errors: batchErrors}
+
+			case rpc.GetValueBatchTask:
+				logrus.Debugf("worker GetValueBatchTask: %d keys", len(task.Keys))
+				values, err := m.GetValues(task.Keys)
+				if err != nil {
+					task.ResCh <- err
+				} else {
+					task.ResCh <- &rpc.RpcValueBatch{Values: values}
+				}
+
+			case rpc.PrepareTxnTask:
+				logrus.Debugf("worker PrepareTxnTask: txn = %s", task.Prepare.TxnId)
+				vote, err := m.PrepareTxn(task.Prepare)
+				if err != nil {
+					task.ResCh <- err
+				} else {
+					task.ResCh <- vote
+				}
+
+			case rpc.ResolveTxnTask:
+				logrus.Debugf("worker ResolveTxnTask: txn = %s commit = %t", task.Resolve.TxnId, task.Resolve.Commit)
+				err := m.ResolveTxn(task.Resolve.TxnId, task.Resolve.Commit, task.Resolve.Keys)
+				if err != nil {
+					task.ResCh <- err
+				} else {
+					task.ResCh <- &rpc.RpcStandardObject{Message: "resolved", Error: false}
+				}
+
+			case rpc.TxnRecordTask:
+				logrus.Debugf("worker TxnRecordTask: txn = %s state = %s", task.Record.TxnId, task.Record.State)
+				record, err := m.SetTxnRecord(task.Record)
+				if err != nil {
+					task.ResCh <- err
+				} else {
+					task.ResCh <- record
+				}
+
+			case rpc.WatchTask:
+				logrus.Debugf("worker rpc WatchTask: %+v", task.Request)
+				go m.watchLocal(task)
+
+			case rpc.ScanTask:
+				logrus.Debugf("worker rpc ScanTask: %+v", task)
+				var err error
+				if task.Index != "" {
+					err = m.queryLocal(task.Index, task.IndexValue, task.Start, task.End, task.Limit, task.Partitions, task.ResCh)
+				} else {
+					err = m.scanLocal(task.Start, task.End, task.Limit, task.Partitions, task.ResCh)
+				}
+				if err != nil {
+					task.ResCh <- err
+				}
+				close(task.ResCh)
 
 			case gossip.JoinTask:
 				// logrus.Warnf("worker JoinTask: %+v", task)
@@ -389,6 +825,12 @@ func (m *Manager) startWorker(workerId int) {
 				}
 				m.clientManager.AddClient(task.Name, rpcClient)
 
+				select {
+				case m.hintCh <- task.Name:
+				default:
+					logrus.Debugf("hint replay already queued for %s", task.Name)
+				}
+
 				if m.config.Manager.Operator == false {
 					err = m.consensusCluster.UpdateFsm(m.GetCurrentEpoch(), m.gossipCluster.GetMembersNames(), m.gossipCluster.GetMembersNames())
 					if err != nil {
@@ -413,18 +855,83 @@ func (m *Manager) startWorker(workerId int) {
 				m.consistencyController.PublishEpoch(task.Epoch)
 
 				m.ring.SetRingMembers(task.Members, task.TempMembers)
+				m.dropNamespaces(m.setNamespaces(task.Namespaces))
+				task.ResCh <- true
+
+			case consensus.PartitionApplyTask:
+				logrus.Debugf("worker PartitionApplyTask: partition = %d key = %s", task.PartitionId, task.Value.Key)
+				stored, err := m.applyStrongValue(task.Value)
+				if err != nil {
+					task.ResCh <- err
+				} else {
+					task.ResCh <- stored
+				}
+
+			case consensus.PartitionSnapshotTask:
+				logrus.Debugf("worker PartitionSnapshotTask: partition = %d", task.PartitionId)
+				values, err := m.strongValues(task.PartitionId)
+				if err != nil {
+					task.ResCh <- err
+				} else {
+					task.ResCh <- &rpc.RpcValueBatch{Values: values}
+				}
+
+			case consensus.PartitionRestoreTask:
+				logrus.Debugf("worker PartitionRestoreTask: partition = %d values = %d", task.PartitionId, len(task.Values))
+				for _, value := range task.Values {
+					// values this node already holds at the same or a newer version are skipped
+					err := m.SetValue(value)
+					if err != nil {
+						logrus.Debugf("PartitionRestoreTask key = %s err = %v", value.Key, err)
+					}
+				}
 				task.ResCh <- true
 
+			case rpc.StrongTask:
+				logrus.Debugf("worker StrongTask: key = %s", task.Request.Key)
+				res, err := m.StrongLocal(task.Request)
+				if err != nil {
+					task.ResCh <- err
+				} else {
+					task.ResCh <- res
+				}
+
 			case rpc.SetValueTask:
 				logrus.Debugf("worker SetValueTask: %+v", task)
 
-				if task.Value.Epoch < m.GetCurrentEpoch()-1 {
+				// repairs keep the epoch the value was written in, so they are accepted for lagging epochs
+				if !task.Value.Repair && task.Value.Epoch < m.GetCurrentEpoch()-1 {
 					task.ResCh <- errors.New("cannot set lagging epoch")
 					continue
 				}
+				err := m.SetValueWithinQuota(task.Value)
+				var conflict *preconditionError
+				if errors.As(err, &conflict) {
+					task.ResCh <- status.Error(codes.FailedPrecondition, conflict.Version)
+				} else if errors.Is(err, ErrNewerValue) {
+					task.ResCh <- status.Error(codes.AlreadyExists, err.Error())
+				} else if errors.Is(err, http.ErrQuotaExceeded) {
+					task.ResCh <- status.Error(codes.ResourceExhausted, err.Error())
+				} else if err != nil {
+					logrus.Warnf("SetValue err = %v", err)
+					task.ResCh <- err
+				} else {
+					task.ResCh <- true
+				}
+
+			case rpc.DeleteValueTask:
+				logrus.Debugf("worker DeleteValueTask: %+v", task)
+
+				if task.Value.Epoch < m.GetCurrentEpoch()-1 {
+					task.ResCh <- errors.New("cannot delete lagging epoch")
+					continue
+				}
+				task.Value.Deleted = true
+				task.Value.Value = nil
+				task.Value.ContentType = ""
 				err := m.SetValue(task.Value)
 				if err != nil {
-					logrus.Warnf("SetValue err = %v", err)
+					logrus.Warnf("SetValue tombstone err = %v", err)
 					task.ResCh <- err
 				} else {
 					task.ResCh <- true
@@ -450,6 +957,8 @@ func (m *Manager) startWorker(workerId int) {
 						buckets = append(buckets, int32(i))
 					}
 				}
+				// expired values are left out of merkle trees so they are not synced either
+				cutoff := m.expiryCutoff(time.Now().UnixMilli())
 				for _, bucket := range buckets {
 					index1, err := BuildEpochIndex(int(task.PartitionId), uint64(bucket), task.LowerEpoch, "")
 					if err != nil {
@@ -472,13 +981,21 @@ func (m *Manager) startWorker(workerId int) {
 							logrus.Fatal(err)
 							continue
 						}
-						timestamp, err := utils.DecodeBytesToInt64(it.Value())
+						if skipUnreadable(it, "StreamBucketsTask") {
+							it.Next()
+							continue
+						}
+						version, deleted, expiresAt, err := ParseEpochIndexValue(it.Value())
 						if err != nil {
 							logrus.Fatal(err)
 							continue
 						}
+						if expired(expiresAt, cutoff) {
+							it.Next()
+							continue
+						}
 
-						task.ResCh <- &rpc.RpcValue{Key: key, Epoch: epoch, UnixTimestamp: timestamp}
+						task.ResCh <- &rpc.RpcValue{Key: key, Epoch: epoch, UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version), Deleted: deleted, ExpiresAt: expiresAt}
 						it.Next()
 					}
 					it.Release()
@@ -564,6 +1081,12 @@ func (m *Manager) startWorker(workerId int) {
 
 				currPartitions := utils.NewIntSet().From(task.Partitions)
 				m.consistencyController.HandleHashringChange(currPartitions)
+				go func() {
+					err := m.ReconcilePartitionGroups()
+					if err != nil {
+						logrus.Errorf("ReconcilePartitionGroups err = %v", err)
+					}
+				}()
 
 				task.ResCh <- true
 
@@ -575,32 +1098,115 @@ func (m *Manager) startWorker(workerId int) {
 	}
 }
 
-func (m *Manager) SetRequest(key, value string) ([]string, error) {
-	nodes, err := m.ring.GetClosestN(key, m.config.Manager.ReplicaCount, true)
+// SetRequest writes value for key to a write quorum of replicas.
+// for sibling mode keys causalContext is the context returned by a previous read, it replaces the siblings it has seen.
+// setOptions are the optional parts of a write.
+type setOptions struct {
+	causalContext *rpc.RpcCausalContext
+	// precondition is checked by every replica. the write is staged as an intent and committed once it held on a write quorum.
+	precondition *rpc.RpcPrecondition
+	// ttl in seconds is turned into an expiry so every replica expires the value at the same time.
+	ttl         int
+	contentType string
+}
+
+// SetRequest writes value to a write quorum of replicas and returns the encoded version of the write.
+func (m *Manager) SetRequest(key string, value []byte, writeQuorum int, options setOptions) ([]string, string, error) {
+	version := m.clock.Now()
+	setReq := &rpc.RpcValue{Key: key, Value: value, ContentType: options.contentType, Epoch: m.GetCurrentEpoch(), UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version), Precondition: options.precondition}
+	if options.ttl > 0 {
+		setReq.ExpiresAt = version.Physical + int64(options.ttl)*1000
+	}
+	if m.isSiblingKey(key) {
+		setReq.Context = options.causalContext
+	}
+	if m.isStrongKey(key) {
+		stored, members, err := m.strongWrite(setReq)
+		if err != nil {
+			return members, "", err
+		}
+		encodedVersion, err := EncodeVersion(stored.Hlc)
+		return members, encodedVersion, err
+	}
+	members, err := m.writeRequest(setReq, writeQuorum)
+	if err != nil {
+		return members, "", err
+	}
+	encodedVersion, err := EncodeVersion(setReq.Hlc)
+	return members, encodedVersion, err
+}
+
+// buildPrecondition returns the precondition of a conditional /set, nil for an unconditional write.
+func (m *Manager) buildPrecondition(key, ifVersion string, ifAbsent bool) (*rpc.RpcPrecondition, error) {
+	if ifVersion == "" && !ifAbsent {
+		return nil, nil
+	}
+	if m.isSiblingKey(key) {
+		return nil, fmt.Errorf("%w: sibling mode keys use context instead", http.ErrInvalidPrecondition)
+	}
+	if ifVersion != "" && ifAbsent {
+		return nil, fmt.Errorf("%w: if_version and if_absent cannot be combined", http.ErrInvalidPrecondition)
+	}
+	version, err := DecodeVersion(ifVersion)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", http.ErrInvalidPrecondition, err)
+	}
+	return &rpc.RpcPrecondition{IfVersion: version, IfAbsent: ifAbsent}, nil
+}
+
+// DeleteRequest writes a tombstone for key to a write quorum of replicas.
+func (m *Manager) DeleteRequest(key string, writeQuorum int, causalContext *rpc.RpcCausalContext) ([]string, error) {
+	version := m.clock.Now()
+	deleteReq := &rpc.RpcValue{Key: key, Epoch: m.GetCurrentEpoch(), UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version), Deleted: true}
+	if m.isSiblingKey(key) {
+		deleteReq.Context = causalContext
+	}
+	if m.isStrongKey(key) {
+		_, members, err := m.strongWrite(deleteReq)
+		return members, err
+	}
+	return m.writeRequest(deleteReq, writeQuorum)
+}
+
+func (m *Manager) writeRequest(setReq *rpc.RpcValue, writeQuorum int) ([]string, error) {
+	if setReq.Precondition != nil {
+		return m.conditionalWriteRequest(setReq, writeQuorum)
+	}
+	replicaCount := m.keyReplicaCount(setReq.Key)
+	nodes, err := m.ring.GetClosestN(setReq.Key, replicaCount, true)
 	if err != nil {
 		return nil, err
 	}
 
-	unixTimestamp := time.Now().Unix()
-	setReq := &rpc.RpcValue{Key: key, Value: value, Epoch: m.GetCurrentEpoch(), UnixTimestamp: unixTimestamp}
+	requestName := "SET"
+	if setReq.Deleted {
+		requestName = "DELETE"
+	}
 
-	responseCh := make(chan *rpc.RpcStandardObject, m.config.Manager.ReplicaCount)
-	errorCh := make(chan error, m.config.Manager.ReplicaCount)
+	responseCh := make(chan *rpc.RpcStandardObject, replicaCount)
+	errorCh := make(chan error, replicaCount)
 
 	var members []string
 	var statuses []codes.Code
+	var conflictVersions []string
+	var quotaErr error
 
 	clientErrors := 0
 
 	for _, member := range nodes {
 
-		members = append(members, member.String())
+		member := member.String()
+		members = append(members, member)
 
-		client, err := m.clientManager.GetClient(member.String())
+		client, err := m.clientManager.GetClient(member)
 		if err != nil {
 			clientErrors++
-			errorCh <- err
 			logrus.Debugf("SetRequest err = %v", err)
+			if m.handoffWrite(member, setReq) {
+				responseCh <- &rpc.RpcStandardObject{Message: "hinted"}
+			} else {
+				errorCh <- err
+			}
 			continue
 		}
 
@@ -608,8 +1214,16 @@ func (m *Manager) SetRequest(key, value string) ([]string, error) {
 			// ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			// defer cancel()
 			ctx := context.Background()
-			res, err := client.SetRequest(ctx, setReq)
-			if err != nil {
+			var res *rpc.RpcStandardObject
+			var err error
+			if setReq.Deleted {
+				res, err = client.DeleteRequest(ctx, setReq)
+			} else {
+				res, err = client.SetRequest(ctx, setReq)
+			}
+			if err != nil && isHintable(err) && m.handoffWrite(member, setReq) {
+				responseCh <- &rpc.RpcStandardObject{Message: "hinted"}
+			} else if err != nil {
 				errorCh <- err
 			} else if res != nil {
 				responseCh <- res
@@ -621,7 +1235,7 @@ func (m *Manager) SetRequest(key, value string) ([]string, error) {
 	responseCount := 0
 	errorCount := 0
 
-	for responseCount < m.config.Manager.WriteQuorum {
+	for responseCount < writeQuorum {
 		select {
 		case <-responseCh:
 			responseCount++
@@ -629,44 +1243,62 @@ func (m *Manager) SetRequest(key, value string) ([]string, error) {
 			st, ok := status.FromError(err)
 			if ok {
 				statuses = append(statuses, st.Code())
+				if st.Code() == codes.FailedPrecondition {
+					conflictVersions = append(conflictVersions, st.Message())
+				}
+				if st.Code() == codes.ResourceExhausted && strings.HasPrefix(st.Message(), http.ErrQuotaExceeded.Error()) {
+					quotaErr = remoteError(http.ErrQuotaExceeded, st.Message())
+				}
 			}
 			errorCount++
 			// logrus.Errorf("SetRequest errorCh: %v", err)
 			_ = err // Handle error if necessary
+			if len(nodes)-errorCount < writeQuorum {
+				if len(conflictVersions) > 0 {
+					return members, &preconditionError{Version: newestVersion(conflictVersions)}
+				}
+				if quotaErr != nil {
+					return members, quotaErr
+				}
+				return members, fmt.Errorf("%s: failed WriteQuorum %d. responseCount = %d errorCount = %d clientErrors = %d statuses = %v", requestName, writeQuorum, responseCount, errorCount, clientErrors, statuses)
+			}
 		case <-timeout:
-			return members, fmt.Errorf("SET: Timeout. responseCount = %d errorCount = %d clientErrors = %d statuses = %v", responseCount, errorCount, clientErrors, statuses)
+			return members, fmt.Errorf("%s: Timeout. responseCount = %d errorCount = %d clientErrors = %d statuses = %v", requestName, responseCount, errorCount, clientErrors, statuses)
 		}
 	}
-	if responseCount < m.config.Manager.WriteQuorum {
+	if responseCount < writeQuorum {
 		return members, fmt.Errorf("failed WriteQuorum. responseCount = %d", responseCount)
 	} else {
 		return members, nil
 	}
 }
 
-func (m *Manager) GetRequest(key string) (*rpc.RpcValue, []string, error) {
-	nodes, err := m.ring.GetClosestN(key, m.config.Manager.ReplicaCount, true)
+func (m *Manager) GetRequest(key string, readQuorum int) (*rpc.RpcValue, []string, error) {
+	if m.isStrongKey(key) {
+		return m.strongRead(key)
+	}
+	replicaCount := m.keyReplicaCount(key)
+	nodes, err := m.ring.GetClosestN(key, replicaCount, true)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	getReq := &rpc.RpcGetRequestMessage{Key: key}
-	responseCh := make(chan *rpc.RpcValue, m.config.Manager.ReplicaCount)
-	errorCh := make(chan error, m.config.Manager.ReplicaCount)
+	responseCh := make(chan readResponse, replicaCount)
 	var statuses []codes.Code
 	var failed_members []string
 	clientErrors := 0
 	for _, member := range nodes {
 
-		client, err := m.clientManager.GetClient(member.String())
+		member := member.String()
+		client, err := m.clientManager.GetClient(member)
 		if err != nil {
-			errorCh <- err
 			clientErrors++
 			logrus.Debugf("GetRequest err = %v", err)
 			continue
 		}
 
-		failed_members = append(failed_members, member.String())
+		failed_members = append(failed_members, member)
 
 		go func() {
 			// ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -674,58 +1306,84 @@ func (m *Manager) GetRequest(key string) (*rpc.RpcValue, []string, error) {
 			// defer cancel()
 			ctx := context.Background()
 			res, err := client.GetRequest(ctx, getReq)
-			if err != nil {
-				st, ok := status.FromError(err)
-				statuses = append(statuses, st.Code())
-				if ok && st.Code() != codes.NotFound {
-					// logrus.Errorf("GetRequest name: %v code %v", member.Name, st.Code())
-				}
-				errorCh <- err
-			} else {
-				responseCh <- res
-			}
+			responseCh <- readResponse{member: member, value: res, err: err}
 		}()
 	}
 
+	siblingMode := m.isSiblingKey(key)
+	pending := len(nodes) - clientErrors
 	responseCount := 0
 	var recentValue *rpc.RpcValue
+	var responses []readResponse
 	timeout := time.After(time.Second * time.Duration(m.config.Manager.DefaultTimeout))
-	for responseCount < m.config.Manager.ReadQuorum {
+	for responseCount < readQuorum {
+		if pending == 0 {
+			break
+		}
 		select {
 		case res := <-responseCh:
+			pending--
+			responses = append(responses, res)
+			if res.err != nil {
+				st, ok := status.FromError(res.err)
+				if ok {
+					statuses = append(statuses, st.Code())
+				}
+				continue
+			}
 
-			if res == nil {
+			if res.value == nil {
 				logrus.Panic("GET res is nil!")
 			}
 
 			responseCount++ // Include not found as a valid response?
 
-			if recentValue == nil {
-				recentValue = res
-			} else if recentValue.Epoch <= res.Epoch && recentValue.UnixTimestamp < res.UnixTimestamp {
-				recentValue = res
+			if siblingMode {
+				recentValue = mergeSiblings(recentValue, res.value)
+			} else if recentValue == nil || isNewerValue(recentValue, res.value) {
+				recentValue = res.value
 			}
-		case err := <-errorCh:
-			// logrus.Errorf("GetRequest errorCh %v", err)
-			// st, ok := status.FromError(err)
-			// if ok && st.Code() != codes.NotFound {
-			// 	logrus.Errorf("GetRequest st %+v code %v", *st, st.Code())
-			// }
-			_ = err
 
 		case <-timeout:
 			return nil, failed_members, fmt.Errorf("GET TIMEOUT: responseCount = %d clientErrors = %d nodes = %d statuses = %v failed_members = %v", responseCount, clientErrors, len(nodes), statuses, failed_members)
 		}
 	}
-	if responseCount < m.config.Manager.ReadQuorum {
+	if responseCount < readQuorum {
 		return nil, failed_members, fmt.Errorf("failed ReadQuorum. responseCount = %d", responseCount)
-	} else if recentValue == nil {
+	}
+
+	var staleMembers []string
+	for _, res := range responses {
+		if needsReadRepair(recentValue, res) {
+			staleMembers = append(staleMembers, res.member)
+		}
+	}
+	if m.config.Manager.ReadRepairBlocking {
+		m.readRepair(recentValue, staleMembers)
+	} else if len(staleMembers) > 0 {
+		go m.readRepair(recentValue, staleMembers)
+	}
+	if pending > 0 && recentValue != nil {
+		go m.readRepairLate(recentValue, responseCh, pending, timeout)
+	}
+
+	if recentValue == nil || recentValue.Deleted || isExpired(recentValue, time.Now().UnixMilli()) {
 		return nil, failed_members, nil
 	} else {
 		return recentValue, failed_members, nil
 	}
 }
 
+// isNewerValue reports if other should replace current during conflict resolution.
+// values are ordered by their hybrid clock version. tombstones win ties so a delete is not lost.
+func isNewerValue(current, other *rpc.RpcValue) bool {
+	cmp := rpc.ValueHybridTimestamp(current).Compare(rpc.ValueHybridTimestamp(other))
+	if cmp != 0 {
+		return cmp < 0
+	}
+	return !current.Deleted && other.Deleted
+}
+
 func (m *Manager) EpochTreeObjectRequest(partitionId int, epoch int64, timeout time.Duration) ([]*rpc.RpcEpochTreeObject, error) {
 	nodes, err := m.ring.GetClosestNForPartition(partitionId, m.config.Manager.ReplicaCount, true)
 	if err != nil {
@@ -778,43 +1436,151 @@ func (m *Manager) getKeyBucket(key string) uint64 {
 }
 
 func (m *Manager) SetValue(value *rpc.RpcValue) error {
-	keyBytes := []byte(value.Key)
-	timestampBytes, err := utils.EncodeInt64ToBytes(value.UnixTimestamp)
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	stored, err := m.setValueTrx(trx, value)
 	if err != nil {
 		return err
 	}
-	valueData, err := proto.Marshal(value)
+	err = trx.Commit()
 	if err != nil {
 		return err
 	}
-	partitionId := m.ring.FindPartitionID(keyBytes)
-	bucket := m.getKeyBucket(value.Key)
-	epochIndex, err := BuildEpochIndex(partitionId, bucket, value.Epoch, value.Key)
+	m.changeFeed.Publish(stored)
+	return nil
+}
+
+// SetValues writes a batch of values in a single transaction.
+// values which are rejected are reported in the returned errors and do not abort the batch.
+// values of a namespace are checked against its quotas in the same transaction, like single writes.
+func (m *Manager) SetValues(values []*rpc.RpcValue) ([]error, error) {
+	quotas := m.lockQuotas(values)
+	defer quotas.release()
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	errs := make([]error, len(values))
+	var stored []*rpc.RpcValue
+	for i, value := range values {
+		var storedValue *rpc.RpcValue
+		storedValue, errs[i] = quotas.setValueTrx(trx, value)
+		if errs[i] == nil {
+			stored = append(stored, storedValue)
+		}
+	}
+	err := trx.Commit()
 	if err != nil {
-		return err
+		return errs, err
+	}
+	m.changeFeed.Publish(stored...)
+	return errs, nil
+}
+
+// setValueTrx writes value in trx and returns the value as stored, merged with its siblings for sibling mode keys.
+// keys locked by a transaction are rejected until the transaction is resolved, and keys of a namespace which does not exist are rejected.
+func (m *Manager) setValueTrx(trx storage.Transaction, value *rpc.RpcValue) (*rpc.RpcValue, error) {
+	// writes of a deleted namespace are rejected, so syncs and repairs cannot bring its keys back
+	_, err := m.getNamespace(keyNamespace(value.Key))
+	if err != nil {
+		return nil, err
+	}
+	err = checkIntent(trx, value.Key, "")
+	if err != nil {
+		return nil, err
+	}
+	return m.writeValueTrx(trx, value)
+}
+
+// writeValueTrx writes value in trx without checking the intents of transactions.
+func (m *Manager) writeValueTrx(trx storage.Transaction, value *rpc.RpcValue) (*rpc.RpcValue, error) {
+	existingValue, value, err := m.resolveValueTrx(trx, value)
+	if err != nil {
+		return nil, err
+	}
+	err = m.putValueTrx(trx, existingValue, value)
+	if err != nil {
+		return nil, err
 	}
+	return value, nil
+}
+
+// resolveValueTrx returns the value stored in trx for the key of value and the value the write would store, without writing it.
+// the write is rejected if its precondition fails or a newer value is stored.
+func (m *Manager) resolveValueTrx(trx storage.Transaction, value *rpc.RpcValue) (*rpc.RpcValue, *rpc.RpcValue, error) {
 	keyIndex, err := BuildKeyIndex(value.Key)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	trx := m.db.NewTransaction(true)
-	defer trx.Discard()
+	var existingValue *rpc.RpcValue
 	existingBytes, err := trx.Get([]byte(keyIndex))
 	if err == nil {
-		existingValue := &rpc.RpcValue{}
-		err = proto.Unmarshal(existingBytes, existingValue)
+		existingValue, err = decodeValueRecord(existingBytes)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		// logrus.Warnf("existing value found. %v %v / %v %v / %v %v", existingValue.Epoch, value.Epoch, existingValue.UnixTimestamp, value.UnixTimestamp, existingValue.Epoch >= value.Epoch, existingValue.UnixTimestamp > value.UnixTimestamp)
-		if existingValue.Epoch >= value.Epoch && existingValue.UnixTimestamp > value.UnixTimestamp {
-			return errors.New("a newer value already exists")
+	}
+
+	if value.Precondition != nil {
+		err = checkPrecondition(value.Precondition, existingValue)
+		if err != nil {
+			return nil, nil, err
 		}
+		value = proto.Clone(value).(*rpc.RpcValue)
+		value.Precondition = nil
+	}
+	if value.Repair {
+		value = proto.Clone(value).(*rpc.RpcValue)
+		value.Repair = false
 	}
 
-	trx.Set([]byte(keyIndex), valueData)
-	trx.Set([]byte(epochIndex), timestampBytes)
-	return trx.Commit()
+	if m.isSiblingKey(value.Key) {
+		// concurrent writes are kept as siblings instead of rejected
+		value = mergeSiblings(existingValue, value)
+	} else if existingValue != nil {
+		cmp := rpc.ValueHybridTimestamp(existingValue).Compare(rpc.ValueHybridTimestamp(value))
+		if cmp > 0 {
+			return nil, nil, ErrNewerValue
+		}
+		if cmp == 0 && existingValue.Deleted && !value.Deleted {
+			return nil, nil, errors.Wrap(ErrNewerValue, "a tombstone already exists")
+		}
+	}
+	return existingValue, value, nil
+}
+
+// putValueTrx replaces existingValue with value in trx, along with their secondary index entries and namespace usage.
+func (m *Manager) putValueTrx(trx storage.Transaction, existingValue, value *rpc.RpcValue) error {
+	keyIndex, err := BuildKeyIndex(value.Key)
+	if err != nil {
+		return err
+	}
+	timestampBytes, err := BuildEpochIndexValue(rpc.ValueHybridTimestamp(value), value.Deleted, value.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	valueData, err := m.encodeValueRecord(value)
+	if err != nil {
+		return err
+	}
+	partitionId := m.ring.FindPartitionID([]byte(value.Key))
+	bucket := m.getKeyBucket(value.Key)
+	epochIndex, err := BuildEpochIndex(partitionId, bucket, value.Epoch, value.Key)
+	if err != nil {
+		return err
+	}
+
+	err = m.setIndexEntry(trx, value.Key, []byte(keyIndex), valueData, value.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	err = m.setIndexEntry(trx, value.Key, []byte(epochIndex), timestampBytes, value.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	err = m.updateSecondaryIndexes(trx, existingValue, value)
+	if err != nil {
+		return err
+	}
+	return m.updateNamespaceUsage(trx, existingValue, value)
 }
 
 func (m *Manager) GetValue(key string) (*rpc.RpcValue, error) {
@@ -827,11 +1593,13 @@ func (m *Manager) GetValue(key string) (*rpc.RpcValue, error) {
 	if err != nil {
 		return nil, err
 	}
-	value := &rpc.RpcValue{}
-	err = proto.Unmarshal(valueBytes, value)
+	value, err := decodeValueRecord(valueBytes)
 	if err != nil {
 		return nil, err
 	}
+	if isExpired(value, time.Now().UnixMilli()) {
+		return nil, storage.KEY_NOT_FOUND
+	}
 	return value, nil
 }
 
@@ -851,6 +1619,10 @@ func (m *Manager) GetEpochTreeLastValid(partitionId int32) (*rpc.RpcEpochTreeObj
 	)
 	defer it.Release()
 	for !it.IsDone() {
+		if skipUnreadable(it, "GetEpochTreeLastValid") {
+			it.Next()
+			continue
+		}
 		epochTreeObjectBytes := it.Value()
 		epochTreeObject := &rpc.RpcEpochTreeObject{}
 		err = proto.Unmarshal(epochTreeObjectBytes, epochTreeObject)
@@ -923,7 +1695,8 @@ func (m *Manager) SyncPartitionRequest(member string, partitionId int32, lowerEp
 	if err != nil {
 		return err
 	}
-	streamClient, err := client.StreamBuckets(ctx, req)
+	// the stream and the values it syncs are compressed in transfer with the codecs they are stored with
+	streamClient, err := client.StreamBuckets(ctx, req, rpc.CompressorOption(m.valueCodec("")))
 	if err != nil {
 		return errors.Wrap(err, "StreamBuckets request")
 	}
@@ -945,22 +1718,26 @@ func (m *Manager) SyncPartitionRequest(member string, partitionId int32, lowerEp
 			return err
 		}
 
+		// sibling mode keys are always merged since a newer local version does not mean the remote siblings were seen
+		siblingMode := m.isSiblingKey(value.Key)
+
 		epochBytes, err := m.db.Get([]byte(epochIndex))
-		if epochBytes != nil {
-			timestamp, err := utils.DecodeBytesToInt64(epochBytes)
-			if err == nil && timestamp >= value.UnixTimestamp {
+		if epochBytes != nil && !siblingMode {
+			version, deleted, _, err := ParseEpochIndexValue(epochBytes)
+			cmp := version.Compare(rpc.ValueHybridTimestamp(value))
+			if err == nil && (cmp > 0 || (cmp == 0 && (deleted || !value.Deleted))) {
 				logrus.Debugf("epochIndex ALREADY SYNCED~~~~~~~~~~~~~~~ KEY = %s", value.Key)
 				continue
 			}
 		}
 
 		myValue, err := m.GetValue(value.Key)
-		if myValue != nil && myValue.UnixTimestamp >= value.UnixTimestamp {
+		if myValue != nil && !siblingMode && !isNewerValue(myValue, value) {
 			logrus.Debugf("GetValue ALREADY SYNCED!!!!!!!!!!!!!!!! KEY = %s", value.Key)
 		} else {
 			getReq := &rpc.RpcGetRequestMessage{Key: value.Key}
 
-			syncedValue, err := client.GetRequest(context.Background(), getReq)
+			syncedValue, err := client.GetRequest(context.Background(), getReq, rpc.CompressorOption(m.valueCodec(value.Key)))
 			if err != nil {
 				logrus.Errorf("FAILED TO SYNC KEY = %s err = %v", value.Key, err)
 				continue
@@ -970,7 +1747,11 @@ func (m *Manager) SyncPartitionRequest(member string, partitionId int32, lowerEp
 				continue
 			}
 			err = m.SetValue(syncedValue)
-			if err != nil {
+			if errors.Is(err, http.ErrInvalidNamespace) {
+				// the namespace was deleted, its keys are dropped instead of synced
+				logrus.Debugf("SKIP SYNC KEY = %q err = %v", syncedValue.Key, err)
+				continue
+			} else if err != nil {
 				logrus.Errorf("FAILED WRITE SYNC KEY = %s err = %v", syncedValue.Key, err)
 				continue
 			} else {
@@ -978,12 +1759,17 @@ func (m *Manager) SyncPartitionRequest(member string, partitionId int32, lowerEp
 			}
 		}
 
+		if siblingMode {
+			// SetValue already indexed the merged siblings
+			continue
+		}
+
 		// write the epochIndex value...
-		timestampBytes, err := utils.EncodeInt64ToBytes(value.UnixTimestamp)
+		timestampBytes, err := BuildEpochIndexValue(rpc.ValueHybridTimestamp(value), value.Deleted, value.ExpiresAt)
 		if err != nil {
-			logrus.Fatal("FAILED TO ENCOUDE UnixTimestamp IN SYNC")
+			logrus.Fatal("FAILED TO ENCOUDE version IN SYNC")
 		}
-		err = m.db.Put([]byte(epochIndex), timestampBytes)
+		err = m.putIndexEntry(value.Key, []byte(epochIndex), timestampBytes, value.ExpiresAt)
 		if err != nil {
 			logrus.Fatal("FAILED TO PUT EpochIndex IN SYNC")
 		}
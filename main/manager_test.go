@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/andrew-delph/my-key-store/config"
 	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
 )
 
 func TestManagerDepsHolder(t *testing.T) {
@@ -48,7 +51,7 @@ func TestManagerStorage(t *testing.T) {
 	for i := 0; i < writeValuesNum; i++ {
 		k := fmt.Sprintf("key%d", i)
 		v := fmt.Sprintf("val%d", i)
-		setVal := &rpc.RpcValue{Key: k, Value: v, Epoch: 1}
+		setVal := &rpc.RpcValue{Key: k, Value: []byte(v), Epoch: 1}
 		err = manager.SetValue(setVal)
 		if err != nil {
 			t.Error(err)
@@ -57,13 +60,13 @@ func TestManagerStorage(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		assert.Equal(t, v, getVal.Value, "get value is wrong")
+		assert.Equal(t, v, string(getVal.Value), "get value is wrong")
 	}
 	// write to epoch 2
 	for i := 0; i < writeValuesNum; i++ {
 		k := fmt.Sprintf("keyz%d", i)
 		v := fmt.Sprintf("valz%d", i)
-		setVal := &rpc.RpcValue{Key: k, Value: v, Epoch: 2}
+		setVal := &rpc.RpcValue{Key: k, Value: []byte(v), Epoch: 2}
 		err = manager.SetValue(setVal)
 		if err != nil {
 			t.Error(err)
@@ -72,7 +75,7 @@ func TestManagerStorage(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		assert.Equal(t, v, getVal.Value, "get value is wrong")
+		assert.Equal(t, v, string(getVal.Value), "get value is wrong")
 	}
 
 	// check iterator for both...
@@ -134,7 +137,7 @@ func TestStreamBucketsTask(t *testing.T) {
 	for i := 0; i < writeValuesNum; i++ {
 		k := fmt.Sprintf("key%d", i)
 		v := fmt.Sprintf("val%d", i)
-		setVal := &rpc.RpcValue{Key: k, Value: v, Epoch: 1}
+		setVal := &rpc.RpcValue{Key: k, Value: []byte(v), Epoch: 1}
 		err = manager.SetValue(setVal)
 		if err != nil {
 			t.Error(err)
@@ -143,13 +146,13 @@ func TestStreamBucketsTask(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		assert.Equal(t, v, getVal.Value, "get value is wrong")
+		assert.Equal(t, v, string(getVal.Value), "get value is wrong")
 	}
 	// write to epoch 2
 	for i := 0; i < writeValuesNum; i++ {
 		k := fmt.Sprintf("keyz%d", i)
 		v := fmt.Sprintf("valz%d", i)
-		setVal := &rpc.RpcValue{Key: k, Value: v, Epoch: 2}
+		setVal := &rpc.RpcValue{Key: k, Value: []byte(v), Epoch: 2}
 		err = manager.SetValue(setVal)
 		if err != nil {
 			t.Error(err)
@@ -158,7 +161,7 @@ func TestStreamBucketsTask(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		assert.Equal(t, v, getVal.Value, "get value is wrong")
+		assert.Equal(t, v, string(getVal.Value), "get value is wrong")
 	}
 	go manager.startWorker(1)
 	resCh := make(chan interface{})
@@ -344,3 +347,68 @@ func TestGetEpochTreeObjectWriteRead(t *testing.T) {
 	}
 	assert.EqualValues(t, 1, epochTreeObject.LowerEpoch, "epochTreeObject.LowerEpoch")
 }
+
+func TestSetValueSameSecond(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := config.GetConfig()
+	c.Storage.DataPath = tmpDir
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+
+	manager := NewManager(c)
+
+	// both writes land in the same second. the hybrid clock still orders them.
+	first := utils.HybridTimestamp{Physical: 1000100, Node: "store-1"}
+	second := utils.HybridTimestamp{Physical: 1000100, Logical: 1, Node: "store-0"}
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "key", Value: []byte("first"), Epoch: 1, UnixTimestamp: first.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(first)})
+	if err != nil {
+		t.Error(err)
+	}
+	err = manager.SetValue(&rpc.RpcValue{Key: "key", Value: []byte("second"), Epoch: 1, UnixTimestamp: second.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(second)})
+	if err != nil {
+		t.Error(err)
+	}
+	err = manager.SetValue(&rpc.RpcValue{Key: "key", Value: []byte("first"), Epoch: 1, UnixTimestamp: first.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(first)})
+	assert.ErrorIs(t, err, ErrNewerValue, "older write should be rejected")
+
+	getVal, err := manager.GetValue("key")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, "second", string(getVal.Value), "newest write should win")
+}
+
+// startTestNode runs the workers and rpc server of a manager for c and returns a client connected to it.
+func startTestNode(t *testing.T, c config.Config) (*Manager, rpc.RpcClient) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Rpc.Port = lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	c.Storage.DataPath = t.TempDir()
+
+	manager := NewManager(c)
+	// the test node has no consensus cluster for the workers to check or update
+	manager.debugTick.Stop()
+	manager.epochTick.Stop()
+	go manager.startWorkers()
+	go manager.rpcWrapper.StartRpcServer()
+	addr := fmt.Sprintf("localhost:%d", c.Rpc.Port)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond, "rpc server did not start")
+
+	conn, client, err := rpc.CreateRawRpcClient("localhost", c.Rpc.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &manager, client
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"reflect"
+	"time"
 
 	"github.com/cbergoon/merkletree"
 	"github.com/pkg/errors"
@@ -86,6 +87,8 @@ func (content MerkleBucket) Equals(other merkletree.Content) (bool, error) {
 func (manager *Manager) RawPartitionMerkleTree(partitionId int, lowerEpoch, upperEpoch int64) (*merkletree.MerkleTree, error) {
 	// Build content list in sorted order of keys
 	bucketList := make([]merkletree.Content, manager.config.Manager.PartitionBuckets)
+	// expired values are left out so the tree does not depend on when a replica swept them
+	cutoff := manager.expiryCutoff(time.Now().UnixMilli())
 
 	for i := 0; i < manager.config.Manager.PartitionBuckets; i++ {
 		size := int32(0)
@@ -100,7 +103,20 @@ func (manager *Manager) RawPartitionMerkleTree(partitionId int, lowerEpoch, uppe
 		}
 		it := manager.db.NewIterator([]byte(index1), []byte(index2), false)
 		for !it.IsDone() {
-			err := bucket.AddItem(it.Value())
+			if skipUnreadable(it, "RawPartitionMerkleTree") {
+				it.Next()
+				continue
+			}
+			_, _, expiresAt, err := ParseEpochIndexValue(it.Value())
+			if err != nil {
+				it.Release()
+				return nil, errors.Wrap(err, "ParseEpochIndexValue")
+			}
+			if expired(expiresAt, cutoff) {
+				it.Next()
+				continue
+			}
+			err = bucket.AddItem(it.Value())
 			if err != nil {
 				return nil, err
 			}
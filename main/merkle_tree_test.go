@@ -38,7 +38,7 @@ func TestMerkleTreeRaw(t *testing.T) {
 		timestamp++
 		k := fmt.Sprintf("key%d", i)
 		v := fmt.Sprintf("val%d", i)
-		setVal := &rpc.RpcValue{Key: k, Value: v, Epoch: 1, UnixTimestamp: timestamp}
+		setVal := &rpc.RpcValue{Key: k, Value: []byte(v), Epoch: 1, UnixTimestamp: timestamp}
 		err = manager.SetValue(setVal)
 		if err != nil {
 			t.Error(err)
@@ -47,7 +47,7 @@ func TestMerkleTreeRaw(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		assert.Equal(t, v, getVal.Value, "get value is wrong")
+		assert.Equal(t, v, string(getVal.Value), "get value is wrong")
 	}
 
 	// write to epoch 2
@@ -55,7 +55,7 @@ func TestMerkleTreeRaw(t *testing.T) {
 		timestamp++
 		k := fmt.Sprintf("keyz%d", i)
 		v := fmt.Sprintf("valz%d", i)
-		setVal := &rpc.RpcValue{Key: k, Value: v, Epoch: 2, UnixTimestamp: timestamp}
+		setVal := &rpc.RpcValue{Key: k, Value: []byte(v), Epoch: 2, UnixTimestamp: timestamp}
 		err = manager.SetValue(setVal)
 		if err != nil {
 			t.Error(err)
@@ -64,7 +64,7 @@ func TestMerkleTreeRaw(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		assert.Equal(t, v, getVal.Value, "get value is wrong")
+		assert.Equal(t, v, string(getVal.Value), "get value is wrong")
 	}
 
 	// check iterator for both...
@@ -29,6 +29,88 @@ var (
 		},
 		[]string{"hostname"},
 	)
+
+	tombstonesCollectedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tombstones_collected",
+			Help: "the number of tombstones garbage collected",
+		},
+	)
+
+	expiredSweptCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "expired_values_swept",
+			Help: "the number of expired values removed by the ttl sweeper",
+		},
+	)
+
+	readRepairCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "read_repairs",
+			Help: "the number of stale replicas repaired during reads",
+		},
+	)
+
+	hintsStoredCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "hints_stored",
+			Help: "the number of writes stored as hints for unavailable replicas",
+		},
+	)
+
+	hintsReplayedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "hints_replayed",
+			Help: "the number of hints replayed to replicas",
+		},
+	)
+
+	hintsDroppedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "hints_dropped",
+			Help: "the number of hints dropped because they expired or the hint limit was reached",
+		},
+	)
+
+	txnCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "transactions",
+			Help: "the number of transactions coordinated by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	storageReencryptedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "storage_reencrypted",
+			Help: "the number of records reencrypted with the active encryption key",
+		},
+	)
+
+	valueBytesCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "value_bytes",
+			Help: "the bytes of the values written before compression by codec",
+		},
+		[]string{"codec"},
+	)
+
+	valueCompressedBytesCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "value_compressed_bytes",
+			Help: "the bytes of the values written after compression by codec",
+		},
+		[]string{"codec"},
+	)
+
+	valueCompressionRatioHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "value_compression_ratio",
+			Help:    "the compressed size of written values divided by their size by codec",
+			Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+		},
+		[]string{"codec"},
+	)
 )
 
 var (
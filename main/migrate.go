@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/storage"
+)
+
+// migrateBatchSize is the number of entries rewritten per transaction by a migration.
+const migrateBatchSize = 1000
+
+// the entries of the legacy format start with the lower case name of their index.
+var (
+	legacyIndexStart = []byte("a")
+	legacyIndexLimit = []byte("{")
+)
+
+// migrateLegacyEntry returns the index of a legacy entry in the current format.
+// the key is the last column of every legacy index, it is taken as the rest of the entry since keys may contain '_'.
+// secondary index entries have no index, they are rebuilt once the entries are migrated.
+func migrateLegacyEntry(index string) (string, error) {
+	parts := strings.SplitN(index, "_", 2)
+	if len(parts) != 2 {
+		return "", errors.Errorf("invalid legacy index: %q", index)
+	}
+	rest := parts[1]
+	switch parts[0] {
+	case keyIndexType.Name:
+		return BuildKeyIndex(rest)
+	case epochIndexType.Name:
+		columns := strings.SplitN(rest, "_", 4)
+		if len(columns) != 4 {
+			return "", errors.Errorf("invalid legacy epoch index: %q", index)
+		}
+		partition, bucket, epoch, err := parseLegacyEpochColumns(columns[0], columns[1], columns[2])
+		if err != nil {
+			return "", err
+		}
+		return BuildEpochIndex(partition, bucket, epoch, columns[3])
+	case epochTreeIndexType.Name:
+		columns := strings.SplitN(rest, "_", 2)
+		if len(columns) != 2 {
+			return "", errors.Errorf("invalid legacy epoch tree index: %q", index)
+		}
+		partition, _, epoch, err := parseLegacyEpochColumns(columns[0], "0", columns[1])
+		if err != nil {
+			return "", err
+		}
+		return BuildEpochTreeObjectIndex(partition, epoch)
+	case hintIndexType.Name:
+		columns := strings.SplitN(rest, "_", 3)
+		if len(columns) != 3 {
+			return "", errors.Errorf("invalid legacy hint index: %q", index)
+		}
+		created, err := strconv.ParseInt(columns[1], 10, 64)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid legacy hint index: %q", index)
+		}
+		return BuildHintIndex(columns[0], created, columns[2])
+	case intentIndexType.Name:
+		return BuildIntentIndex(rest)
+	case txnRecordIndexType.Name:
+		return BuildTxnRecordIndex(rest)
+	case secondaryIndexType.Name, secondaryIndexDefType.Name:
+		return "", nil
+	default:
+		return "", errors.Errorf("unknown legacy index: %q", index)
+	}
+}
+
+func parseLegacyEpochColumns(partitionStr, bucketStr, epochStr string) (int, uint64, int64, error) {
+	partition, err := strconv.Atoi(partitionStr)
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "invalid legacy partition")
+	}
+	bucket, err := strconv.ParseUint(bucketStr, 10, 64)
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "invalid legacy bucket")
+	}
+	epoch, err := strconv.ParseInt(epochStr, 10, 64)
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "invalid legacy epoch")
+	}
+	return partition, bucket, epoch, nil
+}
+
+// MigrateStorage rewrites the entries of the legacy format in the current format and stores the format version.
+// it runs while the node is stopped. an interrupted migration continues with the entries it did not rewrite.
+// the secondary indexes are rebuilt from the migrated values before the format version is stored.
+func (m *Manager) MigrateStorage() (int, error) {
+	version, err := storage.ReadFormatVersion(m.db)
+	if err != nil {
+		return 0, err
+	}
+	if version == storage.FormatVersion {
+		return 0, nil
+	} else if version != storage.LegacyFormatVersion {
+		return 0, errors.Wrapf(storage.ErrFormatVersion, "cannot migrate format %d", version)
+	}
+
+	migrated := 0
+	for {
+		var indexes, values [][]byte
+		it := m.db.NewIterator(legacyIndexStart, legacyIndexLimit, false)
+		for ok := it.First(); ok && len(indexes) < migrateBatchSize; ok = it.Next() {
+			// a migration does not skip entries
+			if err := it.Error(); err != nil {
+				it.Release()
+				return migrated, err
+			}
+			indexes = append(indexes, append([]byte{}, it.Key()...))
+			values = append(values, append([]byte{}, it.Value()...))
+		}
+		it.Release()
+		if len(indexes) == 0 {
+			break
+		}
+		err = m.migrateBatch(indexes, values)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += len(indexes)
+		logrus.Infof("migrated %d entries", migrated)
+	}
+
+	// the legacy secondary index entries were dropped with their definitions
+	err = m.SyncSecondaryIndexes()
+	if err != nil {
+		return migrated, errors.Wrap(err, "rebuilding secondary indexes")
+	}
+	return migrated, storage.WriteFormatVersion(m.db, storage.FormatVersion)
+}
+
+// migrateBatch rewrites a batch of legacy entries.
+// a transaction which grows too big for the engine is committed and the batch continues in a new one.
+func (m *Manager) migrateBatch(indexes, values [][]byte) error {
+	trx := m.db.NewTransaction(true)
+	defer func() { trx.Discard() }()
+	for i, index := range indexes {
+		newIndex, err := migrateLegacyEntry(string(index))
+		if err != nil {
+			return err
+		}
+		if newIndex != "" {
+			value := values[i]
+			trx, err = m.writeOrCommit(trx, func(trx storage.Transaction) error {
+				return trx.Set([]byte(newIndex), value)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		trx, err = m.writeOrCommit(trx, func(trx storage.Transaction) error {
+			return trx.Delete(index)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return trx.Commit()
+}
+
+// writeOrCommit runs write in trx. when trx cannot hold more writes it is committed and write runs in a new transaction.
+func (m *Manager) writeOrCommit(trx storage.Transaction, write func(trx storage.Transaction) error) (storage.Transaction, error) {
+	err := write(trx)
+	if !errors.Is(err, storage.ErrTxnTooBig) {
+		return trx, err
+	}
+	err = trx.Commit()
+	if err != nil {
+		return trx, err
+	}
+	trx = m.db.NewTransaction(true)
+	return trx, write(trx)
+}
+
+// migrate opens the data directory of c and migrates it to the current format.
+func migrate(c config.Config) {
+	db, err := storage.NewStorage(c.Storage)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer db.Close()
+	m := &Manager{config: c, db: db}
+	migrated, err := m.MigrateStorage()
+	if err != nil {
+		logrus.Fatalf("migration failed after %d entries: %v", migrated, err)
+	}
+	logrus.Infof("migrated %d entries to format %d", migrated, storage.FormatVersion)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func TestMigrateStorage(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.SecondaryIndexes = []config.SecondaryIndexConfig{{Name: "city", Prefix: "user_", Path: "city"}}
+	manager := NewManager(c)
+
+	// a data directory written in the legacy format
+	manager.db = storage.NewMemoryStorage()
+	value := &rpc.RpcValue{Key: "user_1", Value: []byte(`{"city":"paris"}`), Epoch: 3, UnixTimestamp: 10}
+	valueData, err := proto.Marshal(value)
+	assert.NoError(t, err)
+	epochValue, err := BuildEpochIndexValue(utils.HybridTimestamp{Physical: 10000}, false, 0)
+	assert.NoError(t, err)
+	legacy := map[string][]byte{
+		"item_user_1":                    valueData,
+		"epoch_1_2_0000000003_user_1":    epochValue,
+		"epochtree_1_0000000003":         []byte("tree"),
+		"hint_store-1_0000000123_user_1": valueData,
+		"intent_user_1":                  []byte("intent"),
+		"txn_txn_1":                      []byte("record"),
+		"idx_city_7061726973_user_1":     {},
+		"idxdef_city":                    []byte("user_\x00city"),
+	}
+	for index, data := range legacy {
+		err = manager.db.Put([]byte(index), data)
+		assert.NoError(t, err)
+	}
+	assert.ErrorIs(t, storage.CheckFormatVersion(manager.db), storage.ErrFormatVersion, "legacy format should not be read")
+
+	migrated, err := manager.MigrateStorage()
+	assert.NoError(t, err)
+	assert.Equal(t, len(legacy), migrated, "migrated wrong value")
+	assert.NoError(t, storage.CheckFormatVersion(manager.db), "migrated storage should have the current format")
+
+	stored, err := manager.GetValue("user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"city":"paris"}`, string(stored.Value), "value wrong value")
+
+	epochIndex, err := BuildEpochIndex(1, 2, 3, "user_1")
+	assert.NoError(t, err)
+	data, err := manager.db.Get([]byte(epochIndex))
+	assert.NoError(t, err)
+	assert.Equal(t, epochValue, data, "epoch index value wrong value")
+
+	treeIndex, err := BuildEpochTreeObjectIndex(1, 3)
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(treeIndex))
+	assert.NoError(t, err, "epoch tree should be migrated")
+
+	hintIndex, err := BuildHintIndex("store-1", 123, "user_1")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(hintIndex))
+	assert.NoError(t, err, "hint should be migrated")
+
+	intentIndex, err := BuildIntentIndex("user_1")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(intentIndex))
+	assert.NoError(t, err, "intent should be migrated")
+
+	txnIndex, err := BuildTxnRecordIndex("txn_1")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(txnIndex))
+	assert.NoError(t, err, "transaction record should be migrated")
+
+	// secondary indexes are rebuilt from the migrated values
+	defIndex, err := BuildSecondaryIndexDefIndex("city")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(defIndex))
+	assert.NoError(t, err, "secondary index definition should be stored")
+	entries, err := manager.secondaryIndexEntries(c.Manager.SecondaryIndexes[0], value)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries), "entries wrong length")
+	_, err = manager.db.Get([]byte(entries[0]))
+	assert.NoError(t, err, "secondary index entry should be rebuilt")
+	for index := range legacy {
+		_, err = manager.db.Get([]byte(index))
+		assert.Equal(t, storage.KEY_NOT_FOUND, err, "legacy entry %s should be removed", index)
+	}
+
+	migrated, err = manager.MigrateStorage()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated, "migrated storage should not be migrated again")
+}
+
+func TestMigrateLegacyEntry(t *testing.T) {
+	_, err := migrateLegacyEntry("epoch_1_2")
+	assert.Error(t, err, "truncated epoch index should not migrate")
+	_, err = migrateLegacyEntry("unknown_1")
+	assert.Error(t, err, "unknown index should not migrate")
+	_, err = migrateLegacyEntry("item")
+	assert.Error(t, err, "index without columns should not migrate")
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/status"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+
+	"github.com/andrew-delph/my-key-store/consensus"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// namespaceSeparator joins a namespace and a key into the key stored for it.
+// keys of the default namespace cannot hold it, so they never share a key with a namespace.
+const namespaceSeparator = "\x00"
+
+// namespaceDropBatchSize is the number of keys removed per transaction when a namespace is deleted.
+const namespaceDropBatchSize = 1000
+
+// namespaceUsageCompactBatchSize is the number of usage changes folded per transaction.
+const namespaceUsageCompactBatchSize = 1000
+
+const maxNamespaceUsageSeq = math.MaxInt64
+
+var namespacesLock sync.RWMutex
+
+// namespaceKey returns the key stored for key in namespace. the default namespace is empty and keeps the key.
+func namespaceKey(namespace, key string) (string, error) {
+	if strings.Contains(key, namespaceSeparator) {
+		return "", fmt.Errorf("%w: keys cannot hold \\x00", http.ErrInvalidNamespace)
+	}
+	if namespace == "" {
+		return key, nil
+	}
+	return namespace + namespaceSeparator + key, nil
+}
+
+// keyNamespace returns the namespace of a stored key, empty for the default namespace.
+func keyNamespace(key string) string {
+	namespace, _, found := strings.Cut(key, namespaceSeparator)
+	if !found {
+		return ""
+	}
+	return namespace
+}
+
+// validateDefaultKey rejects keys holding the separator on the requests which only address the default namespace,
+// so they cannot reach the keys of a namespace around its overrides and quotas.
+func validateDefaultKey(key string) error {
+	_, err := namespaceKey("", key)
+	return err
+}
+
+func validateNamespaceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is required", http.ErrInvalidNamespace)
+	}
+	if strings.Contains(name, namespaceSeparator) {
+		return fmt.Errorf("%w: names cannot hold \\x00", http.ErrInvalidNamespace)
+	}
+	return nil
+}
+
+// setNamespaces replaces the namespaces with those committed by raft and returns the names of the deleted namespaces.
+func (m *Manager) setNamespaces(namespaces []*rpc.RpcNamespace) []string {
+	namespacesLock.Lock()
+	defer namespacesLock.Unlock()
+	current := make(map[string]*rpc.RpcNamespace, len(namespaces))
+	for _, namespace := range namespaces {
+		current[namespace.Name] = namespace
+	}
+	var deleted []string
+	for name := range m.namespaces {
+		if current[name] == nil {
+			deleted = append(deleted, name)
+		}
+	}
+	m.namespaces = current
+	return deleted
+}
+
+// getNamespace returns the namespace named name. the default namespace has no overrides or quotas.
+func (m *Manager) getNamespace(name string) (*rpc.RpcNamespace, error) {
+	if name == "" {
+		return &rpc.RpcNamespace{}, nil
+	}
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+	namespace := m.namespaces[name]
+	if namespace == nil {
+		return nil, fmt.Errorf("%w: %s does not exist", http.ErrInvalidNamespace, name)
+	}
+	return namespace, nil
+}
+
+// namespaceReplication returns the replica count and the default write and read quorums of namespace.
+// quorums which are not overridden are capped by an overridden replica count.
+func (m *Manager) namespaceReplication(namespace *rpc.RpcNamespace) (int, int, int) {
+	replicaCount := m.config.Manager.ReplicaCount
+	writeQuorum := m.config.Manager.WriteQuorum
+	readQuorum := m.config.Manager.ReadQuorum
+	if namespace.ReplicaCount > 0 {
+		replicaCount = int(namespace.ReplicaCount)
+		writeQuorum = utils.Min(writeQuorum, replicaCount)
+		readQuorum = utils.Min(readQuorum, replicaCount)
+	}
+	if namespace.WriteQuorum > 0 {
+		writeQuorum = int(namespace.WriteQuorum)
+	}
+	if namespace.ReadQuorum > 0 {
+		readQuorum = int(namespace.ReadQuorum)
+	}
+	return replicaCount, writeQuorum, readQuorum
+}
+
+// keyReplicaCount returns the number of replicas of a stored key.
+// keys of a namespace this node does not know use the configured count.
+func (m *Manager) keyReplicaCount(key string) int {
+	namespace, err := m.getNamespace(keyNamespace(key))
+	if err != nil {
+		return m.config.Manager.ReplicaCount
+	}
+	replicaCount, _, _ := m.namespaceReplication(namespace)
+	return replicaCount
+}
+
+// validateNamespaceChange rejects namespaces whose overrides the cluster cannot serve.
+// a namespace has at most ReplicaCount replicas, the replicas of a partition which anti-entropy keeps in sync.
+func (m *Manager) validateNamespaceChange(change *rpc.RpcNamespaceChange) error {
+	if change.Create == nil {
+		return validateNamespaceName(change.Delete)
+	}
+	namespace := change.Create
+	err := validateNamespaceName(namespace.Name)
+	if err != nil {
+		return err
+	}
+	if namespace.ReplicaCount < 0 || int(namespace.ReplicaCount) > m.config.Manager.ReplicaCount {
+		return fmt.Errorf("%w: replica count must be between 1 and %d", http.ErrInvalidNamespace, m.config.Manager.ReplicaCount)
+	}
+	replicaCount, _, _ := m.namespaceReplication(namespace)
+	if namespace.WriteQuorum < 0 || int(namespace.WriteQuorum) > replicaCount || namespace.ReadQuorum < 0 || int(namespace.ReadQuorum) > replicaCount {
+		return fmt.Errorf("%w: quorums must be between 1 and the replica count %d", http.ErrInvalidNamespace, replicaCount)
+	}
+	if namespace.MaxKeys < 0 || namespace.MaxBytes < 0 {
+		return fmt.Errorf("%w: quotas cannot be negative", http.ErrInvalidNamespace)
+	}
+	if _, err := utils.ParseCodec(namespace.Codec); err != nil {
+		return fmt.Errorf("%w: %v", http.ErrInvalidNamespace, err)
+	}
+	return nil
+}
+
+// remoteError rebuilds an error wrapping sentinel from the message of a grpc status.
+func remoteError(sentinel error, message string) error {
+	return fmt.Errorf("%w%s", sentinel, strings.TrimPrefix(message, sentinel.Error()))
+}
+
+// ChangeNamespace commits the creation or deletion of a namespace through raft.
+// followers forward the change to the leader.
+func (m *Manager) ChangeNamespace(change *rpc.RpcNamespaceChange) error {
+	err := m.validateNamespaceChange(change)
+	if err != nil {
+		return err
+	}
+	err = m.consensusCluster.ChangeNamespace(change)
+	if errors.Is(err, consensus.ErrNamespaceExists) || errors.Is(err, consensus.ErrNamespaceNotFound) {
+		return fmt.Errorf("%w: %v", http.ErrInvalidNamespace, err)
+	} else if !errors.Is(err, consensus.ErrNotLeader) {
+		return err
+	}
+
+	leader := m.consensusCluster.Leader()
+	if leader == "" || leader == m.config.Manager.Hostname {
+		return errors.New("no leader to change the namespace")
+	}
+	client, err := m.clientManager.GetClient(leader)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+	_, err = client.ChangeNamespace(ctx, change)
+	if st, ok := status.FromError(err); ok && st.Code() == codes.InvalidArgument {
+		return remoteError(http.ErrInvalidNamespace, st.Message())
+	}
+	return err
+}
+
+// ListNamespaces returns the namespaces by name with the usage stored on this node.
+func (m *Manager) ListNamespaces() ([]http.Namespace, error) {
+	namespacesLock.RLock()
+	var namespaces []*rpc.RpcNamespace
+	for _, namespace := range m.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	namespacesLock.RUnlock()
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+
+	list := make([]http.Namespace, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		usage, err := m.readNamespaceUsage(namespace.Name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, http.Namespace{
+			Name:         namespace.Name,
+			ReplicaCount: int(namespace.ReplicaCount),
+			WriteQuorum:  int(namespace.WriteQuorum),
+			ReadQuorum:   int(namespace.ReadQuorum),
+			MaxKeys:      namespace.MaxKeys,
+			MaxBytes:     namespace.MaxBytes,
+			Codec:        namespace.Codec,
+			Keys:         usage.keys,
+			Bytes:        usage.bytes,
+		})
+	}
+	return list, nil
+}
+
+// namespaceUsage is the number of live keys and the bytes of their values which a replica stores for a namespace.
+type namespaceUsage struct {
+	keys  int64
+	bytes int64
+}
+
+func encodeNamespaceUsage(usage namespaceUsage) []byte {
+	data := binary.BigEndian.AppendUint64(nil, uint64(usage.keys))
+	return binary.BigEndian.AppendUint64(data, uint64(usage.bytes))
+}
+
+func decodeNamespaceUsage(data []byte) (namespaceUsage, error) {
+	if len(data) != 16 {
+		return namespaceUsage{}, errors.Errorf("namespace usage has %d bytes", len(data))
+	}
+	return namespaceUsage{keys: int64(binary.BigEndian.Uint64(data[:8])), bytes: int64(binary.BigEndian.Uint64(data[8:]))}, nil
+}
+
+// namespaceUsageRange returns the range of the usage changes of a namespace.
+func namespaceUsageRange(namespace string) ([]byte, []byte, error) {
+	start, err := BuildNamespaceUsageIndex(namespace, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit, err := BuildNamespaceUsageIndex(namespace, maxNamespaceUsageSeq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(start), []byte(limit), nil
+}
+
+// lastNamespaceUsageSeq returns the highest sequence number of the usage changes in db so new changes never replace one.
+func lastNamespaceUsageSeq(db storage.Storage) int64 {
+	var last int64
+	it := db.NewIterator(namespaceUsageType.Start(), namespaceUsageType.Limit(), false)
+	for !it.IsDone() {
+		seq, err := ParseNamespaceUsageSeq(string(it.Key()))
+		if err != nil {
+			logrus.Errorf("ParseNamespaceUsageSeq err = %v", err)
+		} else if seq > last {
+			last = seq
+		}
+		it.Next()
+	}
+	it.Release()
+	return last
+}
+
+// readNamespaceUsage returns the usage this node stores for a namespace, the sum of its changes.
+// the changes are read by one iterator so a compaction is seen whole or not at all.
+func (m *Manager) readNamespaceUsage(namespace string) (namespaceUsage, error) {
+	start, limit, err := namespaceUsageRange(namespace)
+	if err != nil {
+		return namespaceUsage{}, err
+	}
+	usage := namespaceUsage{}
+	it := m.db.NewIterator(start, limit, false)
+	defer it.Release()
+	for ; !it.IsDone(); it.Next() {
+		if skipUnreadable(it, "readNamespaceUsage") {
+			continue
+		}
+		change, err := decodeNamespaceUsage(it.Value())
+		if err != nil {
+			return namespaceUsage{}, errors.Wrapf(err, "namespace %s", namespace)
+		}
+		usage.keys += change.keys
+		usage.bytes += change.bytes
+	}
+	return usage, nil
+}
+
+// valueUsage returns the keys and bytes a stored value counts for. tombstones count for nothing.
+func valueUsage(value *rpc.RpcValue) namespaceUsage {
+	if value == nil {
+		return namespaceUsage{}
+	}
+	if len(value.Siblings) > 0 {
+		usage := namespaceUsage{}
+		for _, sibling := range liveSiblings(value) {
+			usage.keys = 1
+			usage.bytes += int64(len(sibling))
+		}
+		return usage
+	}
+	if value.Deleted {
+		return namespaceUsage{}
+	}
+	return namespaceUsage{keys: 1, bytes: int64(len(value.Value))}
+}
+
+// updateNamespaceUsage adds the replacement of existing by value, either may be nil, to the usage of their namespace.
+// the change is written as a record of its own, so the writes of a namespace never read its usage and do not conflict on it.
+func (m *Manager) updateNamespaceUsage(trx storage.Transaction, existing, value *rpc.RpcValue) error {
+	key := ""
+	if value != nil {
+		key = value.Key
+	} else if existing != nil {
+		key = existing.Key
+	}
+	namespace := keyNamespace(key)
+	if namespace == "" {
+		return nil
+	}
+	before, after := valueUsage(existing), valueUsage(value)
+	if before == after {
+		return nil
+	}
+	index, err := BuildNamespaceUsageIndex(namespace, m.namespaceUsageSeq.Add(1))
+	if err != nil {
+		return err
+	}
+	return trx.Set([]byte(index), encodeNamespaceUsage(namespaceUsage{keys: after.keys - before.keys, bytes: after.bytes - before.bytes}))
+}
+
+// compactNamespaceUsage folds the usage changes of a namespace into its latest change and returns the number removed.
+// the changes are read in the transaction, so concurrent compactions conflict instead of counting a change twice.
+func (m *Manager) compactNamespaceUsage(namespace string) (int, error) {
+	start, limit, err := namespaceUsageRange(namespace)
+	if err != nil {
+		return 0, err
+	}
+	var indexes [][]byte
+	it := m.db.NewIterator(start, limit, false)
+	for ; !it.IsDone(); it.Next() {
+		indexes = append(indexes, append([]byte{}, it.Key()...))
+	}
+	it.Release()
+
+	compacted := 0
+	var folded []byte
+	for len(indexes) > 0 {
+		batch := indexes[:utils.Min(len(indexes), namespaceUsageCompactBatchSize)]
+		indexes = indexes[len(batch):]
+		if folded != nil {
+			batch = append([][]byte{folded}, batch...)
+		}
+		if len(batch) < 2 {
+			break
+		}
+		trx := m.db.NewTransaction(true)
+		total := namespaceUsage{}
+		for _, index := range batch {
+			data, err := trx.Get(index)
+			if err != nil {
+				trx.Discard()
+				return compacted, err
+			}
+			change, err := decodeNamespaceUsage(data)
+			if err != nil {
+				trx.Discard()
+				return compacted, errors.Wrapf(err, "namespace %s", namespace)
+			}
+			total.keys += change.keys
+			total.bytes += change.bytes
+		}
+		folded = batch[len(batch)-1]
+		for _, index := range batch[:len(batch)-1] {
+			err = trx.Delete(index)
+			if err != nil {
+				trx.Discard()
+				return compacted, err
+			}
+		}
+		err = trx.Set(folded, encodeNamespaceUsage(total))
+		if err != nil {
+			trx.Discard()
+			return compacted, err
+		}
+		err = trx.Commit()
+		if err != nil {
+			return compacted, err
+		}
+		compacted += len(batch) - 1
+	}
+	return compacted, nil
+}
+
+// compactNamespaceUsages folds the usage changes of every namespace.
+// a namespace compacted concurrently is left to the next run.
+func (m *Manager) compactNamespaceUsages() (int, error) {
+	namespacesLock.RLock()
+	names := make([]string, 0, len(m.namespaces))
+	for name := range m.namespaces {
+		names = append(names, name)
+	}
+	namespacesLock.RUnlock()
+	compacted := 0
+	for _, name := range names {
+		count, err := m.compactNamespaceUsage(name)
+		compacted += count
+		if errors.Is(err, storage.ErrConflict) {
+			continue
+		} else if err != nil {
+			return compacted, err
+		}
+	}
+	return compacted, nil
+}
+
+// namespaceQuotaLocks serializes the writes checked against the quotas of a namespace,
+// so concurrent writes cannot each pass the check with the same usage.
+var namespaceQuotaLocks sync.Map
+
+// quotaWrites checks writes against the quotas of their namespaces.
+// it holds the quota locks of the namespaces until it is released, and follows their usage as writes are added to a transaction.
+type quotaWrites struct {
+	m     *Manager
+	locks []*sync.Mutex
+	usage map[string]namespaceUsage
+}
+
+// lockQuotas takes the quota locks of the namespaces of values which have quotas.
+// they are taken in order of name, so writes of several namespaces do not deadlock.
+func (m *Manager) lockQuotas(values []*rpc.RpcValue) *quotaWrites {
+	names := make(map[string]bool)
+	for _, value := range values {
+		namespace, err := m.getNamespace(keyNamespace(value.Key))
+		if err == nil && (namespace.MaxKeys > 0 || namespace.MaxBytes > 0) {
+			names[namespace.Name] = true
+		}
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	quotas := &quotaWrites{m: m, usage: make(map[string]namespaceUsage)}
+	for _, name := range sorted {
+		lock, _ := namespaceQuotaLocks.LoadOrStore(name, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		quotas.locks = append(quotas.locks, lock.(*sync.Mutex))
+	}
+	return quotas
+}
+
+func (quotas *quotaWrites) release() {
+	for i := len(quotas.locks) - 1; i >= 0; i-- {
+		quotas.locks[i].Unlock()
+	}
+}
+
+// setValueTrx writes value in trx like Manager.setValueTrx.
+// a write which grows the keys or bytes of its namespace past a quota is rejected with http.ErrQuotaExceeded and nothing of it is written.
+func (quotas *quotaWrites) setValueTrx(trx storage.Transaction, value *rpc.RpcValue) (*rpc.RpcValue, error) {
+	m := quotas.m
+	namespace, err := m.getNamespace(keyNamespace(value.Key))
+	if err != nil {
+		return nil, err
+	}
+	if namespace.MaxKeys == 0 && namespace.MaxBytes == 0 {
+		return m.setValueTrx(trx, value)
+	}
+	err = checkIntent(trx, value.Key, "")
+	if err != nil {
+		return nil, err
+	}
+	existing, stored, err := m.resolveValueTrx(trx, value)
+	if err != nil {
+		return nil, err
+	}
+	err = quotas.check(existing, stored)
+	if err != nil {
+		return nil, err
+	}
+	err = m.putValueTrx(trx, existing, stored)
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// check returns http.ErrQuotaExceeded if replacing existing with value grows its namespace past a quota.
+// the usage of a value which passes is counted, so the values checked together count toward the quotas together.
+func (quotas *quotaWrites) check(existing, value *rpc.RpcValue) error {
+	namespace, err := quotas.m.getNamespace(keyNamespace(value.Key))
+	if err != nil {
+		return err
+	}
+	if namespace.MaxKeys == 0 && namespace.MaxBytes == 0 {
+		return nil
+	}
+	before, ok := quotas.usage[namespace.Name]
+	if !ok {
+		before, err = quotas.m.readNamespaceUsage(namespace.Name)
+		if err != nil {
+			return err
+		}
+	}
+	after, err := checkQuota(namespace, before, existing, value)
+	if err != nil {
+		return err
+	}
+	quotas.usage[namespace.Name] = after
+	return nil
+}
+
+// checkQuota returns the usage of namespace once existing is replaced by stored.
+// http.ErrQuotaExceeded is returned if the replacement grows the keys or bytes of the namespace past a quota.
+func checkQuota(namespace *rpc.RpcNamespace, before namespaceUsage, existing, stored *rpc.RpcValue) (namespaceUsage, error) {
+	change, existingUsage := valueUsage(stored), valueUsage(existing)
+	after := namespaceUsage{keys: before.keys + change.keys - existingUsage.keys, bytes: before.bytes + change.bytes - existingUsage.bytes}
+	if namespace.MaxKeys > 0 && after.keys > namespace.MaxKeys && after.keys > before.keys {
+		return after, fmt.Errorf("%w: %s holds %d of %d keys", http.ErrQuotaExceeded, namespace.Name, before.keys, namespace.MaxKeys)
+	}
+	if namespace.MaxBytes > 0 && after.bytes > namespace.MaxBytes && after.bytes > before.bytes {
+		return after, fmt.Errorf("%w: %s holds %d of %d bytes", http.ErrQuotaExceeded, namespace.Name, before.bytes, namespace.MaxBytes)
+	}
+	return after, nil
+}
+
+// SetValueWithinQuota is SetValue for the writes of clients.
+// a write which grows the keys or bytes of its namespace past a quota is rejected with http.ErrQuotaExceeded.
+func (m *Manager) SetValueWithinQuota(value *rpc.RpcValue) error {
+	quotas := m.lockQuotas([]*rpc.RpcValue{value})
+	defer quotas.release()
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	stored, err := quotas.setValueTrx(trx, value)
+	if err != nil {
+		return err
+	}
+	err = trx.Commit()
+	if err != nil {
+		return err
+	}
+	m.changeFeed.Publish(stored)
+	return nil
+}
+
+// dropNamespace removes the keys this node stores for a deleted namespace and its usage changes.
+func (m *Manager) dropNamespace(name string) (int, error) {
+	start, err := BuildKeyIndex(name + namespaceSeparator)
+	if err != nil {
+		return 0, err
+	}
+	limit, err := BuildKeyIndex(name + "\x01")
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for {
+		var values []*rpc.RpcValue
+		it := m.db.NewIterator([]byte(start), []byte(limit), false)
+		for ok := it.First(); ok && len(values) < namespaceDropBatchSize; ok = it.Next() {
+			if skipUnreadable(it, "dropNamespace") {
+				continue
+			}
+			value, err := decodeValueRecord(it.Value())
+			if err != nil {
+				it.Release()
+				return dropped, err
+			}
+			values = append(values, value)
+		}
+		it.Release()
+		if len(values) == 0 {
+			break
+		}
+		trx := m.db.NewTransaction(true)
+		for _, value := range values {
+			err = m.deleteValueTrx(trx, value)
+			if err != nil {
+				trx.Discard()
+				return dropped, err
+			}
+		}
+		err = trx.Commit()
+		if err != nil {
+			return dropped, err
+		}
+		dropped += len(values)
+	}
+	err = m.dropNamespaceEpochs(name)
+	if err != nil {
+		return dropped, err
+	}
+	usageStart, usageLimit, err := namespaceUsageRange(name)
+	if err != nil {
+		return dropped, err
+	}
+	var indexes [][]byte
+	it := m.db.NewIterator(usageStart, usageLimit, false)
+	for ; !it.IsDone(); it.Next() {
+		indexes = append(indexes, append([]byte{}, it.Key()...))
+	}
+	it.Release()
+	for _, index := range indexes {
+		err = m.db.Delete(index)
+		if err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+// deleteValueTrx removes the item and secondary index entries of a stored value in trx.
+// its epoch index entries are removed by dropNamespaceEpochs.
+func (m *Manager) deleteValueTrx(trx storage.Transaction, value *rpc.RpcValue) error {
+	keyIndex, err := BuildKeyIndex(value.Key)
+	if err != nil {
+		return err
+	}
+	err = trx.Delete([]byte(keyIndex))
+	if err != nil {
+		return err
+	}
+	return m.updateSecondaryIndexes(trx, value, nil)
+}
+
+// dropNamespaceEpochs removes the epoch index entries of the keys of a deleted namespace.
+// a key has an entry for every epoch it was written in, so every epoch is scanned.
+func (m *Manager) dropNamespaceEpochs(name string) error {
+	prefix := name + namespaceSeparator
+	var indexes [][]byte
+	it := m.db.NewIterator(epochIndexType.Start(), epochIndexType.Limit(), false)
+	for ; !it.IsDone(); it.Next() {
+		_, _, _, key, err := ParseEpochIndex(string(it.Key()))
+		if err != nil {
+			it.Release()
+			return errors.Wrap(err, "ParseEpochIndex")
+		}
+		if strings.HasPrefix(key, prefix) {
+			indexes = append(indexes, append([]byte{}, it.Key()...))
+		}
+	}
+	it.Release()
+	for len(indexes) > 0 {
+		batch := indexes[:utils.Min(len(indexes), namespaceDropBatchSize)]
+		indexes = indexes[len(batch):]
+		trx := m.db.NewTransaction(true)
+		for _, index := range batch {
+			err := trx.Delete(index)
+			if err != nil {
+				trx.Discard()
+				return err
+			}
+		}
+		err := trx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropNamespaces removes the keys of deleted namespaces in the background.
+func (m *Manager) dropNamespaces(names []string) {
+	for _, name := range names {
+		name := name
+		go func() {
+			dropped, err := m.dropNamespace(name)
+			if err != nil {
+				logrus.Errorf("drop namespace %s err = %v", name, err)
+				return
+			}
+			logrus.Infof("dropped %d keys of namespace %s", dropped, name)
+		}()
+	}
+}
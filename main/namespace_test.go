@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func TestNamespaceKey(t *testing.T) {
+	key, err := namespaceKey("", "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user_1", key, "default namespace should keep the key")
+	assert.Equal(t, "", keyNamespace(key), "namespace wrong value")
+
+	key, err = namespaceKey("tenant1", "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant1", keyNamespace(key), "namespace wrong value")
+
+	_, err = namespaceKey("", "user\x00_1")
+	assert.ErrorIs(t, err, http.ErrInvalidNamespace, "keys holding the separator should be rejected")
+}
+
+func TestNamespaceReplication(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.ReplicaCount = 3
+	c.Manager.WriteQuorum = 2
+	c.Manager.ReadQuorum = 2
+	manager := NewManager(c)
+
+	replicaCount, writeQuorum, readQuorum := manager.namespaceReplication(&rpc.RpcNamespace{})
+	assert.Equal(t, []int{3, 2, 2}, []int{replicaCount, writeQuorum, readQuorum}, "default replication wrong value")
+	replicaCount, writeQuorum, readQuorum = manager.namespaceReplication(&rpc.RpcNamespace{ReplicaCount: 1})
+	assert.Equal(t, []int{1, 1, 1}, []int{replicaCount, writeQuorum, readQuorum}, "quorums should be capped by the replica count")
+	replicaCount, writeQuorum, readQuorum = manager.namespaceReplication(&rpc.RpcNamespace{WriteQuorum: 3, ReadQuorum: 1})
+	assert.Equal(t, []int{3, 3, 1}, []int{replicaCount, writeQuorum, readQuorum}, "quorum overrides wrong value")
+
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "tenant1", ReplicaCount: 2}})
+	assert.Equal(t, 2, manager.keyReplicaCount("tenant1\x00k"), "namespace replica count wrong value")
+	assert.Equal(t, 3, manager.keyReplicaCount("k"), "default replica count wrong value")
+
+	invalid := []*rpc.RpcNamespaceChange{
+		{Create: &rpc.RpcNamespace{}},
+		{Create: &rpc.RpcNamespace{Name: "a\x00"}},
+		{Create: &rpc.RpcNamespace{Name: "a", ReplicaCount: 4}},
+		{Create: &rpc.RpcNamespace{Name: "a", ReplicaCount: 1, WriteQuorum: 2}},
+		{Create: &rpc.RpcNamespace{Name: "a", MaxKeys: -1}},
+		{Create: &rpc.RpcNamespace{Name: "a", Codec: "gzip"}},
+		{Delete: ""},
+	}
+	for _, change := range invalid {
+		assert.ErrorIs(t, manager.validateNamespaceChange(change), http.ErrInvalidNamespace, "change %v should be rejected", change)
+	}
+	assert.NoError(t, manager.validateNamespaceChange(&rpc.RpcNamespaceChange{Create: &rpc.RpcNamespace{Name: "a", ReplicaCount: 2, WriteQuorum: 2}}))
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	manager := NewManager(c)
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "tenant1", MaxKeys: 2, MaxBytes: 10}})
+
+	version := int64(1000)
+	write := func(key, value string, deleted bool) error {
+		version++
+		return manager.SetValueWithinQuota(&rpc.RpcValue{Key: "tenant1\x00" + key, Value: []byte(value), Deleted: deleted, Hlc: rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: version})})
+	}
+	usage := func() http.Namespace {
+		namespaces, err := manager.ListNamespaces()
+		assert.NoError(t, err)
+		assert.Len(t, namespaces, 1)
+		return namespaces[0]
+	}
+
+	assert.NoError(t, write("a", "aaa", false))
+	assert.NoError(t, write("b", "bbb", false))
+	assert.Equal(t, int64(2), usage().Keys, "keys wrong value")
+	assert.Equal(t, int64(6), usage().Bytes, "bytes wrong value")
+
+	assert.ErrorIs(t, write("c", "c", false), http.ErrQuotaExceeded, "a key past the key quota should be rejected")
+	assert.ErrorIs(t, write("a", "aaaaaaaaa", false), http.ErrQuotaExceeded, "a value past the byte quota should be rejected")
+	assert.NoError(t, write("a", "a", false), "a smaller value should be written")
+	assert.Equal(t, int64(4), usage().Bytes, "bytes wrong value")
+
+	assert.NoError(t, write("b", "", true), "a tombstone should be written")
+	assert.Equal(t, int64(1), usage().Keys, "a tombstone should not count")
+	assert.NoError(t, write("c", "c", false))
+	assert.Equal(t, int64(2), usage().Keys, "keys wrong value")
+
+	// replication writes are not checked
+	err := manager.SetValue(&rpc.RpcValue{Key: "tenant1\x00d", Value: []byte("d"), Hlc: rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: version + 1})})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), usage().Keys, "replicated keys should count")
+
+	err = manager.SetValueWithinQuota(&rpc.RpcValue{Key: "unknown\x00a", Value: []byte("a")})
+	assert.ErrorIs(t, err, http.ErrInvalidNamespace, "unknown namespace should be rejected")
+
+	err = manager.SetValue(&rpc.RpcValue{Key: "tenant", Value: []byte("v")})
+	assert.NoError(t, err)
+	dropped, err := manager.dropNamespace("tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, dropped, "dropped wrong value")
+	_, err = manager.GetValue("tenant1\x00a")
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "keys of a dropped namespace should be removed")
+	_, err = manager.GetValue("tenant")
+	assert.NoError(t, err, "keys of the default namespace should be kept")
+	assert.Equal(t, int64(0), usage().Keys, "usage of a dropped namespace should be removed")
+
+	assert.Equal(t, []string{"tenant1"}, manager.setNamespaces(nil), "deleted namespaces wrong value")
+}
+
+func TestNamespaceUsageConcurrentWrites(t *testing.T) {
+	for _, engine := range []string{"badger", "leveldb", "pebble", "memory"} {
+		t.Run(engine, func(t *testing.T) {
+			c := config.GetConfig()
+			c.Storage.DataPath = t.TempDir()
+			c.Storage.Engine = engine
+			manager := NewManager(c)
+			defer manager.db.Close()
+			manager.setNamespaces([]*rpc.RpcNamespace{{Name: "team"}, {Name: "limited", MaxKeys: 100}})
+
+			writes := 300
+			var wg sync.WaitGroup
+			var lock sync.Mutex
+			var errs []error
+			exceeded := 0
+			for i := 0; i < writes; i++ {
+				wg.Add(2)
+				go func(i int) {
+					defer wg.Done()
+					err := manager.SetValue(&rpc.RpcValue{Key: fmt.Sprintf("team\x00k%d", i), Value: []byte("v")})
+					lock.Lock()
+					defer lock.Unlock()
+					if err != nil {
+						errs = append(errs, err)
+					}
+				}(i)
+				go func(i int) {
+					defer wg.Done()
+					err := manager.SetValueWithinQuota(&rpc.RpcValue{Key: fmt.Sprintf("limited\x00k%d", i), Value: []byte("v")})
+					lock.Lock()
+					defer lock.Unlock()
+					if errors.Is(err, http.ErrQuotaExceeded) {
+						exceeded++
+					} else if err != nil {
+						errs = append(errs, err)
+					}
+				}(i)
+			}
+			wg.Wait()
+			assert.Empty(t, errs, "writes of distinct keys should not conflict")
+			assert.Equal(t, writes-100, exceeded, "writes past the quota wrong value")
+
+			usage, err := manager.readNamespaceUsage("team")
+			assert.NoError(t, err)
+			assert.Equal(t, namespaceUsage{keys: int64(writes), bytes: int64(writes)}, usage, "usage wrong value")
+			usage, err = manager.readNamespaceUsage("limited")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(100), usage.keys, "usage should not pass the quota")
+
+			compacted, err := manager.compactNamespaceUsages()
+			assert.NoError(t, err)
+			assert.Equal(t, writes-1+100-1, compacted, "compacted wrong value")
+			usage, err = manager.readNamespaceUsage("team")
+			assert.NoError(t, err)
+			assert.Equal(t, namespaceUsage{keys: int64(writes), bytes: int64(writes)}, usage, "compaction should keep the usage")
+		})
+	}
+}
+
+func TestDropNamespaceEpochs(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "tenant1"}})
+
+	for epoch := int64(1); epoch <= 3; epoch++ {
+		version := utils.HybridTimestamp{Physical: 1000000 + epoch}
+		err := manager.SetValue(&rpc.RpcValue{Key: "tenant1\x00a", Value: []byte("v"), Epoch: epoch, Hlc: rpc.NewRpcHybridTimestamp(version)})
+		assert.NoError(t, err)
+	}
+	err := manager.SetValue(&rpc.RpcValue{Key: "tenant1a", Value: []byte("v"), Epoch: 1})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"tenant1"}, manager.setNamespaces(nil), "deleted namespaces wrong value")
+	dropped, err := manager.dropNamespace("tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dropped, "dropped wrong value")
+	for epoch := int64(1); epoch <= 3; epoch++ {
+		epochIndex, err := BuildEpochIndex(0, 0, epoch, "tenant1\x00a")
+		assert.NoError(t, err)
+		_, err = manager.db.Get([]byte(epochIndex))
+		assert.Equal(t, storage.KEY_NOT_FOUND, err, "epoch %d of a dropped key should be removed", epoch)
+	}
+	epochIndex, err := BuildEpochIndex(0, 0, 1, "tenant1a")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(epochIndex))
+	assert.NoError(t, err, "epochs of other keys should be kept")
+
+	// a sync or repair of the dropped namespace does not bring it back
+	err = manager.SetValue(&rpc.RpcValue{Key: "tenant1\x00a", Value: []byte("v"), Epoch: 3, Hlc: rpc.NewRpcHybridTimestamp(utils.HybridTimestamp{Physical: 2000000})})
+	assert.ErrorIs(t, err, http.ErrInvalidNamespace)
+	_, err = manager.GetValue("tenant1\x00a")
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "keys of a dropped namespace should not be written")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogo/status"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/rpc"
+)
+
+// readResponse is the answer of a single replica to a quorum read.
+type readResponse struct {
+	member string
+	value  *rpc.RpcValue
+	err    error
+}
+
+// needsReadRepair reports if the replica that answered res is behind winner.
+// replicas that answered NotFound are behind unless nothing was found at all.
+func needsReadRepair(winner *rpc.RpcValue, res readResponse) bool {
+	if winner == nil {
+		return false
+	}
+	if res.err != nil {
+		st, ok := status.FromError(res.err)
+		return ok && st.Code() == codes.NotFound
+	}
+	if len(winner.Siblings) > 0 {
+		return !sameSiblings(winner, res.value)
+	}
+	return isNewerValue(res.value, winner)
+}
+
+// readRepair pushes value to the stale members and waits for them to answer.
+func (m *Manager) readRepair(value *rpc.RpcValue, members []string) {
+	if value == nil || len(members) == 0 {
+		return
+	}
+	// the repair keeps the epoch of value, which the stale members may consider lagging
+	value = proto.Clone(value).(*rpc.RpcValue)
+	value.Repair = true
+	doneCh := make(chan struct{}, len(members))
+	for _, member := range members {
+		member := member
+		go func() {
+			defer func() { doneCh <- struct{}{} }()
+			client, err := m.clientManager.GetClient(member)
+			if err != nil {
+				logrus.Debugf("readRepair GetClient err = %v", err)
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+			defer cancel()
+			_, err = client.SetRequest(ctx, value)
+			if err != nil {
+				logrus.Debugf("readRepair member = %s key = %s err = %v", member, value.Key, err)
+				return
+			}
+			readRepairCounter.Inc()
+		}()
+	}
+	for range members {
+		<-doneCh
+	}
+}
+
+// readRepairLate waits for the replicas that answered after the read quorum and repairs the stale ones.
+func (m *Manager) readRepairLate(value *rpc.RpcValue, responseCh chan readResponse, pending int, timeout <-chan time.Time) {
+	var staleMembers []string
+	for ; pending > 0; pending-- {
+		select {
+		case res := <-responseCh:
+			if needsReadRepair(value, res) {
+				staleMembers = append(staleMembers, res.member)
+			}
+		case <-timeout:
+			pending = 0
+		}
+	}
+	m.readRepair(value, staleMembers)
+}
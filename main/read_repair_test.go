@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gogo/status"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func TestNeedsReadRepair(t *testing.T) {
+	winner := &rpc.RpcValue{Key: "key", Value: []byte("new"), Epoch: 2, UnixTimestamp: 20}
+	older := &rpc.RpcValue{Key: "key", Value: []byte("old"), Epoch: 1, UnixTimestamp: 10}
+	notFound := status.Error(codes.NotFound, "value not found")
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	assert.Equal(t, true, needsReadRepair(winner, readResponse{member: "a", value: older}), "older value should be repaired")
+	assert.Equal(t, false, needsReadRepair(winner, readResponse{member: "a", value: winner}), "same value should not be repaired")
+	assert.Equal(t, true, needsReadRepair(winner, readResponse{member: "a", err: notFound}), "NotFound should be repaired")
+	assert.Equal(t, false, needsReadRepair(winner, readResponse{member: "a", err: unavailable}), "failed member should not be repaired")
+	assert.Equal(t, false, needsReadRepair(nil, readResponse{member: "a", err: notFound}), "nothing to repair with")
+
+	tombstone := &rpc.RpcValue{Key: "key", Epoch: 2, UnixTimestamp: 20, Deleted: true}
+	assert.Equal(t, true, needsReadRepair(tombstone, readResponse{member: "a", value: winner}), "tombstone should be repaired over a tie")
+}
+
+func TestReadRepair(t *testing.T) {
+	for _, blocking := range []bool{true, false} {
+		blocking := blocking
+		t.Run(fmt.Sprintf("blocking=%v", blocking), func(t *testing.T) {
+			c := config.GetConfig()
+			c.Manager.ReplicaCount = 2
+			c.Manager.ReadRepairBlocking = blocking
+
+			c.Manager.Hostname = "store-1"
+			fresh, freshClient := startTestNode(t, c)
+			c.Manager.Hostname = "store-2"
+			stale, staleClient := startTestNode(t, c)
+			// the stale replica moved on several epochs since the value was written
+			stale.SetCurrentEpoch(10)
+			c.Manager.Hostname = "store-0"
+			coordinator, _ := startTestNode(t, c)
+			coordinator.clientManager.AddClient("store-1", freshClient)
+			coordinator.clientManager.AddClient("store-2", staleClient)
+			coordinator.ring.SetRingMembers([]string{"store-1", "store-2"}, []string{"store-1", "store-2"})
+
+			older := utils.HybridTimestamp{Physical: 1000100, Node: "store-0"}
+			newer := utils.HybridTimestamp{Physical: 1000200, Node: "store-0"}
+			err := stale.SetValue(&rpc.RpcValue{Key: "key", Value: []byte("old"), Epoch: 1, UnixTimestamp: older.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(older)})
+			assert.NoError(t, err)
+			err = fresh.SetValue(&rpc.RpcValue{Key: "key", Value: []byte("new"), Epoch: 1, UnixTimestamp: newer.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(newer)})
+			assert.NoError(t, err)
+
+			repairs := testutil.ToFloat64(readRepairCounter)
+			value, _, err := coordinator.GetRequest("key", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "new", string(value.Value), "read should return the newest value")
+			assert.Equal(t, false, value.Repair, "read should not return the repair flag")
+
+			repaired := func() bool {
+				value, err := stale.GetValue("key")
+				return err == nil && string(value.Value) == "new"
+			}
+			if blocking {
+				assert.Equal(t, true, repaired(), "stale replica should be repaired before the read returns")
+			} else {
+				assert.Eventually(t, repaired, 5*time.Second, 10*time.Millisecond, "stale replica should be repaired")
+			}
+			assert.Eventually(t, func() bool {
+				return testutil.ToFloat64(readRepairCounter) == repairs+1
+			}, 5*time.Second, 10*time.Millisecond, "readRepairCounter should count the repair")
+			value, err = stale.GetValue("key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.EqualValues(t, 1, value.Epoch, "repair should keep the epoch of the value")
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/andrew-delph/my-key-store/hashring"
+	"github.com/andrew-delph/my-key-store/http"
+)
+
+// RingRequest returns the ring as seen by this node so clients can send requests straight to a replica.
+// the replicas of a partition include temp members the same as writes do.
+func (m *Manager) RingRequest() (http.RingResponse, error) {
+	partitions := make([][]string, m.config.Manager.PartitionCount)
+	for partitionId := 0; partitionId < m.config.Manager.PartitionCount; partitionId++ {
+		nodes, err := m.ring.GetClosestNForPartition(partitionId, m.config.Manager.ReplicaCount, true)
+		if err != nil {
+			return http.RingResponse{}, err
+		}
+		partitions[partitionId] = hashring.MemberListtoStringList(nodes)
+	}
+	return http.RingResponse{
+		Version:        m.ring.Version(),
+		Members:        m.ring.GetMembersNames(false),
+		TempMembers:    m.ring.GetMembersNames(true),
+		PartitionCount: m.config.Manager.PartitionCount,
+		ReplicaCount:   m.config.Manager.ReplicaCount,
+		Partitions:     partitions,
+	}, nil
+}
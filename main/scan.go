@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+type scanResponse struct {
+	member     string
+	partitions []int32
+	values     []*rpc.RpcValue
+	err        error
+}
+
+// scanRange combines the scan parameters into a key range with an inclusive start and exclusive end.
+// an empty end is unbounded.
+func scanRange(prefix, start, end, token string) (string, string, error) {
+	for _, key := range []string{prefix, start, end} {
+		if validateDefaultKey(key) != nil {
+			return "", "", fmt.Errorf("%w: keys cannot hold \\x00", http.ErrInvalidScan)
+		}
+	}
+	if token != "" {
+		lastKey, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v", http.ErrInvalidScan, err)
+		}
+		// continue right after the last key of the previous page
+		start = utils.Max(start, string(lastKey)+"\x00")
+	}
+	if prefix != "" {
+		start = utils.Max(start, prefix)
+		if prefixEnd := prefixLimit(prefix); prefixEnd != "" && (end == "" || prefixEnd < end) {
+			end = prefixEnd
+		}
+	}
+	if end != "" && start >= end {
+		return "", "", fmt.Errorf("%w: start %q is not before end %q", http.ErrInvalidScan, start, end)
+	}
+	return start, end, nil
+}
+
+// prefixLimit returns the first key after every key with prefix, or empty if there is none.
+func prefixLimit(prefix string) string {
+	return string(storage.PrefixEnd([]byte(prefix)))
+}
+
+// scanItems converts the values of a scan to the items of its response.
+func scanItems(values []*rpc.RpcValue) []http.ScanItem {
+	items := make([]http.ScanItem, 0, len(values))
+	for _, value := range values {
+		item := http.ScanItem{Key: value.Key, Value: string(value.Value)}
+		if len(value.Siblings) > 0 {
+			item.Siblings = liveSiblings(value)
+			if len(item.Siblings) == 1 {
+				item.Value = item.Siblings[0]
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func EncodeScanToken(lastKey string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastKey))
+}
+
+// scanLocal sends the values of the key range which belong to partitions to resCh.
+func (m *Manager) scanLocal(start, end string, limit int, partitions []int32, resCh chan interface{}) error {
+	startIndex, err := BuildKeyIndex(start)
+	if err != nil {
+		return err
+	}
+	limitIndex := keyIndexType.Limit()
+	if end != "" {
+		endIndex, err := BuildKeyIndex(end)
+		if err != nil {
+			return err
+		}
+		limitIndex = []byte(endIndex)
+	}
+	wanted := utils.NewInt32Set()
+	for _, partition := range partitions {
+		wanted.Add(partition)
+	}
+
+	it := m.db.NewIterator([]byte(startIndex), limitIndex, false)
+	defer func() { it.Release() }()
+	count := 0
+	for !it.IsDone() && count < limit {
+		if skipUnreadable(it, "scanLocal") {
+			it.Next()
+			continue
+		}
+		value, err := decodeValueRecord(it.Value())
+		if err != nil {
+			return errors.Wrap(err, "scanLocal Unmarshal")
+		}
+		if namespace := keyNamespace(value.Key); namespace != "" {
+			// scans cover the default namespace. the keys of a namespace sort together before namespace\x01 so they are skipped at once
+			next := namespace + "\x01"
+			if end != "" && next >= end {
+				break
+			}
+			nextIndex, err := BuildKeyIndex(next)
+			if err != nil {
+				return err
+			}
+			it.Release()
+			it = m.db.NewIterator([]byte(nextIndex), limitIndex, false)
+			continue
+		}
+		if wanted.Has(int32(m.ring.FindPartitionID([]byte(value.Key)))) {
+			resCh <- value
+			count++
+		}
+		it.Next()
+	}
+	return nil
+}
+
+// ScanRequest returns up to limit live values of the key range and a token for the next page.
+// every partition is read from its replicas and must reach readQuorum.
+func (m *Manager) ScanRequest(start, end string, limit, readQuorum int) ([]*rpc.RpcValue, string, error) {
+	result, err := m.scanPartitions(&rpc.RpcScanRequest{Start: start, End: end, Limit: int32(limit)}, readQuorum)
+	if err != nil {
+		return nil, "", err
+	}
+	keys, token := result.page(limit)
+	now := time.Now().UnixMilli()
+	var values []*rpc.RpcValue
+	for _, key := range keys {
+		if !result.values[key].Deleted && !isExpired(result.values[key], now) {
+			values = append(values, result.values[key])
+		}
+	}
+	return values, token, nil
+}
+
+// scanResult holds the values the replicas of every partition returned for a scan.
+type scanResult struct {
+	values map[string]*rpc.RpcValue
+	// replies counts the members which returned each key and diverged marks keys returned with different values
+	replies            map[string]int
+	diverged           map[string]bool
+	partitionResponses map[int32]int
+}
+
+// page returns the first limit keys of the result in order and the token of the next page.
+func (result *scanResult) page(limit int) ([]string, string) {
+	keys := make([]string, 0, len(result.values))
+	for key := range result.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// every member returned its first limit keys so the first limit merged keys are complete
+	token := ""
+	if len(keys) >= limit {
+		keys = keys[:limit]
+		token = EncodeScanToken(keys[len(keys)-1])
+	}
+	return keys, token
+}
+
+// scanPartitions sends req to the replicas of every partition and merges the newest value of each key.
+func (m *Manager) scanPartitions(req *rpc.RpcScanRequest, readQuorum int) (*scanResult, error) {
+	memberPartitions := make(map[string][]int32)
+	for partitionId := 0; partitionId < m.config.Manager.PartitionCount; partitionId++ {
+		nodes, err := m.ring.GetClosestNForPartition(partitionId, m.config.Manager.ReplicaCount, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range nodes {
+			memberPartitions[member.String()] = append(memberPartitions[member.String()], int32(partitionId))
+		}
+	}
+
+	responseCh := make(chan scanResponse, len(memberPartitions))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+	for member, partitions := range memberPartitions {
+		member, partitions := member, partitions
+		go func() {
+			memberReq := proto.Clone(req).(*rpc.RpcScanRequest)
+			memberReq.Partitions = partitions
+			values, err := m.scanMember(ctx, member, memberReq)
+			responseCh <- scanResponse{member: member, partitions: partitions, values: values, err: err}
+		}()
+	}
+
+	result := &scanResult{
+		values:             make(map[string]*rpc.RpcValue),
+		replies:            make(map[string]int),
+		diverged:           make(map[string]bool),
+		partitionResponses: make(map[int32]int),
+	}
+	for range memberPartitions {
+		res := <-responseCh
+		if res.err != nil {
+			logrus.Debugf("scanPartitions member = %s err = %v", res.member, res.err)
+			continue
+		}
+		for _, partition := range res.partitions {
+			result.partitionResponses[partition]++
+		}
+		for _, value := range res.values {
+			current, ok := result.values[value.Key]
+			result.replies[value.Key]++
+			if ok && !proto.Equal(current, value) {
+				result.diverged[value.Key] = true
+			}
+			if m.isSiblingKey(value.Key) {
+				result.values[value.Key] = mergeSiblings(current, value)
+			} else if !ok || isNewerValue(current, value) {
+				result.values[value.Key] = value
+			}
+		}
+	}
+	for partitionId := 0; partitionId < m.config.Manager.PartitionCount; partitionId++ {
+		if result.partitionResponses[int32(partitionId)] < readQuorum {
+			return nil, fmt.Errorf("failed ReadQuorum for partition %d. responseCount = %d", partitionId, result.partitionResponses[int32(partitionId)])
+		}
+	}
+	return result, nil
+}
+
+func (m *Manager) scanMember(ctx context.Context, member string, req *rpc.RpcScanRequest) ([]*rpc.RpcValue, error) {
+	client, err := m.clientManager.GetClient(member)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := client.Scan(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Scan request")
+	}
+	var values []*rpc.RpcValue
+	for {
+		value, err := stream.Recv()
+		if err == io.EOF {
+			return values, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "Scan Recv")
+		}
+		values = append(values, value)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+)
+
+func TestScanRange(t *testing.T) {
+	start, end, err := scanRange("user/", "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "user/", start, "start wrong value")
+	assert.Equal(t, "user0", end, "end wrong value")
+
+	start, end, err = scanRange("", "a", "c", EncodeScanToken("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b\x00", start, "token should continue after the last key")
+	assert.Equal(t, "c", end, "end wrong value")
+
+	_, _, err = scanRange("", "c", "a", "")
+	assert.ErrorIs(t, err, http.ErrInvalidScan)
+
+	_, _, err = scanRange("", "", "", "not a token!")
+	assert.ErrorIs(t, err, http.ErrInvalidScan)
+
+	_, _, err = scanRange("tenant1\x00", "", "", "")
+	assert.ErrorIs(t, err, http.ErrInvalidScan, "keys holding the separator should be rejected")
+
+	assert.Equal(t, "", prefixLimit("\xff\xff"), "prefixLimit wrong value")
+	assert.Equal(t, "b", prefixLimit("a\xff"), "prefixLimit wrong value")
+}
+
+func TestScanLocal(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+
+	for i := 0; i < 10; i++ {
+		err := manager.SetValue(&rpc.RpcValue{Key: fmt.Sprintf("user/%d", i), Value: []byte("v"), Epoch: 1})
+		assert.NoError(t, err)
+	}
+	err := manager.SetValue(&rpc.RpcValue{Key: "other", Value: []byte("v"), Epoch: 1})
+	assert.NoError(t, err)
+	// the keys of namespace user/4 sort inside the range
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "user/4"}})
+	for i := 0; i < 10; i++ {
+		err := manager.SetValue(&rpc.RpcValue{Key: fmt.Sprintf("user/4\x00%d", i), Value: []byte("v"), Epoch: 1})
+		assert.NoError(t, err)
+	}
+
+	start, end, err := scanRange("user/", "user/3", "", "")
+	assert.NoError(t, err)
+
+	resCh := make(chan interface{}, 20)
+	err = manager.scanLocal(start, end, 5, []int32{0}, resCh)
+	assert.NoError(t, err)
+	close(resCh)
+	var keys []string
+	for item := range resCh {
+		keys = append(keys, item.(*rpc.RpcValue).Key)
+	}
+	assert.Equal(t, []string{"user/3", "user/4", "user/5", "user/6", "user/7"}, keys, "keys of other namespaces should not count for the limit")
+
+	// partitions which are not requested are skipped
+	resCh = make(chan interface{}, 20)
+	err = manager.scanLocal(start, end, 5, []int32{1}, resCh)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(resCh), "other partitions should be skipped")
+}
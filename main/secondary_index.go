@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// secondaryIndexBatchSize is the number of entries written per transaction while an index is built or dropped.
+const secondaryIndexBatchSize = 1000
+
+// validateSecondaryIndexes checks the declared indexes can be told apart in their entries.
+func validateSecondaryIndexes(indexes []config.SecondaryIndexConfig) error {
+	names := make(map[string]bool)
+	for _, index := range indexes {
+		if index.Name == "" {
+			return errors.New("secondary index has no name")
+		}
+		if names[index.Name] {
+			return errors.Errorf("secondary index %q is declared twice", index.Name)
+		}
+		names[index.Name] = true
+		if index.Path == "" {
+			return errors.Errorf("secondary index %q has no path", index.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) secondaryIndex(name string) (config.SecondaryIndexConfig, bool) {
+	for _, index := range m.config.Manager.SecondaryIndexes {
+		if index.Name == name {
+			return index, true
+		}
+	}
+	return config.SecondaryIndexConfig{}, false
+}
+
+// extractJsonPath returns the scalars at the dotted path of a JSON document. every scalar of an array is returned.
+// data which is not JSON or has no scalar at path has no values.
+func extractJsonPath(data []byte, path string) []string {
+	var doc interface{}
+	if json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+	for _, field := range strings.Split(path, ".") {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = object[field]
+	}
+	var values []string
+	elements, ok := doc.([]interface{})
+	if !ok {
+		elements = []interface{}{doc}
+	}
+	for _, element := range elements {
+		switch scalar := element.(type) {
+		case string:
+			values = append(values, scalar)
+		case float64:
+			values = append(values, strconv.FormatFloat(scalar, 'f', -1, 64))
+		case bool:
+			values = append(values, strconv.FormatBool(scalar))
+		}
+	}
+	return values
+}
+
+// indexValues returns the values of value in index. every live sibling is indexed.
+func indexValues(index config.SecondaryIndexConfig, value *rpc.RpcValue) []string {
+	if value == nil || !strings.HasPrefix(value.Key, index.Prefix) {
+		return nil
+	}
+	var values []string
+	for _, sibling := range liveSiblings(value) {
+		values = append(values, extractJsonPath([]byte(sibling), index.Path)...)
+	}
+	return values
+}
+
+// indexToken returns what the entries of a secondary index hold in place of indexValue.
+// keys are not encrypted, so on encrypted storage it is the keyed hash of indexValue and the entries do not reveal values.
+func (m *Manager) indexToken(indexValue string) string {
+	encrypted, ok := m.db.(storage.EncryptedStorage)
+	if !ok {
+		return indexValue
+	}
+	return hex.EncodeToString(encrypted.IndexToken([]byte(indexValue)))
+}
+
+// secondaryIndexEntries returns the entries of value in index.
+func (m *Manager) secondaryIndexEntries(index config.SecondaryIndexConfig, value *rpc.RpcValue) ([]string, error) {
+	var entries []string
+	seen := make(map[string]bool)
+	for _, indexValue := range indexValues(index, value) {
+		entry, err := BuildSecondaryIndex(index.Name, m.indexToken(indexValue), value.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// updateSecondaryIndexes replaces the entries of existing with the entries of value in the same transaction.
+// either may be nil.
+func (m *Manager) updateSecondaryIndexes(trx storage.Transaction, existing, value *rpc.RpcValue) error {
+	for _, index := range m.config.Manager.SecondaryIndexes {
+		oldEntries, err := m.secondaryIndexEntries(index, existing)
+		if err != nil {
+			return err
+		}
+		newEntries, err := m.secondaryIndexEntries(index, value)
+		if err != nil {
+			return err
+		}
+		keep := make(map[string]bool)
+		for _, entry := range newEntries {
+			keep[entry] = true
+		}
+		for _, entry := range oldEntries {
+			if keep[entry] {
+				continue
+			}
+			err = trx.Delete([]byte(entry))
+			if err != nil {
+				return err
+			}
+		}
+		for _, entry := range newEntries {
+			// the entry expires with the value
+			err = m.setIndexEntry(trx, value.Key, []byte(entry), []byte{}, value.ExpiresAt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SyncSecondaryIndexes builds the indexes which were declared or changed since the last start and drops the removed ones.
+// it runs before the workers so no value is written while an index is built.
+func (m *Manager) SyncSecondaryIndexes() error {
+	declared := make(map[string]bool)
+	for _, index := range m.config.Manager.SecondaryIndexes {
+		declared[index.Name] = true
+		defIndex, err := BuildSecondaryIndexDefIndex(index.Name)
+		if err != nil {
+			return err
+		}
+		definition := index.Prefix + "\x00" + index.Path
+		if _, ok := m.db.(storage.EncryptedStorage); ok {
+			// the entries hold hashed values, entries holding plain values are rebuilt
+			definition += "\x00hmac"
+		}
+		stored, err := m.db.Get([]byte(defIndex))
+		if err == nil && string(stored) == definition {
+			continue
+		} else if err != nil && err != storage.KEY_NOT_FOUND {
+			return err
+		}
+		logrus.Infof("building secondary index %s", index.Name)
+		err = m.dropSecondaryIndex(index.Name)
+		if err != nil {
+			return err
+		}
+		err = m.buildSecondaryIndex(index)
+		if err != nil {
+			return err
+		}
+		err = m.db.Put([]byte(defIndex), []byte(definition))
+		if err != nil {
+			return err
+		}
+	}
+
+	var removed []string
+	it := m.db.NewIterator(secondaryIndexDefType.Start(), secondaryIndexDefType.Limit(), false)
+	for ; !it.IsDone(); it.Next() {
+		name, err := ParseSecondaryIndexDefName(string(it.Key()))
+		if err != nil {
+			it.Release()
+			return err
+		}
+		if !declared[name] {
+			removed = append(removed, name)
+		}
+	}
+	it.Release()
+	for _, name := range removed {
+		logrus.Infof("dropping secondary index %s", name)
+		err := m.dropSecondaryIndex(name)
+		if err != nil {
+			return err
+		}
+		defIndex, err := BuildSecondaryIndexDefIndex(name)
+		if err != nil {
+			return err
+		}
+		err = m.db.Delete([]byte(defIndex))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropSecondaryIndex deletes every entry of the index name.
+func (m *Manager) dropSecondaryIndex(name string) error {
+	prefix, err := storage.NewIndex(secondaryIndexType).AddColumn(storage.CreateUnorderedColumn("index", name)).Build()
+	if err != nil {
+		return err
+	}
+	for {
+		var entries [][]byte
+		it := m.db.NewIterator([]byte(prefix), []byte(storage.Limit(prefix)), false)
+		for ; !it.IsDone() && len(entries) < secondaryIndexBatchSize; it.Next() {
+			entries = append(entries, append([]byte{}, it.Key()...))
+		}
+		it.Release()
+		if len(entries) == 0 {
+			return nil
+		}
+		trx := m.db.NewTransaction(true)
+		for _, entry := range entries {
+			err = trx.Delete(entry)
+			if err != nil {
+				trx.Discard()
+				return err
+			}
+		}
+		err = trx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// buildSecondaryIndex adds the entries of every value stored under the prefix of index.
+func (m *Manager) buildSecondaryIndex(index config.SecondaryIndexConfig) error {
+	startIndex, err := BuildKeyIndex(index.Prefix)
+	if err != nil {
+		return err
+	}
+	limitIndex := keyIndexType.Limit()
+	if limit := prefixLimit(index.Prefix); limit != "" {
+		keyIndex, err := BuildKeyIndex(limit)
+		if err != nil {
+			return err
+		}
+		limitIndex = []byte(keyIndex)
+	}
+
+	it := m.db.NewIterator([]byte(startIndex), limitIndex, false)
+	defer it.Release()
+	trx := m.db.NewTransaction(true)
+	defer func() { trx.Discard() }()
+	pending := 0
+	for ; !it.IsDone(); it.Next() {
+		if skipUnreadable(it, "buildSecondaryIndex") {
+			continue
+		}
+		value, err := decodeValueRecord(it.Value())
+		if err != nil {
+			return errors.Wrap(err, "buildSecondaryIndex Unmarshal")
+		}
+		entries, err := m.secondaryIndexEntries(index, value)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err = m.setIndexEntry(trx, value.Key, []byte(entry), []byte{}, value.ExpiresAt)
+			if err != nil {
+				return err
+			}
+			pending++
+		}
+		if pending >= secondaryIndexBatchSize {
+			err = trx.Commit()
+			if err != nil {
+				return err
+			}
+			trx = m.db.NewTransaction(true)
+			pending = 0
+		}
+	}
+	return trx.Commit()
+}
+
+// queryLocal sends the values of the keys from start to end holding indexValue in the index name
+// which belong to partitions to resCh.
+func (m *Manager) queryLocal(name, indexValue, start, end string, limit int, partitions []int32, resCh chan interface{}) error {
+	indexValue = m.indexToken(indexValue)
+	startIndex, err := BuildSecondaryIndex(name, indexValue, start)
+	if err != nil {
+		return err
+	}
+	limitIndex := ""
+	if end != "" {
+		limitIndex, err = BuildSecondaryIndex(name, indexValue, end)
+	} else {
+		limitIndex, err = BuildSecondaryIndexPrefix(name, indexValue)
+		limitIndex = storage.Limit(limitIndex)
+	}
+	if err != nil {
+		return err
+	}
+	wanted := utils.NewInt32Set()
+	for _, partition := range partitions {
+		wanted.Add(partition)
+	}
+
+	it := m.db.NewIterator([]byte(startIndex), []byte(limitIndex), false)
+	defer it.Release()
+	count := 0
+	for ; !it.IsDone() && count < limit; it.Next() {
+		key, err := ParseSecondaryIndexKey(string(it.Key()))
+		if err != nil {
+			return err
+		}
+		// queries cover the default namespace
+		if keyNamespace(key) != "" || !wanted.Has(int32(m.ring.FindPartitionID([]byte(key)))) {
+			continue
+		}
+		value, err := m.GetValue(key)
+		if err == storage.KEY_NOT_FOUND {
+			continue
+		} else if err != nil {
+			return err
+		}
+		resCh <- value
+		count++
+	}
+	return nil
+}
+
+// QueryRequest returns up to limit live values holding indexValue in the index name from start and a token for the next page.
+// replicas only return the keys they index so a key the replicas of its partition disagree on is read again with readQuorum.
+func (m *Manager) QueryRequest(name, indexValue, start string, limit, readQuorum int) ([]*rpc.RpcValue, string, error) {
+	index, ok := m.secondaryIndex(name)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: unknown index %q", http.ErrInvalidQuery, name)
+	}
+	result, err := m.scanPartitions(&rpc.RpcScanRequest{Start: start, Limit: int32(limit), Index: name, IndexValue: indexValue}, readQuorum)
+	if err != nil {
+		return nil, "", err
+	}
+	keys, token := result.page(limit)
+
+	values := make([]*rpc.RpcValue, len(keys))
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, utils.Max(m.config.Manager.PartitionConcurrency, 1))
+	for i, key := range keys {
+		partition := int32(m.ring.FindPartitionID([]byte(key)))
+		if result.replies[key] == result.partitionResponses[partition] && !result.diverged[key] {
+			values[i] = result.values[key]
+			continue
+		}
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			values[i], _, errs[i] = m.GetRequest(key, readQuorum)
+		}(i, key)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	var matched []*rpc.RpcValue
+	for _, value := range values {
+		if value == nil || value.Deleted || isExpired(value, now) {
+			continue
+		}
+		for _, valueField := range indexValues(index, value) {
+			if valueField == indexValue {
+				matched = append(matched, value)
+				break
+			}
+		}
+	}
+	return matched, token, nil
+}
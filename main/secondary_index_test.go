@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+)
+
+func TestSecondaryIndexEntry(t *testing.T) {
+	index, err := BuildSecondaryIndex("city", "a_b\x00", "user_1")
+	assert.NoError(t, err)
+	key, err := ParseSecondaryIndexKey(index)
+	assert.NoError(t, err)
+	assert.Equal(t, "user_1", key, "parsed key wrong value")
+
+	prefix, err := BuildSecondaryIndexPrefix("city", "a_b\x00")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(index, prefix), "entry should start with the prefix of its value")
+	otherValue, err := BuildSecondaryIndex("city", "a_b\x00c", "user_1")
+	assert.NoError(t, err)
+	assert.False(t, prefix <= otherValue && otherValue < storage.Limit(prefix), "entries of other values should be outside the range")
+
+	keyIndex, err := BuildKeyIndex("user_1")
+	assert.NoError(t, err)
+	_, err = ParseSecondaryIndexKey(keyIndex)
+	assert.Error(t, err, "other indexes should not parse")
+}
+
+func TestExtractJsonPath(t *testing.T) {
+	doc := []byte(`{"address":{"city":"paris","zip":75001},"tags":["a",true,{"x":1}],"active":false}`)
+	assert.Equal(t, []string{"paris"}, extractJsonPath(doc, "address.city"), "nested string wrong value")
+	assert.Equal(t, []string{"75001"}, extractJsonPath(doc, "address.zip"), "number wrong value")
+	assert.Equal(t, []string{"false"}, extractJsonPath(doc, "active"), "bool wrong value")
+	assert.Equal(t, []string{"a", "true"}, extractJsonPath(doc, "tags"), "array scalars wrong value")
+	assert.Empty(t, extractJsonPath(doc, "address"), "objects are not indexed")
+	assert.Empty(t, extractJsonPath(doc, "address.city.name"), "missing path should have no values")
+	assert.Empty(t, extractJsonPath([]byte("not json"), "address.city"), "invalid json should have no values")
+
+	err := validateSecondaryIndexes([]config.SecondaryIndexConfig{{Name: "city", Path: "address.city"}})
+	assert.NoError(t, err)
+	err = validateSecondaryIndexes([]config.SecondaryIndexConfig{{Path: "address.city"}})
+	assert.Error(t, err, "indexes without a name should be rejected")
+	err = validateSecondaryIndexes([]config.SecondaryIndexConfig{{Name: "city", Path: "a"}, {Name: "city", Path: "b"}})
+	assert.Error(t, err, "duplicate names should be rejected")
+}
+
+func queryLocalKeys(t *testing.T, manager *Manager, name, indexValue string) []string {
+	resCh := make(chan interface{}, 20)
+	err := manager.queryLocal(name, indexValue, "", "", 10, []int32{0}, resCh)
+	assert.NoError(t, err)
+	close(resCh)
+	var keys []string
+	for item := range resCh {
+		keys = append(keys, item.(*rpc.RpcValue).Key)
+	}
+	return keys
+}
+
+func TestSecondaryIndexMaintenance(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	c.Manager.SecondaryIndexes = []config.SecondaryIndexConfig{{Name: "city", Prefix: "user/", Path: "address.city"}}
+	manager := NewManager(c)
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "user/1", Value: []byte(`{"address":{"city":"paris"}}`), Epoch: 1, UnixTimestamp: 10})
+	assert.NoError(t, err)
+	err = manager.SetValue(&rpc.RpcValue{Key: "user/2", Value: []byte(`{"address":{"city":"rome"}}`), Epoch: 1, UnixTimestamp: 10})
+	assert.NoError(t, err)
+	err = manager.SetValue(&rpc.RpcValue{Key: "other/1", Value: []byte(`{"address":{"city":"paris"}}`), Epoch: 1, UnixTimestamp: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user/1"}, queryLocalKeys(t, &manager, "city", "paris"), "keys outside the prefix should not be indexed")
+
+	// the entry moves with the value
+	err = manager.SetValue(&rpc.RpcValue{Key: "user/1", Value: []byte(`{"address":{"city":"rome"}}`), Epoch: 1, UnixTimestamp: 11})
+	assert.NoError(t, err)
+	assert.Empty(t, queryLocalKeys(t, &manager, "city", "paris"), "old entry should be removed")
+	assert.Equal(t, []string{"user/1", "user/2"}, queryLocalKeys(t, &manager, "city", "rome"), "rome keys wrong value")
+
+	err = manager.SetValue(&rpc.RpcValue{Key: "user/2", Epoch: 1, UnixTimestamp: 11, Deleted: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user/1"}, queryLocalKeys(t, &manager, "city", "rome"), "tombstones should not be indexed")
+
+	// a rejected write leaves the index unchanged
+	err = manager.SetValue(&rpc.RpcValue{Key: "user/1", Value: []byte(`{"address":{"city":"oslo"}}`), Epoch: 1, UnixTimestamp: 5})
+	assert.Error(t, err, "older write should be rejected")
+	assert.Empty(t, queryLocalKeys(t, &manager, "city", "oslo"), "rejected write should not be indexed")
+}
+
+func TestSyncSecondaryIndexes(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "user/1", Value: []byte(`{"city":"paris"}`), Epoch: 1, UnixTimestamp: 10})
+	assert.NoError(t, err)
+
+	manager.config.Manager.SecondaryIndexes = []config.SecondaryIndexConfig{{Name: "city", Prefix: "user/", Path: "city"}}
+	err = manager.SyncSecondaryIndexes()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user/1"}, queryLocalKeys(t, &manager, "city", "paris"), "existing values should be indexed")
+
+	manager.config.Manager.SecondaryIndexes = nil
+	err = manager.SyncSecondaryIndexes()
+	assert.NoError(t, err)
+	assert.Empty(t, queryLocalKeys(t, &manager, "city", "paris"), "removed index should be dropped")
+}
+
+func TestSecondaryIndexEncrypted(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Storage.Engine = "memory"
+	c.Storage.EncryptionKeys = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	c.Manager.SecondaryIndexes = []config.SecondaryIndexConfig{{Name: "city", Prefix: "user/", Path: "city"}}
+	manager := NewManager(c)
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "user/1", Value: []byte(`{"city":"paris"}`), Epoch: 1, UnixTimestamp: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user/1"}, queryLocalKeys(t, &manager, "city", "paris"), "encrypted storage should be queried by value")
+
+	// the keys of the entries are not encrypted so they hold the token of the value
+	prefix, err := storage.NewIndex(secondaryIndexType).AddColumn(storage.CreateUnorderedColumn("index", "city")).Build()
+	assert.NoError(t, err)
+	it := manager.db.NewIterator([]byte(prefix), []byte(storage.Limit(prefix)), false)
+	defer it.Release()
+	assert.False(t, it.IsDone(), "the value should be indexed")
+	assert.NotContains(t, string(it.Key()), "paris", "the entry should not reveal the value")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// isSiblingKey reports if concurrent writes to key are kept as siblings instead of last-write-wins.
+func (m *Manager) isSiblingKey(key string) bool {
+	for _, prefix := range m.config.Manager.SiblingPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeCausalContext returns the opaque causal context handed to clients.
+func EncodeCausalContext(context *rpc.RpcCausalContext) (string, error) {
+	if context == nil || len(context.Entries) == 0 {
+		return "", nil
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(context)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func DecodeCausalContext(raw string) (*rpc.RpcCausalContext, error) {
+	context := &rpc.RpcCausalContext{}
+	if raw == "" {
+		return context, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	err = proto.Unmarshal(data, context)
+	if err != nil {
+		return nil, err
+	}
+	return context, nil
+}
+
+// dotCovered reports if the write identified by dot has been seen by context.
+func dotCovered(dot utils.HybridTimestamp, context *rpc.RpcCausalContext) bool {
+	if context == nil {
+		return false
+	}
+	seen, ok := context.Entries[dot.Node]
+	if !ok {
+		return false
+	}
+	return utils.HybridTimestamp{Physical: seen.Physical, Logical: seen.Logical, Node: dot.Node}.Compare(dot) >= 0
+}
+
+func addToContext(context *rpc.RpcCausalContext, dot utils.HybridTimestamp) {
+	if dotCovered(dot, context) {
+		return
+	}
+	context.Entries[dot.Node] = rpc.NewRpcHybridTimestamp(dot)
+}
+
+// siblingContainer returns value as a set of siblings.
+// a single write becomes one sibling whose context is what the writer had seen plus the write itself.
+func siblingContainer(value *rpc.RpcValue) *rpc.RpcValue {
+	if value == nil {
+		return &rpc.RpcValue{Context: &rpc.RpcCausalContext{Entries: map[string]*rpc.RpcHybridTimestamp{}}}
+	}
+	if len(value.Siblings) > 0 {
+		return value
+	}
+	sibling := proto.Clone(value).(*rpc.RpcValue)
+	sibling.Context = nil
+	container := &rpc.RpcValue{Key: value.Key, Context: &rpc.RpcCausalContext{Entries: map[string]*rpc.RpcHybridTimestamp{}}, Siblings: []*rpc.RpcValue{sibling}}
+	if value.Context != nil {
+		for node, seen := range value.Context.Entries {
+			container.Context.Entries[node] = seen
+		}
+	}
+	addToContext(container.Context, rpc.ValueHybridTimestamp(sibling))
+	return container
+}
+
+// mergeSiblings joins two sibling sets with dotted version vector semantics.
+// a sibling survives if the other side also has it or has not seen it yet.
+func mergeSiblings(a, b *rpc.RpcValue) *rpc.RpcValue {
+	a = siblingContainer(a)
+	b = siblingContainer(b)
+
+	merged := &rpc.RpcValue{Key: a.Key, Context: &rpc.RpcCausalContext{Entries: map[string]*rpc.RpcHybridTimestamp{}}}
+	if merged.Key == "" {
+		merged.Key = b.Key
+	}
+
+	kept := make(map[utils.HybridTimestamp]bool)
+	keep := func(siblings []*rpc.RpcValue, other *rpc.RpcValue) {
+		for _, sibling := range siblings {
+			dot := rpc.ValueHybridTimestamp(sibling)
+			if kept[dot] {
+				continue
+			}
+			if hasSibling(other, dot) || !dotCovered(dot, other.Context) {
+				kept[dot] = true
+				merged.Siblings = append(merged.Siblings, sibling)
+			}
+		}
+	}
+	keep(a.Siblings, b)
+	keep(b.Siblings, a)
+
+	for _, context := range []*rpc.RpcCausalContext{a.Context, b.Context} {
+		for node, seen := range context.Entries {
+			addToContext(merged.Context, utils.HybridTimestamp{Physical: seen.Physical, Logical: seen.Logical, Node: node})
+		}
+	}
+
+	sort.Slice(merged.Siblings, func(i, j int) bool {
+		return rpc.ValueHybridTimestamp(merged.Siblings[i]).Compare(rpc.ValueHybridTimestamp(merged.Siblings[j])) < 0
+	})
+
+	// the container takes the version of its newest sibling so epoch indexes and sync keep working
+	merged.Deleted = true
+	for _, sibling := range merged.Siblings {
+		merged.Epoch = utils.Max(merged.Epoch, sibling.Epoch)
+		merged.Deleted = merged.Deleted && sibling.Deleted
+	}
+	if len(merged.Siblings) > 0 {
+		newest := merged.Siblings[len(merged.Siblings)-1]
+		merged.Hlc = rpc.NewRpcHybridTimestamp(rpc.ValueHybridTimestamp(newest))
+		merged.UnixTimestamp = newest.UnixTimestamp
+	}
+	return merged
+}
+
+func hasSibling(container *rpc.RpcValue, dot utils.HybridTimestamp) bool {
+	for _, sibling := range container.Siblings {
+		if rpc.ValueHybridTimestamp(sibling) == dot {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSiblings reports if both containers hold the same set of siblings.
+func sameSiblings(a, b *rpc.RpcValue) bool {
+	a = siblingContainer(a)
+	b = siblingContainer(b)
+	if len(a.Siblings) != len(b.Siblings) {
+		return false
+	}
+	for _, sibling := range a.Siblings {
+		if !hasSibling(b, rpc.ValueHybridTimestamp(sibling)) {
+			return false
+		}
+	}
+	return true
+}
+
+// liveSiblings returns the values of the siblings which are not tombstones.
+func liveSiblings(value *rpc.RpcValue) []string {
+	var values []string
+	for _, sibling := range siblingContainer(value).Siblings {
+		if !sibling.Deleted {
+			values = append(values, string(sibling.Value))
+		}
+	}
+	return values
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func siblingWrite(value string, node string, physical int64, context *rpc.RpcCausalContext) *rpc.RpcValue {
+	version := utils.HybridTimestamp{Physical: physical, Node: node}
+	return &rpc.RpcValue{Key: "cart/1", Value: []byte(value), Epoch: 1, UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version), Context: context}
+}
+
+func TestMergeSiblings(t *testing.T) {
+	a := siblingWrite("a", "store-0", 1000, nil)
+	b := siblingWrite("b", "store-1", 1000, nil)
+
+	merged := mergeSiblings(a, b)
+	assert.ElementsMatch(t, []string{"a", "b"}, liveSiblings(merged), "concurrent writes should be siblings")
+	assert.Equal(t, true, sameSiblings(merged, mergeSiblings(b, a)), "merge should be commutative")
+	assert.Equal(t, true, sameSiblings(merged, mergeSiblings(merged, a)), "merge should be idempotent")
+
+	// a write which has seen both siblings replaces them
+	c := siblingWrite("c", "store-0", 2000, merged.Context)
+	resolved := mergeSiblings(merged, c)
+	assert.Equal(t, []string{"c"}, liveSiblings(resolved), "context should collapse siblings")
+
+	// a stale replica merging the resolved value does not bring the old siblings back
+	assert.Equal(t, []string{"c"}, liveSiblings(mergeSiblings(a, resolved)), "covered sibling should not come back")
+}
+
+func TestCausalContextEncoding(t *testing.T) {
+	merged := mergeSiblings(siblingWrite("a", "store-0", 1000, nil), siblingWrite("b", "store-1", 1000, nil))
+	raw, err := EncodeCausalContext(merged.Context)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, raw)
+
+	decoded, err := DecodeCausalContext(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, len(merged.Context.Entries), len(decoded.Entries), "decoded context wrong size")
+
+	_, err = DecodeCausalContext("not a context!")
+	assert.Error(t, err)
+}
+
+func TestSetValueSiblings(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	c.Manager.SiblingPrefixes = []string{"cart/"}
+	manager := NewManager(c)
+
+	err := manager.SetValue(siblingWrite("a", "store-0", 2000, nil))
+	assert.NoError(t, err)
+	// an older concurrent write is kept instead of rejected
+	err = manager.SetValue(siblingWrite("b", "store-1", 1000, nil))
+	assert.NoError(t, err)
+
+	value, err := manager.GetValue("cart/1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, liveSiblings(value), "both writes should be kept")
+
+	err = manager.SetValue(siblingWrite("c", "store-2", 3000, value.Context))
+	assert.NoError(t, err)
+	value, err = manager.GetValue("cart/1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, liveSiblings(value), "siblings should collapse")
+}
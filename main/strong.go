@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/status"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/consensus"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// ErrStrongBatch is reported for strong keys of a batch, they are only served one key at a time.
+var ErrStrongBatch = errors.New("strong keys cannot be read or written in a batch")
+
+// strongRetryDelay is the wait between rounds over the replicas while a partition group elects a leader.
+const strongRetryDelay = 100 * time.Millisecond
+
+// isStrongKey reports if key is read and written through the raft group of its partition instead of quorums.
+func (m *Manager) isStrongKey(key string) bool {
+	for _, prefix := range m.config.Manager.StrongPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) partitionMembers(partitionId int) ([]string, error) {
+	nodes, err := m.ring.GetClosestNForPartition(partitionId, m.config.Manager.ReplicaCount, true)
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	for _, node := range nodes {
+		members = append(members, node.String())
+	}
+	// every replica bootstraps a new group with the same configuration
+	sort.Strings(members)
+	return members, nil
+}
+
+var reconcileLock sync.Mutex
+
+// ReconcilePartitionGroups runs a raft group for every partition this node replicates and stops the others.
+// the leader of each group changes its voters to the replicas of the partition.
+func (m *Manager) ReconcilePartitionGroups() error {
+	if len(m.config.Manager.StrongPrefixes) == 0 {
+		return nil
+	}
+	reconcileLock.Lock()
+	defer reconcileLock.Unlock()
+	held := utils.NewIntSet()
+	for partitionId := 0; partitionId < m.config.Manager.PartitionCount; partitionId++ {
+		members, err := m.partitionMembers(partitionId)
+		if err != nil {
+			return err
+		}
+		i := sort.SearchStrings(members, m.config.Manager.Hostname)
+		if i == len(members) || members[i] != m.config.Manager.Hostname {
+			continue
+		}
+		held.Add(partitionId)
+		group, err := m.partitionGroups.Ensure(partitionId, members)
+		if err != nil {
+			return errors.Wrapf(err, "partition %d Ensure", partitionId)
+		}
+		err = group.SetMembers(members)
+		if err != nil {
+			logrus.Warnf("partition %d SetMembers err = %v", partitionId, err)
+		}
+	}
+	for _, partitionId := range m.partitionGroups.Partitions() {
+		if held.Has(partitionId) {
+			continue
+		}
+		err := m.partitionGroups.Remove(partitionId)
+		if err != nil {
+			logrus.Errorf("partition %d Remove err = %v", partitionId, err)
+		}
+	}
+	return nil
+}
+
+// strongRequest sends req to the leader of the group of its key and returns the value read or stored.
+// replicas which are not the leader answer with the leader they know, which is tried next.
+func (m *Manager) strongRequest(req *rpc.RpcStrongRequest) (*rpc.RpcValue, []string, error) {
+	partitionId := m.ring.FindPartitionID([]byte(req.Key))
+	members, err := m.partitionMembers(partitionId)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+
+	var lastErr error
+	for ctx.Err() == nil {
+		candidates := append([]string{}, members...)
+		if group := m.partitionGroups.Get(partitionId); group != nil && group.Leader() != "" {
+			candidates = append([]string{group.Leader()}, candidates...)
+		}
+		tried := make(map[string]bool)
+		for len(candidates) > 0 {
+			member := candidates[0]
+			candidates = candidates[1:]
+			if tried[member] {
+				continue
+			}
+			tried[member] = true
+			client, err := m.clientManager.GetClient(member)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			res, err := client.StrongRequest(ctx, req)
+			if st, ok := status.FromError(err); ok && st.Code() == codes.FailedPrecondition {
+				return nil, members, &preconditionError{Version: st.Message()}
+			} else if err != nil {
+				lastErr = err
+				continue
+			}
+			if res.NotLeader {
+				if res.Leader != "" {
+					candidates = append([]string{res.Leader}, candidates...)
+				}
+				continue
+			}
+			return res.Value, members, nil
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(strongRetryDelay):
+		}
+	}
+	return nil, members, fmt.Errorf("partition %d: no leader served the request: %v", partitionId, lastErr)
+}
+
+// strongWrite writes value through the raft group of its partition and returns the value stored by the leader.
+func (m *Manager) strongWrite(value *rpc.RpcValue) (*rpc.RpcValue, []string, error) {
+	stored, members, err := m.strongRequest(&rpc.RpcStrongRequest{Key: value.Key, Value: value})
+	if err == nil && stored == nil {
+		err = errors.New("strong write returned no value")
+	}
+	return stored, members, err
+}
+
+// strongRead reads key from the leader of its partition group. it returns nil when there is no live value.
+func (m *Manager) strongRead(key string) (*rpc.RpcValue, []string, error) {
+	value, members, err := m.strongRequest(&rpc.RpcStrongRequest{Key: key})
+	if err != nil || value == nil || value.Deleted || isExpired(value, time.Now().UnixMilli()) {
+		return nil, members, err
+	}
+	return value, members, nil
+}
+
+// StrongLocal serves a strong request when this node leads the group of the key.
+// reads wait on a barrier so every write committed before the read is applied.
+func (m *Manager) StrongLocal(req *rpc.RpcStrongRequest) (*rpc.RpcStrongResponse, error) {
+	group := m.partitionGroups.Get(m.ring.FindPartitionID([]byte(req.Key)))
+	if group == nil {
+		return &rpc.RpcStrongResponse{NotLeader: true}, nil
+	}
+	if !group.IsLeader() {
+		return &rpc.RpcStrongResponse{NotLeader: true, Leader: group.Leader()}, nil
+	}
+	timeout := time.Second * time.Duration(m.config.Manager.DefaultTimeout)
+
+	if req.Value == nil {
+		err := group.Barrier(timeout)
+		if errors.Is(err, consensus.ErrNotLeader) {
+			return &rpc.RpcStrongResponse{NotLeader: true, Leader: group.Leader()}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		value, err := m.GetValue(req.Key)
+		if err == storage.KEY_NOT_FOUND {
+			return &rpc.RpcStrongResponse{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return &rpc.RpcStrongResponse{Value: value}, nil
+	}
+
+	// the leader orders the writes of the group so it versions them
+	value := proto.Clone(req.Value).(*rpc.RpcValue)
+	version := m.clock.Now()
+	value.Hlc = rpc.NewRpcHybridTimestamp(version)
+	value.UnixTimestamp = version.UnixTimestamp()
+	value.Epoch = m.GetCurrentEpoch()
+	stored, err := group.Apply(value, timeout)
+	var conflict *preconditionError
+	if errors.Is(err, consensus.ErrNotLeader) {
+		return &rpc.RpcStrongResponse{NotLeader: true, Leader: group.Leader()}, nil
+	} else if errors.As(err, &conflict) {
+		return nil, status.Error(codes.FailedPrecondition, conflict.Version)
+	} else if err != nil {
+		return nil, err
+	}
+	return &rpc.RpcStrongResponse{Value: stored}, nil
+}
+
+// applyStrongValue writes a value committed by a partition group.
+// every replica applies the same writes in the same order so preconditions are checked against the same value.
+func (m *Manager) applyStrongValue(value *rpc.RpcValue) (*rpc.RpcValue, error) {
+	m.clock.Update(rpc.ValueHybridTimestamp(value))
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	stored, err := m.setValueTrx(trx, value)
+	if err != nil {
+		return nil, err
+	}
+	err = trx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	m.changeFeed.Publish(stored)
+	return stored, nil
+}
+
+// strongValues returns the strong values of a partition held by this node, the snapshot of its group.
+func (m *Manager) strongValues(partitionId int) ([]*rpc.RpcValue, error) {
+	var values []*rpc.RpcValue
+	// prefixes may overlap
+	seen := make(map[string]bool)
+	for _, prefix := range m.config.Manager.StrongPrefixes {
+		startIndex, err := BuildKeyIndex(prefix)
+		if err != nil {
+			return nil, err
+		}
+		limitIndex := keyIndexType.Limit()
+		if limit := prefixLimit(prefix); limit != "" {
+			index, err := BuildKeyIndex(limit)
+			if err != nil {
+				return nil, err
+			}
+			limitIndex = []byte(index)
+		}
+		it := m.db.NewIterator([]byte(startIndex), limitIndex, false)
+		for ; !it.IsDone(); it.Next() {
+			if skipUnreadable(it, "strongValues") {
+				continue
+			}
+			value, err := decodeValueRecord(it.Value())
+			if err != nil {
+				it.Release()
+				return nil, errors.Wrap(err, "strongValues Unmarshal")
+			}
+			if !seen[value.Key] && m.ring.FindPartitionID([]byte(value.Key)) == partitionId {
+				seen[value.Key] = true
+				values = append(values, value)
+			}
+		}
+		it.Release()
+	}
+	return values, nil
+}
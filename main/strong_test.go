@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+)
+
+func TestIsStrongKey(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.StrongPrefixes = []string{"lock/"}
+	manager := NewManager(c)
+
+	assert.True(t, manager.isStrongKey("lock/a"), "prefixed key should be strong")
+	assert.False(t, manager.isStrongKey("user/a"), "other keys should not be strong")
+
+	items := manager.MGetRequest([]string{"lock/a"}, 1)
+	assert.Equal(t, ErrStrongBatch.Error(), items[0].Error, "batches should reject strong keys")
+
+	_, _, err := manager.TxnRequest(nil, []http.TxnWrite{{Key: "lock/a", Value: "1"}}, 1)
+	assert.ErrorIs(t, err, http.ErrInvalidTxn, "transactions should reject strong keys")
+}
+
+func TestStrongValues(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.StrongPrefixes = []string{"lock/", "lock/a"}
+	manager := NewManager(c)
+
+	for _, key := range []string{"lock/a", "lock/b", "user/a"} {
+		err := manager.SetValue(&rpc.RpcValue{Key: key, Value: []byte("v"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 10}})
+		assert.NoError(t, err)
+	}
+	values, err := manager.strongValues(0)
+	assert.NoError(t, err)
+	var keys []string
+	for _, value := range values {
+		keys = append(keys, value.Key)
+	}
+	assert.Equal(t, []string{"lock/a", "lock/b"}, keys, "snapshot should hold each strong key once")
+}
+
+func TestStrongLocalWithoutGroup(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.StrongPrefixes = []string{"lock/"}
+	manager := NewManager(c)
+
+	res, err := manager.StrongLocal(&rpc.RpcStrongRequest{Key: "lock/a"})
+	assert.NoError(t, err)
+	assert.True(t, res.NotLeader, "a node without the group should not serve")
+	assert.Empty(t, res.Leader, "a node without the group does not know the leader")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+type tombstoneEntry struct {
+	epochIndex string
+	key        string
+	epoch      int64
+	version    utils.HybridTimestamp
+}
+
+// CollectTombstones removes tombstones of a partition which are older than the grace period.
+// only tombstones in verified epochs are removed, otherwise a replica missing the delete could resurrect the value.
+func (m *Manager) CollectTombstones(partitionId int) (int, error) {
+	cutoff := (time.Now().Unix() - int64(m.config.Manager.TombstoneGracePeriod)) * 1000
+	verifiedEpochs := make(map[int64]bool)
+
+	var tombstones []tombstoneEntry
+	for bucket := 0; bucket < m.config.Manager.PartitionBuckets; bucket++ {
+		index1, err := BuildEpochIndex(partitionId, uint64(bucket), 0, "")
+		if err != nil {
+			return 0, err
+		}
+		index2, err := BuildEpochIndex(partitionId, uint64(bucket), m.GetCurrentEpoch(), "")
+		if err != nil {
+			return 0, err
+		}
+		it := m.db.NewIterator([]byte(index1), []byte(index2), false)
+		for !it.IsDone() {
+			if skipUnreadable(it, "CollectTombstones") {
+				it.Next()
+				continue
+			}
+			epochIndex := string(it.Key())
+			version, deleted, _, err := ParseEpochIndexValue(it.Value())
+			if err != nil {
+				it.Release()
+				return 0, errors.Wrap(err, "ParseEpochIndexValue")
+			}
+			if !deleted || version.Physical > cutoff {
+				it.Next()
+				continue
+			}
+			_, _, epoch, key, err := ParseEpochIndex(epochIndex)
+			if err != nil {
+				it.Release()
+				return 0, errors.Wrap(err, "ParseEpochIndex")
+			}
+			verified, ok := verifiedEpochs[epoch]
+			if !ok {
+				verified = m.isEpochVerified(partitionId, epoch)
+				verifiedEpochs[epoch] = verified
+			}
+			if verified {
+				tombstones = append(tombstones, tombstoneEntry{epochIndex: epochIndex, key: key, epoch: epoch, version: version})
+			}
+			it.Next()
+		}
+		it.Release()
+	}
+
+	for _, tombstone := range tombstones {
+		err := m.collectTombstone(tombstone)
+		if err != nil {
+			return 0, err
+		}
+	}
+	tombstonesCollectedCounter.Add(float64(len(tombstones)))
+	return len(tombstones), nil
+}
+
+func (m *Manager) isEpochVerified(partitionId int, epoch int64) bool {
+	index, err := BuildEpochTreeObjectIndex(partitionId, epoch)
+	if err != nil {
+		return false
+	}
+	epochTreeObjectBytes, err := m.db.Get([]byte(index))
+	if err != nil {
+		return false
+	}
+	epochTreeObject := &rpc.RpcEpochTreeObject{}
+	err = proto.Unmarshal(epochTreeObjectBytes, epochTreeObject)
+	if err != nil {
+		logrus.Errorf("isEpochVerified Unmarshal err = %v", err)
+		return false
+	}
+	return epochTreeObject.Valid
+}
+
+func (m *Manager) collectTombstone(tombstone tombstoneEntry) error {
+	keyIndex, err := BuildKeyIndex(tombstone.key)
+	if err != nil {
+		return err
+	}
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+
+	// the item index may already hold a newer write for the key
+	existingBytes, err := trx.Get([]byte(keyIndex))
+	if err == nil {
+		existingValue, err := decodeValueRecord(existingBytes)
+		if err != nil {
+			return err
+		}
+		if existingValue.Deleted && existingValue.Epoch == tombstone.epoch && rpc.ValueHybridTimestamp(existingValue) == tombstone.version {
+			err = trx.Delete([]byte(keyIndex))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = trx.Delete([]byte(tombstone.epochIndex))
+	if err != nil {
+		return err
+	}
+	return trx.Commit()
+}
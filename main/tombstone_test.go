@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+)
+
+func TestTombstoneConflict(t *testing.T) {
+	value := &rpc.RpcValue{Key: "key", Value: []byte("value"), Epoch: 1, UnixTimestamp: 10}
+	tombstone := &rpc.RpcValue{Key: "key", Epoch: 1, UnixTimestamp: 10, Deleted: true}
+	newerValue := &rpc.RpcValue{Key: "key", Value: []byte("value"), Epoch: 1, UnixTimestamp: 11}
+
+	assert.Equal(t, true, isNewerValue(value, tombstone), "tombstone should win a tie")
+	assert.Equal(t, false, isNewerValue(tombstone, value), "value should not win a tie")
+	assert.Equal(t, true, isNewerValue(tombstone, newerValue), "newer value should win")
+}
+
+func TestCollectTombstones(t *testing.T) {
+	var err error
+
+	tmpDir := t.TempDir()
+	logrus.Info("Temporary Directory:", tmpDir)
+	c := config.GetConfig()
+	c.Storage.DataPath = tmpDir
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	c.Manager.TombstoneGracePeriod = 0
+	manager := NewManager(c)
+	manager.CurrentEpoch = 10
+
+	oldTimestamp := time.Now().Unix() - 10
+
+	// epoch 1 is verified, epoch 2 is not
+	for _, epoch := range []int64{1, 2} {
+		obj := &rpc.RpcEpochTreeObject{Partition: 0, LowerEpoch: epoch, Valid: epoch == 1}
+		data, err := proto.Marshal(obj)
+		if err != nil {
+			t.Error(err)
+		}
+		index, err := BuildEpochTreeObjectIndex(0, epoch)
+		if err != nil {
+			t.Error(err)
+		}
+		err = manager.db.Put([]byte(index), data)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err = manager.SetValue(&rpc.RpcValue{Key: "verified", Value: []byte("v"), Epoch: 1, UnixTimestamp: oldTimestamp - 1})
+	if err != nil {
+		t.Error(err)
+	}
+	err = manager.SetValue(&rpc.RpcValue{Key: "verified", Epoch: 1, UnixTimestamp: oldTimestamp, Deleted: true})
+	if err != nil {
+		t.Error(err)
+	}
+	err = manager.SetValue(&rpc.RpcValue{Key: "unverified", Epoch: 2, UnixTimestamp: oldTimestamp, Deleted: true})
+	if err != nil {
+		t.Error(err)
+	}
+	err = manager.SetValue(&rpc.RpcValue{Key: "live", Value: []byte("v"), Epoch: 1, UnixTimestamp: oldTimestamp})
+	if err != nil {
+		t.Error(err)
+	}
+
+	tombstone, err := manager.GetValue("verified")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, true, tombstone.Deleted, "GetValue should return the tombstone")
+
+	collected, err := manager.CollectTombstones(0)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, collected, "only the verified tombstone should be collected")
+
+	_, err = manager.GetValue("verified")
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "verified tombstone should be removed")
+
+	tombstone, err = manager.GetValue("unverified")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, true, tombstone.Deleted, "unverified tombstone should remain")
+
+	live, err := manager.GetValue("live")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, "v", string(live.Value), "live value should remain")
+}
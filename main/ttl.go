@@ -0,0 +1,162 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+type expiredEntry struct {
+	epochIndex string
+	key        string
+	expiresAt  int64
+	version    utils.HybridTimestamp
+}
+
+// expired reports if an expiry in unix millis has passed at now. zero never expires.
+func expired(expiresAt, now int64) bool {
+	return expiresAt != 0 && expiresAt <= now
+}
+
+func isExpired(value *rpc.RpcValue, now int64) bool {
+	return value != nil && expired(value.ExpiresAt, now)
+}
+
+func (m *Manager) expiryGranularity() int64 {
+	return int64(utils.Max(m.config.Manager.TtlSweepInterval, 1)) * 1000
+}
+
+// expiryCutoff returns the time up to which expired entries are left out of merkle trees and swept.
+// now is rounded down to TtlSweepInterval so replicas building the tree of an epoch at about the same time agree.
+func (m *Manager) expiryCutoff(now int64) int64 {
+	return now - now%m.expiryGranularity()
+}
+
+// nativeExpiry returns when the storage engine may drop an entry expiring at expiresAt.
+// it is rounded up to TtlSweepInterval so an entry is only dropped once every replica leaves it out of merkle trees.
+func (m *Manager) nativeExpiry(expiresAt int64) time.Time {
+	granularity := m.expiryGranularity()
+	if expiresAt%granularity != 0 {
+		expiresAt += granularity - expiresAt%granularity
+	}
+	return time.UnixMilli(expiresAt)
+}
+
+// expiresNatively reports if the storage engine drops the entries of key once they expire.
+// keys of a namespace are left to the sweep, which counts them out of the namespace usage.
+func (m *Manager) expiresNatively(key string, expiresAt int64) bool {
+	return expiresAt != 0 && m.db.NativeExpiry() && keyNamespace(key) == ""
+}
+
+// setIndexEntry sets an index entry of key. entries of an expiring key outside a namespace also expire in the storage engine.
+func (m *Manager) setIndexEntry(trx storage.Transaction, key string, index, data []byte, expiresAt int64) error {
+	if !m.expiresNatively(key, expiresAt) {
+		return trx.Set(index, data)
+	}
+	return trx.SetWithExpiry(index, data, m.nativeExpiry(expiresAt))
+}
+
+func (m *Manager) putIndexEntry(key string, index, data []byte, expiresAt int64) error {
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	err := m.setIndexEntry(trx, key, index, data, expiresAt)
+	if err != nil {
+		return err
+	}
+	return trx.Commit()
+}
+
+// SweepExpired removes the item and epoch index entries of a partition which expired before the cutoff.
+// it runs on every engine, since namespaced keys and entries written without engine expiry are only removed by it.
+// their secondary index entries and namespace usage are removed with them.
+func (m *Manager) SweepExpired(partitionId int) (int, error) {
+	cutoff := m.expiryCutoff(time.Now().UnixMilli())
+
+	var entries []expiredEntry
+	for bucket := 0; bucket < m.config.Manager.PartitionBuckets; bucket++ {
+		index1, err := BuildEpochIndex(partitionId, uint64(bucket), 0, "")
+		if err != nil {
+			return 0, err
+		}
+		index2, err := BuildEpochIndex(partitionId, uint64(bucket), m.GetCurrentEpoch()+1, "")
+		if err != nil {
+			return 0, err
+		}
+		it := m.db.NewIterator([]byte(index1), []byte(index2), false)
+		for !it.IsDone() {
+			if skipUnreadable(it, "SweepExpired") {
+				it.Next()
+				continue
+			}
+			epochIndex := string(it.Key())
+			version, _, expiresAt, err := ParseEpochIndexValue(it.Value())
+			if err != nil {
+				it.Release()
+				return 0, errors.Wrap(err, "ParseEpochIndexValue")
+			}
+			if !expired(expiresAt, cutoff) {
+				it.Next()
+				continue
+			}
+			_, _, _, key, err := ParseEpochIndex(epochIndex)
+			if err != nil {
+				it.Release()
+				return 0, errors.Wrap(err, "ParseEpochIndex")
+			}
+			entries = append(entries, expiredEntry{epochIndex: epochIndex, key: key, expiresAt: expiresAt, version: version})
+			it.Next()
+		}
+		it.Release()
+	}
+
+	for _, entry := range entries {
+		err := m.sweepExpiredEntry(entry)
+		if err != nil {
+			return 0, err
+		}
+	}
+	expiredSweptCounter.Add(float64(len(entries)))
+	return len(entries), nil
+}
+
+func (m *Manager) sweepExpiredEntry(entry expiredEntry) error {
+	keyIndex, err := BuildKeyIndex(entry.key)
+	if err != nil {
+		return err
+	}
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+
+	// the item index may already hold a newer write for the key
+	existingBytes, err := trx.Get([]byte(keyIndex))
+	if err == nil {
+		existingValue, err := decodeValueRecord(existingBytes)
+		if err != nil {
+			return err
+		}
+		if existingValue.ExpiresAt == entry.expiresAt && rpc.ValueHybridTimestamp(existingValue) == entry.version {
+			err = trx.Delete([]byte(keyIndex))
+			if err != nil {
+				return err
+			}
+			err = m.updateSecondaryIndexes(trx, existingValue, nil)
+			if err != nil {
+				return err
+			}
+			err = m.updateNamespaceUsage(trx, existingValue, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = trx.Delete([]byte(entry.epochIndex))
+	if err != nil {
+		return err
+	}
+	return trx.Commit()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+)
+
+func TestExpiryRounding(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.TtlSweepInterval = 60
+	manager := NewManager(c)
+
+	assert.EqualValues(t, 120000, manager.expiryCutoff(150000), "cutoff should round down")
+	assert.EqualValues(t, 180000, manager.nativeExpiry(150000).UnixMilli(), "native expiry should round up")
+	assert.EqualValues(t, 120000, manager.nativeExpiry(120000).UnixMilli(), "native expiry on a boundary")
+
+	assert.Equal(t, false, expired(0, 150000), "no expiry should never expire")
+	assert.Equal(t, true, expired(150000, 150000), "expiry should be inclusive")
+	assert.Equal(t, false, expired(150001, 150000), "future expiry")
+}
+
+func TestSweepExpired(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+	manager.CurrentEpoch = 1
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "tenant1", MaxKeys: 1}})
+
+	// leveldb has no native expiry so expired values are kept until they are swept
+	c.Storage.DataPath = t.TempDir()
+	manager.db = storage.NewLevelDbStorage(c.Storage)
+
+	now := time.Now().UnixMilli()
+	err := manager.SetValue(&rpc.RpcValue{Key: "expired", Value: []byte("v"), Epoch: 1, UnixTimestamp: now / 1000, ExpiresAt: now - 3600*1000})
+	assert.NoError(t, err)
+	err = manager.SetValue(&rpc.RpcValue{Key: "live", Value: []byte("v"), Epoch: 1, UnixTimestamp: now / 1000, ExpiresAt: now + 3600*1000})
+	assert.NoError(t, err)
+	err = manager.SetValue(&rpc.RpcValue{Key: "forever", Value: []byte("v"), Epoch: 1, UnixTimestamp: now / 1000})
+	assert.NoError(t, err)
+	err = manager.SetValueWithinQuota(&rpc.RpcValue{Key: "tenant1\x00expired", Value: []byte("v"), Epoch: 1, UnixTimestamp: now / 1000, ExpiresAt: now - 3600*1000})
+	assert.NoError(t, err)
+
+	_, err = manager.GetValue("expired")
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "expired value should not be found")
+	_, err = manager.GetValue("live")
+	assert.NoError(t, err)
+
+	usage, err := manager.readNamespaceUsage("tenant1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, usage.keys, "expired values count until they are swept")
+
+	before, err := manager.RawPartitionMerkleTree(0, 0, 2)
+	assert.NoError(t, err)
+
+	swept, err := manager.SweepExpired(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, swept, "only the expired values should be swept")
+
+	usage, err = manager.readNamespaceUsage("tenant1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, usage.keys, "swept values should be counted out of the quota")
+
+	keyIndex, err := BuildKeyIndex("expired")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(keyIndex))
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "expired item index should be removed")
+
+	after, err := manager.RawPartitionMerkleTree(0, 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, before.MerkleRoot(), after.MerkleRoot(), "sweeping should not change the merkle tree")
+}
+
+func TestNativeExpiry(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Storage.Engine = "badger"
+	c.Manager.PartitionCount = 1
+	c.Manager.PartitionBuckets = 1
+	manager := NewManager(c)
+	manager.CurrentEpoch = 1
+	manager.setNamespaces([]*rpc.RpcNamespace{{Name: "tenant1", MaxKeys: 1}})
+
+	now := time.Now().UnixMilli()
+	err := manager.SetValue(&rpc.RpcValue{Key: "expired", Value: []byte("v"), Epoch: 1, UnixTimestamp: now / 1000, ExpiresAt: now - 3600*1000})
+	assert.NoError(t, err)
+	err = manager.SetValueWithinQuota(&rpc.RpcValue{Key: "tenant1\x00expired", Value: []byte("v"), Epoch: 1, UnixTimestamp: now / 1000, ExpiresAt: now - 3600*1000})
+	assert.NoError(t, err)
+
+	// badger drops the expired value itself
+	keyIndex, err := BuildKeyIndex("expired")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(keyIndex))
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "expired item index should be dropped by badger")
+	epochIndex, err := BuildEpochIndex(0, 0, 1, "expired")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(epochIndex))
+	assert.Equal(t, storage.KEY_NOT_FOUND, err, "expired epoch index should be dropped by badger")
+
+	// the namespaced value is kept until the sweep counts it out of the quota
+	keyIndex, err = BuildKeyIndex("tenant1\x00expired")
+	assert.NoError(t, err)
+	_, err = manager.db.Get([]byte(keyIndex))
+	assert.NoError(t, err, "namespaced value should be left to the sweep")
+	swept, err := manager.SweepExpired(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, swept, "only the namespaced value should be swept")
+	usage, err := manager.readNamespaceUsage("tenant1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, usage.keys, "swept values should be counted out of the quota")
+}
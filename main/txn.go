@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+)
+
+// ErrKeyLocked is returned when a key has an intent of a transaction which is not resolved yet.
+var ErrKeyLocked = errors.New("key is locked by a transaction")
+
+// ErrTxnInDoubt is returned when neither outcome of a transaction reached a quorum of its record.
+// the intents stay until the resolver decides the transaction after TxnTimeout.
+var ErrTxnInDoubt = errors.New("transaction outcome is unknown")
+
+// txnQuorum is a majority of the replicas of a transaction record so a commit and an abort cannot both reach it.
+func (m *Manager) txnQuorum() int {
+	return m.config.Manager.ReplicaCount/2 + 1
+}
+
+// getIntent returns the intent staged on key, nil when there is none.
+func getIntent(trx storage.Transaction, key string) (*rpc.RpcTxnIntent, error) {
+	index, err := BuildIntentIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := trx.Get([]byte(index))
+	if err == storage.KEY_NOT_FOUND {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	intent := &rpc.RpcTxnIntent{}
+	err = proto.Unmarshal(data, intent)
+	if err != nil {
+		return nil, err
+	}
+	return intent, nil
+}
+
+// checkIntent rejects writes to a key with an intent of a transaction other than txnId.
+func checkIntent(trx storage.Transaction, key, txnId string) error {
+	intent, err := getIntent(trx, key)
+	if err != nil {
+		return err
+	}
+	if intent != nil && intent.TxnId != txnId {
+		return errors.Wrapf(ErrKeyLocked, "txn %s", intent.TxnId)
+	}
+	return nil
+}
+
+func getValueTrx(trx storage.Transaction, key string) (*rpc.RpcValue, error) {
+	keyIndex, err := BuildKeyIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := trx.Get([]byte(keyIndex))
+	if err == storage.KEY_NOT_FOUND {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	value, err := decodeValueRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// PrepareTxn stages an intent on every key of the transaction held by this node.
+// reads are checked against their precondition and locked so the keys cannot change before the transaction is resolved.
+// conditional writes are checked against their precondition, and writes of a namespace against its quotas.
+// nothing is staged when a key conflicts.
+func (m *Manager) PrepareTxn(prepare *rpc.RpcTxnPrepare) (*rpc.RpcTxnVote, error) {
+	quotas := m.lockQuotas(prepare.Writes)
+	defer quotas.release()
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	now := time.Now().UnixMilli()
+	intents := make(map[string]*rpc.RpcTxnIntent)
+	vote := &rpc.RpcTxnVote{}
+	var conflicts []string
+	for _, read := range prepare.Reads {
+		err := checkIntent(trx, read.Key, prepare.TxnId)
+		if errors.Is(err, ErrKeyLocked) {
+			conflicts = append(conflicts, read.Key)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if read.Precondition != nil {
+			existing, err := getValueTrx(trx, read.Key)
+			if err != nil {
+				return nil, err
+			}
+			if checkPrecondition(read.Precondition, existing) != nil {
+				conflicts = append(conflicts, read.Key)
+				continue
+			}
+		}
+		intents[read.Key] = &rpc.RpcTxnIntent{TxnId: prepare.TxnId, Anchor: prepare.Anchor, ReadOnly: true, CreatedAt: now}
+	}
+	for _, value := range prepare.Writes {
+		err := checkIntent(trx, value.Key, prepare.TxnId)
+		if errors.Is(err, ErrKeyLocked) {
+			conflicts = append(conflicts, value.Key)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		existing, err := getValueTrx(trx, value.Key)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && rpc.ValueHybridTimestamp(existing).Compare(rpc.ValueHybridTimestamp(value)) > 0 {
+			conflicts = append(conflicts, value.Key)
+			continue
+		}
+		if value.Precondition != nil {
+			err = checkPrecondition(value.Precondition, existing)
+			var conflict *preconditionError
+			if errors.As(err, &conflict) {
+				if vote.Versions == nil {
+					vote.Versions = make(map[string]string)
+				}
+				vote.Versions[value.Key] = conflict.Version
+				conflicts = append(conflicts, value.Key)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			// the intent locks the key, so the precondition holds until the write is resolved
+			value = proto.Clone(value).(*rpc.RpcValue)
+			value.Precondition = nil
+		}
+		err = quotas.check(existing, value)
+		if errors.Is(err, http.ErrQuotaExceeded) {
+			vote.QuotaExceeded = append(vote.QuotaExceeded, value.Key)
+			conflicts = append(conflicts, value.Key)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// a key which is read and written is locked by its write
+		intents[value.Key] = &rpc.RpcTxnIntent{TxnId: prepare.TxnId, Anchor: prepare.Anchor, Value: value, CreatedAt: now}
+	}
+	if len(conflicts) > 0 {
+		vote.Conflicts = conflicts
+		return vote, nil
+	}
+	for key, intent := range intents {
+		index, err := BuildIntentIndex(key)
+		if err != nil {
+			return nil, err
+		}
+		data, err := proto.Marshal(intent)
+		if err != nil {
+			return nil, err
+		}
+		err = trx.Set([]byte(index), data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vote, trx.Commit()
+}
+
+// ResolveTxn commits or aborts the intents of a transaction on keys.
+// keys without an intent of the transaction are skipped so a transaction can be resolved more than once.
+func (m *Manager) ResolveTxn(txnId string, commit bool, keys []string) error {
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	var stored []*rpc.RpcValue
+	for _, key := range keys {
+		intent, err := getIntent(trx, key)
+		if err != nil {
+			return err
+		}
+		if intent == nil || intent.TxnId != txnId {
+			continue
+		}
+		index, err := BuildIntentIndex(key)
+		if err != nil {
+			return err
+		}
+		err = trx.Delete([]byte(index))
+		if err != nil {
+			return err
+		}
+		if !commit || intent.ReadOnly {
+			continue
+		}
+		value, err := m.writeValueTrx(trx, intent.Value)
+		if err != nil {
+			// a newer value reached this replica through sync, the write of the transaction is superseded
+			logrus.Debugf("ResolveTxn txn = %s key = %s err = %v", txnId, key, err)
+			continue
+		}
+		stored = append(stored, value)
+	}
+	err := trx.Commit()
+	if err != nil {
+		return err
+	}
+	m.changeFeed.Publish(stored...)
+	return nil
+}
+
+// SetTxnRecord sets the outcome of a transaction and returns the outcome stored.
+// the first outcome set on a replica is final. a pending record only reads the outcome.
+func (m *Manager) SetTxnRecord(record *rpc.RpcTxnRecord) (*rpc.RpcTxnRecord, error) {
+	index, err := BuildTxnRecordIndex(record.TxnId)
+	if err != nil {
+		return nil, err
+	}
+	trx := m.db.NewTransaction(true)
+	defer trx.Discard()
+	data, err := trx.Get([]byte(index))
+	if err == nil {
+		existing := &rpc.RpcTxnRecord{}
+		err = proto.Unmarshal(data, existing)
+		if err != nil {
+			return nil, err
+		}
+		return existing, nil
+	} else if err != storage.KEY_NOT_FOUND {
+		return nil, err
+	}
+	if record.State == rpc.TxnPending {
+		return record, nil
+	}
+	record = &rpc.RpcTxnRecord{TxnId: record.TxnId, State: record.State, CreatedAt: time.Now().UnixMilli()}
+	data, err = proto.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	err = trx.Set([]byte(index), data)
+	if err != nil {
+		return nil, err
+	}
+	return record, trx.Commit()
+}
+
+// decideTxn proposes an outcome to the replicas of the transaction record and returns the outcome stored by a quorum.
+// TxnPending is returned when neither outcome reached a quorum.
+func (m *Manager) decideTxn(txnId, anchor string, state rpc.RpcTxnState) rpc.RpcTxnState {
+	nodes, err := m.ring.GetClosestN(anchor, m.config.Manager.ReplicaCount, true)
+	if err != nil {
+		logrus.Errorf("decideTxn txn = %s err = %v", txnId, err)
+		return rpc.TxnPending
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+	statesCh := make(chan rpc.RpcTxnState, len(nodes))
+	for _, node := range nodes {
+		member := node.String()
+		go func() {
+			client, err := m.clientManager.GetClient(member)
+			if err != nil {
+				statesCh <- rpc.TxnPending
+				return
+			}
+			record, err := client.SetTxnRecord(ctx, &rpc.RpcTxnRecord{TxnId: txnId, State: state})
+			if err != nil {
+				logrus.Debugf("SetTxnRecord member = %s err = %v", member, err)
+				statesCh <- rpc.TxnPending
+				return
+			}
+			statesCh <- record.State
+		}()
+	}
+	counts := make(map[rpc.RpcTxnState]int)
+	for range nodes {
+		counts[<-statesCh]++
+		if counts[rpc.TxnCommitted] >= m.txnQuorum() {
+			return rpc.TxnCommitted
+		}
+		if counts[rpc.TxnAborted] >= m.txnQuorum() {
+			return rpc.TxnAborted
+		}
+	}
+	return rpc.TxnPending
+}
+
+type txnVoteResponse struct {
+	member string
+	vote   *rpc.RpcTxnVote
+	err    error
+}
+
+// buildTxn validates a transaction and converts it to the writes and reads sent to the replicas.
+func (m *Manager) buildTxn(reads []http.TxnRead, writes []http.TxnWrite) ([]*rpc.RpcValue, []*rpc.RpcTxnRead, error) {
+	if len(writes) == 0 || len(reads)+len(writes) > http.MaxBatchSize {
+		return nil, nil, fmt.Errorf("%w: transaction must write between 1 and %d keys", http.ErrInvalidTxn, http.MaxBatchSize)
+	}
+	version := m.clock.Now()
+	written := make(map[string]bool)
+	values := make([]*rpc.RpcValue, 0, len(writes))
+	for _, write := range writes {
+		if write.Key == "" || written[write.Key] {
+			return nil, nil, fmt.Errorf("%w: keys must be set and written once", http.ErrInvalidTxn)
+		}
+		if validateDefaultKey(write.Key) != nil {
+			return nil, nil, fmt.Errorf("%w: keys cannot hold \\x00", http.ErrInvalidTxn)
+		}
+		if m.isSiblingKey(write.Key) {
+			return nil, nil, fmt.Errorf("%w: sibling mode keys cannot be written in a transaction", http.ErrInvalidTxn)
+		}
+		if m.isStrongKey(write.Key) {
+			return nil, nil, fmt.Errorf("%w: strong keys cannot be written in a transaction", http.ErrInvalidTxn)
+		}
+		written[write.Key] = true
+		value := &rpc.RpcValue{Key: write.Key, Epoch: m.GetCurrentEpoch(), UnixTimestamp: version.UnixTimestamp(), Hlc: rpc.NewRpcHybridTimestamp(version), Deleted: write.Delete}
+		if !write.Delete {
+			value.Value = []byte(write.Value)
+		}
+		values = append(values, value)
+	}
+	txnReads := make([]*rpc.RpcTxnRead, 0, len(reads))
+	for _, read := range reads {
+		if read.Key == "" {
+			return nil, nil, fmt.Errorf("%w: keys must be set", http.ErrInvalidTxn)
+		}
+		if validateDefaultKey(read.Key) != nil {
+			return nil, nil, fmt.Errorf("%w: keys cannot hold \\x00", http.ErrInvalidTxn)
+		}
+		if m.isStrongKey(read.Key) {
+			return nil, nil, fmt.Errorf("%w: strong keys cannot be read in a transaction", http.ErrInvalidTxn)
+		}
+		precondition, err := m.buildPrecondition(read.Key, read.Version, read.Absent)
+		if err != nil {
+			return nil, nil, err
+		}
+		txnReads = append(txnReads, &rpc.RpcTxnRead{Key: read.Key, Precondition: precondition})
+	}
+	return values, txnReads, nil
+}
+
+// TxnRequest writes a set of keys atomically with two phase commit.
+// every replica of every key stages an intent. once each key is prepared on writeQuorum replicas the outcome is
+// written to the transaction record on the replicas of the first written key, which is the commit point, and the intents are resolved.
+// it returns the id of the transaction and the keys which conflicted when it was aborted.
+func (m *Manager) TxnRequest(reads []http.TxnRead, writes []http.TxnWrite, writeQuorum int) (string, []string, error) {
+	values, txnReads, err := m.buildTxn(reads, writes)
+	if err != nil {
+		return "", nil, err
+	}
+	anchor := values[0].Key
+	txnId := fmt.Sprintf("%s-%s", m.config.Manager.Hostname, rpc.ValueHybridTimestamp(values[0]).String())
+
+	prepares := make(map[string]*rpc.RpcTxnPrepare)
+	memberKeys := make(map[string][]string)
+	added := make(map[string]bool)
+	addKey := func(key string) ([]string, error) {
+		nodes, err := m.ring.GetClosestN(key, m.config.Manager.ReplicaCount, true)
+		if err != nil {
+			return nil, err
+		}
+		var members []string
+		for _, node := range nodes {
+			member := node.String()
+			if prepares[member] == nil {
+				prepares[member] = &rpc.RpcTxnPrepare{TxnId: txnId, Anchor: anchor}
+			}
+			// a key which is read and written is counted once
+			if !added[member+"/"+key] {
+				added[member+"/"+key] = true
+				memberKeys[member] = append(memberKeys[member], key)
+			}
+			members = append(members, member)
+		}
+		return members, nil
+	}
+	for _, value := range values {
+		members, err := addKey(value.Key)
+		if err != nil {
+			return txnId, nil, err
+		}
+		for _, member := range members {
+			prepares[member].Writes = append(prepares[member].Writes, value)
+		}
+	}
+	for _, read := range txnReads {
+		members, err := addKey(read.Key)
+		if err != nil {
+			return txnId, nil, err
+		}
+		for _, member := range members {
+			prepares[member].Reads = append(prepares[member].Reads, read)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+	votesCh := make(chan txnVoteResponse, len(prepares))
+	for member, prepare := range prepares {
+		member, prepare := member, prepare
+		go func() {
+			client, err := m.clientManager.GetClient(member)
+			if err != nil {
+				votesCh <- txnVoteResponse{member: member, err: err}
+				return
+			}
+			vote, err := client.PrepareTxn(ctx, prepare)
+			votesCh <- txnVoteResponse{member: member, vote: vote, err: err}
+		}()
+	}
+	prepared := make(map[string]int)
+	conflictSet := make(map[string]bool)
+	for range prepares {
+		res := <-votesCh
+		if res.err != nil {
+			logrus.Debugf("PrepareTxn member = %s err = %v", res.member, res.err)
+			continue
+		}
+		for _, key := range res.vote.Conflicts {
+			conflictSet[key] = true
+		}
+		if len(res.vote.Conflicts) == 0 {
+			for _, key := range memberKeys[res.member] {
+				prepared[key]++
+			}
+		}
+	}
+	var conflicts []string
+	for key := range conflictSet {
+		conflicts = append(conflicts, key)
+	}
+	var unprepared []string
+	for _, key := range append(keysOfValues(values), keysOfReads(txnReads)...) {
+		if prepared[key] < writeQuorum {
+			unprepared = append(unprepared, key)
+		}
+	}
+
+	state := rpc.TxnAborted
+	if len(conflicts) == 0 && len(unprepared) == 0 {
+		state = m.decideTxn(txnId, anchor, rpc.TxnCommitted)
+	} else {
+		// the record is aborted so the resolver does not wait on it
+		m.decideTxn(txnId, anchor, rpc.TxnAborted)
+	}
+	if state == rpc.TxnPending {
+		txnCounter.WithLabelValues("in_doubt").Inc()
+		return txnId, nil, ErrTxnInDoubt
+	}
+	m.resolveParticipants(txnId, state == rpc.TxnCommitted, memberKeys)
+	if state == rpc.TxnCommitted {
+		txnCounter.WithLabelValues("committed").Inc()
+		return txnId, nil, nil
+	}
+	txnCounter.WithLabelValues("aborted").Inc()
+	if len(conflicts) > 0 {
+		return txnId, conflicts, nil
+	}
+	return txnId, nil, fmt.Errorf("failed WriteQuorum %d for keys %v", writeQuorum, unprepared)
+}
+
+func keysOfValues(values []*rpc.RpcValue) []string {
+	keys := make([]string, 0, len(values))
+	for _, value := range values {
+		keys = append(keys, value.Key)
+	}
+	return keys
+}
+
+func keysOfReads(reads []*rpc.RpcTxnRead) []string {
+	keys := make([]string, 0, len(reads))
+	for _, read := range reads {
+		keys = append(keys, read.Key)
+	}
+	return keys
+}
+
+// resolveParticipants sends the outcome to every participant. participants which miss it resolve their intents with the resolver.
+func (m *Manager) resolveParticipants(txnId string, commit bool, memberKeys map[string][]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(m.config.Manager.DefaultTimeout))
+	defer cancel()
+	doneCh := make(chan struct{}, len(memberKeys))
+	for member, keys := range memberKeys {
+		member, keys := member, keys
+		go func() {
+			defer func() { doneCh <- struct{}{} }()
+			client, err := m.clientManager.GetClient(member)
+			if err != nil {
+				logrus.Debugf("ResolveTxn member = %s err = %v", member, err)
+				return
+			}
+			_, err = client.ResolveTxn(ctx, &rpc.RpcTxnResolve{TxnId: txnId, Commit: commit, Keys: keys})
+			if err != nil {
+				logrus.Debugf("ResolveTxn member = %s err = %v", member, err)
+			}
+		}()
+	}
+	for range memberKeys {
+		<-doneCh
+	}
+}
+
+type staleTxn struct {
+	anchor string
+	keys   []string
+}
+
+// ResolveStaleIntents resolves the intents staged longer than TxnTimeout, whose coordinator failed before resolving them.
+// an abort is proposed to the transaction record so a transaction which did not commit can never commit later.
+func (m *Manager) ResolveStaleIntents() (int, error) {
+	cutoff := time.Now().Add(-time.Duration(m.config.Manager.TxnTimeout) * time.Second).UnixMilli()
+	stale := make(map[string]*staleTxn)
+	it := m.db.NewIterator(intentIndexType.Start(), intentIndexType.Limit(), false)
+	for ; !it.IsDone(); it.Next() {
+		if skipUnreadable(it, "ResolveStaleIntents") {
+			continue
+		}
+		intent := &rpc.RpcTxnIntent{}
+		err := proto.Unmarshal(it.Value(), intent)
+		if err != nil {
+			it.Release()
+			return 0, errors.Wrap(err, "ResolveStaleIntents Unmarshal")
+		}
+		if intent.CreatedAt > cutoff {
+			continue
+		}
+		key, err := ParseIntentKey(string(it.Key()))
+		if err != nil {
+			it.Release()
+			return 0, err
+		}
+		if stale[intent.TxnId] == nil {
+			stale[intent.TxnId] = &staleTxn{anchor: intent.Anchor}
+		}
+		stale[intent.TxnId].keys = append(stale[intent.TxnId].keys, key)
+	}
+	it.Release()
+
+	resolved := 0
+	for txnId, txn := range stale {
+		state := m.decideTxn(txnId, txn.anchor, rpc.TxnAborted)
+		if state == rpc.TxnPending {
+			logrus.Warnf("transaction %s is in doubt", txnId)
+			continue
+		}
+		err := m.ResolveTxn(txnId, state == rpc.TxnCommitted, txn.keys)
+		if err != nil {
+			return resolved, err
+		}
+		resolved += len(txn.keys)
+	}
+	return resolved, nil
+}
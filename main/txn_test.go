@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+)
+
+func TestIntentIndex(t *testing.T) {
+	index, err := BuildIntentIndex("user_1")
+	assert.NoError(t, err)
+	key, err := ParseIntentKey(index)
+	assert.NoError(t, err)
+	assert.Equal(t, "user_1", key, "keys with the separator should be parsed")
+
+	keyIndex, err := BuildKeyIndex("user_1")
+	assert.NoError(t, err)
+	_, err = ParseIntentKey(keyIndex)
+	assert.Error(t, err, "other indexes should not be parsed")
+}
+
+func TestBuildTxnKeys(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	manager := NewManager(c)
+
+	_, _, err := manager.buildTxn(nil, []http.TxnWrite{{Key: "tenant1\x00a", Value: "v"}})
+	assert.ErrorIs(t, err, http.ErrInvalidTxn, "written keys holding the separator should be rejected")
+	_, _, err = manager.buildTxn([]http.TxnRead{{Key: "tenant1\x00a", Absent: true}}, []http.TxnWrite{{Key: "a", Value: "v"}})
+	assert.ErrorIs(t, err, http.ErrInvalidTxn, "read keys holding the separator should be rejected")
+	_, _, err = manager.buildTxn(nil, []http.TxnWrite{{Key: "a", Value: "v"}})
+	assert.NoError(t, err)
+}
+
+func TestPrepareResolveTxn(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	manager := NewManager(c)
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "read1", Value: []byte("v1"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 10}})
+	assert.NoError(t, err)
+
+	write := &rpc.RpcValue{Key: "write1", Value: []byte("v1"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 20}}
+	prepare := &rpc.RpcTxnPrepare{
+		TxnId:  "txn1",
+		Anchor: "write1",
+		Writes: []*rpc.RpcValue{write},
+		Reads:  []*rpc.RpcTxnRead{{Key: "read1", Precondition: &rpc.RpcPrecondition{IfVersion: &rpc.RpcHybridTimestamp{Physical: 10}}}},
+	}
+	vote, err := manager.PrepareTxn(prepare)
+	assert.NoError(t, err)
+	assert.Empty(t, vote.Conflicts, "prepare should not conflict")
+
+	err = manager.SetValue(&rpc.RpcValue{Key: "read1", Value: []byte("v2"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 30}})
+	assert.ErrorIs(t, err, ErrKeyLocked, "a read key should be locked")
+
+	vote, err = manager.PrepareTxn(&rpc.RpcTxnPrepare{TxnId: "txn2", Anchor: "write1", Writes: []*rpc.RpcValue{{Key: "write1", Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 40}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"write1"}, vote.Conflicts, "a locked key should conflict")
+
+	vote, err = manager.PrepareTxn(prepare)
+	assert.NoError(t, err)
+	assert.Empty(t, vote.Conflicts, "prepare should be idempotent")
+
+	_, values := manager.changeFeed.Subscribe("write1", "")
+	err = manager.ResolveTxn("txn1", true, []string{"read1", "write1"})
+	assert.NoError(t, err)
+	value, err := manager.GetValue("write1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(value.Value), "committed write wrong value")
+	assert.Len(t, values, 1, "committed write should be published")
+
+	err = manager.SetValue(&rpc.RpcValue{Key: "read1", Value: []byte("v2"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 30}})
+	assert.NoError(t, err, "resolve should unlock the keys")
+
+	err = manager.ResolveTxn("txn1", true, []string{"write1"})
+	assert.NoError(t, err, "resolve should be idempotent")
+}
+
+func TestPrepareTxnConflicts(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	manager := NewManager(c)
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "key1", Value: []byte("v1"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 50}})
+	assert.NoError(t, err)
+
+	vote, err := manager.PrepareTxn(&rpc.RpcTxnPrepare{
+		TxnId:  "txn1",
+		Anchor: "key2",
+		Writes: []*rpc.RpcValue{{Key: "key2", Value: []byte("v1"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 60}}},
+		Reads:  []*rpc.RpcTxnRead{{Key: "key1", Precondition: &rpc.RpcPrecondition{IfAbsent: true}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key1"}, vote.Conflicts, "failed precondition should conflict")
+
+	vote, err = manager.PrepareTxn(&rpc.RpcTxnPrepare{TxnId: "txn2", Anchor: "key1", Writes: []*rpc.RpcValue{{Key: "key1", Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 40}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key1"}, vote.Conflicts, "an older write should conflict")
+
+	// nothing is staged by a conflicting prepare
+	err = manager.SetValue(&rpc.RpcValue{Key: "key2", Value: []byte("v2"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 70}})
+	assert.NoError(t, err)
+
+	vote, err = manager.PrepareTxn(&rpc.RpcTxnPrepare{TxnId: "txn3", Anchor: "key3", Writes: []*rpc.RpcValue{{Key: "key3", Value: []byte("v1"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 80}}}})
+	assert.NoError(t, err)
+	assert.Empty(t, vote.Conflicts)
+	err = manager.ResolveTxn("txn3", false, []string{"key3"})
+	assert.NoError(t, err)
+	_, err = manager.GetValue("key3")
+	assert.Error(t, err, "aborted write should not be stored")
+}
+
+func TestSetTxnRecord(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	manager := NewManager(c)
+
+	record, err := manager.SetTxnRecord(&rpc.RpcTxnRecord{TxnId: "txn1", State: rpc.TxnPending})
+	assert.NoError(t, err)
+	assert.Equal(t, rpc.TxnPending, record.State, "pending should not be stored")
+
+	record, err = manager.SetTxnRecord(&rpc.RpcTxnRecord{TxnId: "txn1", State: rpc.TxnCommitted})
+	assert.NoError(t, err)
+	assert.Equal(t, rpc.TxnCommitted, record.State, "first outcome should be stored")
+
+	record, err = manager.SetTxnRecord(&rpc.RpcTxnRecord{TxnId: "txn1", State: rpc.TxnAborted})
+	assert.NoError(t, err)
+	assert.Equal(t, rpc.TxnCommitted, record.State, "first outcome should be final")
+}
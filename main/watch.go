@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+	"github.com/andrew-delph/my-key-store/storage"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// watchBufferSize is the number of values a watch may fall behind before it is dropped.
+const watchBufferSize = 256
+
+// watchRetryInterval is the wait before a coordinator reconnects to a member whose watch ended.
+const watchRetryInterval = time.Second
+
+var errWatchBehind = errors.New("watch fell behind the change feed")
+
+type watcher struct {
+	key    string
+	prefix string
+	ch     chan *rpc.RpcValue
+}
+
+func (w *watcher) matches(key string) bool {
+	if w.key != "" {
+		return key == w.key
+	}
+	return strings.HasPrefix(key, w.prefix)
+}
+
+// ChangeFeed fans the values committed on this node out to the watches of their keys.
+type ChangeFeed struct {
+	lock     sync.Mutex
+	nextId   int
+	watchers map[int]*watcher
+}
+
+func NewChangeFeed() *ChangeFeed {
+	return &ChangeFeed{watchers: make(map[int]*watcher)}
+}
+
+// Subscribe watches a key, or every key with prefix when key is empty.
+// the channel is closed when the watch is dropped for falling behind.
+func (feed *ChangeFeed) Subscribe(key, prefix string) (int, <-chan *rpc.RpcValue) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	feed.nextId++
+	w := &watcher{key: key, prefix: prefix, ch: make(chan *rpc.RpcValue, watchBufferSize)}
+	feed.watchers[feed.nextId] = w
+	return feed.nextId, w.ch
+}
+
+func (feed *ChangeFeed) Unsubscribe(id int) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	if w, ok := feed.watchers[id]; ok {
+		close(w.ch)
+		delete(feed.watchers, id)
+	}
+}
+
+// Publish never blocks the commit path. a watch which is full is dropped and has to resume from its cursor.
+func (feed *ChangeFeed) Publish(values ...*rpc.RpcValue) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	for id, w := range feed.watchers {
+		for _, value := range values {
+			if !w.matches(value.Key) {
+				continue
+			}
+			select {
+			case w.ch <- value:
+			default:
+				logrus.Warnf("dropping watch key = %s prefix = %s: %v", w.key, w.prefix, errWatchBehind)
+				close(w.ch)
+				delete(feed.watchers, id)
+			}
+			if _, ok := feed.watchers[id]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// WatchCursor is the position of a watch. changes at or after Epoch with a version at or after Timestamp are replayed.
+type WatchCursor struct {
+	Epoch     int64
+	Timestamp int64
+}
+
+func valueCursor(value *rpc.RpcValue) WatchCursor {
+	return WatchCursor{Epoch: value.Epoch, Timestamp: rpc.ValueHybridTimestamp(value).Physical}
+}
+
+func (cursor WatchCursor) String() string {
+	return fmt.Sprintf("%d.%d", cursor.Epoch, cursor.Timestamp)
+}
+
+func ParseWatchCursor(raw string) (WatchCursor, error) {
+	rawEpoch, rawTimestamp, ok := strings.Cut(raw, ".")
+	if !ok {
+		return WatchCursor{}, fmt.Errorf("%w: cursor must be epoch.timestamp", http.ErrInvalidWatch)
+	}
+	epoch, err := strconv.ParseInt(rawEpoch, 10, 64)
+	if err != nil || epoch < 0 {
+		return WatchCursor{}, fmt.Errorf("%w: invalid cursor epoch", http.ErrInvalidWatch)
+	}
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil || timestamp < 0 {
+		return WatchCursor{}, fmt.Errorf("%w: invalid cursor timestamp", http.ErrInvalidWatch)
+	}
+	return WatchCursor{Epoch: epoch, Timestamp: timestamp}, nil
+}
+
+// replayChanges returns the current values of the keys changed since cursor in version order.
+// the epoch index holds an entry for every epoch a key was written in, so each key is read once from the key index.
+func (m *Manager) replayChanges(key, prefix string, cursor WatchCursor) ([]*rpc.RpcValue, error) {
+	partitions := make([]int, 0, m.config.Manager.PartitionCount)
+	buckets := make([]uint64, 0, m.config.Manager.PartitionBuckets)
+	if key != "" {
+		partitions = append(partitions, m.ring.FindPartitionID([]byte(key)))
+		buckets = append(buckets, m.getKeyBucket(key))
+	} else {
+		for partitionId := 0; partitionId < m.config.Manager.PartitionCount; partitionId++ {
+			partitions = append(partitions, partitionId)
+		}
+		for bucket := 0; bucket < m.config.Manager.PartitionBuckets; bucket++ {
+			buckets = append(buckets, uint64(bucket))
+		}
+	}
+	// writes may carry the next epoch before this node has seen it
+	upperEpoch := m.GetCurrentEpoch() + 2
+	match := watcher{key: key, prefix: prefix}
+	seen := make(map[string]bool)
+	var values []*rpc.RpcValue
+	for _, partitionId := range partitions {
+		for _, bucket := range buckets {
+			lowerIndex, err := BuildEpochIndex(partitionId, bucket, cursor.Epoch, "")
+			if err != nil {
+				return nil, err
+			}
+			upperIndex, err := BuildEpochIndex(partitionId, bucket, upperEpoch, "")
+			if err != nil {
+				return nil, err
+			}
+			it := m.db.NewIterator([]byte(lowerIndex), []byte(upperIndex), false)
+			for ; !it.IsDone(); it.Next() {
+				_, _, _, indexKey, err := ParseEpochIndex(string(it.Key()))
+				if err != nil {
+					it.Release()
+					return nil, errors.Wrap(err, "replayChanges ParseEpochIndex")
+				}
+				if seen[indexKey] || !match.matches(indexKey) {
+					continue
+				}
+				seen[indexKey] = true
+				value, err := m.GetValue(indexKey)
+				if err == storage.KEY_NOT_FOUND {
+					continue
+				} else if err != nil {
+					it.Release()
+					return nil, err
+				}
+				if rpc.ValueHybridTimestamp(value).Physical < cursor.Timestamp {
+					continue
+				}
+				values = append(values, value)
+			}
+			it.Release()
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return rpc.ValueHybridTimestamp(values[i]).Compare(rpc.ValueHybridTimestamp(values[j])) < 0
+	})
+	return values, nil
+}
+
+// sendValue sends item on resCh unless ctx is done first.
+func sendValue(ctx context.Context, resCh chan interface{}, item interface{}) bool {
+	select {
+	case resCh <- item:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// watchLocal streams the values committed on this node to a member coordinating a watch.
+// it subscribes before replaying so no commit falls between the replay and the live values.
+func (m *Manager) watchLocal(task rpc.WatchTask) {
+	defer close(task.ResCh)
+	req := task.Request
+	id, values := m.changeFeed.Subscribe(req.Key, req.Prefix)
+	defer m.changeFeed.Unsubscribe(id)
+
+	if req.Replay {
+		replayed, err := m.replayChanges(req.Key, req.Prefix, WatchCursor{Epoch: req.Epoch, Timestamp: req.Timestamp})
+		if err != nil {
+			sendValue(task.Ctx, task.ResCh, err)
+			return
+		}
+		for _, value := range replayed {
+			if !sendValue(task.Ctx, task.ResCh, value) {
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case value, ok := <-values:
+			if !ok {
+				sendValue(task.Ctx, task.ResCh, errWatchBehind)
+				return
+			}
+			if !sendValue(task.Ctx, task.ResCh, value) {
+				return
+			}
+		case <-task.Ctx.Done():
+			return
+		}
+	}
+}
+
+// watchMember receives the values of a watch from member and reconnects from the last value received until ctx is done.
+func (m *Manager) watchMember(ctx context.Context, member string, req *rpc.RpcWatchRequest, valuesCh chan<- *rpc.RpcValue) {
+	req = &rpc.RpcWatchRequest{Key: req.Key, Prefix: req.Prefix, Replay: req.Replay, Epoch: req.Epoch, Timestamp: req.Timestamp}
+	for ctx.Err() == nil {
+		err := m.watchMemberOnce(ctx, member, req, valuesCh)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Debugf("watchMember member = %s err = %v", member, err)
+		// the member replays what was missed while disconnected
+		req.Replay = true
+		select {
+		case <-time.After(watchRetryInterval):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (m *Manager) watchMemberOnce(ctx context.Context, member string, req *rpc.RpcWatchRequest, valuesCh chan<- *rpc.RpcValue) error {
+	client, err := m.clientManager.GetClient(member)
+	if err != nil {
+		return err
+	}
+	stream, err := client.Watch(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "Watch request")
+	}
+	for {
+		value, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "Watch Recv")
+		}
+		cursor := valueCursor(value)
+		req.Epoch, req.Timestamp = cursor.Epoch, cursor.Timestamp
+		select {
+		case valuesCh <- value:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// watchDedup drops the values a watch has already emitted. every replica sends each write,
+// so only the first copy of a version, and only versions newer than the last one emitted for the key, are emitted.
+type watchDedup struct {
+	last map[string]utils.HybridTimestamp
+}
+
+func newWatchDedup() *watchDedup {
+	return &watchDedup{last: make(map[string]utils.HybridTimestamp)}
+}
+
+func (dedup *watchDedup) fresh(value *rpc.RpcValue) bool {
+	version := rpc.ValueHybridTimestamp(value)
+	if last, ok := dedup.last[value.Key]; ok && last.Compare(version) >= 0 {
+		return false
+	}
+	dedup.last[value.Key] = version
+	return true
+}
+
+func watchEvent(value *rpc.RpcValue) http.WatchEvent {
+	event := http.WatchEvent{Key: value.Key, Deleted: value.Deleted, Cursor: valueCursor(value).String()}
+	if len(value.Siblings) > 0 {
+		event.Siblings = liveSiblings(value)
+		event.Deleted = len(event.Siblings) == 0
+		return event
+	}
+	event.Value = string(value.Value)
+	version, err := valueVersion(value)
+	if err != nil {
+		logrus.Errorf("watchEvent valueVersion err = %v", err)
+	}
+	event.Version = version
+	return event
+}
+
+// WatchRequest starts a watch of task.Key, or of every key with task.Prefix, coordinated by this node.
+// every replica of the key is watched so the watch continues while a replica is down. it follows the members
+// of the ring when it started, consumers reconnect with their cursor to pick up new members.
+// task.Events is closed when task.Ctx is done.
+func (m *Manager) WatchRequest(task http.WatchTask) error {
+	if task.Key != "" && task.Prefix != "" {
+		return fmt.Errorf("%w: key and prefix cannot both be set", http.ErrInvalidWatch)
+	}
+	if validateDefaultKey(task.Key) != nil || validateDefaultKey(task.Prefix) != nil {
+		return fmt.Errorf("%w: keys cannot hold \\x00", http.ErrInvalidWatch)
+	}
+	req := &rpc.RpcWatchRequest{Key: task.Key, Prefix: task.Prefix}
+	if task.Cursor != "" {
+		cursor, err := ParseWatchCursor(task.Cursor)
+		if err != nil {
+			return err
+		}
+		req.Replay, req.Epoch, req.Timestamp = true, cursor.Epoch, cursor.Timestamp
+	} else {
+		// members which reconnect replay from when the watch started
+		req.Epoch, req.Timestamp = m.GetCurrentEpoch(), time.Now().UnixMilli()
+	}
+
+	var members []string
+	if task.Key != "" {
+		nodes, err := m.ring.GetClosestN(task.Key, m.config.Manager.ReplicaCount, true)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			members = append(members, node.String())
+		}
+	} else {
+		memberSet := make(map[string]bool)
+		for _, member := range append(m.ring.GetMembersNames(false), m.ring.GetMembersNames(true)...) {
+			if !memberSet[member] {
+				memberSet[member] = true
+				members = append(members, member)
+			}
+		}
+	}
+	if len(members) == 0 {
+		return errors.New("no members to watch")
+	}
+
+	valuesCh := make(chan *rpc.RpcValue, watchBufferSize)
+	for _, member := range members {
+		go m.watchMember(task.Ctx, member, req, valuesCh)
+	}
+	go func() {
+		defer close(task.Events)
+		dedup := newWatchDedup()
+		for {
+			select {
+			case value := <-valuesCh:
+				if !dedup.fresh(value) {
+					continue
+				}
+				select {
+				case task.Events <- watchEvent(value):
+				case <-task.Ctx.Done():
+					return
+				}
+			case <-task.Ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
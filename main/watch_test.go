@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/http"
+	"github.com/andrew-delph/my-key-store/rpc"
+)
+
+func TestChangeFeed(t *testing.T) {
+	feed := NewChangeFeed()
+	keyId, keyCh := feed.Subscribe("user/1", "")
+	_, prefixCh := feed.Subscribe("", "user/")
+
+	feed.Publish(&rpc.RpcValue{Key: "user/1"}, &rpc.RpcValue{Key: "user/2"}, &rpc.RpcValue{Key: "other"})
+	assert.Len(t, keyCh, 1, "key watch wrong number of values")
+	assert.Len(t, prefixCh, 2, "prefix watch wrong number of values")
+
+	feed.Unsubscribe(keyId)
+	// the value published before is still buffered
+	<-keyCh
+	_, ok := <-keyCh
+	assert.False(t, ok, "unsubscribe should close the channel")
+
+	for i := 0; i < watchBufferSize; i++ {
+		feed.Publish(&rpc.RpcValue{Key: "user/3"})
+	}
+	for range prefixCh {
+	}
+	assert.Len(t, feed.watchers, 0, "a full watch should be dropped")
+}
+
+func TestWatchCursor(t *testing.T) {
+	cursor, err := ParseWatchCursor(WatchCursor{Epoch: 3, Timestamp: 1700000000000}.String())
+	assert.NoError(t, err)
+	assert.Equal(t, WatchCursor{Epoch: 3, Timestamp: 1700000000000}, cursor, "cursor wrong value")
+
+	for _, raw := range []string{"3", "a.1", "3.b", "-1.1"} {
+		_, err = ParseWatchCursor(raw)
+		assert.ErrorIs(t, err, http.ErrInvalidWatch, "cursor %s should be invalid", raw)
+	}
+}
+
+func TestWatchDedup(t *testing.T) {
+	dedup := newWatchDedup()
+	v1 := &rpc.RpcValue{Key: "key1", Hlc: &rpc.RpcHybridTimestamp{Physical: 10}}
+	v2 := &rpc.RpcValue{Key: "key1", Hlc: &rpc.RpcHybridTimestamp{Physical: 20}}
+
+	assert.True(t, dedup.fresh(v1), "first copy should be emitted")
+	assert.False(t, dedup.fresh(v1), "copy from another replica should be dropped")
+	assert.True(t, dedup.fresh(v2), "newer version should be emitted")
+	assert.False(t, dedup.fresh(v1), "older version should be dropped")
+}
+
+func TestSetValuePublishes(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	manager := NewManager(c)
+	_, values := manager.changeFeed.Subscribe("key1", "")
+
+	err := manager.SetValue(&rpc.RpcValue{Key: "key1", Value: []byte("v1"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 10}})
+	assert.NoError(t, err)
+	err = manager.SetValue(&rpc.RpcValue{Key: "key1", Value: []byte("old"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 5}})
+	assert.Error(t, err)
+
+	assert.Len(t, values, 1, "only committed values should be published")
+	assert.Equal(t, "v1", string((<-values).Value), "published value wrong value")
+}
+
+func TestReplayChanges(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Manager.PartitionCount = 2
+	c.Manager.PartitionBuckets = 2
+	manager := NewManager(c)
+	manager.SetCurrentEpoch(3)
+
+	writes := []*rpc.RpcValue{
+		{Key: "user/old", Value: []byte("v"), Epoch: 1, Hlc: &rpc.RpcHybridTimestamp{Physical: 100}},
+		{Key: "user/b", Value: []byte("v"), Epoch: 2, Hlc: &rpc.RpcHybridTimestamp{Physical: 300}},
+		{Key: "user/a", Value: []byte("v"), Epoch: 2, Hlc: &rpc.RpcHybridTimestamp{Physical: 200}},
+		{Key: "user/a", Value: []byte("v2"), Epoch: 3, Hlc: &rpc.RpcHybridTimestamp{Physical: 400}},
+		{Key: "user/c", Deleted: true, Epoch: 3, Hlc: &rpc.RpcHybridTimestamp{Physical: 500}},
+		{Key: "other", Value: []byte("v"), Epoch: 3, Hlc: &rpc.RpcHybridTimestamp{Physical: 600}},
+	}
+	for _, value := range writes {
+		err := manager.SetValue(value)
+		assert.NoError(t, err)
+	}
+
+	values, err := manager.replayChanges("", "user/", WatchCursor{Epoch: 2, Timestamp: 200})
+	assert.NoError(t, err)
+	var keys []string
+	for _, value := range values {
+		keys = append(keys, value.Key)
+	}
+	assert.Equal(t, []string{"user/b", "user/a", "user/c"}, keys, "replay should hold each changed key once in version order")
+	assert.Equal(t, "v2", string(values[1].Value), "replay should hold the current value")
+	assert.True(t, values[2].Deleted, "replay should hold deletes")
+
+	values, err = manager.replayChanges("user/a", "", WatchCursor{Epoch: 0, Timestamp: 0})
+	assert.NoError(t, err)
+	assert.Len(t, values, 1, "key replay wrong number of values")
+}
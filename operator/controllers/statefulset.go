@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
+	"github.com/andrew-delph/my-key-store/config"
 	"github.com/andrew-delph/my-key-store/rpc"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -27,6 +29,16 @@ import (
 
 type MyKeyStoreStatefulSet struct{}
 
+// operatorRpcConfig returns the client certificate the operator presents to the nodes, set by the RPC_TLS_* env vars.
+// the certificate name must be in the TLS_ALLOWED_PEERS of the nodes.
+func operatorRpcConfig() config.RpcConfig {
+	return config.RpcConfig{
+		TlsCertFile: os.Getenv("RPC_TLS_CERT_FILE"),
+		TlsKeyFile:  os.Getenv("RPC_TLS_KEY_FILE"),
+		TlsCaFile:   os.Getenv("RPC_TLS_CA_FILE"),
+	}
+}
+
 func ProcessStatefulSet(r *MyKeyStoreReconciler, ctx context.Context, req ctrl.Request, log logr.Logger, mykeystore *cachev1alpha1.MyKeyStore) (*ctrl.Result, error) {
 	found := &appsv1.StatefulSet{}
 	err := r.Get(ctx, types.NamespacedName{Name: mykeystore.Name, Namespace: mykeystore.Namespace}, found)
@@ -282,7 +294,7 @@ func waitForPodsHealthy(r *MyKeyStoreReconciler, ctx context.Context, req ctrl.R
 	// logrus.Warnf("list= %v err = %v", len(pods.Items), err)
 	for _, pod := range pods.Items {
 		addr := fmt.Sprintf("%s.%s.%s", pod.Name, mykeystore.Name, pod.Namespace)
-		conn, client, err := rpc.CreateRawRpcClient(addr, 7070)
+		conn, client, err := rpc.CreateTlsRpcClient(addr, 7070, operatorRpcConfig())
 		if err != nil {
 			// logrus.Errorf("Client %s err = %v", addr, err)
 			errorCount++
@@ -317,7 +329,7 @@ func verifyEpochUpdate(r *MyKeyStoreReconciler, ctx context.Context, req ctrl.Re
 	// logrus.Warnf("list= %v err = %v", len(pods.Items), err)
 	for _, pod := range pods.Items {
 		addr := fmt.Sprintf("%s.%s.%s", pod.Name, mykeystore.Name, pod.Namespace)
-		conn, client, err := rpc.CreateRawRpcClient(addr, 7070)
+		conn, client, err := rpc.CreateTlsRpcClient(addr, 7070, operatorRpcConfig())
 		if err != nil {
 			// logrus.Errorf("verifyEpochUpdate Client %s err = %v", addr, err)
 			errorCount++
@@ -362,7 +374,7 @@ func notifyMembers(r *MyKeyStoreReconciler, ctx context.Context, req ctrl.Reques
 	// logrus.Warnf("AddTempNode list= %v err = %v", len(pods.Items), err)
 	for _, pod := range pods.Items {
 		addr := fmt.Sprintf("%s.%s.%s", pod.Name, mykeystore.Name, pod.Namespace)
-		conn, client, err := rpc.CreateRawRpcClient(addr, 7070)
+		conn, client, err := rpc.CreateTlsRpcClient(addr, 7070, operatorRpcConfig())
 		if err != nil {
 			// logrus.Errorf("Client %s err = %v", addr, err)
 			errorCount++
@@ -14,6 +14,12 @@ import (
 
 type RpcClient = datap.InternalNodeServiceClient
 
+const (
+	TxnPending   = datap.TxnState_TXN_PENDING
+	TxnCommitted = datap.TxnState_TXN_COMMITTED
+	TxnAborted   = datap.TxnState_TXN_ABORTED
+)
+
 type (
 	RpcValue                = datap.Value
 	RpcGetRequestMessage    = datap.GetRequestMessage
@@ -21,14 +27,38 @@ type (
 	RpcEpochTreeObject      = datap.EpochTreeObject
 	RpcStreamBucketsRequest = datap.StreamBucketsRequest
 	RpcMembers              = datap.Members
+	RpcCausalContext        = datap.CausalContext
+	RpcScanRequest          = datap.ScanRequest
+	RpcValueBatch           = datap.ValueBatch
+	RpcBatchGetRequest      = datap.BatchGetRequestMessage
+	RpcBatchResult          = datap.BatchResult
+	RpcPrecondition         = datap.Precondition
+	RpcWatchRequest         = datap.WatchRequestMessage
+	RpcTxnIntent            = datap.TxnIntent
+	RpcTxnRead              = datap.TxnRead
+	RpcTxnPrepare           = datap.TxnPrepare
+	RpcTxnVote              = datap.TxnVote
+	RpcTxnResolve           = datap.TxnResolve
+	RpcTxnRecord            = datap.TxnRecord
+	RpcTxnState             = datap.TxnState
+	RpcStrongRequest        = datap.StrongRequestMessage
+	RpcStrongResponse       = datap.StrongResponseMessage
+	RpcNamespace            = datap.Namespace
+	RpcNamespaceChange      = datap.NamespaceChange
 )
 
 func (rpcWrapper *RpcWrapper) CreateRpcClient(ip string) (*grpc.ClientConn, RpcClient, error) {
-	return CreateRawRpcClient(ip, rpcWrapper.rpcConfig.Port)
+	return CreateRawRpcClient(ip, rpcWrapper.rpcConfig.Port,
+		rpcWrapper.transportOption,
+		grpc.WithUnaryInterceptor(clockUnaryClientInterceptor(rpcWrapper.clock)),
+		grpc.WithStreamInterceptor(clockStreamClientInterceptor(rpcWrapper.clock)),
+	)
 }
 
-func CreateRawRpcClient(ip string, port int) (*grpc.ClientConn, RpcClient, error) {
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", ip, port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+// CreateRawRpcClient dials a node. the connection is insecure unless opts hold transport credentials, see ClientTransportOption.
+func CreateRawRpcClient(ip string, port int, opts ...grpc.DialOption) (*grpc.ClientConn, RpcClient, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", ip, port), opts...)
 	if err != nil {
 		return nil, nil, err
 	}
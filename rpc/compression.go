@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"bytes"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// codecCompressor compresses grpc messages with a value codec.
+// servers answer with the compressor of the request, so a client choosing one compresses both directions.
+type codecCompressor struct {
+	codec utils.Codec
+}
+
+func init() {
+	encoding.RegisterCompressor(codecCompressor{codec: utils.CodecSnappy})
+	encoding.RegisterCompressor(codecCompressor{codec: utils.CodecZstd})
+}
+
+func (compressor codecCompressor) Name() string {
+	return compressor.codec.String()
+}
+
+func (compressor codecCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return &codecWriter{codec: compressor.codec, w: w}, nil
+}
+
+func (compressor codecCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err = compressor.codec.Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// codecWriter buffers a message and writes it compressed on Close.
+type codecWriter struct {
+	codec utils.Codec
+	w     io.Writer
+	buf   bytes.Buffer
+}
+
+func (writer *codecWriter) Write(p []byte) (int, error) {
+	return writer.buf.Write(p)
+}
+
+func (writer *codecWriter) Close() error {
+	data, err := writer.codec.Compress(writer.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = writer.w.Write(data)
+	return err
+}
+
+// CompressorOption returns the call option compressing a request and its response with codec.
+// CodecNone sends them uncompressed.
+func CompressorOption(codec utils.Codec) grpc.CallOption {
+	if codec == utils.CodecNone {
+		return grpc.EmptyCallOption{}
+	}
+	return grpc.UseCompressor(codec.String())
+}
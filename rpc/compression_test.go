@@ -0,0 +1,34 @@
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+func TestCompressor(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"value"}`), 100)
+	for _, codec := range []utils.Codec{utils.CodecSnappy, utils.CodecZstd} {
+		compressor := encoding.GetCompressor(codec.String())
+		assert.NotNil(t, compressor, "compressor %s should be registered", codec)
+
+		var buf bytes.Buffer
+		writer, err := compressor.Compress(&buf)
+		assert.NoError(t, err)
+		_, err = writer.Write(data)
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Close())
+		assert.Less(t, buf.Len(), len(data), "%s should compress", codec)
+
+		reader, err := compressor.Decompress(&buf)
+		assert.NoError(t, err)
+		decompressed, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decompressed, "%s round trip wrong value", codec)
+	}
+}
@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	datap "github.com/andrew-delph/my-key-store/datap"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// hlcMetadataKey carries the sender's hybrid clock on every internal request and response.
+const hlcMetadataKey = "x-hlc"
+
+type RpcHybridTimestamp = datap.HybridTimestamp
+
+func NewRpcHybridTimestamp(ts utils.HybridTimestamp) *RpcHybridTimestamp {
+	return &RpcHybridTimestamp{Physical: ts.Physical, Logical: ts.Logical, Node: ts.Node}
+}
+
+// ValueHybridTimestamp returns the version of a value.
+// values written before hybrid clocks were added fall back to their unix timestamp.
+func ValueHybridTimestamp(value *RpcValue) utils.HybridTimestamp {
+	if value.Hlc == nil {
+		return utils.HybridTimestamp{Physical: value.UnixTimestamp * 1000}
+	}
+	return ToHybridTimestamp(value.Hlc)
+}
+
+func ToHybridTimestamp(ts *RpcHybridTimestamp) utils.HybridTimestamp {
+	return utils.HybridTimestamp{Physical: ts.Physical, Logical: ts.Logical, Node: ts.Node}
+}
+
+func updateClockFromMetadata(clock *utils.HybridClock, md metadata.MD) {
+	for _, raw := range md.Get(hlcMetadataKey) {
+		ts, err := utils.ParseHybridTimestamp(raw)
+		if err != nil {
+			logrus.Debugf("updateClockFromMetadata err = %v", err)
+			continue
+		}
+		clock.Update(ts)
+	}
+}
+
+func clockUnaryServerInterceptor(clock *utils.HybridClock) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			updateClockFromMetadata(clock, md)
+		}
+		res, err := handler(ctx, req)
+		grpc.SetHeader(ctx, metadata.Pairs(hlcMetadataKey, clock.Now().String()))
+		return res, err
+	}
+}
+
+func clockStreamServerInterceptor(clock *utils.HybridClock) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if md, ok := metadata.FromIncomingContext(ss.Context()); ok {
+			updateClockFromMetadata(clock, md)
+		}
+		ss.SetHeader(metadata.Pairs(hlcMetadataKey, clock.Now().String()))
+		return handler(srv, ss)
+	}
+}
+
+func clockUnaryClientInterceptor(clock *utils.HybridClock) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, hlcMetadataKey, clock.Now().String())
+		var header metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+		updateClockFromMetadata(clock, header)
+		return err
+	}
+}
+
+func clockStreamClientInterceptor(clock *utils.HybridClock) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, hlcMetadataKey, clock.Now().String())
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &clockClientStream{ClientStream: stream, clock: clock}, nil
+	}
+}
+
+// clockClientStream updates the clock from the stream header once it is received.
+type clockClientStream struct {
+	grpc.ClientStream
+	clock   *utils.HybridClock
+	updated bool
+}
+
+func (stream *clockClientStream) RecvMsg(m interface{}) error {
+	err := stream.ClientStream.RecvMsg(m)
+	if !stream.updated {
+		stream.updated = true
+		if header, headerErr := stream.Header(); headerErr == nil {
+			updateClockFromMetadata(stream.clock, header)
+		}
+	}
+	return err
+}
@@ -16,18 +16,48 @@ import (
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 )
 
 type RpcWrapper struct {
-	rpcConfig config.RpcConfig
-	reqCh     chan interface{}
-	grpc      *grpc.Server
+	rpcConfig       config.RpcConfig
+	reqCh           chan interface{}
+	grpc            *grpc.Server
+	clock           *utils.HybridClock
+	transportOption grpc.DialOption
 	// datap.InternalNodeServiceServer
 }
 
-func CreateRpcWrapper(rpcConfig config.RpcConfig, reqCh chan interface{}) *RpcWrapper {
-	grpc := grpc.NewServer()
-	rpcWrapper := &RpcWrapper{rpcConfig: rpcConfig, grpc: grpc, reqCh: reqCh}
+// CreateRpcWrapper creates the internal rpc server. when tls is configured it requires client certificates
+// and only serves the peers named by members, the current ring members, or by TlsAllowedPeers.
+func CreateRpcWrapper(rpcConfig config.RpcConfig, reqCh chan interface{}, clock *utils.HybridClock, members func() []string) *RpcWrapper {
+	unaryInterceptors := []grpc.UnaryServerInterceptor{clockUnaryServerInterceptor(clock)}
+	streamInterceptors := []grpc.StreamServerInterceptor{clockStreamServerInterceptor(clock)}
+	var serverOptions []grpc.ServerOption
+	transportOption := grpc.DialOption(grpc.EmptyDialOption{})
+
+	enabled, err := TlsEnabled(rpcConfig)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if enabled {
+		reloader, err := newCertReloader(rpcConfig)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		authorizer := peerAuthorizer{members: members, allowedPeers: rpcConfig.TlsAllowedPeers}
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{authorizer.unaryServerInterceptor()}, unaryInterceptors...)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{authorizer.streamServerInterceptor()}, streamInterceptors...)
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(reloader.serverConfig())))
+		transportOption = grpc.WithTransportCredentials(credentials.NewTLS(reloader.clientConfig()))
+	}
+
+	serverOptions = append(serverOptions,
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	)
+	grpc := grpc.NewServer(serverOptions...)
+	rpcWrapper := &RpcWrapper{rpcConfig: rpcConfig, grpc: grpc, reqCh: reqCh, clock: clock, transportOption: transportOption}
 	datap.RegisterInternalNodeServiceServer(grpc, rpcWrapper)
 	return rpcWrapper
 }
@@ -37,11 +67,36 @@ type SetValueTask struct {
 	ResCh chan interface{}
 }
 
+type DeleteValueTask struct {
+	Value *RpcValue
+	ResCh chan interface{}
+}
+
 type GetValueTask struct {
 	Key   string
 	ResCh chan interface{}
 }
 
+type SetValueBatchTask struct {
+	Values []*RpcValue
+	ResCh  chan interface{}
+}
+
+type GetValueBatchTask struct {
+	Keys  []string
+	ResCh chan interface{}
+}
+
+type ScanTask struct {
+	Start      string
+	End        string
+	Limit      int
+	Partitions []int32
+	Index      string
+	IndexValue string
+	ResCh      chan interface{}
+}
+
 type GetEpochTreeObjectTask struct {
 	PartitionId int32
 	LowerEpoch  int64
@@ -62,6 +117,33 @@ type StreamBucketsTask struct {
 	ResCh       chan interface{}
 }
 
+// WatchTask streams the values committed on this node until Ctx is done.
+type WatchTask struct {
+	Ctx     context.Context
+	Request *RpcWatchRequest
+	ResCh   chan interface{}
+}
+
+type PrepareTxnTask struct {
+	Prepare *RpcTxnPrepare
+	ResCh   chan interface{}
+}
+
+type ResolveTxnTask struct {
+	Resolve *RpcTxnResolve
+	ResCh   chan interface{}
+}
+
+type TxnRecordTask struct {
+	Record *RpcTxnRecord
+	ResCh  chan interface{}
+}
+
+type StrongTask struct {
+	Request *RpcStrongRequest
+	ResCh   chan interface{}
+}
+
 type PartitionsHealthCheckTask struct {
 	ResCh chan interface{}
 }
@@ -77,6 +159,11 @@ type UpdateEpochTask struct {
 	UpdateId string
 }
 
+type ChangeNamespaceTask struct {
+	Change *RpcNamespaceChange
+	ResCh  chan interface{}
+}
+
 func (rpcWrapper *RpcWrapper) Stop() error {
 	rpcWrapper.grpc.GracefulStop()
 	return nil
@@ -106,6 +193,28 @@ func (rpcWrapper *RpcWrapper) SetRequest(ctx context.Context, value *datap.Value
 		return &datap.StandardObject{Message: "Value set"}, nil
 	case error:
 		// logrus.Errorf("SetRequest err = %v", res)
+		if st, ok := status.FromError(res); ok && (st.Code() == codes.FailedPrecondition || st.Code() == codes.ResourceExhausted || st.Code() == codes.AlreadyExists) {
+			return nil, res
+		}
+		return nil, status.Error(codes.Internal, res.Error())
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, errors.New("?????")
+}
+
+func (rpcWrapper *RpcWrapper) DeleteRequest(ctx context.Context, value *datap.Value) (*datap.StandardObject, error) {
+	logrus.Debugf("SERVER Handling DeleteRequest: key=%s epoch=%d", value.Key, value.Epoch)
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, DeleteValueTask{Value: value, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	rawRes := utils.RecieveChannelTimeout(resCh, rpcWrapper.rpcConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case bool:
+		return &datap.StandardObject{Message: "Value deleted"}, nil
+	case error:
 		return nil, status.Error(codes.Internal, res.Error())
 	default:
 		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
@@ -136,6 +245,123 @@ func (rpcWrapper *RpcWrapper) GetRequest(ctx context.Context, req *datap.GetRequ
 	return nil, errors.New("?????")
 }
 
+func (rpcWrapper *RpcWrapper) BatchSetRequest(ctx context.Context, batch *datap.ValueBatch) (*datap.BatchResult, error) {
+	logrus.Debugf("SERVER BatchSetRequest Values %d", len(batch.Values))
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, SetValueBatchTask{Values: batch.Values, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	rawRes := utils.RecieveChannelTimeout(resCh, rpcWrapper.rpcConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case *datap.BatchResult:
+		return res, nil
+	case error:
+		return nil, status.Error(codes.Internal, res.Error())
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, errors.New("?????")
+}
+
+func (rpcWrapper *RpcWrapper) PrepareTxn(ctx context.Context, req *datap.TxnPrepare) (*datap.TxnVote, error) {
+	logrus.Debugf("SERVER PrepareTxn TxnId %s", req.TxnId)
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, PrepareTxnTask{Prepare: req, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	rawRes := utils.RecieveChannelTimeout(resCh, rpcWrapper.rpcConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case *datap.TxnVote:
+		return res, nil
+	case error:
+		return nil, status.Error(codes.Internal, res.Error())
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, errors.New("?????")
+}
+
+func (rpcWrapper *RpcWrapper) ResolveTxn(ctx context.Context, req *datap.TxnResolve) (*datap.StandardObject, error) {
+	logrus.Debugf("SERVER ResolveTxn TxnId %s", req.TxnId)
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, ResolveTxnTask{Resolve: req, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	rawRes := utils.RecieveChannelTimeout(resCh, rpcWrapper.rpcConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case *datap.StandardObject:
+		return res, nil
+	case error:
+		return nil, status.Error(codes.Internal, res.Error())
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, errors.New("?????")
+}
+
+func (rpcWrapper *RpcWrapper) SetTxnRecord(ctx context.Context, req *datap.TxnRecord) (*datap.TxnRecord, error) {
+	logrus.Debugf("SERVER SetTxnRecord TxnId %s", req.TxnId)
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, TxnRecordTask{Record: req, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	rawRes := utils.RecieveChannelTimeout(resCh, rpcWrapper.rpcConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case *datap.TxnRecord:
+		return res, nil
+	case error:
+		return nil, status.Error(codes.Internal, res.Error())
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, errors.New("?????")
+}
+
+func (rpcWrapper *RpcWrapper) StrongRequest(ctx context.Context, req *datap.StrongRequestMessage) (*datap.StrongResponseMessage, error) {
+	logrus.Debugf("SERVER StrongRequest Key %s", req.Key)
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, StrongTask{Request: req, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	rawRes := utils.RecieveChannelTimeout(resCh, rpcWrapper.rpcConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case *datap.StrongResponseMessage:
+		return res, nil
+	case error:
+		if st, ok := status.FromError(res); ok && st.Code() == codes.FailedPrecondition {
+			return nil, res
+		}
+		return nil, status.Error(codes.Internal, res.Error())
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, errors.New("?????")
+}
+
+func (rpcWrapper *RpcWrapper) BatchGetRequest(ctx context.Context, req *datap.BatchGetRequestMessage) (*datap.ValueBatch, error) {
+	logrus.Debugf("SERVER BatchGetRequest Keys %d", len(req.Keys))
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, GetValueBatchTask{Keys: req.Keys, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	rawRes := utils.RecieveChannelTimeout(resCh, rpcWrapper.rpcConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case *datap.ValueBatch:
+		return res, nil
+	case error:
+		return nil, status.Error(codes.Internal, res.Error())
+	default:
+		logrus.Panicf("http unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, errors.New("?????")
+}
+
 func (rpcWrapper *RpcWrapper) StreamBuckets(req *datap.StreamBucketsRequest, stream datap.InternalNodeService_StreamBucketsServer) error {
 	logrus.Debugf("SERVER StreamBuckets Buckets %v LowerEpoch %v UpperEpoch %v Partition %v", req.Buckets, req.LowerEpoch, req.UpperEpoch, req.Partition)
 	resCh := make(chan interface{})
@@ -167,6 +393,63 @@ func (rpcWrapper *RpcWrapper) StreamBuckets(req *datap.StreamBucketsRequest, str
 	}
 }
 
+func (rpcWrapper *RpcWrapper) Watch(req *datap.WatchRequestMessage, stream datap.InternalNodeService_WatchServer) error {
+	logrus.Debugf("SERVER Watch Key %v Prefix %v Replay %v Epoch %v Timestamp %v", req.Key, req.Prefix, req.Replay, req.Epoch, req.Timestamp)
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, WatchTask{Ctx: stream.Context(), Request: req, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	for itemObj := range resCh {
+		switch item := itemObj.(type) {
+		case *datap.Value:
+			err := stream.Send(item)
+			if err != nil {
+				logrus.Debugf("SERVER Watch err = %v", err)
+				return err
+			}
+		case error:
+			logrus.Debugf("SERVER Watch err = %v", item)
+			return item
+		default:
+			logrus.Panicf("http unkown res type: %v", reflect.TypeOf(item))
+		}
+	}
+	logrus.Debug("SERVER Watch channel closed")
+	return nil
+}
+
+func (rpcWrapper *RpcWrapper) Scan(req *datap.ScanRequest, stream datap.InternalNodeService_ScanServer) error {
+	logrus.Debugf("SERVER Scan Start %v End %v Limit %v Partitions %v Index %v", req.Start, req.End, req.Limit, req.Partitions, req.Index)
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, ScanTask{Start: req.Start, End: req.End, Limit: int(req.Limit), Partitions: req.Partitions, Index: req.Index, IndexValue: req.IndexValue, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case itemObj, ok := <-resCh:
+			if !ok {
+				logrus.Debug("SERVER Scan channel closed")
+				return nil
+			}
+			switch item := itemObj.(type) {
+			case *datap.Value:
+				err := stream.Send(item)
+				if err != nil {
+					logrus.Debugf("SERVER Scan err = %v", err)
+					return err
+				}
+			case error:
+				logrus.Debugf("SERVER Scan err = %v", item)
+				return item
+			default:
+				logrus.Panicf("http unkown res type: %v", reflect.TypeOf(item))
+			}
+		}
+	}
+}
+
 func (rpcWrapper *RpcWrapper) GetEpochTree(ctx context.Context, req *datap.EpochTreeObject) (*datap.EpochTreeObject, error) {
 	logrus.Debugf("Handling GetEpochTree: Partition=%d LowerEpoch=%d UpperEpoch=%d", req.Partition, req.LowerEpoch, req.UpperEpoch)
 	resCh := make(chan interface{})
@@ -261,3 +544,25 @@ func (rpcWrapper *RpcWrapper) UpdateEpoch(ctx context.Context, req *datap.Standa
 	}
 	return nil, nil
 }
+
+func (rpcWrapper *RpcWrapper) ChangeNamespace(ctx context.Context, req *datap.NamespaceChange) (*datap.StandardObject, error) {
+	logrus.Debugf("SERVER ChangeNamespace Create %v Delete %v", req.GetCreate().GetName(), req.Delete)
+	resCh := make(chan interface{})
+	err := utils.WriteChannelTimeout(rpcWrapper.reqCh, ChangeNamespaceTask{Change: req, ResCh: resCh}, rpcWrapper.rpcConfig.DefaultTimeout)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	rawRes := utils.RecieveChannelTimeout(resCh, rpcWrapper.rpcConfig.DefaultTimeout)
+	switch res := rawRes.(type) {
+	case bool:
+		return &datap.StandardObject{Message: "namespace changed"}, nil
+	case error:
+		if st, ok := status.FromError(res); ok && st.Code() == codes.InvalidArgument {
+			return nil, res
+		}
+		return nil, status.Error(codes.Internal, res.Error())
+	default:
+		logrus.Panicf("rpc unkown res type: %v", reflect.TypeOf(res))
+	}
+	return nil, errors.New("?????")
+}
@@ -0,0 +1,256 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/status"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+// ErrTlsConfig is returned when the tls files of the rpc config are incomplete or cannot be loaded.
+var ErrTlsConfig = errors.New("invalid rpc tls config")
+
+// TlsEnabled reports if rpcConfig sets the tls files. setting only some of them is an error.
+func TlsEnabled(rpcConfig config.RpcConfig) (bool, error) {
+	set := 0
+	for _, file := range []string{rpcConfig.TlsCertFile, rpcConfig.TlsKeyFile, rpcConfig.TlsCaFile} {
+		if file != "" {
+			set++
+		}
+	}
+	switch set {
+	case 0:
+		return false, nil
+	case 3:
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: the cert, key and CA files must be set together", ErrTlsConfig)
+}
+
+// certReloader holds the certificate and CA of the rpc config and reloads them when their files change.
+type certReloader struct {
+	rpcConfig config.RpcConfig
+
+	lock     sync.Mutex
+	modTimes [3]time.Time
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+func newCertReloader(rpcConfig config.RpcConfig) (*certReloader, error) {
+	reloader := &certReloader{rpcConfig: rpcConfig}
+	_, _, err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// load returns the current certificate and CA pool. files which fail to load keep the previous ones.
+func (reloader *certReloader) load() (*tls.Certificate, *x509.CertPool, error) {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	var modTimes [3]time.Time
+	for i, file := range []string{reloader.rpcConfig.TlsCertFile, reloader.rpcConfig.TlsKeyFile, reloader.rpcConfig.TlsCaFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return reloader.current(fmt.Errorf("%w: %v", ErrTlsConfig, err))
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if reloader.cert != nil && modTimes == reloader.modTimes {
+		return reloader.cert, reloader.pool, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.rpcConfig.TlsCertFile, reloader.rpcConfig.TlsKeyFile)
+	if err != nil {
+		return reloader.current(fmt.Errorf("%w: %v", ErrTlsConfig, err))
+	}
+	caPem, err := os.ReadFile(reloader.rpcConfig.TlsCaFile)
+	if err != nil {
+		return reloader.current(fmt.Errorf("%w: %v", ErrTlsConfig, err))
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPem) {
+		return reloader.current(fmt.Errorf("%w: no certificates in %s", ErrTlsConfig, reloader.rpcConfig.TlsCaFile))
+	}
+	if reloader.cert != nil {
+		logrus.Infof("reloaded rpc tls certificates")
+	}
+	reloader.cert = &cert
+	reloader.pool = pool
+	reloader.modTimes = modTimes
+	return reloader.cert, reloader.pool, nil
+}
+
+// current returns the loaded certificate and CA pool after a failed reload, or err if none were loaded.
+func (reloader *certReloader) current(err error) (*tls.Certificate, *x509.CertPool, error) {
+	if reloader.cert == nil {
+		return nil, nil, err
+	}
+	logrus.Errorf("rpc tls reload err = %v", err)
+	return reloader.cert, reloader.pool, nil
+}
+
+// serverConfig requires clients to present a certificate signed by the current CA.
+func (reloader *certReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool, err := reloader.load()
+			if err != nil {
+				return nil, err
+			}
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}, nil
+		},
+	}
+}
+
+// clientConfig verifies that servers present a certificate signed by the current CA.
+// nodes are dialed by ip, so the server name is not checked. servers check the identity of their clients.
+func (reloader *certReloader) clientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// the chain is verified by VerifyPeerCertificate against the reloaded CA
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _, err := reloader.load()
+			return cert, err
+		},
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, pool, err := reloader.load()
+			if err != nil {
+				return err
+			}
+			if len(rawCerts) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, raw := range rawCerts {
+				certs[i], err = x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err = certs[0].Verify(x509.VerifyOptions{
+				Roots:         pool,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			return err
+		},
+	}
+}
+
+// ClientTransportOption returns the dial option securing connections to nodes configured with rpcConfig.
+// connections are insecure when tls is not configured.
+func ClientTransportOption(rpcConfig config.RpcConfig) (grpc.DialOption, error) {
+	enabled, err := TlsEnabled(rpcConfig)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return grpc.EmptyDialOption{}, nil
+	}
+	reloader, err := newCertReloader(rpcConfig)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(reloader.clientConfig())), nil
+}
+
+// CreateTlsRpcClient dials a node with the client certificate of rpcConfig, or insecurely when tls is not configured.
+func CreateTlsRpcClient(ip string, port int, rpcConfig config.RpcConfig) (*grpc.ClientConn, RpcClient, error) {
+	transportOption, err := ClientTransportOption(rpcConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return CreateRawRpcClient(ip, port, transportOption)
+}
+
+// certificateNames returns the common name and dns names of a certificate.
+func certificateNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// nameMatches reports if a certificate name identifies member. a name may be qualified by a domain, as in member.service.namespace.
+func nameMatches(name, member string) bool {
+	return name == member || strings.HasPrefix(name, member+".")
+}
+
+// peerAuthorizer allows the peers whose certificate names a ring member or an allowed peer.
+type peerAuthorizer struct {
+	members      func() []string
+	allowedPeers []string
+}
+
+// authorize returns a PermissionDenied error unless the tls peer of ctx is allowed.
+// the ring is filled through gossip and raft, so nodes do not need internal rpcs to be allowed.
+func (authorizer peerAuthorizer) authorize(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no peer")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return status.Error(codes.Unauthenticated, "no verified client certificate")
+	}
+	names := certificateNames(tlsInfo.State.VerifiedChains[0][0])
+	for _, allowed := range append(authorizer.members(), authorizer.allowedPeers...) {
+		for _, name := range names {
+			if nameMatches(name, allowed) {
+				return nil
+			}
+		}
+	}
+	logrus.Warnf("rejected rpc peer %v names = %v", p.Addr, names)
+	return status.Errorf(codes.PermissionDenied, "peer %v is not a ring member", names)
+}
+
+func (authorizer peerAuthorizer) unaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		err := authorizer.authorize(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (authorizer peerAuthorizer) streamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := authorizer.authorize(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
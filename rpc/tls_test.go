@@ -0,0 +1,144 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogo/status"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/andrew-delph/my-key-store/config"
+	"github.com/andrew-delph/my-key-store/utils"
+)
+
+// testCA is a throwaway certificate authority issuing node certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+var testFileTime = time.Now()
+
+// writeNodeFiles writes a certificate for name issued by ca, its key and the CA to dir and returns the rpc config using them.
+func (ca *testCA) writeNodeFiles(t *testing.T, dir, name string) config.RpcConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name + ".store.default"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	rpcConfig := config.RpcConfig{
+		DefaultTimeout: 5,
+		TlsCertFile:    filepath.Join(dir, "tls.crt"),
+		TlsKeyFile:     filepath.Join(dir, "tls.key"),
+		TlsCaFile:      filepath.Join(dir, "ca.crt"),
+	}
+	files := map[string][]byte{
+		rpcConfig.TlsCertFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		rpcConfig.TlsKeyFile:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		rpcConfig.TlsCaFile:   ca.pem,
+	}
+	// files are dated apart from the previous ones so a reload sees the change on file systems with coarse times
+	testFileTime = testFileTime.Add(time.Second)
+	for file, data := range files {
+		assert.NoError(t, os.WriteFile(file, data, 0o600))
+		assert.NoError(t, os.Chtimes(file, testFileTime, testFileTime))
+	}
+	return rpcConfig
+}
+
+// healthCheck calls a node as the client configured by rpcConfig and returns the status code.
+func healthCheck(t *testing.T, port int, rpcConfig config.RpcConfig) codes.Code {
+	conn, client, err := CreateTlsRpcClient("127.0.0.1", port, rpcConfig)
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.PartitionsHealthCheck(ctx, &RpcStandardObject{})
+	return status.Code(err)
+}
+
+func TestTls(t *testing.T) {
+	ca := newTestCA(t)
+	serverConfig := ca.writeNodeFiles(t, t.TempDir(), "node-0")
+	serverConfig.TlsAllowedPeers = []string{"operator"}
+
+	reqCh := make(chan interface{})
+	go func() {
+		for task := range reqCh {
+			task.(PartitionsHealthCheckTask).ResCh <- nil
+		}
+	}()
+	defer close(reqCh)
+	members := func() []string { return []string{"node-0", "node-1"} }
+	rpcWrapper := CreateRpcWrapper(serverConfig, reqCh, utils.NewHybridClock("node-0"), members)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go rpcWrapper.grpc.Serve(lis)
+	defer rpcWrapper.Stop()
+	port := lis.Addr().(*net.TCPAddr).Port
+
+	assert.Equal(t, codes.OK, healthCheck(t, port, ca.writeNodeFiles(t, t.TempDir(), "node-1")), "ring members should be served")
+	assert.Equal(t, codes.OK, healthCheck(t, port, ca.writeNodeFiles(t, t.TempDir(), "operator")), "allowed peers should be served")
+	assert.Equal(t, codes.PermissionDenied, healthCheck(t, port, ca.writeNodeFiles(t, t.TempDir(), "node-9")), "peers outside the ring should be rejected")
+	assert.Equal(t, codes.Unavailable, healthCheck(t, port, config.RpcConfig{}), "plaintext clients should be rejected")
+	otherCa := newTestCA(t)
+	assert.Equal(t, codes.Unavailable, healthCheck(t, port, otherCa.writeNodeFiles(t, t.TempDir(), "node-1")), "certificates of another CA should be rejected")
+
+	// the server reloads its files when they change
+	otherCa.writeNodeFiles(t, filepath.Dir(serverConfig.TlsCertFile), "node-0")
+	assert.Equal(t, codes.OK, healthCheck(t, port, otherCa.writeNodeFiles(t, t.TempDir(), "node-1")), "certificates of the reloaded CA should be served")
+	assert.Equal(t, codes.Unavailable, healthCheck(t, port, ca.writeNodeFiles(t, t.TempDir(), "node-1")), "certificates of the replaced CA should be rejected")
+
+	_, err = TlsEnabled(config.RpcConfig{TlsCertFile: "tls.crt"})
+	assert.ErrorIs(t, err, ErrTlsConfig, "partial tls config should be rejected")
+}
+
+func TestCertificateNames(t *testing.T) {
+	assert.True(t, nameMatches("node-1", "node-1"))
+	assert.True(t, nameMatches("node-1.store.default", "node-1"))
+	assert.False(t, nameMatches("node-10", "node-1"), "names should match whole labels")
+	assert.False(t, nameMatches("store.node-1", "node-1"))
+}
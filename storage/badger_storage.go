@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/sirupsen/logrus"
@@ -55,11 +56,27 @@ func (storage BadgerStorage) Get(key []byte) ([]byte, error) {
 	return value, nil
 }
 
+func (storage BadgerStorage) Delete(key []byte) error {
+	txn := storage.db.NewTransaction(true)
+	defer txn.Discard()
+
+	err := txn.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
 func (storage BadgerStorage) NewTransaction(update bool) Transaction {
 	trx := storage.db.NewTransaction(update)
 	return BadgerTransaction{trx: trx}
 }
 
+func (storage BadgerStorage) NativeExpiry() bool {
+	return true
+}
+
 func (storage BadgerStorage) Close() error {
 	err := storage.db.Sync()
 	if err != nil {
@@ -82,22 +99,22 @@ func (storage BadgerStorage) NewIterator(Start []byte, Limit []byte, reverse boo
 	op.Reverse = reverse
 	it := trx.NewIterator(op)
 
-	if reverse {
-		it.Seek(Limit)
-	} else {
-		it.Seek(Start)
-	}
-
-	return BadgerIterator{it: it, trx: trx, Start: Start, Limit: Limit, reverse: reverse}
+	iterator := BadgerIterator{it: it, trx: trx, Start: Start, Limit: Limit, reverse: reverse}
+	iterator.First()
+	return iterator
 }
 
+// First seeks the first key of the range. a reverse seek finds Limit itself, which is excluded.
 func (iterator BadgerIterator) First() bool {
 	if iterator.reverse {
 		iterator.it.Seek(iterator.Limit)
+		if iterator.it.Valid() && bytes.Equal(iterator.it.Item().Key(), iterator.Limit) {
+			iterator.it.Next()
+		}
 	} else {
 		iterator.it.Seek(iterator.Start)
 	}
-	return iterator.it.Valid()
+	return !iterator.IsDone()
 }
 
 func (iterator BadgerIterator) Next() bool {
@@ -107,7 +124,13 @@ func (iterator BadgerIterator) Next() bool {
 }
 
 func (iterator BadgerIterator) IsDone() bool {
-	return !iterator.it.Valid() || bytes.Compare(iterator.it.Item().Key(), iterator.Limit) >= 0
+	if !iterator.it.Valid() {
+		return true
+	}
+	if iterator.reverse {
+		return bytes.Compare(iterator.it.Item().Key(), iterator.Start) < 0
+	}
+	return bytes.Compare(iterator.it.Item().Key(), iterator.Limit) >= 0
 }
 
 func (iterator BadgerIterator) Key() []byte {
@@ -124,6 +147,10 @@ func (iterator BadgerIterator) Value() []byte {
 	return value
 }
 
+func (iterator BadgerIterator) Error() error {
+	return nil
+}
+
 func (iterator BadgerIterator) Release() {
 	iterator.it.Close()
 	iterator.trx.Discard()
@@ -138,16 +165,39 @@ func (transaction BadgerTransaction) Discard() {
 }
 
 func (transaction BadgerTransaction) Commit() error {
-	return transaction.trx.Commit()
+	err := transaction.trx.Commit()
+	if err == badger.ErrConflict {
+		return ErrConflict
+	}
+	return err
 }
 
 func (transaction BadgerTransaction) Set(key []byte, value []byte) error {
-	return transaction.trx.Set(key, value)
+	return badgerWriteError(transaction.trx.Set(key, value))
+}
+
+func (transaction BadgerTransaction) SetWithExpiry(key []byte, value []byte, expiresAt time.Time) error {
+	entry := badger.NewEntry(key, value)
+	entry.ExpiresAt = uint64(expiresAt.Unix())
+	return badgerWriteError(transaction.trx.SetEntry(entry))
+}
+
+func (transaction BadgerTransaction) Delete(key []byte) error {
+	return badgerWriteError(transaction.trx.Delete(key))
+}
+
+func badgerWriteError(err error) error {
+	if err == badger.ErrTxnTooBig {
+		return ErrTxnTooBig
+	}
+	return err
 }
 
 func (transaction BadgerTransaction) Get(key []byte) ([]byte, error) {
 	item, err := transaction.trx.Get(key)
-	if err != nil {
+	if err == badger.ErrKeyNotFound {
+		return nil, KEY_NOT_FOUND
+	} else if err != nil {
 		return nil, err
 	}
 
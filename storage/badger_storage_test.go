@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -44,3 +45,25 @@ func TestBadgerShutdown(t *testing.T) {
 	}
 	assert.EqualValues(t, value, res, "value should be equal")
 }
+
+func TestBadgerTxnTooBig(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	storage := NewBadgerStorage(c.Storage)
+	defer storage.Close()
+
+	trx := storage.NewTransaction(true)
+	defer trx.Discard()
+	var err error
+	written := 0
+	for ; err == nil && written < 1000000; written++ {
+		err = trx.Set([]byte(fmt.Sprintf("key%d", written)), []byte("value"))
+	}
+	assert.ErrorIs(t, err, ErrTxnTooBig, "a full transaction should return ErrTxnTooBig")
+
+	// the writes before it can still be committed
+	err = trx.Commit()
+	assert.NoError(t, err)
+	_, err = storage.Get([]byte("key0"))
+	assert.NoError(t, err)
+}
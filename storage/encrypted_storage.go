@@ -0,0 +1,463 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+// ErrEncryption is returned when the data directory cannot be opened with the configured encryption keys.
+var ErrEncryption = errors.New("storage encryption")
+
+// encryptedRecordVersion is the first byte of an encrypted record.
+const encryptedRecordVersion = byte(0x01)
+
+// an encrypted record is the version, the id of its key, when it expires in unix milliseconds, the nonce and the sealed value.
+// the header and the storage key are authenticated, so a record cannot be moved to another key or expiry.
+const (
+	encryptedHeaderSize = 1 + 4 + 8
+	encryptedNonceSize  = 12
+)
+
+// encryptionMarkerKey is stored encrypted in every encrypted data directory.
+// it tells an encrypted directory from a plain one and checks that the configured keys decrypt it.
+var encryptionMarkerKey = []byte{metaPrefix, 'e', 'n', 'c', 'r', 'y', 'p', 't'}
+
+var encryptionMarkerValue = []byte("encrypted")
+
+// indexTokenKey is stored encrypted in every encrypted data directory. it holds the random key of IndexToken,
+// which stays the same when the encryption keys are rotated.
+var indexTokenKey = []byte{metaPrefix, 'i', 'n', 'd', 'e', 'x', 'k', 'e', 'y'}
+
+type encryptionKey struct {
+	id   uint32
+	aead cipher.AEAD
+}
+
+// encryptionKeys is a key ring. records are encrypted with the active key and decrypted with the key of their id.
+type encryptionKeys struct {
+	active *encryptionKey
+	byId   map[uint32]*encryptionKey
+}
+
+// LoadEncryptionKeys returns the keys of conf, EncryptionKeys if it is set, else the keys in EncryptionKeyFile.
+// keys are base64 encoded 32 byte AES keys separated by commas or new lines. the first key encrypts new records.
+// it returns no keys when encryption is not configured.
+func LoadEncryptionKeys(conf config.StorageConfig) ([][]byte, error) {
+	raw := conf.EncryptionKeys
+	if raw == "" && conf.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(conf.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrEncryption, err)
+		}
+		raw = string(data)
+	}
+	var keys [][]byte
+	for _, field := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(field)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%w: keys must be base64 encoded 32 byte keys", ErrEncryption)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func newEncryptionKeys(keys [][]byte) (*encryptionKeys, error) {
+	ring := &encryptionKeys{byId: make(map[uint32]*encryptionKey, len(keys))}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrEncryption, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrEncryption, err)
+		}
+		sum := sha256.Sum256(key)
+		id := binary.BigEndian.Uint32(sum[:4])
+		if ring.byId[id] != nil {
+			return nil, fmt.Errorf("%w: duplicate key", ErrEncryption)
+		}
+		ring.byId[id] = &encryptionKey{id: id, aead: aead}
+		if ring.active == nil {
+			ring.active = ring.byId[id]
+		}
+	}
+	return ring, nil
+}
+
+// seal encrypts the value of key with the active key.
+func (keys *encryptionKeys) seal(key, value []byte, expiresAt int64) ([]byte, error) {
+	record := make([]byte, encryptedHeaderSize+encryptedNonceSize, encryptedHeaderSize+encryptedNonceSize+len(value)+keys.active.aead.Overhead())
+	record[0] = encryptedRecordVersion
+	binary.BigEndian.PutUint32(record[1:5], keys.active.id)
+	binary.BigEndian.PutUint64(record[5:encryptedHeaderSize], uint64(expiresAt))
+	nonce := record[encryptedHeaderSize:]
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return keys.active.aead.Seal(record, nonce, value, recordData(key, record[:encryptedHeaderSize])), nil
+}
+
+// open decrypts the record of key and returns its value and expiry.
+func (keys *encryptionKeys) open(key, record []byte) ([]byte, int64, error) {
+	id, err := recordKeyId(record)
+	if err != nil {
+		return nil, 0, err
+	}
+	encryptionKey := keys.byId[id]
+	if encryptionKey == nil {
+		return nil, 0, fmt.Errorf("%w: no configured key has id %08x", ErrEncryption, id)
+	}
+	header := record[:encryptedHeaderSize]
+	nonce := record[encryptedHeaderSize : encryptedHeaderSize+encryptedNonceSize]
+	value, err := encryptionKey.aead.Open(nil, nonce, record[encryptedHeaderSize+encryptedNonceSize:], recordData(key, header))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrEncryption, err)
+	}
+	return value, int64(binary.BigEndian.Uint64(header[5:])), nil
+}
+
+func recordKeyId(record []byte) (uint32, error) {
+	if len(record) < encryptedHeaderSize+encryptedNonceSize || record[0] != encryptedRecordVersion {
+		return 0, fmt.Errorf("%w: record is not encrypted", ErrEncryption)
+	}
+	return binary.BigEndian.Uint32(record[1:5]), nil
+}
+
+// recordData is the additional data authenticated with a record.
+func recordData(key, header []byte) []byte {
+	data := make([]byte, 0, len(header)+len(key))
+	data = append(data, header...)
+	return append(data, key...)
+}
+
+// EncryptedStorage encrypts the values stored in another engine with AES-GCM.
+// keys are stored in plain text so they keep their order, so the user keys held by index keys are not encrypted.
+// the keys are shared by the copies of an EncryptedStorage so ReloadKeys replaces them for all of them.
+type EncryptedStorage struct {
+	storage  Storage
+	keys     *atomic.Pointer[encryptionKeys]
+	tokenKey []byte
+}
+
+// NewEncryptedStorage encrypts the values of db with keys, the first of which encrypts new records.
+// an empty db is marked as encrypted. a db which holds plain data or which none of keys decrypts is refused.
+func NewEncryptedStorage(db Storage, keys [][]byte) (EncryptedStorage, error) {
+	if len(keys) == 0 {
+		return EncryptedStorage{}, fmt.Errorf("%w: no keys", ErrEncryption)
+	}
+	ring, err := newEncryptionKeys(keys)
+	if err != nil {
+		return EncryptedStorage{}, err
+	}
+	storage := EncryptedStorage{storage: db, keys: &atomic.Pointer[encryptionKeys]{}}
+	storage.keys.Store(ring)
+	_, err = db.Get(encryptionMarkerKey)
+	if err == KEY_NOT_FOUND {
+		if !isEmpty(db) {
+			return EncryptedStorage{}, fmt.Errorf("%w: encryption cannot be enabled on a data directory written without it", ErrEncryption)
+		}
+		err = storage.Put(encryptionMarkerKey, encryptionMarkerValue)
+		if err != nil {
+			return EncryptedStorage{}, err
+		}
+		return storage.loadTokenKey()
+	} else if err != nil {
+		return EncryptedStorage{}, err
+	}
+	marker, err := storage.Get(encryptionMarkerKey)
+	if err != nil {
+		return EncryptedStorage{}, fmt.Errorf("%w: the configured keys do not decrypt the data directory: %v", ErrEncryption, err)
+	}
+	if !bytes.Equal(marker, encryptionMarkerValue) {
+		return EncryptedStorage{}, fmt.Errorf("%w: invalid encryption marker", ErrEncryption)
+	}
+	return storage.loadTokenKey()
+}
+
+// loadTokenKey reads the key of IndexToken, which is created the first time the directory is opened encrypted.
+func (storage EncryptedStorage) loadTokenKey() (EncryptedStorage, error) {
+	tokenKey, err := storage.Get(indexTokenKey)
+	if err == KEY_NOT_FOUND {
+		tokenKey = make([]byte, 32)
+		_, err = rand.Read(tokenKey)
+		if err != nil {
+			return EncryptedStorage{}, err
+		}
+		err = storage.Put(indexTokenKey, tokenKey)
+	}
+	if err != nil {
+		return EncryptedStorage{}, err
+	}
+	storage.tokenKey = tokenKey
+	return storage, nil
+}
+
+// IndexToken returns the HMAC-SHA256 of value under the token key of the data directory.
+// keys are stored in plain text, so indexes which hold values in their keys store the token of a value instead.
+// equal values have equal tokens, so an index can still be looked up by value.
+func (storage EncryptedStorage) IndexToken(value []byte) []byte {
+	mac := hmac.New(sha256.New, storage.tokenKey)
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+// checkNotEncrypted returns an error if db was written encrypted.
+func checkNotEncrypted(db Storage) error {
+	_, err := db.Get(encryptionMarkerKey)
+	if err == KEY_NOT_FOUND {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: the data directory is encrypted, configure its keys", ErrEncryption)
+}
+
+func (storage EncryptedStorage) Put(key []byte, value []byte) error {
+	record, err := storage.keys.Load().seal(key, value, 0)
+	if err != nil {
+		return err
+	}
+	return storage.storage.Put(key, record)
+}
+
+func (storage EncryptedStorage) Get(key []byte) ([]byte, error) {
+	record, err := storage.storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := storage.keys.Load().open(key, record)
+	return value, err
+}
+
+func (storage EncryptedStorage) Delete(key []byte) error {
+	return storage.storage.Delete(key)
+}
+
+func (storage EncryptedStorage) NewIterator(Start []byte, Limit []byte, reverse bool) Iterator {
+	return &EncryptedIterator{it: storage.storage.NewIterator(Start, Limit, reverse), keys: storage.keys.Load()}
+}
+
+func (storage EncryptedStorage) NewTransaction(update bool) Transaction {
+	return EncryptedTransaction{trx: storage.storage.NewTransaction(update), keys: storage.keys.Load()}
+}
+
+func (storage EncryptedStorage) NativeExpiry() bool {
+	return storage.storage.NativeExpiry()
+}
+
+// ReloadKeys replaces the encryption keys. the first of keys encrypts new records from then on
+// and Reencrypt rewrites the records of the others. keys which do not decrypt the data directory are refused.
+func (storage EncryptedStorage) ReloadKeys(keys [][]byte) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("%w: no keys", ErrEncryption)
+	}
+	ring, err := newEncryptionKeys(keys)
+	if err != nil {
+		return err
+	}
+	record, err := storage.storage.Get(encryptionMarkerKey)
+	if err != nil {
+		return err
+	}
+	_, _, err = ring.open(encryptionMarkerKey, record)
+	if err != nil {
+		return fmt.Errorf("%w: the configured keys do not decrypt the data directory: %v", ErrEncryption, err)
+	}
+	storage.keys.Store(ring)
+	return nil
+}
+
+func (storage EncryptedStorage) Close() error {
+	return storage.storage.Close()
+}
+
+// Reencrypt rewrites the records which are not encrypted with the active key, batchSize records per transaction.
+// records written concurrently are left to their writer. it returns the number of records rewritten.
+// old keys can be removed from the config once it returns without error.
+func (storage EncryptedStorage) Reencrypt(batchSize int) (int, error) {
+	type entry struct {
+		key    []byte
+		record []byte
+	}
+	keys := storage.keys.Load()
+	reencrypted := 0
+	start := []byte{metaPrefix}
+	for {
+		var entries []entry
+		it := storage.storage.NewIterator(start, []byte{0xff}, false)
+		for ok := it.First(); ok && len(entries) < batchSize; ok = it.Next() {
+			id, err := recordKeyId(it.Value())
+			if err != nil {
+				it.Release()
+				return reencrypted, err
+			}
+			if id != keys.active.id {
+				entries = append(entries, entry{key: bytes.Clone(it.Key()), record: bytes.Clone(it.Value())})
+			}
+		}
+		it.Release()
+		if len(entries) == 0 {
+			return reencrypted, nil
+		}
+
+		trx := storage.storage.NewTransaction(true)
+		rewritten := 0
+		for _, entry := range entries {
+			record, err := trx.Get(entry.key)
+			if err == KEY_NOT_FOUND || (err == nil && !bytes.Equal(record, entry.record)) {
+				continue
+			} else if err != nil {
+				trx.Discard()
+				return reencrypted, err
+			}
+			value, expiresAt, err := keys.open(entry.key, record)
+			if err != nil {
+				trx.Discard()
+				return reencrypted, err
+			}
+			record, err = keys.seal(entry.key, value, expiresAt)
+			if err != nil {
+				trx.Discard()
+				return reencrypted, err
+			}
+			if expiresAt == 0 {
+				err = trx.Set(entry.key, record)
+			} else {
+				err = trx.SetWithExpiry(entry.key, record, time.UnixMilli(expiresAt))
+			}
+			if err != nil {
+				trx.Discard()
+				return reencrypted, err
+			}
+			rewritten++
+		}
+		err := trx.Commit()
+		if err == ErrConflict {
+			// the batch is read again
+			logrus.Debugf("Reencrypt conflict, retrying batch")
+			continue
+		} else if err != nil {
+			return reencrypted, err
+		}
+		reencrypted += rewritten
+		start = append(entries[len(entries)-1].key, 0x00)
+	}
+}
+
+// EncryptedIterator decrypts the entry it is at once and keeps the value or the error for Value and Error.
+type EncryptedIterator struct {
+	it     Iterator
+	keys   *encryptionKeys
+	opened bool
+	value  []byte
+	err    error
+}
+
+func (iterator *EncryptedIterator) First() bool {
+	iterator.opened = false
+	return iterator.it.First()
+}
+
+func (iterator *EncryptedIterator) Next() bool {
+	iterator.opened = false
+	return iterator.it.Next()
+}
+
+func (iterator *EncryptedIterator) IsDone() bool {
+	return iterator.it.IsDone()
+}
+
+func (iterator *EncryptedIterator) Key() []byte {
+	return iterator.it.Key()
+}
+
+func (iterator *EncryptedIterator) open() {
+	if iterator.opened {
+		return
+	}
+	iterator.opened = true
+	iterator.value, iterator.err = nil, nil
+	if !iterator.it.IsDone() {
+		iterator.value, _, iterator.err = iterator.keys.open(iterator.it.Key(), iterator.it.Value())
+	}
+}
+
+// Value returns the decrypted value. a record which cannot be decrypted is read as nil and its error is returned by Error.
+func (iterator *EncryptedIterator) Value() []byte {
+	iterator.open()
+	return iterator.value
+}
+
+func (iterator *EncryptedIterator) Error() error {
+	iterator.open()
+	return iterator.err
+}
+
+func (iterator *EncryptedIterator) Release() {
+	iterator.it.Release()
+}
+
+type EncryptedTransaction struct {
+	trx  Transaction
+	keys *encryptionKeys
+}
+
+func (transaction EncryptedTransaction) Discard() {
+	transaction.trx.Discard()
+}
+
+func (transaction EncryptedTransaction) Commit() error {
+	return transaction.trx.Commit()
+}
+
+func (transaction EncryptedTransaction) Set(key []byte, value []byte) error {
+	record, err := transaction.keys.seal(key, value, 0)
+	if err != nil {
+		return err
+	}
+	return transaction.trx.Set(key, record)
+}
+
+func (transaction EncryptedTransaction) SetWithExpiry(key []byte, value []byte, expiresAt time.Time) error {
+	record, err := transaction.keys.seal(key, value, expiresAt.UnixMilli())
+	if err != nil {
+		return err
+	}
+	return transaction.trx.SetWithExpiry(key, record, expiresAt)
+}
+
+func (transaction EncryptedTransaction) Get(key []byte) ([]byte, error) {
+	record, err := transaction.trx.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := transaction.keys.open(key, record)
+	return value, err
+}
+
+func (transaction EncryptedTransaction) Delete(key []byte) error {
+	return transaction.trx.Delete(key)
+}
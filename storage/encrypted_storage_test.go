@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+func testEncryptionKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	callbacks := []StorageCallback{
+		storageTransactionIsolation,
+		storageSetGet,
+		storageTransaction,
+		storageDelete,
+		storageSetWithExpiry,
+		storageIterator,
+	}
+	key := testEncryptionKey(t)
+	for _, callback := range callbacks {
+		AllStorage(t, func(t *testing.T, storage Storage) {
+			keys, err := LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: key})
+			assert.NoError(t, err)
+			encrypted, err := NewEncryptedStorage(storage, keys)
+			assert.NoError(t, err)
+			callback(t, encrypted)
+		})
+	}
+}
+
+func TestEncryptionKeys(t *testing.T) {
+	key1, key2 := testEncryptionKey(t), testEncryptionKey(t)
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	assert.NoError(t, os.WriteFile(keyFile, []byte(key1+"\n"+key2+"\n"), 0o600))
+
+	keys, err := LoadEncryptionKeys(config.StorageConfig{EncryptionKeyFile: keyFile})
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2, "file keys wrong value")
+	keys, err = LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: key2 + "," + key1, EncryptionKeyFile: keyFile})
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2, "env keys wrong value")
+	keys, err = LoadEncryptionKeys(config.StorageConfig{})
+	assert.NoError(t, err)
+	assert.Empty(t, keys, "encryption should not be configured")
+
+	for _, invalid := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short")), key1 + "," + key1} {
+		keys, err = LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: invalid})
+		if err == nil {
+			_, err = newEncryptionKeys(keys)
+		}
+		assert.ErrorIs(t, err, ErrEncryption, "keys %q should be rejected", invalid)
+	}
+}
+
+func TestEncryptionRotation(t *testing.T) {
+	AllStorage(t, func(t *testing.T, storage Storage) {
+		key1, key2 := testEncryptionKey(t), testEncryptionKey(t)
+		open := func(raw string) (EncryptedStorage, error) {
+			keys, err := LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: raw})
+			assert.NoError(t, err)
+			return NewEncryptedStorage(storage, keys)
+		}
+
+		encrypted, err := open(key1)
+		assert.NoError(t, err)
+		value := []byte("secret value")
+		assert.NoError(t, encrypted.Put([]byte("a"), value))
+		trx := encrypted.NewTransaction(true)
+		assert.NoError(t, trx.SetWithExpiry([]byte("b"), value, time.Now().Add(time.Hour)))
+		assert.NoError(t, trx.Commit())
+		raw, err := storage.Get([]byte("a"))
+		assert.NoError(t, err)
+		assert.False(t, bytes.Contains(raw, value), "value should be stored encrypted")
+
+		_, err = open(key2)
+		assert.ErrorIs(t, err, ErrEncryption, "a key which does not decrypt the data should be rejected")
+		assert.ErrorIs(t, checkNotEncrypted(storage), ErrEncryption, "an encrypted directory should not be opened without keys")
+
+		// the new key encrypts new records and the old key still decrypts the others
+		encrypted, err = open(key2 + "," + key1)
+		assert.NoError(t, err)
+		reencrypted, err := encrypted.Reencrypt(1)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, reencrypted, "the marker, the index token key and both records should be reencrypted")
+		reencrypted, err = encrypted.Reencrypt(1)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, reencrypted, "records should be reencrypted once")
+
+		// the old key can be removed once every record is reencrypted
+		encrypted, err = open(key2)
+		assert.NoError(t, err)
+		for _, key := range []string{"a", "b"} {
+			res, err := encrypted.Get([]byte(key))
+			assert.NoError(t, err)
+			assert.Equal(t, value, res, "reencrypted value wrong value")
+		}
+		raw, err = storage.Get([]byte("b"))
+		assert.NoError(t, err)
+		_, expiresAt, err := encrypted.keys.Load().open([]byte("b"), raw)
+		assert.NoError(t, err)
+		assert.NotZero(t, expiresAt, "expiry should be kept")
+	})
+}
+
+func TestEncryptionReloadKeys(t *testing.T) {
+	AllStorage(t, func(t *testing.T, storage Storage) {
+		key1, key2, key3 := testEncryptionKey(t), testEncryptionKey(t), testEncryptionKey(t)
+		load := func(raw string) [][]byte {
+			keys, err := LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: raw})
+			assert.NoError(t, err)
+			return keys
+		}
+		encrypted, err := NewEncryptedStorage(storage, load(key1))
+		assert.NoError(t, err)
+		value := []byte("secret value")
+		assert.NoError(t, encrypted.Put([]byte("a"), value))
+
+		// a rotation is completed without opening the storage again
+		assert.NoError(t, encrypted.ReloadKeys(load(key2+","+key1)))
+		reencrypted, err := encrypted.Reencrypt(10)
+		assert.NoError(t, err)
+		assert.Greater(t, reencrypted, 0, "records should be reencrypted with the new key")
+		assert.NoError(t, encrypted.ReloadKeys(load(key2)))
+		res, err := encrypted.Get([]byte("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, value, res, "reencrypted value wrong value")
+
+		assert.ErrorIs(t, encrypted.ReloadKeys(load(key3)), ErrEncryption, "keys which do not decrypt the data should be refused")
+		res, err = encrypted.Get([]byte("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, value, res, "refused keys should not replace the keys")
+	})
+}
+
+func TestEncryptedIteratorError(t *testing.T) {
+	AllStorage(t, func(t *testing.T, storage Storage) {
+		keys, err := LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: testEncryptionKey(t)})
+		assert.NoError(t, err)
+		encrypted, err := NewEncryptedStorage(storage, keys)
+		assert.NoError(t, err)
+		assert.NoError(t, encrypted.Put([]byte("a"), []byte("value a")))
+		assert.NoError(t, encrypted.Put([]byte("b"), []byte("value b")))
+		// a record which no configured key decrypts
+		assert.NoError(t, storage.Put([]byte("a"), []byte("plain")))
+
+		it := encrypted.NewIterator([]byte("a"), []byte("c"), false)
+		defer it.Release()
+		assert.ErrorIs(t, it.Error(), ErrEncryption, "an unreadable record should return its error")
+		assert.Nil(t, it.Value(), "an unreadable record should be read as nil")
+		assert.True(t, it.Next())
+		assert.NoError(t, it.Error(), "the error should not outlive its record")
+		assert.Equal(t, []byte("value b"), it.Value(), "value wrong value")
+	})
+}
+
+func TestIndexToken(t *testing.T) {
+	AllStorage(t, func(t *testing.T, storage Storage) {
+		key1, key2 := testEncryptionKey(t), testEncryptionKey(t)
+		keys, err := LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: key1})
+		assert.NoError(t, err)
+		encrypted, err := NewEncryptedStorage(storage, keys)
+		assert.NoError(t, err)
+		token := encrypted.IndexToken([]byte("paris"))
+		assert.Equal(t, token, encrypted.IndexToken([]byte("paris")), "equal values should have equal tokens")
+		assert.NotEqual(t, token, encrypted.IndexToken([]byte("rome")), "values should have different tokens")
+		assert.False(t, bytes.Contains(token, []byte("paris")), "the token should not hold the value")
+
+		// the token key is kept when the encryption keys are rotated
+		keys, err = LoadEncryptionKeys(config.StorageConfig{EncryptionKeys: key2 + "," + key1})
+		assert.NoError(t, err)
+		encrypted, err = NewEncryptedStorage(storage, keys)
+		assert.NoError(t, err)
+		assert.Equal(t, token, encrypted.IndexToken([]byte("paris")), "tokens should survive a rotation")
+	})
+}
+
+func TestEncryptionPlainData(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+	c.Storage.Engine = "leveldb"
+	storage, err := NewStorage(c.Storage)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Put([]byte("a"), []byte("plain")))
+	assert.NoError(t, storage.Close())
+
+	c.Storage.EncryptionKeys = testEncryptionKey(t)
+	_, err = NewStorage(c.Storage)
+	assert.ErrorIs(t, err, ErrEncryption, "encryption should not be enabled on plain data")
+
+	c.Storage.DataPath = t.TempDir()
+	storage, err = NewStorage(c.Storage)
+	assert.NoError(t, err)
+	assert.IsType(t, EncryptedStorage{}, storage, "engine wrong type")
+	version, err := ReadFormatVersion(storage)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatVersion, version, "new encrypted storage should have the current format")
+	assert.NoError(t, storage.Close())
+}
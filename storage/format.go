@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// FormatVersion is the on-disk format written by this build.
+// format 1 joined the columns of an index with '_', format 2 is the tuple encoding of Index.
+const FormatVersion = 2
+
+// LegacyFormatVersion is the format of data directories written before the format version was stored.
+const LegacyFormatVersion = 1
+
+// ErrFormatVersion is returned when a data directory was written in a format this build does not read.
+var ErrFormatVersion = errors.New("unsupported storage format")
+
+var formatVersionKey = []byte{metaPrefix, 'f', 'o', 'r', 'm', 'a', 't'}
+
+// ReadFormatVersion returns the format of the data in db. an empty db has the current format,
+// data without a stored version has the legacy format.
+func ReadFormatVersion(db Storage) (int, error) {
+	value, err := db.Get(formatVersionKey)
+	if err == KEY_NOT_FOUND {
+		if isEmpty(db) {
+			return FormatVersion, nil
+		}
+		return LegacyFormatVersion, nil
+	} else if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("%w: format version %q", ErrFormatVersion, value)
+	}
+	return version, nil
+}
+
+// isEmpty reports if db holds no entries other than the encryption marker and the index token key.
+func isEmpty(db Storage) bool {
+	it := db.NewIterator([]byte{metaPrefix}, []byte{0xff}, false)
+	defer it.Release()
+	for ok := it.First(); ok; ok = it.Next() {
+		if !bytes.Equal(it.Key(), encryptionMarkerKey) && !bytes.Equal(it.Key(), indexTokenKey) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteFormatVersion stores the format of the data in db.
+func WriteFormatVersion(db Storage, version int) error {
+	return db.Put(formatVersionKey, []byte(strconv.Itoa(version)))
+}
+
+// CheckFormatVersion returns an error unless db holds data in the current format. an empty db is stamped with it.
+func CheckFormatVersion(db Storage) error {
+	version, err := ReadFormatVersion(db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version == LegacyFormatVersion:
+		return fmt.Errorf("%w: the data directory has format %d, migrate it to format %d with the migrate command", ErrFormatVersion, version, FormatVersion)
+	case version != FormatVersion:
+		return fmt.Errorf("%w: the data directory has format %d, this build reads format %d", ErrFormatVersion, version, FormatVersion)
+	}
+	return WriteFormatVersion(db, FormatVersion)
+}
@@ -3,8 +3,10 @@ module storage
 go 1.20
 
 require (
+	github.com/cockroachdb/pebble v1.1.0
 	github.com/dgraph-io/badger v1.6.2
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/prometheus/client_golang v1.16.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/stretchr/testify v1.8.4
 	github.com/syndtr/goleveldb v1.0.0
@@ -1,16 +1,69 @@
 package storage
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"strings"
+	"strconv"
 )
 
+// ErrInvalidIndex is returned when an index does not decode with the columns of its Index.
+var ErrInvalidIndex = errors.New("invalid index")
+
+// type codes of the encoded columns, like the FoundationDB tuple layer.
+const (
+	bytesCode = byte(0x02)
+	intCode   = byte(0x14)
+)
+
+// bytesEscape follows a 0x00 inside an unordered value so it cannot be taken for the terminator.
+const bytesEscape = byte(0xff)
+
+// metaPrefix is reserved for the entries of the storage itself, such as the format version.
+const metaPrefix = byte(0x00)
+
+// IndexType names an index and holds the byte every entry of the index starts with,
+// which keeps the entries of different index types apart. prefixes are below 0xff and not 0x00.
+type IndexType struct {
+	Name   string
+	Prefix byte
+}
+
+// Start is the first entry of the index type.
+func (indexType IndexType) Start() []byte {
+	return []byte{indexType.Prefix}
+}
+
+// Limit sorts after every entry of the index type.
+func (indexType IndexType) Limit() []byte {
+	return []byte{indexType.Prefix + 1}
+}
+
+// PrefixEnd returns the first key after every key with prefix, or nil if there is none.
+func PrefixEnd(prefix []byte) []byte {
+	limit := append([]byte{}, prefix...)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return limit[:i+1]
+		}
+	}
+	return nil
+}
+
 type Column interface {
-	GetValue() (string, error)
 	GetName() string
-	Parse(string) string
+	// Encode appends the encoded value of the column to index.
+	Encode(index []byte) ([]byte, error)
+	// Decode returns the value encoded at the start of index and the rest of index.
+	Decode(index []byte) (string, []byte, error)
 }
 
+// UnorderedColumn encodes its value as bytes terminated by 0x00. a 0x00 in the value is escaped as 0x00 0xff
+// so values hold any byte and sort as the raw values do.
+// values are not length prefixed: a length prefix sorts "b" before "a_", while scans and the merkle trees of the
+// epoch index walk keys in the order of the raw keys and bound their ranges with encoded raw keys.
+// the escaping makes the encoding as unambiguous as a length prefix, like the bytes of the FoundationDB tuple layer.
 type UnorderedColumn struct {
 	Name  string
 	Value string
@@ -24,14 +77,37 @@ func (uc UnorderedColumn) GetName() string {
 	return uc.Name
 }
 
-func (uc UnorderedColumn) GetValue() (string, error) {
-	return uc.Value, nil
+func (uc UnorderedColumn) Encode(index []byte) ([]byte, error) {
+	index = append(index, bytesCode)
+	for i := 0; i < len(uc.Value); i++ {
+		index = append(index, uc.Value[i])
+		if uc.Value[i] == 0x00 {
+			index = append(index, bytesEscape)
+		}
+	}
+	return append(index, 0x00), nil
 }
 
-func (uc UnorderedColumn) Parse(value string) string {
-	return value
+func (uc UnorderedColumn) Decode(index []byte) (string, []byte, error) {
+	if len(index) == 0 || index[0] != bytesCode {
+		return "", nil, fmt.Errorf("%w: column %s is not bytes", ErrInvalidIndex, uc.Name)
+	}
+	var value []byte
+	for i := 1; i < len(index); i++ {
+		if index[i] != 0x00 {
+			value = append(value, index[i])
+		} else if i+1 < len(index) && index[i+1] == bytesEscape {
+			value = append(value, 0x00)
+			i++
+		} else {
+			return string(value), index[i+1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w: column %s is not terminated", ErrInvalidIndex, uc.Name)
 }
 
+// OrderedColumn encodes its value, an integer of at most Length digits, as 8 big endian bytes with the sign bit flipped
+// so entries sort by the value.
 type OrderedColumn struct {
 	Name   string
 	Value  string
@@ -42,36 +118,39 @@ func CreateOrderedColumn(name, value string, length int) Column {
 	return OrderedColumn{Name: name, Value: value, Length: length}
 }
 
-func (uc OrderedColumn) GetName() string {
-	return uc.Name
+func (oc OrderedColumn) GetName() string {
+	return oc.Name
 }
 
-func (oc OrderedColumn) GetValue() (string, error) {
-	var valueStr string = oc.Value
-
-	if len(string(oc.Value)) > oc.Length || oc.Length-len(valueStr) < 0 {
-		return "", fmt.Errorf("index value invalid length %d", len(string(oc.Value)))
+func (oc OrderedColumn) Encode(index []byte) ([]byte, error) {
+	if len(oc.Value) > oc.Length {
+		return nil, fmt.Errorf("index value invalid length %d", len(oc.Value))
+	}
+	value, err := strconv.ParseInt(oc.Value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("index value is not an integer: %w", err)
 	}
-	return fmt.Sprintf("%s%s", strings.Repeat("0", oc.Length-len(valueStr)), valueStr), nil
+	index = append(index, intCode)
+	return binary.BigEndian.AppendUint64(index, uint64(value)^(1<<63)), nil
 }
 
-func (uc OrderedColumn) Parse(value string) string {
-	parsedValue := strings.TrimLeft(value, "0")
-	if parsedValue == "" { // if it is 0
-		parsedValue = "0"
+func (oc OrderedColumn) Decode(index []byte) (string, []byte, error) {
+	if len(index) < 9 || index[0] != intCode {
+		return "", nil, fmt.Errorf("%w: column %s is not an integer", ErrInvalidIndex, oc.Name)
 	}
-	return parsedValue
+	value := int64(binary.BigEndian.Uint64(index[1:9]) ^ (1 << 63))
+	return strconv.FormatInt(value, 10), index[9:], nil
 }
 
 type Index struct {
+	Type    IndexType
 	Columns []Column
 }
 
-func NewIndex(name string) *Index {
-	columns := make([]Column, 0)
-	columns = append(columns, CreateUnorderedColumn("name", name))
+func NewIndex(indexType IndexType) *Index {
 	return &Index{
-		Columns: columns,
+		Type:    indexType,
+		Columns: make([]Column, 0),
 	}
 }
 
@@ -80,31 +159,44 @@ func (idx *Index) AddColumn(column Column) *Index {
 	return idx
 }
 
+// Limit sorts after every index which extends index with more columns and before every other index.
+// a 0x00 inside an unordered value is followed by 0xff, so the range of a value holding it starts at 0xff.
+func Limit(index string) string {
+	return index + "\xff"
+}
+
+// Build encodes the prefix of the index type followed by every column.
+// an index built with the first columns of another sorts before it, so it is the start of a range over them.
 func (idx *Index) Build() (string, error) {
-	index := ""
-	for i, column := range idx.Columns {
-		value, err := column.GetValue()
+	index := []byte{idx.Type.Prefix}
+	for _, column := range idx.Columns {
+		var err error
+		index, err = column.Encode(index)
 		if err != nil {
 			return "", err
 		}
-		if i != 0 {
-			index += "_"
-		}
-		index += value
-
 	}
-	return index, nil
+	return string(index), nil
 }
 
+// Parse decodes index into the values of the columns by name. "name" holds the name of the index type.
 func (idx *Index) Parse(index string) (map[string]string, error) {
-	parts := strings.Split(index, "_")
-	if len(idx.Columns) != len(parts) {
-		return nil, fmt.Errorf("index columns #%d and parts #%d not equal", len(idx.Columns)+1, len(parts))
+	data := []byte(index)
+	if len(data) == 0 || data[0] != idx.Type.Prefix {
+		return nil, fmt.Errorf("%w: not a %s index", ErrInvalidIndex, idx.Type.Name)
+	}
+	data = data[1:]
+	m := map[string]string{"name": idx.Type.Name}
+	for _, column := range idx.Columns {
+		value, rest, err := column.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		m[column.GetName()] = value
+		data = rest
 	}
-	m := map[string]string{}
-	for i := len(idx.Columns) - 1; i >= 0; i-- {
-		column := idx.Columns[i]
-		m[column.GetName()] = column.Parse(parts[i])
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes after the columns of a %s index", ErrInvalidIndex, len(data), idx.Type.Name)
 	}
 	return m, nil
 }
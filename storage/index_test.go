@@ -1,40 +1,88 @@
 package storage
 
 import (
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+var testIndexType = IndexType{Name: "test", Prefix: 0x10}
+
 func TestIndexStorage(t *testing.T) {
-	index, err := NewIndex("test").
+	index, err := NewIndex(testIndexType).
 		AddColumn(CreateUnorderedColumn("partition", "part1")).
-		AddColumn(CreateUnorderedColumn("bucket", "bucket1")).
 		AddColumn(CreateOrderedColumn("epoch", "1", 4)).
-		AddColumn(CreateUnorderedColumn("key", "mykey")).
+		AddColumn(CreateUnorderedColumn("key", "my\x00key")).
 		Build()
 	if err != nil {
 		t.Error(err)
 	}
-	assert.EqualValues(t, "test_part1_bucket1_0001_mykey", index, "index should be equal")
+	assert.EqualValues(t, "\x10\x02part1\x00\x14\x80\x00\x00\x00\x00\x00\x00\x01\x02my\x00\xffkey\x00", index, "index should be equal")
 
-	index2 := NewIndex("zzz").
-		AddColumn(CreateUnorderedColumn("partition", "part1")).
+	index2 := NewIndex(testIndexType).
+		AddColumn(CreateUnorderedColumn("partition", "part_1")).
 		AddColumn(CreateUnorderedColumn("bucket", "bucket1")).
 		AddColumn(CreateOrderedColumn("epoch", "100", 4)).
-		AddColumn(CreateUnorderedColumn("key", "mykey"))
+		AddColumn(CreateUnorderedColumn("key", "my_key\x00"))
 	index2Str, err := index2.Build()
 	if err != nil {
 		t.Error(err)
 	}
-	assert.EqualValues(t, "zzz_part1_bucket1_0100_mykey", index2Str, "index2 should be equal")
 	index2Parse, err := index2.Parse(index2Str)
 	if err != nil {
 		t.Error(err)
 	}
-	assert.EqualValues(t, "zzz", index2Parse["name"], "should be equal")
-	assert.EqualValues(t, "part1", index2Parse["partition"], "should be equal")
+	assert.EqualValues(t, "test", index2Parse["name"], "should be equal")
+	assert.EqualValues(t, "part_1", index2Parse["partition"], "should be equal")
 	assert.EqualValues(t, "bucket1", index2Parse["bucket"], "should be equal")
 	assert.EqualValues(t, "100", index2Parse["epoch"], "should be equal")
-	assert.EqualValues(t, "mykey", index2Parse["key"], "should be equal")
+	assert.EqualValues(t, "my_key\x00", index2Parse["key"], "should be equal")
+
+	_, err = NewIndex(IndexType{Name: "other", Prefix: 0x11}).
+		AddColumn(CreateUnorderedColumn("partition", "")).Parse(index2Str)
+	assert.ErrorIs(t, err, ErrInvalidIndex, "other index types should not parse")
+	_, err = index2.Parse(index2Str[:len(index2Str)-1])
+	assert.ErrorIs(t, err, ErrInvalidIndex, "truncated index should not parse")
+	_, err = NewIndex(testIndexType).AddColumn(CreateOrderedColumn("epoch", "12345", 4)).Build()
+	assert.Error(t, err, "value longer than the column should not build")
+}
+
+func TestIndexOrder(t *testing.T) {
+	build := func(partition string, epoch string, key string) string {
+		index, err := NewIndex(testIndexType).
+			AddColumn(CreateUnorderedColumn("partition", partition)).
+			AddColumn(CreateOrderedColumn("epoch", epoch, 10)).
+			AddColumn(CreateUnorderedColumn("key", key)).
+			Build()
+		assert.NoError(t, err)
+		return index
+	}
+	// the order of the indexes is the order of their columns
+	ordered := []string{
+		build("a", "-1", "z"),
+		build("a", "2", ""),
+		build("a", "2", "\x00"),
+		build("a", "2", "\x00a"),
+		build("a", "2", "\x01"),
+		build("a", "2", "k"),
+		build("a", "2", "k_1"),
+		build("a", "10", "a"),
+		build("a\x00", "0", "a"),
+		build("a_", "0", "a"),
+		build("b", "0", "a"),
+	}
+	sorted := append([]string{}, ordered...)
+	sort.Strings(sorted)
+	assert.Equal(t, ordered, sorted, "indexes should sort by their columns")
+
+	// an index of the first columns starts the range of the indexes holding them
+	prefix, err := NewIndex(testIndexType).AddColumn(CreateUnorderedColumn("partition", "a")).Build()
+	assert.NoError(t, err)
+	limit := Limit(prefix)
+	for _, index := range ordered {
+		inRange := prefix <= index && index < limit
+		assert.Equal(t, index < build("a\x00", "0", "a"), inRange, "index %q range", index)
+	}
+	assert.Nil(t, PrefixEnd([]byte{0xff, 0xff}), "there is no key after every 0xff key")
 }
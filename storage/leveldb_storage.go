@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
@@ -11,7 +13,8 @@ import (
 )
 
 type LevelDbStorage struct {
-	db *leveldb.DB
+	db     *leveldb.DB
+	oracle *commitOracle
 }
 
 func NewLevelDbStorage(conf config.StorageConfig) LevelDbStorage {
@@ -19,13 +22,13 @@ func NewLevelDbStorage(conf config.StorageConfig) LevelDbStorage {
 	if err != nil {
 		logrus.Fatal(err)
 	}
-	return LevelDbStorage{db: db}
+	return LevelDbStorage{db: db, oracle: newCommitOracle()}
 }
 
 func (storage LevelDbStorage) Put(key []byte, value []byte) error {
-	writeOpts := &opt.WriteOptions{}
-	writeOpts.Sync = true
-	return storage.db.Put(key, value, writeOpts)
+	batch := new(leveldb.Batch)
+	batch.Put(key, value)
+	return storage.oracle.commit(map[string]struct{}{string(key): {}}, nil, 0, storage.writeBatch(batch))
 }
 
 func (storage LevelDbStorage) Get(key []byte) ([]byte, error) {
@@ -39,22 +42,58 @@ func (storage LevelDbStorage) Get(key []byte) ([]byte, error) {
 	return value, nil
 }
 
+func (storage LevelDbStorage) Delete(key []byte) error {
+	batch := new(leveldb.Batch)
+	batch.Delete(key)
+	return storage.oracle.commit(map[string]struct{}{string(key): {}}, nil, 0, storage.writeBatch(batch))
+}
+
 func (storage LevelDbStorage) NewIterator(Start []byte, Limit []byte, reverse bool) Iterator {
 	rng := &util.Range{Start: Start, Limit: Limit}
 	// Create an Iterator to iterate through the keys within the range
 	readOpts := &opt.ReadOptions{}
 	iter := storage.db.NewIterator(rng, readOpts)
 
-	return LevelDbIterator{it: iter, reverse: reverse}
+	// positioned like the other engines so callers may check IsDone before First
+	iterator := LevelDbIterator{it: iter, reverse: reverse}
+	iterator.First()
+	return iterator
+}
+
+func (storage LevelDbStorage) writeBatch(batch *leveldb.Batch) func() error {
+	return func() error {
+		return storage.db.Write(batch, &opt.WriteOptions{Sync: true})
+	}
+}
+
+func (storage LevelDbStorage) NativeExpiry() bool {
+	return false
 }
 
 func (storage LevelDbStorage) Close() error {
 	return storage.db.Close()
 }
 
+// NewTransaction returns a transaction reading a snapshot of the db. its writes are applied in one batch on Commit,
+// which fails with ErrConflict when a key it read was written after the snapshot.
 func (storage LevelDbStorage) NewTransaction(update bool) Transaction {
-	// TODO implement sync/map for transcations and test. create trx id. if another commits while touching key hash. error.
-	return LevelDbTransaction{storage: storage}
+	var snapshot *leveldb.Snapshot
+	startTs, err := storage.oracle.begin(func() (err error) {
+		snapshot, err = storage.db.GetSnapshot()
+		return err
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return &LevelDbTransaction{
+		storage:  storage,
+		snapshot: snapshot,
+		startTs:  startTs,
+		update:   update,
+		batch:    new(leveldb.Batch),
+		writes:   make(map[string]bufferedWrite),
+		reads:    make(map[string]struct{}),
+	}
 }
 
 type LevelDbIterator struct {
@@ -90,26 +129,94 @@ func (iterator LevelDbIterator) Value() []byte {
 	return iterator.it.Value()
 }
 
+func (iterator LevelDbIterator) Error() error {
+	return iterator.it.Error()
+}
+
 func (iterator LevelDbIterator) Release() {
 	iterator.it.Release()
 }
 
 type LevelDbTransaction struct {
-	storage LevelDbStorage
+	storage  LevelDbStorage
+	snapshot *leveldb.Snapshot
+	startTs  uint64
+	update   bool
+	batch    *leveldb.Batch
+	writes   map[string]bufferedWrite
+	reads    map[string]struct{}
+	done     bool
+}
+
+func (trx *LevelDbTransaction) Discard() {
+	if trx.done {
+		return
+	}
+	trx.done = true
+	trx.snapshot.Release()
+	trx.storage.oracle.end(trx.startTs)
 }
 
-func (LevelDbTransaction) Discard() {
-	return
+func (trx *LevelDbTransaction) Commit() error {
+	defer trx.Discard()
+	if trx.done {
+		return errTransactionDone
+	}
+	if len(trx.writes) == 0 {
+		return nil
+	}
+	keys := make(map[string]struct{}, len(trx.writes))
+	for key := range trx.writes {
+		keys[key] = struct{}{}
+	}
+	return trx.storage.oracle.commit(keys, trx.reads, trx.startTs, trx.storage.writeBatch(trx.batch))
 }
 
-func (LevelDbTransaction) Commit() error {
+func (trx *LevelDbTransaction) Set(key []byte, value []byte) error {
+	if trx.done {
+		return errTransactionDone
+	}
+	if !trx.update {
+		return errReadOnlyTransaction
+	}
+	trx.batch.Put(key, value)
+	trx.writes[string(key)] = bufferedWrite{value: append([]byte{}, value...)}
 	return nil
 }
 
-func (trx LevelDbTransaction) Set(key []byte, value []byte) error {
-	return trx.storage.Put(key, value)
+// SetWithExpiry ignores expiresAt, expired entries are removed by the manager.
+func (trx *LevelDbTransaction) SetWithExpiry(key []byte, value []byte, expiresAt time.Time) error {
+	return trx.Set(key, value)
 }
 
-func (trx LevelDbTransaction) Get(key []byte) (value []byte, err error) {
-	return trx.storage.Get(key)
+func (trx *LevelDbTransaction) Get(key []byte) (value []byte, err error) {
+	if trx.done {
+		return nil, errTransactionDone
+	}
+	if write, ok := trx.writes[string(key)]; ok {
+		if write.deleted {
+			return nil, KEY_NOT_FOUND
+		}
+		return append([]byte{}, write.value...), nil
+	}
+	if trx.update {
+		trx.reads[string(key)] = struct{}{}
+	}
+	value, err = trx.snapshot.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, KEY_NOT_FOUND
+	}
+	return value, err
+}
+
+func (trx *LevelDbTransaction) Delete(key []byte) error {
+	if trx.done {
+		return errTransactionDone
+	}
+	if !trx.update {
+		return errReadOnlyTransaction
+	}
+	trx.batch.Delete(key)
+	trx.writes[string(key)] = bufferedWrite{deleted: true}
+	return nil
 }
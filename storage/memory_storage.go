@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryPurgeInterval is the number of writes between removals of the expired entries.
+const memoryPurgeInterval = 1024
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (entry memoryEntry) expired(now time.Time) bool {
+	return !entry.expiresAt.IsZero() && !entry.expiresAt.After(now)
+}
+
+// memoryCommit holds the entries a commit replaced, a nil entry was absent.
+type memoryCommit struct {
+	ts    uint64
+	prior map[string]*memoryEntry
+}
+
+// memoryData holds the entries of a MemoryStorage and their keys in order.
+// the entries replaced by a commit are kept while a transaction which started before it is open,
+// so transactions read the entries of their start and detect conflicting commits.
+type memoryData struct {
+	lock     sync.RWMutex
+	keys     []string
+	entries  map[string]memoryEntry
+	writes   int
+	commitTs uint64
+	active   map[uint64]int
+	commits  []memoryCommit
+}
+
+func (data *memoryData) get(key []byte) ([]byte, error) {
+	data.lock.RLock()
+	defer data.lock.RUnlock()
+	entry, ok := data.entries[string(key)]
+	if !ok || entry.expired(time.Now()) {
+		return nil, KEY_NOT_FOUND
+	}
+	return append([]byte{}, entry.value...), nil
+}
+
+// begin returns the start ts of a transaction reading every commit up to it.
+func (data *memoryData) begin() uint64 {
+	data.lock.Lock()
+	defer data.lock.Unlock()
+	data.active[data.commitTs]++
+	return data.commitTs
+}
+
+func (data *memoryData) end(startTs uint64) {
+	data.lock.Lock()
+	defer data.lock.Unlock()
+	data.active[startTs]--
+	if data.active[startTs] == 0 {
+		delete(data.active, startTs)
+	}
+	oldest := data.commitTs
+	for ts := range data.active {
+		if ts < oldest {
+			oldest = ts
+		}
+	}
+	i := 0
+	for i < len(data.commits) && data.commits[i].ts <= oldest {
+		i++
+	}
+	data.commits = data.commits[i:]
+}
+
+// getAt reads key as it was at startTs, from the first later commit which replaced it.
+func (data *memoryData) getAt(key []byte, startTs uint64) ([]byte, error) {
+	data.lock.RLock()
+	defer data.lock.RUnlock()
+	entry, ok := data.entries[string(key)]
+	for _, committed := range data.commits {
+		if committed.ts <= startTs {
+			continue
+		}
+		if prior, replaced := committed.prior[string(key)]; replaced {
+			if prior == nil {
+				return nil, KEY_NOT_FOUND
+			}
+			entry, ok = *prior, true
+			break
+		}
+	}
+	if !ok || entry.expired(time.Now()) {
+		return nil, KEY_NOT_FOUND
+	}
+	return append([]byte{}, entry.value...), nil
+}
+
+// commit sets every entry of writes unless a key of reads was written by a commit after startTs. a nil entry deletes its key.
+func (data *memoryData) commit(writes map[string]*memoryEntry, reads map[string]struct{}, startTs uint64) error {
+	data.lock.Lock()
+	defer data.lock.Unlock()
+	for _, committed := range data.commits {
+		if committed.ts <= startTs {
+			continue
+		}
+		for key := range reads {
+			if _, ok := committed.prior[key]; ok {
+				return ErrConflict
+			}
+		}
+	}
+	var prior map[string]*memoryEntry
+	if len(data.active) > 0 {
+		prior = make(map[string]*memoryEntry, len(writes))
+	}
+	for key, entry := range writes {
+		i := sort.SearchStrings(data.keys, key)
+		existing, exists := data.entries[key]
+		if prior != nil {
+			if exists {
+				prior[key] = &existing
+			} else {
+				prior[key] = nil
+			}
+		}
+		if entry == nil {
+			if exists {
+				data.keys = append(data.keys[:i], data.keys[i+1:]...)
+				delete(data.entries, key)
+			}
+			continue
+		}
+		if !exists {
+			data.keys = append(data.keys, "")
+			copy(data.keys[i+1:], data.keys[i:])
+			data.keys[i] = key
+		}
+		data.entries[key] = *entry
+	}
+	data.commitTs++
+	if prior != nil {
+		data.commits = append(data.commits, memoryCommit{ts: data.commitTs, prior: prior})
+	}
+	data.writes++
+	if data.writes%memoryPurgeInterval == 0 {
+		data.purgeExpired(time.Now())
+	}
+	return nil
+}
+
+// purgeExpired removes the expired entries, reads already skip them. the caller holds the lock.
+func (data *memoryData) purgeExpired(now time.Time) {
+	live := data.keys[:0]
+	for _, key := range data.keys {
+		if data.entries[key].expired(now) {
+			delete(data.entries, key)
+		} else {
+			live = append(live, key)
+		}
+	}
+	data.keys = live
+}
+
+// MemoryStorage keeps every entry in memory, for tests and ephemeral caches. nothing is persisted.
+type MemoryStorage struct {
+	data *memoryData
+}
+
+func NewMemoryStorage() MemoryStorage {
+	return MemoryStorage{data: &memoryData{entries: make(map[string]memoryEntry), active: make(map[uint64]int)}}
+}
+
+func (storage MemoryStorage) Put(key []byte, value []byte) error {
+	return storage.data.commit(map[string]*memoryEntry{string(key): {value: append([]byte{}, value...)}}, nil, 0)
+}
+
+func (storage MemoryStorage) Get(key []byte) ([]byte, error) {
+	return storage.data.get(key)
+}
+
+func (storage MemoryStorage) Delete(key []byte) error {
+	return storage.data.commit(map[string]*memoryEntry{string(key): nil}, nil, 0)
+}
+
+// NewIterator copies the live entries from Start up to Limit so the iterator reads a snapshot. a nil Limit is unbounded.
+func (storage MemoryStorage) NewIterator(Start []byte, Limit []byte, reverse bool) Iterator {
+	storage.data.lock.RLock()
+	defer storage.data.lock.RUnlock()
+	now := time.Now()
+	var keys []string
+	var values [][]byte
+	for i := sort.SearchStrings(storage.data.keys, string(Start)); i < len(storage.data.keys); i++ {
+		key := storage.data.keys[i]
+		if Limit != nil && bytes.Compare([]byte(key), Limit) >= 0 {
+			break
+		}
+		entry := storage.data.entries[key]
+		if entry.expired(now) {
+			continue
+		}
+		keys = append(keys, key)
+		values = append(values, entry.value)
+	}
+	if reverse {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+	return &MemoryIterator{keys: keys, values: values}
+}
+
+// NewTransaction returns a transaction reading the entries of its start. its writes are applied at once on Commit,
+// which fails with ErrConflict when a key it read was written after the start.
+func (storage MemoryStorage) NewTransaction(update bool) Transaction {
+	return &MemoryTransaction{
+		data:    storage.data,
+		startTs: storage.data.begin(),
+		update:  update,
+		writes:  make(map[string]*memoryEntry),
+		reads:   make(map[string]struct{}),
+	}
+}
+
+// NativeExpiry is true, expired entries are hidden from reads and iterators.
+func (storage MemoryStorage) NativeExpiry() bool {
+	return true
+}
+
+func (storage MemoryStorage) Close() error {
+	return nil
+}
+
+type MemoryIterator struct {
+	keys   []string
+	values [][]byte
+	pos    int
+}
+
+func (iterator *MemoryIterator) First() bool {
+	iterator.pos = 0
+	return !iterator.IsDone()
+}
+
+func (iterator *MemoryIterator) Next() bool {
+	iterator.pos++
+	return !iterator.IsDone()
+}
+
+func (iterator *MemoryIterator) IsDone() bool {
+	return iterator.pos >= len(iterator.keys)
+}
+
+func (iterator *MemoryIterator) Key() []byte {
+	return []byte(iterator.keys[iterator.pos])
+}
+
+func (iterator *MemoryIterator) Value() []byte {
+	return append([]byte{}, iterator.values[iterator.pos]...)
+}
+
+func (iterator *MemoryIterator) Error() error {
+	return nil
+}
+
+func (iterator *MemoryIterator) Release() {
+	iterator.keys = nil
+	iterator.values = nil
+}
+
+// MemoryTransaction buffers its writes and applies them at once on Commit. reads see the buffered writes.
+type MemoryTransaction struct {
+	data    *memoryData
+	startTs uint64
+	update  bool
+	writes  map[string]*memoryEntry
+	reads   map[string]struct{}
+	done    bool
+}
+
+func (transaction *MemoryTransaction) Discard() {
+	if transaction.done {
+		return
+	}
+	transaction.done = true
+	transaction.data.end(transaction.startTs)
+}
+
+func (transaction *MemoryTransaction) Commit() error {
+	defer transaction.Discard()
+	if transaction.done {
+		return errTransactionDone
+	}
+	if len(transaction.writes) == 0 {
+		return nil
+	}
+	return transaction.data.commit(transaction.writes, transaction.reads, transaction.startTs)
+}
+
+func (transaction *MemoryTransaction) Set(key []byte, value []byte) error {
+	return transaction.SetWithExpiry(key, value, time.Time{})
+}
+
+func (transaction *MemoryTransaction) SetWithExpiry(key []byte, value []byte, expiresAt time.Time) error {
+	if transaction.done {
+		return errTransactionDone
+	}
+	if !transaction.update {
+		return errReadOnlyTransaction
+	}
+	transaction.writes[string(key)] = &memoryEntry{value: append([]byte{}, value...), expiresAt: expiresAt}
+	return nil
+}
+
+func (transaction *MemoryTransaction) Get(key []byte) ([]byte, error) {
+	if transaction.done {
+		return nil, errTransactionDone
+	}
+	if entry, ok := transaction.writes[string(key)]; ok {
+		if entry == nil || entry.expired(time.Now()) {
+			return nil, KEY_NOT_FOUND
+		}
+		return append([]byte{}, entry.value...), nil
+	}
+	if transaction.update {
+		transaction.reads[string(key)] = struct{}{}
+	}
+	return transaction.data.getAt(key, transaction.startTs)
+}
+
+func (transaction *MemoryTransaction) Delete(key []byte) error {
+	if transaction.done {
+		return errTransactionDone
+	}
+	if !transaction.update {
+		return errReadOnlyTransaction
+	}
+	transaction.writes[string(key)] = nil
+	return nil
+}
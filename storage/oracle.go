@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"sync"
+)
+
+// bufferedWrite is the latest write of a key in a transaction so Get reads the writes of the transaction.
+type bufferedWrite struct {
+	value   []byte
+	deleted bool
+}
+
+type oracleCommit struct {
+	ts   uint64
+	keys map[string]struct{}
+}
+
+// commitOracle orders the commits of an engine without transactions of its own and detects conflicts between its transactions.
+// the writes of a commit are kept while a transaction which started before it is open.
+type commitOracle struct {
+	lock     sync.Mutex
+	commitTs uint64
+	active   map[uint64]int
+	commits  []oracleCommit
+}
+
+func newCommitOracle() *commitOracle {
+	return &commitOracle{active: make(map[uint64]int)}
+}
+
+// begin calls snapshot, which takes a snapshot holding every commit up to the returned start ts.
+func (oracle *commitOracle) begin(snapshot func() error) (uint64, error) {
+	oracle.lock.Lock()
+	defer oracle.lock.Unlock()
+	err := snapshot()
+	if err != nil {
+		return 0, err
+	}
+	oracle.active[oracle.commitTs]++
+	return oracle.commitTs, nil
+}
+
+func (oracle *commitOracle) end(startTs uint64) {
+	oracle.lock.Lock()
+	defer oracle.lock.Unlock()
+	oracle.active[startTs]--
+	if oracle.active[startTs] == 0 {
+		delete(oracle.active, startTs)
+	}
+	oracle.prune()
+}
+
+// prune drops the commits no open transaction can conflict with. the caller holds the lock.
+func (oracle *commitOracle) prune() {
+	oldest := oracle.commitTs
+	for startTs := range oracle.active {
+		if startTs < oldest {
+			oldest = startTs
+		}
+	}
+	i := 0
+	for i < len(oracle.commits) && oracle.commits[i].ts <= oldest {
+		i++
+	}
+	oracle.commits = oracle.commits[i:]
+}
+
+// commit calls write, which writes keys, unless a key of reads was written by a commit after startTs.
+func (oracle *commitOracle) commit(keys, reads map[string]struct{}, startTs uint64, write func() error) error {
+	oracle.lock.Lock()
+	defer oracle.lock.Unlock()
+	for _, committed := range oracle.commits {
+		if committed.ts <= startTs {
+			continue
+		}
+		for key := range reads {
+			if _, ok := committed.keys[key]; ok {
+				return ErrConflict
+			}
+		}
+	}
+	err := write()
+	if err != nil {
+		return err
+	}
+	oracle.commitTs++
+	if len(oracle.active) > 0 {
+		oracle.commits = append(oracle.commits, oracleCommit{ts: oracle.commitTs, keys: keys})
+	}
+	return nil
+}
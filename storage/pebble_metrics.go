@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"github.com/cockroachdb/pebble"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registerer is the registry served on /metrics.
+var registerer = prometheus.DefaultRegisterer
+
+var (
+	pebbleCompactionsDesc = prometheus.NewDesc(
+		"pebble_compactions",
+		"the number of compactions",
+		nil, nil,
+	)
+	pebbleCompactionDebtDesc = prometheus.NewDesc(
+		"pebble_compaction_debt_bytes",
+		"the estimated number of bytes to compact for the lsm to reach a stable state",
+		nil, nil,
+	)
+	pebbleCompactionsInProgressDesc = prometheus.NewDesc(
+		"pebble_compactions_in_progress",
+		"the number of compactions in progress",
+		nil, nil,
+	)
+	pebbleCompactionSecondsDesc = prometheus.NewDesc(
+		"pebble_compaction_seconds",
+		"the cumulative duration of the compactions",
+		nil, nil,
+	)
+	pebbleFlushesDesc = prometheus.NewDesc(
+		"pebble_flushes",
+		"the number of memtable flushes",
+		nil, nil,
+	)
+	pebbleReadAmpDesc = prometheus.NewDesc(
+		"pebble_read_amplification",
+		"the number of sublevels and files a read may have to check",
+		nil, nil,
+	)
+	pebbleCacheSizeDesc = prometheus.NewDesc(
+		"pebble_cache_size_bytes",
+		"the bytes in use by a cache",
+		[]string{"cache"}, nil,
+	)
+	pebbleCacheCountDesc = prometheus.NewDesc(
+		"pebble_cache_entries",
+		"the number of blocks or tables in a cache",
+		[]string{"cache"}, nil,
+	)
+	pebbleCacheHitsDesc = prometheus.NewDesc(
+		"pebble_cache_hits",
+		"the number of cache hits",
+		[]string{"cache"}, nil,
+	)
+	pebbleCacheMissesDesc = prometheus.NewDesc(
+		"pebble_cache_misses",
+		"the number of cache misses",
+		[]string{"cache"}, nil,
+	)
+)
+
+// pebbleCollector reads the metrics of a pebble db on every scrape.
+type pebbleCollector struct {
+	db *pebble.DB
+}
+
+func (collector *pebbleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pebbleCompactionsDesc
+	ch <- pebbleCompactionDebtDesc
+	ch <- pebbleCompactionsInProgressDesc
+	ch <- pebbleCompactionSecondsDesc
+	ch <- pebbleFlushesDesc
+	ch <- pebbleReadAmpDesc
+	ch <- pebbleCacheSizeDesc
+	ch <- pebbleCacheCountDesc
+	ch <- pebbleCacheHitsDesc
+	ch <- pebbleCacheMissesDesc
+}
+
+func (collector *pebbleCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := collector.db.Metrics()
+	ch <- prometheus.MustNewConstMetric(pebbleCompactionsDesc, prometheus.CounterValue, float64(metrics.Compact.Count))
+	ch <- prometheus.MustNewConstMetric(pebbleCompactionDebtDesc, prometheus.GaugeValue, float64(metrics.Compact.EstimatedDebt))
+	ch <- prometheus.MustNewConstMetric(pebbleCompactionsInProgressDesc, prometheus.GaugeValue, float64(metrics.Compact.NumInProgress))
+	ch <- prometheus.MustNewConstMetric(pebbleCompactionSecondsDesc, prometheus.CounterValue, metrics.Compact.Duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(pebbleFlushesDesc, prometheus.CounterValue, float64(metrics.Flush.Count))
+	ch <- prometheus.MustNewConstMetric(pebbleReadAmpDesc, prometheus.GaugeValue, float64(metrics.ReadAmp()))
+	for cache, cacheMetrics := range map[string]pebble.CacheMetrics{"block": metrics.BlockCache, "table": metrics.TableCache} {
+		ch <- prometheus.MustNewConstMetric(pebbleCacheSizeDesc, prometheus.GaugeValue, float64(cacheMetrics.Size), cache)
+		ch <- prometheus.MustNewConstMetric(pebbleCacheCountDesc, prometheus.GaugeValue, float64(cacheMetrics.Count), cache)
+		ch <- prometheus.MustNewConstMetric(pebbleCacheHitsDesc, prometheus.CounterValue, float64(cacheMetrics.Hits), cache)
+		ch <- prometheus.MustNewConstMetric(pebbleCacheMissesDesc, prometheus.CounterValue, float64(cacheMetrics.Misses), cache)
+	}
+}
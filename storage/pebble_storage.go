@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/sirupsen/logrus"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+type PebbleStorage struct {
+	db        *pebble.DB
+	oracle    *commitOracle
+	collector *pebbleCollector
+}
+
+func NewPebbleStorage(conf config.StorageConfig) PebbleStorage {
+	db, err := pebble.Open(conf.DataPath, &pebble.Options{Logger: logrus.StandardLogger()})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	collector := &pebbleCollector{db: db}
+	err = registerer.Register(collector)
+	if err != nil {
+		logrus.Warnf("pebble metrics are not registered: %v", err)
+		collector = nil
+	}
+	return PebbleStorage{db: db, oracle: newCommitOracle(), collector: collector}
+}
+
+func (storage PebbleStorage) Put(key []byte, value []byte) error {
+	return storage.oracle.commit(map[string]struct{}{string(key): {}}, nil, 0, func() error {
+		return storage.db.Set(key, value, pebble.Sync)
+	})
+}
+
+func (storage PebbleStorage) Get(key []byte) ([]byte, error) {
+	value, closer, err := storage.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, KEY_NOT_FOUND
+	} else if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte{}, value...), nil
+}
+
+func (storage PebbleStorage) Delete(key []byte) error {
+	return storage.oracle.commit(map[string]struct{}{string(key): {}}, nil, 0, func() error {
+		return storage.db.Delete(key, pebble.Sync)
+	})
+}
+
+func (storage PebbleStorage) NewIterator(Start []byte, Limit []byte, reverse bool) Iterator {
+	it, err := storage.db.NewIter(&pebble.IterOptions{LowerBound: Start, UpperBound: Limit})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	iterator := PebbleIterator{it: it, reverse: reverse}
+	iterator.First()
+	return iterator
+}
+
+// NewTransaction returns a transaction reading a snapshot of the db. its writes are applied in one batch on Commit,
+// which fails with ErrConflict when a key it read was written after the snapshot.
+func (storage PebbleStorage) NewTransaction(update bool) Transaction {
+	var snapshot *pebble.Snapshot
+	startTs, _ := storage.oracle.begin(func() error {
+		snapshot = storage.db.NewSnapshot()
+		return nil
+	})
+	return &PebbleTransaction{
+		storage:  storage,
+		snapshot: snapshot,
+		startTs:  startTs,
+		update:   update,
+		batch:    storage.db.NewBatch(),
+		writes:   make(map[string]bufferedWrite),
+		reads:    make(map[string]struct{}),
+	}
+}
+
+// NativeExpiry is false, pebble has no ttl so expired entries are removed by the manager.
+func (storage PebbleStorage) NativeExpiry() bool {
+	return false
+}
+
+func (storage PebbleStorage) Close() error {
+	if storage.collector != nil {
+		registerer.Unregister(storage.collector)
+	}
+	return storage.db.Close()
+}
+
+type PebbleIterator struct {
+	it      *pebble.Iterator
+	reverse bool
+}
+
+func (iterator PebbleIterator) First() bool {
+	if iterator.reverse {
+		return iterator.it.Last()
+	}
+	return iterator.it.First()
+}
+
+func (iterator PebbleIterator) Next() bool {
+	if iterator.reverse {
+		return iterator.it.Prev()
+	}
+	return iterator.it.Next()
+}
+
+func (iterator PebbleIterator) IsDone() bool {
+	return !iterator.it.Valid()
+}
+
+// Key copies the key, pebble reuses its buffer when the iterator moves.
+func (iterator PebbleIterator) Key() []byte {
+	return append([]byte{}, iterator.it.Key()...)
+}
+
+func (iterator PebbleIterator) Value() []byte {
+	return append([]byte{}, iterator.it.Value()...)
+}
+
+func (iterator PebbleIterator) Error() error {
+	return iterator.it.Error()
+}
+
+func (iterator PebbleIterator) Release() {
+	err := iterator.it.Close()
+	if err != nil {
+		logrus.Errorf("PebbleIterator Close err = %v", err)
+	}
+}
+
+type PebbleTransaction struct {
+	storage  PebbleStorage
+	snapshot *pebble.Snapshot
+	startTs  uint64
+	update   bool
+	batch    *pebble.Batch
+	writes   map[string]bufferedWrite
+	reads    map[string]struct{}
+	done     bool
+}
+
+func (transaction *PebbleTransaction) Discard() {
+	if transaction.done {
+		return
+	}
+	transaction.done = true
+	transaction.batch.Close()
+	err := transaction.snapshot.Close()
+	if err != nil {
+		logrus.Errorf("PebbleTransaction snapshot Close err = %v", err)
+	}
+	transaction.storage.oracle.end(transaction.startTs)
+}
+
+func (transaction *PebbleTransaction) Commit() error {
+	defer transaction.Discard()
+	if transaction.done {
+		return errTransactionDone
+	}
+	if len(transaction.writes) == 0 {
+		return nil
+	}
+	keys := make(map[string]struct{}, len(transaction.writes))
+	for key := range transaction.writes {
+		keys[key] = struct{}{}
+	}
+	return transaction.storage.oracle.commit(keys, transaction.reads, transaction.startTs, func() error {
+		return transaction.batch.Commit(pebble.Sync)
+	})
+}
+
+func (transaction *PebbleTransaction) Set(key []byte, value []byte) error {
+	if transaction.done {
+		return errTransactionDone
+	}
+	if !transaction.update {
+		return errReadOnlyTransaction
+	}
+	transaction.writes[string(key)] = bufferedWrite{value: append([]byte{}, value...)}
+	return transaction.batch.Set(key, value, nil)
+}
+
+// SetWithExpiry ignores expiresAt, expired entries are removed by the manager.
+func (transaction *PebbleTransaction) SetWithExpiry(key []byte, value []byte, expiresAt time.Time) error {
+	return transaction.Set(key, value)
+}
+
+func (transaction *PebbleTransaction) Get(key []byte) ([]byte, error) {
+	if transaction.done {
+		return nil, errTransactionDone
+	}
+	if write, ok := transaction.writes[string(key)]; ok {
+		if write.deleted {
+			return nil, KEY_NOT_FOUND
+		}
+		return append([]byte{}, write.value...), nil
+	}
+	if transaction.update {
+		transaction.reads[string(key)] = struct{}{}
+	}
+	value, closer, err := transaction.snapshot.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, KEY_NOT_FOUND
+	} else if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte{}, value...), nil
+}
+
+func (transaction *PebbleTransaction) Delete(key []byte) error {
+	if transaction.done {
+		return errTransactionDone
+	}
+	if !transaction.update {
+		return errReadOnlyTransaction
+	}
+	transaction.writes[string(key)] = bufferedWrite{deleted: true}
+	return transaction.batch.Delete(key, nil)
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrew-delph/my-key-store/config"
+)
+
+func TestPebbleShutdown(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+
+	storage := NewPebbleStorage(c.Storage)
+	key := []byte("testkey")
+	value := []byte("testvalue")
+
+	trx := storage.NewTransaction(true)
+	err := trx.Set(key, value)
+	assert.Nil(t, err)
+	err = trx.Commit()
+	assert.Nil(t, err)
+	trx.Discard()
+	err = storage.Close()
+	assert.Nil(t, err)
+
+	storage = NewPebbleStorage(c.Storage)
+	defer storage.Close()
+	res, err := storage.Get(key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, value, res, "value should be equal")
+}
+
+func TestPebbleMetrics(t *testing.T) {
+	c := config.GetConfig()
+	c.Storage.DataPath = t.TempDir()
+
+	storage := NewPebbleStorage(c.Storage)
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.Nil(t, err)
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	assert.True(t, names["pebble_compactions"], "compaction metrics should be registered")
+	assert.True(t, names["pebble_cache_hits"], "cache metrics should be registered")
+
+	err = storage.Close()
+	assert.Nil(t, err)
+	families, err = prometheus.DefaultGatherer.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		assert.NotEqual(t, "pebble_compactions", family.GetName(), "metrics should be unregistered on Close")
+	}
+}
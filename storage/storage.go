@@ -2,18 +2,81 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/patrickmn/go-cache"
+
+	"github.com/andrew-delph/my-key-store/config"
 )
 
 var KEY_NOT_FOUND = errors.New("KEY_NOT_FOUND")
 
+// ErrConflict is returned by Commit when a key the transaction read was written by a transaction committed after it started.
+var ErrConflict = errors.New("transaction conflict")
+
+// ErrTxnTooBig is returned by Set and Delete when the transaction cannot hold more writes.
+// the writes before it are kept, the caller commits them and continues in a new transaction.
+var ErrTxnTooBig = errors.New("transaction too big")
+
+var errTransactionDone = errors.New("transaction already committed or discarded")
+
+var errReadOnlyTransaction = errors.New("transaction is read only")
+
+// Engines lists the storage engines which can be selected with StorageConfig.Engine.
+var Engines = []string{"badger", "leveldb", "pebble", "memory"}
+
+// NewStorage opens the engine selected by conf. an empty engine is badger.
+// the engine is wrapped in an EncryptedStorage when encryption keys are configured.
+func NewStorage(conf config.StorageConfig) (Storage, error) {
+	db, err := newEngine(conf)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := LoadEncryptionKeys(conf)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(keys) == 0 {
+		err = checkNotEncrypted(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	}
+	encrypted, err := NewEncryptedStorage(db, keys)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+func newEngine(conf config.StorageConfig) (Storage, error) {
+	switch conf.Engine {
+	case "", "badger":
+		return NewBadgerStorage(conf), nil
+	case "leveldb":
+		return NewLevelDbStorage(conf), nil
+	case "pebble":
+		return NewPebbleStorage(conf), nil
+	case "memory":
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q, expected one of %v", conf.Engine, Engines)
+	}
+}
+
 type Storage interface {
 	Put(key []byte, value []byte) error
 	Get(key []byte) ([]byte, error)
+	Delete(key []byte) error
 	NewIterator(Start []byte, Limit []byte, reverse bool) Iterator
 	NewTransaction(update bool) Transaction
+	// NativeExpiry reports if entries written with SetWithExpiry are removed by the engine itself.
+	NativeExpiry() bool
 	Close() error
 }
 
@@ -23,6 +86,8 @@ type Iterator interface {
 	IsDone() bool
 	Key() []byte
 	Value() []byte
+	// Error returns why Value could not read the entry the iterator is at. callers skip the entry when it is not nil.
+	Error() error
 	Release()
 }
 
@@ -30,7 +95,10 @@ type Transaction interface {
 	Discard()
 	Commit() error
 	Set(key []byte, value []byte) error
+	// SetWithExpiry sets an entry which engines with native expiry remove after expiresAt.
+	SetWithExpiry(key []byte, value []byte, expiresAt time.Time) error
 	Get(key []byte) (value []byte, err error)
+	Delete(key []byte) error
 }
 
 func cacheTest() {
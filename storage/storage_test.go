@@ -14,39 +14,124 @@ import (
 
 type StorageCallback func(t *testing.T, storage Storage)
 
+// AllStorage runs storageCallback against every engine, the suite every engine must pass.
 func AllStorage(t *testing.T, storageCallback StorageCallback) {
-	var storage Storage
-	var err error
-	var startTime time.Time
-	var endTime time.Time
-	var elapsedTime time.Duration
-	c := config.GetConfig()
+	for _, engine := range Engines {
+		c := config.GetConfig()
+		c.Storage.DataPath = t.TempDir()
+		c.Storage.Engine = engine
 
+		storage, err := NewStorage(c.Storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		startTime := time.Now()
+		storageCallback(t, storage)
+		elapsedTime := time.Since(startTime)
+		err = storage.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logrus.Warnf("%s: [%s] elapsed: %v", t.Name(), engine, elapsedTime)
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	c := config.GetConfig()
 	c.Storage.DataPath = t.TempDir()
+	c.Storage.Engine = "memory"
+	storage, err := NewStorage(c.Storage)
+	assert.NoError(t, err)
+	assert.IsType(t, MemoryStorage{}, storage, "engine wrong type")
 
-	storage = NewLevelDbStorage(c.Storage)
-	startTime = time.Now()
-	storageCallback(t, storage)
-	endTime = time.Now()
-	elapsedTime = endTime.Sub(startTime)
-	err = storage.Close()
-	if err != nil {
-		t.Fatal(err)
+	c.Storage.Engine = "unknown"
+	_, err = NewStorage(c.Storage)
+	assert.Error(t, err, "unknown engine should error")
+}
+
+func TestStorageTransactionIsolation(t *testing.T) {
+	AllStorage(t, storageTransactionIsolation)
+}
+
+func storageTransactionIsolation(t *testing.T, storage Storage) {
+	key := []byte("testkey")
+	value := []byte("testvalue")
+
+	trx := storage.NewTransaction(true)
+	defer trx.Discard()
+	err := trx.Set(key, value)
+	assert.Nil(t, err)
+	res, err := trx.Get(key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, value, res, "transaction should read its own write")
+	trx.Discard()
+
+	_, err = storage.Get(key)
+	assert.EqualValues(t, KEY_NOT_FOUND, err, "discarded write should not be visible")
+
+	trx = storage.NewTransaction(true)
+	defer trx.Discard()
+	_, err = trx.Get(key)
+	assert.EqualValues(t, KEY_NOT_FOUND, err, "transaction should report missing keys as KEY_NOT_FOUND")
+}
+
+// detectsConflicts reports if the transactions of storage read a snapshot and fail on conflicting commits.
+func detectsConflicts(storage Storage) bool {
+	switch storage.(type) {
+	case BadgerStorage, LevelDbStorage, MemoryStorage, PebbleStorage:
+		return true
 	}
-	logrus.Warnf("%s: [leveldb] elapsed: %v", t.Name(), elapsedTime)
+	return false
+}
 
-	c.Storage.DataPath = t.TempDir()
+func TestStorageConflict(t *testing.T) {
+	AllStorage(t, storageConflict)
+}
 
-	storage = NewBadgerStorage(c.Storage)
-	startTime = time.Now()
-	storageCallback(t, storage)
-	endTime = time.Now()
-	elapsedTime = endTime.Sub(startTime)
-	logrus.Warnf("%s: [badger] elapsed: %v", t.Name(), elapsedTime)
-	err = storage.Close()
-	if err != nil {
-		t.Fatal(err)
+func storageConflict(t *testing.T, storage Storage) {
+	if !detectsConflicts(storage) {
+		return
 	}
+	key := []byte("testkey")
+	err := storage.Put(key, []byte("v1"))
+	assert.Nil(t, err)
+
+	first := storage.NewTransaction(true)
+	defer first.Discard()
+	second := storage.NewTransaction(true)
+	defer second.Discard()
+	res, err := first.Get(key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "v1", res, "first read wrong value")
+	_, err = second.Get(key)
+	assert.Nil(t, err)
+	assert.Nil(t, first.Set(key, []byte("v2")))
+	assert.Nil(t, second.Set(key, []byte("v3")))
+	assert.Nil(t, first.Commit(), "first commit should succeed")
+	assert.EqualValues(t, ErrConflict, second.Commit(), "commit after a conflicting write should fail")
+	res, err = storage.Get(key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "v2", res, "conflicting write should not be applied")
+
+	// a transaction reads the snapshot it started with
+	reader := storage.NewTransaction(false)
+	defer reader.Discard()
+	err = storage.Put(key, []byte("v4"))
+	assert.Nil(t, err)
+	res, err = reader.Get(key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "v2", res, "snapshot read wrong value")
+
+	// writes to keys which were not read do not conflict
+	other := []byte("otherkey")
+	first = storage.NewTransaction(true)
+	defer first.Discard()
+	second = storage.NewTransaction(true)
+	defer second.Discard()
+	assert.Nil(t, first.Set(other, []byte("a")))
+	assert.Nil(t, second.Set(other, []byte("b")))
+	assert.Nil(t, first.Commit(), "blind write should succeed")
+	assert.Nil(t, second.Commit(), "blind write should succeed")
 }
 
 func TestStorageSetGet(t *testing.T) {
@@ -99,12 +184,90 @@ func storageTransaction(t *testing.T, storage Storage) {
 	assert.EqualValues(t, value, res, "value should be equal")
 }
 
+func TestStorageDelete(t *testing.T) {
+	AllStorage(t, storageDelete)
+}
+
+func storageDelete(t *testing.T, storage Storage) {
+	key := []byte("testkey")
+	value := []byte("testvalue")
+
+	err := storage.Put(key, value)
+	if err != nil {
+		t.Error(err)
+	}
+	err = storage.Delete(key)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = storage.Get(key)
+	assert.EqualValues(t, KEY_NOT_FOUND, err, "should error KEY_NOT_FOUND after Delete")
+
+	err = storage.Put(key, value)
+	if err != nil {
+		t.Error(err)
+	}
+	trx := storage.NewTransaction(true)
+	defer trx.Discard()
+	err = trx.Delete(key)
+	if err != nil {
+		t.Error(err)
+	}
+	err = trx.Commit()
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = storage.Get(key)
+	assert.EqualValues(t, KEY_NOT_FOUND, err, "should error KEY_NOT_FOUND after transaction Delete")
+
+	err = storage.Delete([]byte("not found"))
+	assert.Nil(t, err, "deleting a missing key should not error")
+}
+
+func TestStorageSetWithExpiry(t *testing.T) {
+	AllStorage(t, storageSetWithExpiry)
+}
+
+func storageSetWithExpiry(t *testing.T, storage Storage) {
+	expired := []byte("expired")
+	live := []byte("live")
+	value := []byte("testvalue")
+
+	trx := storage.NewTransaction(true)
+	defer trx.Discard()
+	err := trx.SetWithExpiry(expired, value, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Error(err)
+	}
+	err = trx.SetWithExpiry(live, value, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+	err = trx.Commit()
+	if err != nil {
+		t.Error(err)
+	}
+
+	res, err := storage.Get(live)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.EqualValues(t, value, res, "live value should be equal")
+
+	_, err = storage.Get(expired)
+	if storage.NativeExpiry() {
+		assert.EqualValues(t, KEY_NOT_FOUND, err, "native expiry should remove the expired value")
+	} else {
+		assert.Nil(t, err, "expired value should be kept until it is swept")
+	}
+}
+
 func TestStorageIterator(t *testing.T) {
 	AllStorage(t, storageIterator)
 }
 
 func testIndex(i int) string {
-	index, _ := NewIndex("test").
+	index, _ := NewIndex(testIndexType).
 		AddColumn(CreateUnorderedColumn("partition", "1")).
 		AddColumn(CreateOrderedColumn("key", fmt.Sprint(i), 4)).
 		Build()
@@ -172,6 +335,18 @@ func storageIterator(t *testing.T, storage Storage) {
 	}
 	it.Release()
 	assert.EqualValues(t, endRange-startRange, count, "Should have iterated the range")
+
+	// a reverse range starts before Limit and ends at Start
+	it = storage.NewIterator([]byte(testIndex(startRange)), []byte(testIndex(endRange)), true)
+	assert.EqualValues(t, true, it.First(), "it.First() should be true")
+	count = 0
+	for !it.IsDone() {
+		assert.EqualValues(t, fmt.Sprintf("%d", endRange-count-1), string(it.Value()), "value should be ith")
+		it.Next()
+		count++
+	}
+	it.Release()
+	assert.EqualValues(t, endRange-startRange, count, "Should have iterated the range in reverse")
 }
 
 func TestStorageBenchmark(t *testing.T) {
@@ -208,3 +383,28 @@ func storageBenchmark(t *testing.T, storage Storage) {
 	}
 	wg.Wait()
 }
+
+func TestFormatVersion(t *testing.T) {
+	AllStorage(t, func(t *testing.T, storage Storage) {
+		version, err := ReadFormatVersion(storage)
+		assert.NoError(t, err)
+		assert.Equal(t, FormatVersion, version, "empty storage should have the current format")
+		assert.NoError(t, CheckFormatVersion(storage))
+		_, err = storage.Get(formatVersionKey)
+		assert.NoError(t, err, "format version should be stored")
+
+		err = WriteFormatVersion(storage, FormatVersion+1)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, CheckFormatVersion(storage), ErrFormatVersion, "newer format should not be read")
+
+		// data written before the format version was stored
+		err = storage.Delete(formatVersionKey)
+		assert.NoError(t, err)
+		err = storage.Put([]byte("item_key"), []byte("value"))
+		assert.NoError(t, err)
+		version, err = ReadFormatVersion(storage)
+		assert.NoError(t, err)
+		assert.Equal(t, LegacyFormatVersion, version, "unversioned data should have the legacy format")
+		assert.ErrorIs(t, CheckFormatVersion(storage), ErrFormatVersion, "legacy format should not be read")
+	})
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec compresses values. its byte value is stored in front of compressed records so they decode with their codec.
+type Codec byte
+
+// codec bytes are part of the storage format, they must not be changed or reused.
+// they are below 0x08, which no marshalled proto message starts with.
+const (
+	CodecNone   Codec = 0x01
+	CodecSnappy Codec = 0x02
+	CodecZstd   Codec = 0x03
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// ParseCodec returns the codec named name. an empty name is CodecNone.
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "", "none":
+		return CodecNone, nil
+	case "snappy":
+		return CodecSnappy, nil
+	case "zstd":
+		return CodecZstd, nil
+	}
+	return 0, fmt.Errorf("unknown codec %q", name)
+}
+
+func (codec Codec) String() string {
+	switch codec {
+	case CodecNone:
+		return "none"
+	case CodecSnappy:
+		return "snappy"
+	case CodecZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("codec(%d)", byte(codec))
+}
+
+func (codec Codec) Compress(data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecSnappy:
+		return snappy.Encode(nil, data), nil
+	case CodecZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("unknown codec %d", byte(codec))
+}
+
+func (codec Codec) Decompress(data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecSnappy:
+		return snappy.Decode(nil, data)
+	case CodecZstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	return nil, fmt.Errorf("unknown codec %d", byte(codec))
+}
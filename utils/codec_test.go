@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"city":"paris","name":"user"}`), 100)
+	for _, name := range []string{"none", "snappy", "zstd"} {
+		codec, err := ParseCodec(name)
+		assert.NoError(t, err)
+		assert.Equal(t, name, codec.String(), "name wrong value")
+		compressed, err := codec.Compress(data)
+		assert.NoError(t, err)
+		if codec != CodecNone {
+			assert.Less(t, len(compressed), len(data), "%s should compress", name)
+		}
+		decompressed, err := codec.Decompress(compressed)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decompressed, "%s round trip wrong value", name)
+	}
+
+	codec, err := ParseCodec("")
+	assert.NoError(t, err)
+	assert.Equal(t, CodecNone, codec, "empty codec should be none")
+	_, err = ParseCodec("gzip")
+	assert.Error(t, err, "unknown codec should be rejected")
+	_, err = Codec(0).Decompress(data)
+	assert.Error(t, err, "unknown codec should not decompress")
+}
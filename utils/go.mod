@@ -3,6 +3,8 @@ module utils
 go 1.20
 
 require (
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.15.15
 	github.com/sirupsen/logrus v1.9.3
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9
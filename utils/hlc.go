@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HybridTimestamp is a hybrid logical clock reading.
+// Physical is wall clock milliseconds, Logical orders events within the same millisecond
+// and Node breaks ties between events of different nodes.
+type HybridTimestamp struct {
+	Physical int64
+	Logical  int32
+	Node     string
+}
+
+// Compare returns -1, 0 or 1 if ts is before, equal to or after other.
+func (ts HybridTimestamp) Compare(other HybridTimestamp) int {
+	switch {
+	case ts.Physical != other.Physical:
+		return compareOrdered(ts.Physical, other.Physical)
+	case ts.Logical != other.Logical:
+		return compareOrdered(ts.Logical, other.Logical)
+	default:
+		return strings.Compare(ts.Node, other.Node)
+	}
+}
+
+// UnixTimestamp returns the physical part of ts in seconds.
+func (ts HybridTimestamp) UnixTimestamp() int64 {
+	return ts.Physical / 1000
+}
+
+// String encodes the clock part of ts so it can be passed in request metadata.
+func (ts HybridTimestamp) String() string {
+	return fmt.Sprintf("%d.%d", ts.Physical, ts.Logical)
+}
+
+func ParseHybridTimestamp(raw string) (HybridTimestamp, error) {
+	parts := strings.SplitN(raw, ".", 2)
+	if len(parts) != 2 {
+		return HybridTimestamp{}, fmt.Errorf("invalid hybrid timestamp: %s", raw)
+	}
+	physical, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return HybridTimestamp{}, err
+	}
+	logical, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return HybridTimestamp{}, err
+	}
+	return HybridTimestamp{Physical: physical, Logical: int32(logical)}, nil
+}
+
+func compareOrdered[T int64 | int32](a, b T) int {
+	if a < b {
+		return -1
+	}
+	return 1
+}
+
+// HybridClock issues monotonic hybrid timestamps for a node.
+type HybridClock struct {
+	lock sync.Mutex
+	node string
+	last HybridTimestamp
+	now  func() int64
+}
+
+func NewHybridClock(node string) *HybridClock {
+	return &HybridClock{node: node, now: func() int64 { return time.Now().UnixMilli() }}
+}
+
+// Now returns a timestamp after every timestamp previously issued or observed by the clock.
+func (clock *HybridClock) Now() HybridTimestamp {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	physical := clock.now()
+	if physical > clock.last.Physical {
+		clock.last = HybridTimestamp{Physical: physical}
+	} else {
+		clock.last.Logical++
+	}
+	clock.last.Node = clock.node
+	return clock.last
+}
+
+// Update merges a timestamp received from another node into the clock.
+func (clock *HybridClock) Update(remote HybridTimestamp) HybridTimestamp {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	physical := clock.now()
+	switch {
+	case physical > clock.last.Physical && physical > remote.Physical:
+		clock.last = HybridTimestamp{Physical: physical}
+	case remote.Physical > clock.last.Physical:
+		clock.last = HybridTimestamp{Physical: remote.Physical, Logical: remote.Logical + 1}
+	case clock.last.Physical > remote.Physical:
+		clock.last.Logical++
+	default:
+		clock.last.Logical = Max(clock.last.Logical, remote.Logical) + 1
+	}
+	clock.last.Node = clock.node
+	return clock.last
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHybridClock(t *testing.T) {
+	physical := int64(1000)
+	clock := NewHybridClock("a")
+	clock.now = func() int64 { return physical }
+
+	first := clock.Now()
+	second := clock.Now()
+	assert.EqualValues(t, HybridTimestamp{Physical: 1000, Logical: 0, Node: "a"}, first, "first wrong value")
+	assert.EqualValues(t, HybridTimestamp{Physical: 1000, Logical: 1, Node: "a"}, second, "same millisecond should increment logical")
+	assert.Equal(t, -1, first.Compare(second), "Compare wrong value")
+
+	// a remote clock ahead of us moves the clock forward
+	updated := clock.Update(HybridTimestamp{Physical: 2000, Logical: 5, Node: "b"})
+	assert.EqualValues(t, HybridTimestamp{Physical: 2000, Logical: 6, Node: "a"}, updated, "Update wrong value")
+	assert.Equal(t, 1, clock.Now().Compare(updated), "Now should be after Update")
+
+	// wall clock catching up resets the logical counter
+	physical = 3000
+	assert.EqualValues(t, HybridTimestamp{Physical: 3000, Logical: 0, Node: "a"}, clock.Now(), "Now wrong value")
+
+	// clock going backwards stays monotonic
+	physical = 100
+	assert.EqualValues(t, HybridTimestamp{Physical: 3000, Logical: 1, Node: "a"}, clock.Now(), "Now wrong value")
+
+	// node breaks ties
+	assert.Equal(t, -1, HybridTimestamp{Physical: 1, Node: "a"}.Compare(HybridTimestamp{Physical: 1, Node: "b"}), "Compare node wrong value")
+	assert.Equal(t, 0, HybridTimestamp{Physical: 1, Node: "a"}.Compare(HybridTimestamp{Physical: 1, Node: "a"}), "Compare equal wrong value")
+}
+
+func TestHybridTimestampString(t *testing.T) {
+	ts := HybridTimestamp{Physical: 1700000000000, Logical: 3, Node: "a"}
+	parsed, err := ParseHybridTimestamp(ts.String())
+	assert.NoError(t, err)
+	assert.EqualValues(t, HybridTimestamp{Physical: 1700000000000, Logical: 3}, parsed, "ParseHybridTimestamp wrong value")
+
+	_, err = ParseHybridTimestamp("bad")
+	assert.Error(t, err)
+}